@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opts repo.BlameOptions
+	blameCmd := &cobra.Command{
+		Use:   "blame <file> [rev]",
+		Short: "Show what revision and author last modified each line of a file.",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			rev := "HEAD"
+			if len(args) == 2 {
+				rev = args[1]
+			}
+			fmt.Print(repo.Blame(args[0], rev, opts))
+		},
+	}
+	blameCmd.Flags().StringArrayVarP(&opts.Ranges, "line-range", "L", nil, "Annotate only the line range given by <start>,<end>")
+	blameCmd.Flags().BoolVarP(&opts.Porcelain, "porcelain", "p", false, "Show in a format designed for machine consumption")
+	blameCmd.Flags().BoolVarP(&opts.IgnoreWhitespace, "ignore-whitespace", "w", false, "Ignore whitespace when comparing lines")
+	blameCmd.Flags().StringArrayVar(&opts.IgnoreRevs, "ignore-rev", nil, "Ignore changes made by the revision")
+	blameCmd.Flags().StringVar(&opts.IgnoreRevsFile, "ignore-revs-file", "", "Ignore revisions listed in the file")
+	RootCmd.AddCommand(blameCmd)
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/pencil001/pit/repo"
 	"github.com/pencil001/pit/util"
@@ -10,19 +11,60 @@ import (
 )
 
 func init() {
+	var showType, showSize, exists, pretty bool
+	var batch, batchCheck, allObjects bool
 	catFileCmd := &cobra.Command{
-		Use:   "cat-file [type] [object]",
+		Use:   "cat-file ([type] | -t | -s | -e | -p) [object] | (--batch | --batch-check) [--batch-all-objects]",
 		Short: "Provide content of repository objects",
-		Args:  cobra.ExactArgs(2),
+		Args:  cobra.RangeArgs(0, 2),
 		Run: func(cmd *cobra.Command, args []string) {
-			objType := args[0]
-			objSHA := args[1]
-			if !util.ObjectIn([]string{repo.TypeBlob, repo.TypeCommit, repo.TypeTag, repo.TypeTree}, objType) {
-				log.Panicf("Unknown type: %v", objType)
+			switch {
+			case batch || batchCheck:
+				if len(args) != 0 {
+					log.Panic("--batch and --batch-check read objects from standard input")
+				}
+				repo.CatBatch(os.Stdin, os.Stdout, batch, allObjects)
+				return
+			case allObjects:
+				log.Panic("--batch-all-objects requires --batch or --batch-check")
+			case showType || showSize || exists || pretty:
+				if len(args) != 1 {
+					log.Panic("-t, -s, -e and -p take a single object")
+				}
+			default:
+				if len(args) != 2 {
+					log.Panic("Both a type and an object are required")
+				}
+			}
+
+			switch {
+			case showType:
+				fmt.Println(repo.CatType(args[0]))
+			case showSize:
+				fmt.Println(repo.CatSize(args[0]))
+			case exists:
+				if !repo.CatExists(args[0]) {
+					os.Exit(1)
+				}
+			case pretty:
+				fmt.Print(repo.CatPretty(args[0]))
+			default:
+				objType := args[0]
+				objSHA := args[1]
+				if !util.ObjectIn([]string{repo.TypeBlob, repo.TypeCommit, repo.TypeTag, repo.TypeTree}, objType) {
+					log.Panicf("Unknown type: %v", objType)
+				}
+				content := repo.Cat(objType, objSHA)
+				fmt.Println(content)
 			}
-			content := repo.Cat(objType, objSHA)
-			fmt.Println(content)
 		},
 	}
+	catFileCmd.Flags().BoolVarP(&showType, "type", "t", false, "Show the object type")
+	catFileCmd.Flags().BoolVarP(&showSize, "size", "s", false, "Show the object size")
+	catFileCmd.Flags().BoolVarP(&exists, "exists", "e", false, "Exit with zero status if the object exists and is valid")
+	catFileCmd.Flags().BoolVarP(&pretty, "pretty", "p", false, "Pretty-print the object content")
+	catFileCmd.Flags().BoolVar(&batch, "batch", false, "Show the header and content of objects read from standard input")
+	catFileCmd.Flags().BoolVar(&batchCheck, "batch-check", false, "Show the header of objects read from standard input")
+	catFileCmd.Flags().BoolVar(&allObjects, "batch-all-objects", false, "Show all objects of the repository instead of reading standard input")
 	RootCmd.AddCommand(catFileCmd)
 }
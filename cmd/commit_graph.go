@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	commitGraphCmd := &cobra.Command{
+		Use:   "commit-graph",
+		Short: "Write and verify the commit-graph file.",
+	}
+
+	var changedPaths bool
+	writeCmd := &cobra.Command{
+		Use:   "write",
+		Short: "Write a commit-graph file for the commits reachable from the refs.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Print(repo.CommitGraphWrite(changedPaths))
+		},
+	}
+	writeCmd.Flags().BoolVar(&changedPaths, "changed-paths", false, "Compute and write changed-path Bloom filters")
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the commit-graph file against the object database.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			result, ok := repo.CommitGraphVerify()
+			fmt.Print(result)
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+
+	commitGraphCmd.AddCommand(writeCmd, verifyCmd)
+	RootCmd.AddCommand(commitGraphCmd)
+}
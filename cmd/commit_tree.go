@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var parents, messages []string
+	var keyID string
+	var noSign bool
+	commitTreeCmd := &cobra.Command{
+		Use:   "commit-tree [tree]",
+		Short: "Create a new commit object.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			message := strings.Join(messages, "\n\n")
+			if len(messages) == 0 {
+				bs, err := ioutil.ReadAll(os.Stdin)
+				if err != nil {
+					log.Panic(err)
+				}
+				message = string(bs)
+			}
+			sign := cmd.Flags().Changed("gpg-sign")
+			if keyID == "-" {
+				keyID = ""
+			}
+			hash := repo.CommitTree(args[0], parents, message, sign, noSign, keyID)
+			fmt.Println(hash)
+		},
+	}
+	commitTreeCmd.Flags().StringArrayVarP(&parents, "parent", "p", nil, "Each -p indicates the id of a parent commit object")
+	commitTreeCmd.Flags().StringArrayVarP(&messages, "message", "m", nil, "A paragraph in the commit log message")
+	commitTreeCmd.Flags().StringVarP(&keyID, "gpg-sign", "S", "", "GPG-sign the commit, optionally with the given key")
+	commitTreeCmd.Flags().Lookup("gpg-sign").NoOptDefVal = "-"
+	commitTreeCmd.Flags().BoolVar(&noSign, "no-gpg-sign", false, "Do not sign the commit, even if commit.gpgSign is set")
+	RootCmd.AddCommand(commitTreeCmd)
+}
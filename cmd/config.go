@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opts repo.ConfigOptions
+	var isSystem, isGlobal, isLocal, isWorktree bool
+	var isGet, isGetAll, isSet, isAdd, isUnset, isUnsetAll, isList bool
+	configCmd := &cobra.Command{
+		Use:   "config [name] [value]",
+		Short: "Get and set repository or global options.",
+		Args:  cobra.MaximumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			switch {
+			case isSystem:
+				opts.Scope = repo.ScopeSystem
+			case isGlobal:
+				opts.Scope = repo.ScopeGlobal
+			case isLocal:
+				opts.Scope = repo.ScopeLocal
+			case isWorktree:
+				opts.Scope = repo.ScopeWorktree
+			}
+
+			switch {
+			case isList:
+				fmt.Print(repo.ConfigList(opts))
+			case isUnset || isUnsetAll:
+				if len(args) != 1 {
+					log.Panic("wrong number of arguments, should be 1")
+				}
+				repo.ConfigUnset(opts, args[0], isUnsetAll)
+			case isSet || isAdd || (!isGet && !isGetAll && len(args) == 2):
+				if len(args) != 2 {
+					log.Panic("wrong number of arguments, should be 2")
+				}
+				repo.ConfigSet(opts, args[0], args[1], isAdd)
+			case len(args) == 1:
+				value, ok := repo.ConfigGet(opts, args[0], isGetAll)
+				if !ok {
+					os.Exit(1)
+				}
+				fmt.Print(value)
+			default:
+				cmd.Usage()
+				os.Exit(129)
+			}
+		},
+	}
+	configCmd.Flags().BoolVar(&isSystem, "system", false, "Use the system-wide config file")
+	configCmd.Flags().BoolVar(&isGlobal, "global", false, "Use the per-user config file")
+	configCmd.Flags().BoolVar(&isLocal, "local", false, "Use the repository config file")
+	configCmd.Flags().BoolVar(&isWorktree, "worktree", false, "Use the per-worktree config file")
+	configCmd.Flags().StringVarP(&opts.File, "file", "f", "", "Use the given config file")
+	configCmd.Flags().StringVar(&opts.Type, "type", "", "Value is given this type (bool, int, bool-or-int, path, color)")
+	configCmd.Flags().BoolVar(&opts.ShowOrigin, "show-origin", false, "Show the origin of each value")
+	configCmd.Flags().BoolVar(&isGet, "get", false, "Get the value of a key")
+	configCmd.Flags().BoolVar(&isGetAll, "get-all", false, "Get all values of a multi-valued key")
+	configCmd.Flags().BoolVar(&isSet, "set", false, "Set the value of a key")
+	configCmd.Flags().BoolVar(&isAdd, "add", false, "Add a new value to a key without altering existing ones")
+	configCmd.Flags().BoolVar(&isUnset, "unset", false, "Remove a key")
+	configCmd.Flags().BoolVar(&isUnsetAll, "unset-all", false, "Remove all values of a key")
+	configCmd.Flags().BoolVarP(&isList, "list", "l", false, "List all variables set in the config")
+	RootCmd.AddCommand(configCmd)
+}
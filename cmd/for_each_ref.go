@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opts repo.ForEachRefOptions
+	forEachRefCmd := &cobra.Command{
+		Use:   "for-each-ref [--count=<n>] [--sort=<key>...] [--format=<format>] [--contains=<commit>] [--merged=<commit>] [pattern...]",
+		Short: "Output information on each ref",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Print(repo.ForEachRef(args, opts))
+		},
+	}
+	forEachRefCmd.Flags().StringVar(&opts.Format, "format", "", "Format each ref with atoms such as %(refname:short), %(objectname) or %(upstream)")
+	forEachRefCmd.Flags().StringArrayVar(&opts.Sort, "sort", nil, "Sort on a key, reversed with a leading '-'; the last key takes precedence")
+	forEachRefCmd.Flags().IntVar(&opts.Count, "count", 0, "Show only the first n refs")
+	forEachRefCmd.Flags().StringVar(&opts.Contains, "contains", "", "Show only refs which contain the commit")
+	forEachRefCmd.Flags().StringVar(&opts.NoContains, "no-contains", "", "Show only refs which don't contain the commit")
+	forEachRefCmd.Flags().StringVar(&opts.Merged, "merged", "", "Show only refs merged into the commit")
+	forEachRefCmd.Flags().StringVar(&opts.NoMerged, "no-merged", "", "Show only refs not merged into the commit")
+	forEachRefCmd.Flags().StringVar(&opts.PointsAt, "points-at", "", "Show only refs which point at the object")
+	RootCmd.AddCommand(forEachRefCmd)
+}
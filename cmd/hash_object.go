@@ -2,30 +2,47 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"os"
 
 	"github.com/pencil001/pit/repo"
-	"github.com/pencil001/pit/util"
 	"github.com/spf13/cobra"
 )
 
 func init() {
-	var isStore bool
-	var objType string
+	var opts repo.HashOptions
+	var stdin, stdinPaths bool
 	hashObjectCmd := &cobra.Command{
-		Use:   "hash-object [file]",
+		Use:   "hash-object [-t type] [-w] [--path=file | --no-filters] [--literally] [--stdin] [file...] | --stdin-paths",
 		Short: "Compute object ID and optionally creates a blob from a file",
-		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			if !util.ObjectIn([]string{repo.TypeBlob, repo.TypeCommit, repo.TypeTag, repo.TypeTree}, objType) {
-				log.Panicf("Unknown type: %v", objType)
+			if opts.Path != "" && opts.NoFilters {
+				log.Panic("Can't use --path with --no-filters")
 			}
-			file := args[0]
-			hash := repo.Hash(file, objType, isStore)
-			fmt.Println(hash)
+			if stdinPaths {
+				if stdin || len(args) != 0 {
+					log.Panic("--stdin-paths reads paths only from standard input")
+				}
+				repo.HashPaths(os.Stdin, os.Stdout, opts)
+				return
+			}
+			if !stdin && len(args) == 0 {
+				log.Panic("Nothing to hash")
+			}
+			var in io.Reader
+			if stdin {
+				in = os.Stdin
+			}
+			fmt.Print(repo.Hash(args, in, opts))
 		},
 	}
-	hashObjectCmd.Flags().BoolVarP(&isStore, "write", "w", false, "Actually write the object into the database")
-	hashObjectCmd.Flags().StringVarP(&objType, "type", "t", repo.TypeBlob, "Specify the type")
+	hashObjectCmd.Flags().BoolVarP(&opts.Write, "write", "w", false, "Actually write the object into the database")
+	hashObjectCmd.Flags().StringVarP(&opts.Type, "type", "t", repo.TypeBlob, "Specify the type")
+	hashObjectCmd.Flags().BoolVar(&stdin, "stdin", false, "Read the object from standard input")
+	hashObjectCmd.Flags().BoolVar(&stdinPaths, "stdin-paths", false, "Read file names from standard input instead of the command line")
+	hashObjectCmd.Flags().StringVar(&opts.Path, "path", "", "Hash the object as if it were located at the given path")
+	hashObjectCmd.Flags().BoolVar(&opts.NoFilters, "no-filters", false, "Hash the contents as is, ignoring any input filter")
+	hashObjectCmd.Flags().BoolVar(&opts.Literally, "literally", false, "Allow any type and skip validation of the object")
 	RootCmd.AddCommand(hashObjectCmd)
 }
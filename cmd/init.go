@@ -6,6 +6,8 @@ import (
 )
 
 func init() {
+	var objectFormat, initialBranch string
+	var bare bool
 	initCmd := &cobra.Command{
 		Use:   "init [path]",
 		Short: "Initialize a new, empty repository.",
@@ -15,8 +17,11 @@ func init() {
 			if len(args) == 1 {
 				path = args[0]
 			}
-			repo.Init(path)
+			repo.Init(path, objectFormat, initialBranch, bare)
 		},
 	}
+	initCmd.Flags().StringVar(&objectFormat, "object-format", repo.FormatSHA1.Name, "Specify the hash algorithm to use (sha1 or sha256)")
+	initCmd.Flags().StringVarP(&initialBranch, "initial-branch", "b", "", "Use the given name for the initial branch (default: init.defaultBranch, or master)")
+	initCmd.Flags().BoolVar(&bare, "bare", false, "Create a bare repository, without a work tree")
 	RootCmd.AddCommand(initCmd)
 }
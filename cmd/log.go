@@ -8,18 +8,31 @@ import (
 )
 
 func init() {
+	var opts repo.LogOptions
 	logCmd := &cobra.Command{
-		Use:   "log [commit]",
+		Use:   "log [<revision>...] [-- <path>...]",
 		Short: "Display history of a given commit.",
-		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			objSHA := "HEAD"
-			if len(args) == 1 {
-				objSHA = args[0]
+			revs := args
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				revs, opts.Paths = args[:dash], args[dash:]
 			}
-			log := repo.Log(objSHA)
-			fmt.Println(log)
+			fmt.Print(repo.Log(revs, opts))
 		},
 	}
+	logCmd.Flags().IntVarP(&opts.MaxCount, "max-count", "n", -1, "Limit the number of commits to output")
+	logCmd.Flags().BoolVar(&opts.FirstParent, "first-parent", false, "Follow only the first parent of merge commits")
+	logCmd.Flags().BoolVar(&opts.ShowSignature, "show-signature", false, "Check the signature of each commit")
+	logCmd.Flags().BoolVarP(&opts.Patch, "patch", "p", false, "Show the changes of each commit as a patch")
+	logCmd.Flags().BoolVar(&opts.Stat, "stat", false, "Show a diffstat of each commit")
+	logCmd.Flags().BoolVar(&opts.Combined, "cc", false, "Show the patch of merges against all of their parents")
+	logCmd.Flags().BoolVar(&opts.Graph, "graph", false, "Draw the history graph next to the commits")
+	logCmd.Flags().BoolVar(&opts.Oneline, "oneline", false, "Show each commit on a single line")
+	logCmd.Flags().BoolVar(&opts.Decorate, "decorate", false, "Show the names of the refs pointing to commits")
+	logCmd.Flags().StringVar(&opts.Format, "format", "", "Write the history as a graph: dot, mermaid or json-graph")
+	logCmd.Flags().BoolVar(&opts.Clusters, "cluster", false, "Group the commits of the graph by branch")
+	logCmd.Flags().BoolVar(&opts.Trees, "trees", false, "Add the trees and blobs of each commit to the graph")
+	logCmd.Flags().BoolVar(&opts.FullHistory, "full-history", false, "Follow every parent of merges when limiting to paths")
+	logCmd.Flags().BoolVar(&opts.Follow, "follow", false, "Follow the renames of a single file")
 	RootCmd.AddCommand(logCmd)
 }
@@ -8,15 +8,27 @@ import (
 )
 
 func init() {
-	logCmd := &cobra.Command{
-		Use:   "ls-tree [object]",
-		Short: "Pretty-print a tree object.",
-		Args:  cobra.ExactArgs(1),
+	var opts repo.LsTreeOptions
+	lsTreeCmd := &cobra.Command{
+		Use:   "ls-tree [-d] [-r] [-t] [-l] [-z] [--name-only | --object-only | --format=<format>] [--full-name] [--full-tree] [--abbrev[=<n>]] <tree-ish> [path...]",
+		Short: "List the contents of a tree object",
+		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			objSHA := args[0]
-			tree := repo.ListTree(objSHA)
-			fmt.Println(tree)
+			fmt.Print(repo.ListTree(args[0], args[1:], opts))
 		},
 	}
-	RootCmd.AddCommand(logCmd)
+	lsTreeCmd.Flags().BoolVarP(&opts.TreesOnly, "dirs-only", "d", false, "Show only trees")
+	lsTreeCmd.Flags().BoolVarP(&opts.Recursive, "recursive", "r", false, "Recurse into subtrees")
+	lsTreeCmd.Flags().BoolVarP(&opts.ShowTrees, "show-trees", "t", false, "Show trees when recursing")
+	lsTreeCmd.Flags().BoolVarP(&opts.Long, "long", "l", false, "Show the size of blobs")
+	lsTreeCmd.Flags().BoolVarP(&opts.NullTerminate, "null", "z", false, "Terminate entries with NUL bytes and don't quote paths")
+	lsTreeCmd.Flags().BoolVar(&opts.NameOnly, "name-only", false, "List only paths")
+	lsTreeCmd.Flags().BoolVar(&opts.NameOnly, "name-status", false, "List only paths")
+	lsTreeCmd.Flags().BoolVar(&opts.ObjectOnly, "object-only", false, "List only object names")
+	lsTreeCmd.Flags().BoolVar(&opts.FullName, "full-name", false, "Show paths from the root of the tree")
+	lsTreeCmd.Flags().BoolVar(&opts.FullTree, "full-tree", false, "List the entire tree, not just the current directory (implies --full-name)")
+	lsTreeCmd.Flags().IntVar(&opts.Abbrev, "abbrev", 0, "Abbreviate object names to n digits")
+	lsTreeCmd.Flags().Lookup("abbrev").NoOptDefVal = "7"
+	lsTreeCmd.Flags().StringVar(&opts.Format, "format", "", "Format entries with %(objectmode), %(objecttype), %(objectname), %(objectsize) and %(path)")
+	RootCmd.AddCommand(lsTreeCmd)
 }
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var all, isAncestor bool
+	mergeBaseCmd := &cobra.Command{
+		Use:   "merge-base <commit> <commit>",
+		Short: "Find as good common ancestors as possible for a merge.",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if isAncestor {
+				if !repo.IsAncestor(args[0], args[1]) {
+					os.Exit(1)
+				}
+				return
+			}
+			bases := repo.MergeBase(args[0], args[1], all)
+			if bases == "" {
+				os.Exit(1)
+			}
+			fmt.Print(bases)
+		},
+	}
+	mergeBaseCmd.Flags().BoolVarP(&all, "all", "a", false, "Output all merge bases")
+	mergeBaseCmd.Flags().BoolVar(&isAncestor, "is-ancestor", false, "Check if the first commit is an ancestor of the second")
+	RootCmd.AddCommand(mergeBaseCmd)
+}
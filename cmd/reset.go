@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opts repo.ResetOptions
+	var soft, mixed, hard bool
+	resetCmd := &cobra.Command{
+		Use:   "reset [--soft | --mixed | --hard] [<commit>] [-- <path>...]",
+		Short: "Reset the current branch, the index and the work tree to a commit.",
+		Run: func(cmd *cobra.Command, args []string) {
+			var paths []string
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				args, paths = args[:dash], args[dash:]
+			}
+			modes := 0
+			for mode, set := range map[string]bool{repo.ResetSoft: soft, repo.ResetMixed: mixed, repo.ResetHard: hard} {
+				if set {
+					opts.Mode = mode
+					modes++
+				}
+			}
+			if modes > 1 {
+				log.Panic("--soft, --mixed and --hard are mutually exclusive")
+			}
+			fmt.Print(repo.Reset(args, paths, opts))
+		},
+	}
+	resetCmd.Flags().BoolVar(&soft, "soft", false, "Only move the current branch")
+	resetCmd.Flags().BoolVar(&mixed, "mixed", false, "Move the current branch and reset the index (default)")
+	resetCmd.Flags().BoolVar(&hard, "hard", false, "Move the current branch and reset the index and the work tree")
+	resetCmd.Flags().BoolVarP(&opts.Quiet, "quiet", "q", false, "Only report errors")
+	RootCmd.AddCommand(resetCmd)
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opts repo.RestoreOptions
+	restoreCmd := &cobra.Command{
+		Use:   "restore [--source=<tree>] [--staged] [--worktree] <path>...",
+		Short: "Restore files of the work tree or the index.",
+		Run: func(cmd *cobra.Command, args []string) {
+			repo.Restore(args, opts)
+		},
+	}
+	restoreCmd.Flags().StringVarP(&opts.Source, "source", "s", "", "Restore the files from the given tree")
+	restoreCmd.Flags().BoolVarP(&opts.Staged, "staged", "S", false, "Restore the index")
+	restoreCmd.Flags().BoolVarP(&opts.Worktree, "worktree", "W", false, "Restore the work tree (default)")
+	RootCmd.AddCommand(restoreCmd)
+}
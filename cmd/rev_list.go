@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var opts repo.RevListOptions
+	var since, until string
+	var topoOrder, dateOrder, authorDateOrder bool
+	revListCmd := &cobra.Command{
+		Use:   "rev-list <commit>... [-- <path>...]",
+		Short: "Lists commit objects in reverse chronological order.",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				args, opts.Paths = args[:dash], args[dash:]
+			}
+			switch {
+			case topoOrder:
+				opts.Sort = repo.SortTopo
+			case authorDateOrder:
+				opts.Sort = repo.SortAuthorDate
+			case dateOrder:
+				opts.Sort = repo.SortDate
+			}
+			opts.Since = parseDateFlag(since)
+			opts.Until = parseDateFlag(until)
+			fmt.Print(repo.RevList(args, opts))
+		},
+	}
+	revListCmd.Flags().IntVarP(&opts.MaxCount, "max-count", "n", -1, "Limit the number of commits to output")
+	revListCmd.Flags().StringVar(&since, "since", "", "Show commits more recent than a specific date")
+	revListCmd.Flags().StringVar(&since, "after", "", "Show commits more recent than a specific date")
+	revListCmd.Flags().StringVar(&until, "until", "", "Show commits older than a specific date")
+	revListCmd.Flags().StringVar(&until, "before", "", "Show commits older than a specific date")
+	revListCmd.Flags().BoolVar(&opts.FirstParent, "first-parent", false, "Follow only the first parent of merge commits")
+	revListCmd.Flags().BoolVar(&opts.FullHistory, "full-history", false, "Follow every parent of merges when limiting to paths")
+	revListCmd.Flags().BoolVar(&opts.AncestryPath, "ancestry-path", false, "Only show commits on the ancestry path of the range")
+	revListCmd.Flags().BoolVar(&opts.Merges, "merges", false, "Only show merge commits")
+	revListCmd.Flags().BoolVar(&opts.NoMerges, "no-merges", false, "Do not show merge commits")
+	revListCmd.Flags().BoolVar(&topoOrder, "topo-order", false, "Avoid interleaving lines of history")
+	revListCmd.Flags().BoolVar(&dateOrder, "date-order", false, "Show commits in commit timestamp order")
+	revListCmd.Flags().BoolVar(&authorDateOrder, "author-date-order", false, "Show commits in author timestamp order")
+	revListCmd.Flags().BoolVar(&opts.Count, "count", false, "Print the number of commits instead of listing them")
+	revListCmd.Flags().BoolVar(&opts.Objects, "objects", false, "Also list the trees and blobs of the listed commits")
+	revListCmd.Flags().BoolVar(&opts.LeftRight, "left-right", false, "Mark which side of a symmetric difference a commit is from")
+	RootCmd.AddCommand(revListCmd)
+}
+
+func parseDateFlag(strDate string) time.Time {
+	if strDate == "" {
+		return time.Time{}
+	}
+	when, err := repo.ParseDate(strDate)
+	if err != nil {
+		log.Panic(err)
+	}
+	return when
+}
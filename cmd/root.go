@@ -2,8 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"os/exec"
+	"strings"
 
+	"github.com/pencil001/pit/repo"
 	"github.com/spf13/cobra"
 )
 
@@ -11,12 +15,192 @@ import (
 var RootCmd = &cobra.Command{
 	Use:   "pit",
 	Short: "A self-implemented git",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := applyGlobalOptions(globalOpts); err != nil {
+			log.Panic(err)
+		}
+	},
+}
+
+// globalOptions apply to any command: -C changes directory first, and
+// --git-dir and --work-tree stand for $GIT_DIR and $GIT_WORK_TREE.
+type globalOptions struct {
+	dirs     []string
+	gitDir   string
+	workTree string
+}
+
+var globalOpts globalOptions
+
+func init() {
+	RootCmd.PersistentFlags().StringArrayVarP(&globalOpts.dirs, "directory", "C", nil, "Run as if started in the given path")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.gitDir, "git-dir", "", "Set the path to the repository")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.workTree, "work-tree", "", "Set the path to the work tree")
 }
 
 // Execute is the entrance of the cobra cmd
 func Execute() {
+	// Global options before the command are applied at once, so that
+	// aliases are looked up in the right repository.
+	var opts globalOptions
+	args, err := parseGlobalOptions(os.Args[1:], &opts)
+	if err == nil {
+		err = applyGlobalOptions(opts)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	RootCmd.SetArgs(expandAlias(args))
 	if err := RootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
+
+// expandAlias replaces a leading alias.<name> with its definition. Aliases
+// starting with "!" are run by the shell instead.
+func expandAlias(args []string) []string {
+	seen := map[string]bool{}
+	for len(args) > 0 && !isCommand(args[0]) {
+		name := args[0]
+		if seen[name] {
+			log.Panicf("Alias loop detected: expansion of '%v' does not terminate", name)
+		}
+		seen[name] = true
+
+		alias, ok := repo.ConfigAlias(name)
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(alias, "!") {
+			shellArgs := append([]string{"-c", alias[1:] + ` "$@"`, alias[1:]}, args[1:]...)
+			sh := exec.Command("sh", shellArgs...)
+			sh.Stdin, sh.Stdout, sh.Stderr = os.Stdin, os.Stdout, os.Stderr
+			if err := sh.Run(); err != nil {
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					os.Exit(exitErr.ExitCode())
+				}
+				log.Panic(err)
+			}
+			os.Exit(0)
+		}
+		words, err := splitCmdline(alias)
+		if err != nil {
+			log.Panicf("bad alias.%v string: %v", name, err)
+		}
+		args = append(words, args[1:]...)
+	}
+	return args
+}
+
+// splitCmdline splits an alias into words on whitespace, like git: single
+// quotes keep everything up to the next one, double quotes keep everything
+// but backslash escapes, and a backslash outside single quotes escapes the
+// next character.
+func splitCmdline(s string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == 0 && (c == ' ' || c == '\t' || c == '\n'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\\' && quote != '\'':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("cmdline ends with \\")
+			}
+			i++
+			word.WriteByte(s[i])
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		default:
+			word.WriteByte(c)
+		}
+		inWord = true
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func isCommand(name string) bool {
+	if strings.HasPrefix(name, "-") {
+		return true
+	}
+	for _, c := range RootCmd.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return true
+		}
+	}
+	return name == "help"
+}
+
+// parseGlobalOptions takes the global options off the front of args.
+func parseGlobalOptions(args []string, opts *globalOptions) ([]string, error) {
+	for len(args) > 0 {
+		arg := args[0]
+		var name, value string
+		switch {
+		case arg == "-C" || arg == "--git-dir" || arg == "--work-tree":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("no directory given for %v", arg)
+			}
+			name, value, args = arg, args[1], args[2:]
+		case strings.HasPrefix(arg, "-C") && !strings.HasPrefix(arg, "--"):
+			name, value, args = "-C", arg[2:], args[1:]
+		case strings.HasPrefix(arg, "--git-dir="), strings.HasPrefix(arg, "--work-tree="):
+			i := strings.IndexByte(arg, '=')
+			name, value, args = arg[:i], arg[i+1:], args[1:]
+		default:
+			return args, nil
+		}
+		switch name {
+		case "-C":
+			opts.dirs = append(opts.dirs, value)
+		case "--git-dir":
+			opts.gitDir = value
+		case "--work-tree":
+			opts.workTree = value
+		}
+	}
+	return args, nil
+}
+
+// applyGlobalOptions changes to each -C directory in turn, each one being
+// relative to the previous one, and exports the repository paths to the
+// environment, like git does.
+func applyGlobalOptions(opts globalOptions) error {
+	for _, dir := range opts.dirs {
+		if dir == "" {
+			continue
+		}
+		if err := os.Chdir(dir); err != nil {
+			return fmt.Errorf("cannot change to '%v': %v", dir, err)
+		}
+	}
+	if opts.gitDir != "" {
+		if err := os.Setenv("GIT_DIR", opts.gitDir); err != nil {
+			return err
+		}
+	}
+	if opts.workTree != "" {
+		if err := os.Setenv("GIT_WORK_TREE", opts.workTree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
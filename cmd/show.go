@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	showCmd := &cobra.Command{
+		Use:   "show [<object>...]",
+		Short: "Show various types of objects.",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Print(repo.Show(args))
+		},
+	}
+	RootCmd.AddCommand(showCmd)
+}
@@ -2,20 +2,36 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/pencil001/pit/repo"
 	"github.com/spf13/cobra"
 )
 
 func init() {
+	var opts repo.ShowRefOptions
+	var quiet bool
 	showRefCmd := &cobra.Command{
-		Use:   "show-ref",
+		Use:   "show-ref [-q] [--verify] [--head] [-d] [-s] [--abbrev[=<n>]] [--heads] [--tags] [pattern...]",
 		Short: "List references.",
-		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			refs := repo.ShowRefs("", true)
-			fmt.Println(refs)
+			refs := repo.ShowRefs(args, opts)
+			if !quiet {
+				fmt.Print(refs)
+			}
+			if refs == "" {
+				os.Exit(1)
+			}
 		},
 	}
+	showRefCmd.Flags().BoolVar(&opts.Heads, "heads", false, "Show only refs under refs/heads")
+	showRefCmd.Flags().BoolVar(&opts.Tags, "tags", false, "Show only refs under refs/tags")
+	showRefCmd.Flags().BoolVar(&opts.Head, "head", false, "Show the HEAD reference too")
+	showRefCmd.Flags().BoolVarP(&opts.Dereference, "dereference", "d", false, "Show the objects tags peel to, as <ref>^{}")
+	showRefCmd.Flags().BoolVar(&opts.Verify, "verify", false, "Require exact ref names, which must all exist")
+	showRefCmd.Flags().BoolVarP(&opts.HashOnly, "hash", "s", false, "Show only object names")
+	showRefCmd.Flags().IntVar(&opts.Abbrev, "abbrev", 0, "Abbreviate object names to n digits")
+	showRefCmd.Flags().Lookup("abbrev").NoOptDefVal = "7"
+	showRefCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Show nothing, only exit with the status")
 	RootCmd.AddCommand(showRefCmd)
 }
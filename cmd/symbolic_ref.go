@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var quiet, short, isDelete bool
+	symbolicRefCmd := &cobra.Command{
+		Use:   "symbolic-ref [-q] [--short] <name> | <name> <ref> | -d [-q] <name>",
+		Short: "Read, modify and delete symbolic refs",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := args[0]
+			switch {
+			case isDelete:
+				if len(args) != 1 {
+					log.Panic("-d takes a single ref")
+				}
+				repo.DeleteSymbolicRef(name)
+			case len(args) == 2:
+				repo.UpdateSymbolicRef(name, args[1])
+			default:
+				target, ok := repo.ReadSymbolicRef(name, short)
+				if !ok {
+					if quiet {
+						os.Exit(1)
+					}
+					log.Panicf("ref %v is not a symbolic ref", name)
+				}
+				fmt.Println(target)
+			}
+		},
+	}
+	symbolicRefCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Do not complain when the ref is not a symbolic ref, only exit with status 1")
+	symbolicRefCmd.Flags().BoolVar(&short, "short", false, "Shorten the ref name, e.g. refs/heads/master to master")
+	symbolicRefCmd.Flags().BoolVarP(&isDelete, "delete", "d", false, "Delete the symbolic ref")
+	RootCmd.AddCommand(symbolicRefCmd)
+}
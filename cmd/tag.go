@@ -2,30 +2,51 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 
 	"github.com/pencil001/pit/repo"
 	"github.com/spf13/cobra"
 )
 
 func init() {
-	var isCreateObject bool
+	var opts repo.TagOptions
+	var isDelete, isList bool
+	var sortKey string
 	tagCmd := &cobra.Command{
 		Use:   "tag [name] [object]",
 		Short: "List and create tags.",
-		Args:  cobra.MaximumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
-			tagName := ""
-			objSHA := ""
-			if len(args) >= 1 {
-				tagName = args[0]
+			if isDelete {
+				if len(args) == 0 {
+					log.Panic("tag name required")
+				}
+				fmt.Print(repo.DeleteTags(args))
+				return
 			}
-			if len(args) >= 2 {
-				objSHA = args[1]
+			if isList || len(args) == 0 {
+				fmt.Print(repo.ListTags(args, sortKey))
+				return
 			}
-			tags := repo.ShowOrNewTag(tagName, objSHA, isCreateObject)
-			fmt.Println(tags)
+			if len(args) > 2 {
+				log.Panic("too many arguments")
+			}
+
+			tagName := args[0]
+			rev := ""
+			if len(args) == 2 {
+				rev = args[1]
+			}
+			fmt.Print(repo.CreateTag(tagName, rev, opts))
 		},
 	}
-	tagCmd.Flags().BoolVarP(&isCreateObject, "add", "a", false, "Whether to create a tag object")
+	tagCmd.Flags().BoolVarP(&opts.Annotate, "annotate", "a", false, "Make an annotated tag object")
+	tagCmd.Flags().StringVarP(&opts.Message, "message", "m", "", "Use the given tag message (implies -a)")
+	tagCmd.Flags().BoolVarP(&opts.Sign, "sign", "s", false, "Make a signed tag, using the default signing key")
+	tagCmd.Flags().StringVarP(&opts.SigningKey, "local-user", "u", "", "Make a signed tag, using the given key")
+	tagCmd.Flags().BoolVar(&opts.NoSign, "no-sign", false, "Do not sign the tag, even if tag.gpgSign is set")
+	tagCmd.Flags().BoolVarP(&opts.Force, "force", "f", false, "Replace an existing tag")
+	tagCmd.Flags().BoolVarP(&isDelete, "delete", "d", false, "Delete tags")
+	tagCmd.Flags().BoolVarP(&isList, "list", "l", false, "List tags, optionally matching the given patterns")
+	tagCmd.Flags().StringVar(&sortKey, "sort", "refname", "Sort tags by the given key (refname, version:refname, creatordate, objectname)")
 	RootCmd.AddCommand(tagCmd)
 }
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	verifyCommitCmd := &cobra.Command{
+		Use:   "verify-commit [commit...]",
+		Short: "Check the signature of commits.",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			result, ok := repo.Verify(args, repo.TypeCommit)
+			fmt.Print(result)
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+	RootCmd.AddCommand(verifyCommitCmd)
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	verifyTagCmd := &cobra.Command{
+		Use:   "verify-tag [tag...]",
+		Short: "Check the signature of tags.",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			result, ok := repo.Verify(args, repo.TypeTag)
+			fmt.Print(result)
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+	RootCmd.AddCommand(verifyTagCmd)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pencil001/pit/repo"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	worktreeCmd := &cobra.Command{
+		Use:   "worktree",
+		Short: "Manage multiple working trees.",
+	}
+
+	var addOpts repo.WorktreeAddOptions
+	addCmd := &cobra.Command{
+		Use:   "add <path> [commit-ish]",
+		Short: "Create a working tree at path and check out commit-ish into it.",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			commitish := ""
+			if len(args) == 2 {
+				commitish = args[1]
+			}
+			fmt.Print(repo.WorktreeAdd(args[0], commitish, addOpts))
+		},
+	}
+	addCmd.Flags().StringVarP(&addOpts.NewBranch, "branch", "b", "", "Create a new branch for the working tree")
+	addCmd.Flags().StringVarP(&addOpts.ResetBranch, "force-branch", "B", "", "Create or reset a branch for the working tree")
+	addCmd.Flags().BoolVarP(&addOpts.Detach, "detach", "d", false, "Detach HEAD in the new working tree")
+	addCmd.Flags().BoolVarP(&addOpts.Force, "force", "f", false, "Check out a branch even if it is checked out elsewhere")
+
+	var porcelain bool
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the working trees.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Print(repo.WorktreeList(porcelain))
+		},
+	}
+	listCmd.Flags().BoolVar(&porcelain, "porcelain", false, "Give the output in an easy-to-parse format for scripts")
+
+	var force int
+	removeCmd := &cobra.Command{
+		Use:   "remove <worktree>",
+		Short: "Remove a working tree.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			repo.WorktreeRemove(args[0], force)
+		},
+	}
+	removeCmd.Flags().CountVarP(&force, "force", "f", "Remove a working tree with changes; twice to remove a locked one")
+
+	var dryRun, verbose bool
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Prune the administrative files of missing working trees.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Print(repo.WorktreePrune(dryRun, verbose))
+		},
+	}
+	pruneCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Only tell what would be removed")
+	pruneCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Report all removals")
+
+	worktreeCmd.AddCommand(addCmd, listCmd, removeCmd, pruneCmd)
+	RootCmd.AddCommand(worktreeCmd)
+}
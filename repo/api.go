@@ -1,14 +1,18 @@
 package repo
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/pencil001/pit/util"
@@ -21,15 +25,27 @@ const (
 	TypeTag    = "tag"
 )
 
-func Init(repoPath string) *Repository {
-	repo := createRepository(repoPath, true)
+// Init creates a repository, or reinitializes an existing one. HEAD points
+// to initialBranch, or to init.defaultBranch when it is empty, falling
+// back to "master". A bare repository is laid out in repoPath itself,
+// without a work tree.
+func Init(repoPath string, objectFormat string, initialBranch string, bare bool) *Repository {
+	repo := createRepository(repoPath, path.Join(repoPath, ".git"))
+	if bare {
+		repo = createRepository("", repoPath)
+	}
+	objFormat, err := lookupObjectFormat(objectFormat)
+	if err != nil {
+		log.Panic(err)
+	}
+	repo.objFormat = objFormat
 
-	isExist, err := util.IsExist(repo.workTree)
+	isExist, err := util.IsExist(repoPath)
 	if err != nil {
 		log.Panicf("%v is not exist!", repoPath)
 	}
 	if isExist {
-		if isDir, err := util.IsDir(repo.workTree); err != nil || !isDir {
+		if isDir, err := util.IsDir(repoPath); err != nil || !isDir {
 			log.Panicf("%v is not a directory!", repoPath)
 		}
 	} else {
@@ -38,55 +54,77 @@ func Init(repoPath string) *Repository {
 		}
 	}
 
-	if err := repo.initGitDir(); err != nil {
+	branch := initialBranch
+	if branch == "" {
+		if branch, _ = repo.configString("init.defaultBranch"); branch == "" {
+			branch = "master"
+		}
+	}
+	if err := checkRefName("refs/heads/" + branch); err != nil {
+		log.Panicf("invalid initial branch name: '%v'", branch)
+	}
+	if _, err := os.Stat(path.Join(repo.gitDir, "HEAD")); err == nil && initialBranch != "" {
+		fmt.Fprintf(os.Stderr, "warning: re-init: ignored --initial-branch=%v\n", initialBranch)
+	}
+
+	if err := repo.initGitDir(branch); err != nil {
 		log.Panic(err)
 	}
 	return repo
 }
 
-func Hash(filePath string, objType string, isStore bool) string {
-	var repo *Repository
-	if isStore {
-		repo = createRepository(".", false)
-	}
+// HashOptions selects what "hash-object" does with the objects it names.
+type HashOptions struct {
+	Type  string
+	Write bool
+	// Literally skips validation, so that objects of any type, and
+	// malformed ones, can be made.
+	Literally bool
+	// Path is the path whose attributes decide the clean filter of blobs,
+	// instead of the path of each file.
+	Path      string
+	NoFilters bool
+}
 
-	isExist, err := util.IsExist(filePath)
-	if err != nil || !isExist {
-		log.Panicf("%v is not exist!", filePath)
-	}
-	fileData, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		log.Panic(err)
+// Hash names the objects whose content is read from stdin, when it isn't
+// nil, and then from each file, one per line.
+func Hash(filePaths []string, stdin io.Reader, opts HashOptions) string {
+	repo := opts.repo()
+	var sb strings.Builder
+	if stdin != nil {
+		sha, err := repo.hashReader(stdin, opts)
+		if err != nil {
+			log.Panic(err)
+		}
+		sb.WriteString(sha + "\n")
 	}
-
-	var obj Object
-	switch objType {
-	case TypeBlob:
-		obj = createBlob(repo, fileData)
-	case TypeCommit:
-		obj = createCommit(repo, fileData)
-	case TypeTree:
-		obj = createTree(repo, fileData)
-	case TypeTag:
-		obj = createTag(repo, fileData)
-	default:
-		log.Panicf("Unknown type: %v", objType)
+	for _, filePath := range filePaths {
+		sha, err := repo.hashFile(filePath, opts)
+		if err != nil {
+			log.Panic(err)
+		}
+		sb.WriteString(sha + "\n")
 	}
+	return sb.String()
+}
 
-	sha := ""
-	if isStore {
-		sha, err = obj.Save()
+// HashPaths names the files whose paths are read from in, one per line,
+// writing each name as soon as it is known.
+func HashPaths(in io.Reader, out io.Writer, opts HashOptions) {
+	repo := opts.repo()
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		sha, err := repo.hashFile(scanner.Text(), opts)
 		if err != nil {
 			log.Panic(err)
 		}
-	} else {
-		bs, err := obj.Encode()
-		if err != nil {
+		if _, err := fmt.Fprintln(out, sha); err != nil {
 			log.Panic(err)
 		}
-		sha = util.CalcSHA(bs)
 	}
-	return sha
+	if err := scanner.Err(); err != nil {
+		log.Panic(err)
+	}
 }
 
 func Cat(objType string, objSHA string) string {
@@ -119,50 +157,342 @@ func Cat(objType string, objSHA string) string {
 	return string(bs)
 }
 
-func Log(objSHA string) string {
+// CatType returns the type of an object.
+func CatType(rev string) string {
 	repo := findRepo(".")
+	sha, err := resolveName(repo, rev)
+	if err != nil {
+		log.Panic(err)
+	}
+	format, _, err := repo.objectHeader(sha)
+	if err != nil {
+		log.Panic(err)
+	}
+	return format
+}
 
-	var sb strings.Builder
-	sb.WriteString("digraph pit{\n")
-	graphvizLog(&sb, repo, objSHA, map[string]bool{})
-	sb.WriteString("}\n")
-	return sb.String()
+// CatSize returns the size of the content of an object.
+func CatSize(rev string) int64 {
+	repo := findRepo(".")
+	sha, err := resolveName(repo, rev)
+	if err != nil {
+		log.Panic(err)
+	}
+	_, size, err := repo.objectHeader(sha)
+	if err != nil {
+		log.Panic(err)
+	}
+	return size
 }
 
-func ListTree(objSHA string) string {
-	var err error
+// CatExists tells whether rev names a valid object.
+func CatExists(rev string) bool {
+	repo := findRepo(".")
+	sha, err := resolveName(repo, rev)
+	if err != nil {
+		return false
+	}
+	_, err = repo.readObject(sha)
+	return err == nil
+}
 
+// CatPretty returns the content of an object in a readable form, with the
+// entries of trees listed one per line.
+func CatPretty(rev string) string {
 	repo := findRepo(".")
-	obj, err := repo.readObject(objSHA)
+	sha, err := resolveName(repo, rev)
+	if err != nil {
+		log.Panic(err)
+	}
+	obj, err := repo.readObject(sha)
+	if err != nil {
+		log.Panic(err)
+	}
+	if obj.GetFormat() == TypeTree {
+		return formatTreeLeaves(obj.(*Tree).leaves)
+	}
+	content, err := obj.Serialize()
 	if err != nil {
 		log.Panic(err)
 	}
+	return content
+}
 
-	format := obj.GetFormat()
-	if format != TypeCommit && format != TypeTree {
-		log.Panic("not a tree object")
+// CatBatch reads object names from in, one per line, and writes the
+// "<sha> <type> <size>" header of each object to out, followed by its
+// content and a newline when contents is set. With allObjects, every
+// object of the repository is written instead, in the order of their
+// names.
+func CatBatch(in io.Reader, out io.Writer, contents bool, allObjects bool) {
+	repo := findRepo(".")
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+
+	if allObjects {
+		shas, err := repo.looseObjects()
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, sha := range shas {
+			if err := repo.writeBatchObject(bw, sha, sha, contents); err != nil {
+				log.Panic(err)
+			}
+		}
+		return
 	}
 
-	var str string
-	if format == TypeTree {
-		tree := obj.(*Tree)
-		str, err = tree.Display()
+	br := bufio.NewReader(in)
+	for {
+		// Output is flushed whenever we are about to wait for input, so
+		// that callers can talk to us one object at a time.
+		if br.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				log.Panic(err)
+			}
+		}
+		line, err := br.ReadString('\n')
+		if line == "" && err == io.EOF {
+			return
+		}
+		if err != nil && err != io.EOF {
+			log.Panic(err)
+		}
+		name := strings.TrimSuffix(line, "\n")
+		sha, err := repo.resolveBatchName(name)
 		if err != nil {
+			if _, ok := err.(*ambiguousError); ok {
+				fmt.Fprintf(bw, "%v ambiguous\n", name)
+			} else {
+				fmt.Fprintf(bw, "%v missing\n", name)
+			}
+			continue
+		}
+		if err := repo.writeBatchObject(bw, name, sha, contents); err != nil {
 			log.Panic(err)
 		}
 	}
-	if format == TypeCommit {
-		commit := obj.(*Commit)
-		tree := getTreeByCommit(commit)
-		if tree == nil {
-			log.Panic("No tree in commit")
+}
+
+type LogOptions struct {
+	RevWalkOptions
+	ShowSignature bool
+	Patch         bool
+	Stat          bool
+	Graph         bool
+	Oneline       bool
+	Decorate      bool
+	// Combined shows the patch of a merge against all of its parents at
+	// once, like "git log --cc".
+	Combined bool
+	// Format writes the history as a graph in one of the DAGFormat
+	// formats. Clusters groups its commits by the branch whose
+	// first-parent chain reaches them first, starting with the branch HEAD
+	// is on, and Trees adds the trees and blobs of each commit.
+	Format   string
+	Clusters bool
+	Trees    bool
+}
+
+// Log shows the history of revs like "git log" when any of its text
+// options is set, or else as a graph, in graphviz format by default.
+// Paths are relative to the current directory.
+func Log(revs []string, opts LogOptions) string {
+	repo := findRepo(".")
+	opts.Paths = repoPaths(repo, opts.Paths)
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+
+	// Like git, the graph implies the topological order, and --cc a patch
+	// unless --stat is given.
+	if opts.Graph {
+		opts.Sort = SortTopo
+	}
+	if opts.Combined && !opts.Stat {
+		opts.Patch = true
+	}
+	walk := newRevWalk(repo, opts.RevWalkOptions)
+	walk.rewrite = opts.Graph
+	walk.mergeDiffs = opts.Combined && opts.Patch
+	for _, rev := range revs {
+		if err := walk.Push(rev); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	var sb strings.Builder
+	if opts.Format == "" && (opts.Patch || opts.Stat || opts.Graph || opts.Oneline || opts.Decorate) {
+		textLog(&sb, repo, walk, opts)
+		return sb.String()
+	}
+	d, err := buildDAG(repo, walk, opts)
+	if err != nil {
+		log.Panic(err)
+	}
+	switch opts.Format {
+	case "", DAGFormatDot:
+		d.writeDot(&sb)
+	case DAGFormatMermaid:
+		d.writeMermaid(&sb)
+	case DAGFormatJSONGraph:
+		if err := d.writeJSONGraph(&sb); err != nil {
+			log.Panic(err)
 		}
-		str, err = tree.Display()
+	default:
+		log.Panicf("unknown log format %q", opts.Format)
+	}
+	return sb.String()
+}
+
+// Show shows objects like "git show": a commit with its patch against its
+// parent, or its combined patch when it is a merge, a tag followed by the
+// object it points to, the listing of a tree or the content of a blob.
+func Show(revs []string) string {
+	repo := findRepo(".")
+	if len(revs) == 0 {
+		revs = []string{"HEAD"}
+	}
+
+	var sb strings.Builder
+	shown := false
+	for _, rev := range revs {
+		sha, err := resolveName(repo, rev)
 		if err != nil {
 			log.Panic(err)
 		}
+		for sha != "" {
+			obj, err := repo.readObject(sha)
+			if err != nil {
+				log.Panic(err)
+			}
+			format := obj.GetFormat()
+			if shown && format != TypeBlob {
+				sb.WriteString("\n")
+			}
+			current := sha
+			sha = ""
+			switch format {
+			case TypeTag:
+				tag := obj.(*Tag)
+				sb.WriteString(fmt.Sprintf("tag %v\n", tag.TagName()))
+				if tagger, err := tag.Tagger(); err == nil {
+					sb.WriteString(fmt.Sprintf("Tagger: %v <%v>\n", tagger.Name, tagger.Email))
+					sb.WriteString(fmt.Sprintf("Date:   %v\n", tagger.When.Format("Mon Jan 2 15:04:05 2006 -0700")))
+				}
+				sb.WriteString("\n" + tag.value(""))
+				sha = tag.Object()
+			case TypeCommit:
+				opts := LogOptions{RevWalkOptions: RevWalkOptions{MaxCount: -1, NoWalk: true}, Patch: true, Combined: true}
+				walk := newRevWalk(repo, opts.RevWalkOptions)
+				if err := walk.Push(current); err != nil {
+					log.Panic(err)
+				}
+				textLog(&sb, repo, walk, opts)
+			case TypeTree:
+				sb.WriteString(fmt.Sprintf("tree %v\n\n", rev))
+				for _, leaf := range obj.(*Tree).leaves {
+					sb.WriteString(leaf.path)
+					if leaf.mode == ModeTree {
+						sb.WriteString("/")
+					}
+					sb.WriteString("\n")
+				}
+			case TypeBlob:
+				bs, err := obj.Serialize()
+				if err != nil {
+					log.Panic(err)
+				}
+				sb.WriteString(bs)
+			}
+			shown = shown || format != TypeBlob
+		}
+	}
+	return sb.String()
+}
+
+// LsTreeOptions selects the entries "ls-tree" lists and how.
+type LsTreeOptions struct {
+	Recursive bool
+	// ShowTrees lists the trees opened when recursing.
+	ShowTrees bool
+	TreesOnly bool
+	NameOnly  bool
+	// ObjectOnly lists only object names.
+	ObjectOnly    bool
+	Long          bool
+	NullTerminate bool
+	// FullName shows paths from the root of the tree instead of from the
+	// current directory, and FullTree also ignores the current directory
+	// when reading path arguments.
+	FullName bool
+	FullTree bool
+	Abbrev   int
+	Format   string
+}
+
+// ListTree lists the entries of a tree-ish, limited to paths when some
+// are given. Both the paths and the listed names are relative to the
+// current directory.
+func ListTree(rev string, paths []string, opts LsTreeOptions) string {
+	repo := findRepo(".")
+	treeSHA, err := resolveRev(repo, rev, TypeTree)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	prefix := ""
+	if !opts.FullTree {
+		if prefix, err = repo.relativePath("."); err != nil {
+			log.Panic(err)
+		}
+		if prefix == "." {
+			prefix = ""
+		}
+	}
+	specs, err := lsTreeSpecs(prefix, paths)
+	if err != nil {
+		log.Panic(err)
+	}
+	if opts.FullName {
+		prefix = ""
+	}
+
+	format := opts.Format
+	switch {
+	case format != "" && (opts.Long || opts.NameOnly || opts.ObjectOnly):
+		log.Panic("--format can't be combined with other format-altering options")
+	case opts.NameOnly && opts.ObjectOnly, opts.Long && (opts.NameOnly || opts.ObjectOnly):
+		log.Panic("-l, --name-only and --object-only are incompatible")
+	case format != "":
+	case opts.NameOnly:
+		format = lsTreeNameFormat
+	case opts.ObjectOnly:
+		format = lsTreeObjectFormat
+	case opts.Long:
+		format = lsTreeLongFormat
+	default:
+		format = lsTreeDefaultFormat
+	}
+	formatter, err := repo.newLsTreeFormatter(format, prefix, opts)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// -d with -r lists every tree, which are otherwise only opened.
+	if opts.TreesOnly && opts.Recursive {
+		opts.ShowTrees = true
+	}
+	entries, err := repo.listTreeEntries(treeSHA, "", specs, opts)
+	if err != nil {
+		log.Panic(err)
+	}
+	var sb strings.Builder
+	for _, e := range entries {
+		if err := formatter.format(&sb, e); err != nil {
+			log.Panic(err)
+		}
 	}
-	return str
+	return sb.String()
 }
 
 func Checkout(objSHA string, dir string) {
@@ -197,22 +527,34 @@ func Checkout(objSHA string, dir string) {
 func checkoutTree(treeObj Object, dir string) error {
 	tree := treeObj.(*Tree)
 	for _, leaf := range tree.leaves {
-		subObj, err := tree.repo.readObject(leaf.sha)
-		if err != nil {
+		if err := verifyLeafPath(leaf.path); err != nil {
 			return err
 		}
 
 		destPath := path.Join(dir, leaf.path)
-		switch subObj.GetFormat() {
-		case TypeBlob:
-			if err := checkoutBlob(subObj, destPath); err != nil {
+		if err := verifyCheckoutPath(destPath); err != nil {
+			return err
+		}
+		switch leaf.mode {
+		case ModeGitlink:
+			// A gitlink points at a commit of another repository, which we
+			// don't have. Leave an empty directory for the submodule instead.
+			if err := ensureEmptyDir(destPath); err != nil {
+				return err
+			}
+		case ModeTree:
+			subTree := createTree(tree.repo, nil)
+			if err := subTree.Read(leaf.sha); err != nil {
 				return err
 			}
-		case TypeTree:
 			if err := ensureEmptyDir(destPath); err != nil {
 				return err
 			}
-			if err := checkoutTree(subObj, destPath); err != nil {
+			if err := checkoutTree(subTree, destPath); err != nil {
+				return err
+			}
+		default:
+			if err := checkoutBlob(tree.repo, leaf.sha, leaf.mode, destPath); err != nil {
 				return err
 			}
 		}
@@ -220,19 +562,53 @@ func checkoutTree(treeObj Object, dir string) error {
 	return nil
 }
 
-func checkoutBlob(blobObj Object, filePath string) error {
-	fBlob, err := util.CreateFile(filePath)
+// verifyCheckoutPath refuses to write an entry of a tree where something
+// already is. Everything under the destination is created by the checkout,
+// so that can only be an entry of the same name, and a symbolic link or a
+// file there would be followed, possibly out of the destination.
+func verifyCheckoutPath(destPath string) error {
+	_, err := os.Lstat(destPath)
+	if err == nil {
+		return fmt.Errorf("Refusing to check out %v: path already exists", destPath)
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// checkoutBlob streams a blob into filePath, so that large files never
+// have to fit in memory.
+func checkoutBlob(repo *Repository, blobSHA string, mode string, filePath string) error {
+	or, err := repo.openObject(blobSHA)
 	if err != nil {
 		return err
 	}
-	defer fBlob.Close()
+	defer or.Close()
+	if or.format != TypeBlob {
+		return fmt.Errorf("Type is not correct: %v", or.format)
+	}
+
+	if mode == ModeSymlink && repo.configBool("core.symlinks", true) {
+		target, err := ioutil.ReadAll(or)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(string(target), filePath)
+	}
 
-	content, err := blobObj.Serialize()
+	perm := os.FileMode(0666)
+	if mode == ModeExec && repo.configBool("core.filemode", true) {
+		perm = 0777
+	}
+	fBlob, err := util.CreateFileWithMode(filePath, perm)
 	if err != nil {
 		return err
 	}
-	fBlob.WriteString(content)
-	return nil
+	defer fBlob.Close()
+
+	_, err = io.Copy(fBlob, or)
+	return err
 }
 
 func ensureEmptyDir(dir string) error {
@@ -257,258 +633,1874 @@ func ensureEmptyDir(dir string) error {
 }
 
 func getTreeByCommit(commit *Commit) *Tree {
-	for _, kv := range commit.kvlm {
-		if kv.key == "tree" {
-			treeSHA := kv.list[0]
-			tree := createTree(commit.repo, nil)
-			if err := tree.Read(treeSHA); err != nil {
-				log.Panic(err)
-			}
-			return tree
-		}
+	treeSHA := commit.Tree()
+	if treeSHA == "" {
+		return nil
 	}
-	return nil
+	tree := createTree(commit.repo, nil)
+	if err := tree.Read(treeSHA); err != nil {
+		log.Panic(err)
+	}
+	return tree
 }
 
-func ShowRefs(prefix string, withHash bool) string {
-	repo := findRepo(".")
+// ShowRefOptions selects the refs "show-ref" lists and how.
+type ShowRefOptions struct {
+	Heads bool
+	Tags  bool
+	// Head lists HEAD as well.
+	Head bool
+	// Dereference also lists the objects annotated tags peel to, as
+	// "<name>^{}".
+	Dereference bool
+	// Verify takes patterns as full ref names which must all exist.
+	Verify   bool
+	HashOnly bool
+	Abbrev   int
+}
 
-	refs, err := repo.getRefs()
+// warnBrokenRefs tells about the refs left out of a listing because they
+// could not be read.
+func warnBrokenRefs(names []string) {
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "warning: ignoring broken ref %v\n", name)
+	}
+}
+
+// ShowRefs lists the refs matching any of patterns, on whole components
+// from the end of their names, or all of them when there is no pattern.
+// Refs are sorted by name.
+func ShowRefs(patterns []string, opts ShowRefOptions) string {
+	repo := findRepo(".")
+	refs, broken, err := repo.listRefs()
 	if err != nil {
 		log.Panic(err)
 	}
+	warnBrokenRefs(broken)
 
-	// TODO: sort key
-	var sb strings.Builder
-	for k, v := range refs {
-		if strings.HasPrefix(k, prefix) {
-			k = strings.TrimPrefix(k, prefix)
-			if withHash {
-				sb.WriteString(fmt.Sprintf("%v %v\n", v, k))
-			} else {
-				sb.WriteString(fmt.Sprintf("%v\n", k))
+	names := []string{}
+	if opts.Verify {
+		for _, name := range patterns {
+			if name == "HEAD" {
+				sha, err := repo.readRef("HEAD", refs)
+				if err != nil {
+					log.Panicf("'%v' - not a valid ref", name)
+				}
+				refs[name] = sha
+			}
+			if _, ok := refs[name]; !ok || (name != "HEAD" && !strings.HasPrefix(name, "refs/")) {
+				log.Panicf("'%v' - not a valid ref", name)
+			}
+			names = append(names, name)
+		}
+	} else {
+		for name := range refs {
+			switch {
+			case (opts.Heads || opts.Tags) &&
+				!(opts.Heads && strings.HasPrefix(name, "refs/heads/")) &&
+				!(opts.Tags && strings.HasPrefix(name, "refs/tags/")):
+				continue
+			case len(patterns) == 0:
+				names = append(names, name)
+				continue
+			}
+			for _, p := range patterns {
+				if showRefMatches(p, name) {
+					names = append(names, name)
+					break
+				}
+			}
+		}
+		sort.Strings(names)
+		if opts.Head {
+			if sha, err := repo.readRef("HEAD", refs); err == nil {
+				refs["HEAD"] = sha
+				names = append([]string{"HEAD"}, names...)
 			}
 		}
-	}
-	return sb.String()
-}
-
-func ShowOrNewTag(tagName string, objSHA string, isCreateObject bool) string {
-	if tagName == "" {
-		return ShowRefs("refs/tags/", false)
 	}
 
-	// TODO: add tag
-	repo := findRepo(".")
-	if !isCreateObject {
-		if err := repo.writeRef(path.Join("refs", "tags", tagName), objSHA); err != nil {
-			log.Panic(err)
+	var sb strings.Builder
+	show := func(sha string, name string) {
+		if opts.Abbrev > 0 && opts.Abbrev < len(sha) {
+			sha = sha[:opts.Abbrev]
 		}
-	} else {
-		tag := createTag(repo, nil)
-		tag.kvlm = []KList{
-			KList{key: "object", list: []string{objSHA}},
-			KList{key: "type", list: []string{TypeCommit}},
-			KList{key: "tag", list: []string{"vincent"}},
-			KList{key: "tagger", list: []string{"vincent <pencil.xu@gmail.com>"}},
-			KList{key: "", list: []string{"This is the commit message that should have come from the user\n"}},
+		if opts.HashOnly {
+			sb.WriteString(sha + "\n")
+		} else {
+			sb.WriteString(fmt.Sprintf("%v %v\n", sha, name))
 		}
-
-		sha, err := tag.Save()
+	}
+	for _, name := range names {
+		sha := refs[name]
+		show(sha, name)
+		if !opts.Dereference {
+			continue
+		}
+		peeled, err := peelRev(repo, sha, "")
 		if err != nil {
 			log.Panic(err)
 		}
-
-		if err := repo.writeRef(path.Join("refs", "tags", tagName), sha); err != nil {
-			log.Panic(err)
+		if peeled != sha {
+			show(peeled, name+"^{}")
 		}
 	}
-	return ""
+	return sb.String()
 }
 
-func RevParse(objRev, revType string) string {
+// ForEachRefOptions selects the refs "for-each-ref" lists and how.
+type ForEachRefOptions struct {
+	// Format renders each ref, with atoms such as "%(refname:short)".
+	Format string
+	// Sort holds sort keys, the last one taking precedence.
+	Sort  []string
+	Count int
+	// Contains, NoContains, Merged and NoMerged keep the refs whose commit
+	// contains or is merged into the given commit, or not.
+	Contains   string
+	NoContains string
+	Merged     string
+	NoMerged   string
+	// PointsAt keeps the refs pointing at an object, directly or through a
+	// tag.
+	PointsAt string
+}
+
+// ForEachRef lists the refs matching any of patterns, either as leading
+// components of their names or as globs, or all of them when there is
+// no pattern.
+func ForEachRef(patterns []string, opts ForEachRefOptions) string {
 	repo := findRepo(".")
-	candidates, err := resolveObjectRev(repo, objRev)
+	format := opts.Format
+	if format == "" {
+		format = "%(objectname) %(objecttype)\t%(refname)"
+	}
+	f, err := parseRefFormat(format)
 	if err != nil {
 		log.Panic(err)
 	}
+	keys := []refSortKey{}
+	for i := len(opts.Sort) - 1; i >= 0; i-- {
+		key, err := parseRefSortKey(opts.Sort[i])
+		if err != nil {
+			log.Panic(err)
+		}
+		keys = append(keys, key)
+	}
 
-	if len(candidates) == 0 {
-		log.Panicf("No such reference %v.", objRev)
+	refs, broken, err := repo.listRefs()
+	if err != nil {
+		log.Panic(err)
 	}
-	if len(candidates) > 1 {
-		log.Panicf("Ambiguous reference %v: Candidates are:\n%v.", objRev, strings.Join(candidates, "\n"))
+	warnBrokenRefs(broken)
+	headRef, err := repo.headTarget()
+	if err != nil {
+		log.Panic(err)
+	}
+	filter, err := repo.newRefCommitFilter(opts)
+	if err != nil {
+		log.Panic(err)
 	}
 
-	hash := candidates[0]
-	for {
-		obj, err := repo.readObject(hash)
+	items := []*refItem{}
+	for name, sha := range refs {
+		matched := len(patterns) == 0
+		for _, p := range patterns {
+			matched = matched || forEachRefMatches(p, name)
+		}
+		if !matched {
+			continue
+		}
+		keep, err := filter(sha)
 		if err != nil {
 			log.Panic(err)
 		}
-
-		if revType != "" && obj.GetFormat() == revType {
-			break
+		if keep {
+			items = append(items, &refItem{repo: repo, refs: refs, headRef: headRef, name: name, sha: sha})
 		}
-
-		if obj.GetFormat() == TypeTag {
-			isFollowed := false
-			tag := obj.(*Tag)
-			for _, kv := range tag.kvlm {
-				if kv.key == "object" {
-					hash = kv.list[0]
-					isFollowed = true
-					break
+	}
+	if err := sortRefItems(items, keys); err != nil {
+		log.Panic(err)
+	}
+	if opts.Count > 0 && opts.Count < len(items) {
+		items = items[:opts.Count]
+	}
+
+	var sb strings.Builder
+	for _, it := range items {
+		line, err := it.format(f)
+		if err != nil {
+			log.Panic(err)
+		}
+		sb.WriteString(line + "\n")
+	}
+	return sb.String()
+}
+
+// ReadSymbolicRef returns the ref that the symbolic ref name leads to, in
+// short form when asked to. ok is false when name isn't a symbolic ref.
+func ReadSymbolicRef(name string, short bool) (target string, ok bool) {
+	repo := findRepo(".")
+	target, err := repo.resolveSymbolicRef(name)
+	if os.IsNotExist(err) {
+		log.Panicf("No such ref: %v", name)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	if target == "" {
+		return "", false
+	}
+	if short {
+		refs, err := repo.getRefs()
+		if err != nil {
+			log.Panic(err)
+		}
+		target = shortenRefName(target, refs)
+	}
+	return target, true
+}
+
+// UpdateSymbolicRef makes the symbolic ref name point to the ref target.
+func UpdateSymbolicRef(name string, target string) {
+	repo := findRepo(".")
+	if err := checkRefName(name); err != nil {
+		log.Panic(err)
+	}
+	if err := repo.writeSymbolicRef(name, target); err != nil {
+		log.Panic(err)
+	}
+}
+
+// DeleteSymbolicRef deletes the symbolic ref name. HEAD can't be deleted.
+func DeleteSymbolicRef(name string) {
+	repo := findRepo(".")
+	if name == "HEAD" {
+		log.Panicf("deleting '%v' is not allowed", name)
+	}
+	target, err := repo.symbolicRefTarget(name)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	if target == "" {
+		log.Panicf("Cannot delete %v, not a symbolic ref", name)
+	}
+	if err := repo.deleteRef(name); err != nil {
+		log.Panic(err)
+	}
+}
+
+// WorktreeAddOptions selects the branch a new worktree checks out.
+// NewBranch creates a branch, and ResetBranch creates or resets one.
+type WorktreeAddOptions struct {
+	NewBranch   string
+	ResetBranch string
+	Detach      bool
+	// Force allows checking out a branch that is already checked out in
+	// another worktree.
+	Force bool
+}
+
+// WorktreeAdd creates a linked worktree at wtPath, sharing the objects
+// and refs of the repository, and checks out commitish there. Without
+// commitish, a branch named after the directory is checked out, and
+// created from HEAD if it doesn't exist.
+func WorktreeAdd(wtPath string, commitish string, opts WorktreeAddOptions) string {
+	repo := findRepo(".")
+	if ok, _ := util.IsExist(wtPath); ok {
+		if empty, _ := util.IsEmptyDir(wtPath); !empty {
+			log.Panicf("'%v' already exists", wtPath)
+		}
+	}
+
+	newBranch, reset := opts.NewBranch, false
+	if opts.ResetBranch != "" {
+		newBranch, reset = opts.ResetBranch, true
+	}
+	branch := ""
+	if newBranch == "" && !opts.Detach {
+		name := commitish
+		if name == "" {
+			name = filepath.Base(wtPath)
+		}
+		if _, err := repo.readRef("refs/heads/"+name, nil); err == nil {
+			branch = name
+		} else if commitish == "" {
+			newBranch = name
+		}
+	}
+	// A branch is named in full, as a remote branch may have its name.
+	start := commitish
+	if branch != "" {
+		start = "refs/heads/" + branch
+	} else if start == "" {
+		start = "HEAD"
+	}
+	commit, err := resolveRev(repo, start, TypeCommit)
+	if err != nil {
+		log.Panicf("invalid reference: %v", start)
+	}
+
+	checkFree := func(name string) {
+		if opts.Force {
+			return
+		}
+		wt, err := repo.checkedOutAt("refs/heads/" + name)
+		if err != nil {
+			log.Panic(err)
+		}
+		if wt != nil {
+			log.Panicf("'%v' is already checked out at '%v'", name, wt.path)
+		}
+	}
+	switch {
+	case newBranch != "":
+		if err := checkRefName("refs/heads/" + newBranch); err != nil {
+			log.Panicf("'%v' is not a valid branch name", newBranch)
+		}
+		old, err := repo.readRef("refs/heads/"+newBranch, nil)
+		if err == nil && !reset {
+			log.Panicf("a branch named '%v' already exists", newBranch)
+		}
+		if err == nil {
+			checkFree(newBranch)
+			fmt.Fprintf(os.Stderr, "Preparing worktree (resetting branch '%v'; was at %v)\n", newBranch, old[:7])
+		} else {
+			fmt.Fprintf(os.Stderr, "Preparing worktree (new branch '%v')\n", newBranch)
+		}
+		if err := repo.writeRef("refs/heads/"+newBranch, commit); err != nil {
+			log.Panic(err)
+		}
+		branch = newBranch
+	case branch != "":
+		checkFree(branch)
+		fmt.Fprintf(os.Stderr, "Preparing worktree (checking out '%v')\n", branch)
+	default:
+		fmt.Fprintf(os.Stderr, "Preparing worktree (detached HEAD %v)\n", commit[:7])
+	}
+
+	ref := ""
+	if branch != "" {
+		ref = "refs/heads/" + branch
+	}
+	if err := repo.addWorktree(wtPath, ref, commit); err != nil {
+		log.Panic(err)
+	}
+	obj, err := repo.readObject(commit)
+	if err != nil {
+		log.Panic(err)
+	}
+	return fmt.Sprintf("HEAD is now at %v %v\n", commit[:7], commitSubject(obj.(*Commit).Message()))
+}
+
+// WorktreeList describes each worktree, the main one first: its path, its
+// commit and its branch. The porcelain format has one attribute per line,
+// and a blank line after each worktree.
+func WorktreeList(porcelain bool) string {
+	repo := findRepo(".")
+	list, err := repo.worktrees()
+	if err != nil {
+		log.Panic(err)
+	}
+	sort.SliceStable(list[1:], func(i, j int) bool {
+		return list[1+i].path < list[1+j].path
+	})
+	zero := strings.Repeat("0", repo.objectFormat().HexSize())
+
+	var sb strings.Builder
+	if porcelain {
+		for _, wt := range list {
+			sb.WriteString(fmt.Sprintf("worktree %v\n", wt.path))
+			switch {
+			case wt.bare:
+				sb.WriteString("bare\n")
+			default:
+				head := wt.head
+				if head == "" {
+					head = zero
+				}
+				sb.WriteString(fmt.Sprintf("HEAD %v\n", head))
+				if wt.branch != "" {
+					sb.WriteString(fmt.Sprintf("branch %v\n", wt.branch))
+				} else {
+					sb.WriteString("detached\n")
+				}
+			}
+			if wt.locked {
+				sb.WriteString(strings.TrimSpace("locked "+wt.lockReason) + "\n")
+			}
+			if wt.prunable != "" {
+				sb.WriteString(fmt.Sprintf("prunable %v\n", wt.prunable))
+			}
+			sb.WriteString("\n")
+		}
+		return sb.String()
+	}
+
+	width := 0
+	for _, wt := range list {
+		if len(wt.path) > width {
+			width = len(wt.path)
+		}
+	}
+	for _, wt := range list {
+		sb.WriteString(fmt.Sprintf("%-*v ", width+1, wt.path))
+		switch {
+		case wt.bare:
+			sb.WriteString("(bare)")
+		default:
+			head := wt.head
+			if head == "" {
+				head = zero
+			}
+			sb.WriteString(head[:7] + " ")
+			if wt.branch != "" {
+				sb.WriteString(fmt.Sprintf("[%v]", strings.TrimPrefix(wt.branch, "refs/heads/")))
+			} else {
+				sb.WriteString("(detached HEAD)")
+			}
+		}
+		if wt.locked {
+			sb.WriteString(" locked")
+		}
+		if wt.prunable != "" {
+			sb.WriteString(" prunable")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// WorktreeRemove deletes a linked worktree. One force allows deleting a
+// worktree with changes, and two a locked one.
+func WorktreeRemove(wtPath string, force int) {
+	repo := findRepo(".")
+	wt, err := repo.findWorktree(wtPath)
+	if err != nil {
+		log.Panic(err)
+	}
+	if wt.isMain() {
+		log.Panicf("'%v' is a main working tree", wtPath)
+	}
+	if wt.locked && force < 2 {
+		if wt.lockReason != "" {
+			log.Panicf("cannot remove a locked working tree, lock reason: %v\nuse 'remove -f -f' to override or unlock first", wt.lockReason)
+		}
+		log.Panic("cannot remove a locked working tree;\nuse 'remove -f -f' to override or unlock first")
+	}
+
+	if ok, _ := util.IsExist(wt.path); ok && force == 0 {
+		wtRepo, err := openRepository(wt.path, path.Join(repo.commonDir, "worktrees", wt.id))
+		if err != nil {
+			log.Panic(err)
+		}
+		clean, err := wtRepo.isWorkTreeClean()
+		if err != nil {
+			log.Panic(err)
+		}
+		if !clean {
+			log.Panicf("'%v' contains modified or untracked files, use --force to delete it", wtPath)
+		}
+	}
+	if err := repo.removeWorktree(wt); err != nil {
+		log.Panic(err)
+	}
+}
+
+// WorktreePrune deletes what is left of the linked worktrees whose files
+// are gone, telling which when verbose. A dry run only tells.
+func WorktreePrune(dryRun bool, verbose bool) string {
+	repo := findRepo(".")
+	names, err := repo.linkedWorktreeNames()
+	if err != nil {
+		log.Panic(err)
+	}
+	var sb strings.Builder
+	for _, id := range names {
+		reason := repo.pruneReason(id)
+		if reason == "" {
+			continue
+		}
+		if verbose || dryRun {
+			sb.WriteString(fmt.Sprintf("Removing worktrees/%v: %v\n", id, reason))
+		}
+		if !dryRun {
+			if err := repo.deleteWorktreeDir(id); err != nil {
+				log.Panic(err)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Modes of Reset: whether it only moves HEAD, also resets the index, or
+// also resets the work tree.
+const (
+	ResetSoft  = "soft"
+	ResetMixed = "mixed"
+	ResetHard  = "hard"
+)
+
+// ResetOptions selects what Reset changes besides HEAD.
+type ResetOptions struct {
+	Mode  string
+	Quiet bool
+}
+
+// Reset moves the current branch to a commit, and resets the index, and
+// the work tree for a hard reset, to it. With paths, only their entries
+// of the index are reset, from a tree. args are the revision, if any,
+// followed by paths; the revision is told apart from paths by resolving it
+// when paths aren't given separately.
+func Reset(args []string, paths []string, opts ResetOptions) string {
+	repo := findRepo(".")
+	if opts.Mode == "" {
+		opts.Mode = ResetMixed
+	}
+	rev := "HEAD"
+	switch {
+	case paths != nil:
+		if len(args) > 1 {
+			log.Panicf("ambiguous argument '%v': unknown revision", args[1])
+		}
+		if len(args) == 1 {
+			rev = args[0]
+		}
+	case len(args) > 0:
+		if _, err := resolveRev(repo, args[0], ""); err == nil {
+			rev, paths = args[0], args[1:]
+		} else {
+			paths = args
+		}
+		// Without "--", paths must be files of the work tree.
+		for _, p := range paths {
+			if _, err := os.Lstat(p); err != nil {
+				log.Panicf("ambiguous argument '%v': unknown revision or path not in the working tree.\n"+
+					"Use '--' to separate paths from revisions, like this:\n"+
+					"'pit <command> [<revision>...] -- [<file>...]'", p)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		paths = nil
+	}
+
+	if paths != nil && opts.Mode != ResetMixed {
+		log.Panicf("Cannot do %v reset with paths.", opts.Mode)
+	}
+	if opts.Mode == ResetMixed && repo.isBare() {
+		log.Panic("mixed reset is not allowed in a bare repository")
+	}
+	if opts.Mode == ResetHard {
+		if err := repo.requireWorkTree(); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	revType := TypeCommit
+	if paths != nil {
+		revType = TypeTree
+	}
+	sha, err := resolveRev(repo, rev, revType)
+	if err != nil {
+		log.Panicf("Failed to resolve '%v' as a valid revision.", rev)
+	}
+	if opts.Mode == ResetSoft {
+		if err := repo.moveHead(sha); err != nil {
+			log.Panic(err)
+		}
+		return ""
+	}
+
+	treeSHA, err := resolveRev(repo, sha, TypeTree)
+	if err != nil {
+		log.Panic(err)
+	}
+	entries, err := repo.treeEntries(treeSHA, "")
+	if err != nil {
+		log.Panic(err)
+	}
+	specs, err := parsePathspecs(repoPaths(repo, paths))
+	if err != nil {
+		log.Panic(err)
+	}
+	idx, err := repo.readIndex()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var sb strings.Builder
+	if opts.Mode == ResetHard {
+		if err := repo.resetHard(idx, entries); err != nil {
+			log.Panic(err)
+		}
+	} else {
+		idx.resetEntries(entries, specs)
+		changes, err := repo.refreshIndex(idx)
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(changes) > 0 && !opts.Quiet {
+			sb.WriteString("Unstaged changes after reset:\n")
+			sb.WriteString(strings.Join(changes, "\n") + "\n")
+		}
+	}
+	if err := idx.write(); err != nil {
+		log.Panic(err)
+	}
+
+	if paths == nil {
+		if err := repo.moveHead(sha); err != nil {
+			log.Panic(err)
+		}
+	}
+	if opts.Mode == ResetHard && !opts.Quiet {
+		obj, err := repo.readObject(sha)
+		if err != nil {
+			log.Panic(err)
+		}
+		sb.WriteString(fmt.Sprintf("HEAD is now at %v %v\n", sha[:7], commitSubject(obj.(*Commit).Message())))
+	}
+	return sb.String()
+}
+
+// RestoreOptions selects where Restore takes content from, and what it
+// restores: the work tree by default, and the index when Staged.
+type RestoreOptions struct {
+	Source   string
+	Staged   bool
+	Worktree bool
+}
+
+// Restore gives paths the content they have in the source tree. The index
+// is the default source of the work tree, and HEAD that of the index.
+// Tracked files missing from a source tree are deleted.
+func Restore(paths []string, opts RestoreOptions) {
+	repo := findRepo(".")
+	if err := repo.requireWorkTree(); err != nil {
+		log.Panic(err)
+	}
+	if len(paths) == 0 {
+		log.Panic("you must specify path(s) to restore")
+	}
+	if !opts.Staged {
+		opts.Worktree = true
+	}
+	specs, err := parsePathspecs(repoPaths(repo, paths))
+	if err != nil {
+		log.Panic(err)
+	}
+	idx, err := repo.readIndex()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// The source is a tree, or else the index itself.
+	var source []*indexEntry
+	switch {
+	case opts.Source != "":
+		treeSHA, err := resolveRev(repo, opts.Source, TypeTree)
+		if err != nil {
+			log.Panicf("could not resolve %v", opts.Source)
+		}
+		if source, err = repo.treeEntries(treeSHA, ""); err != nil {
+			log.Panic(err)
+		}
+	case opts.Staged:
+		if source, err = repo.headTreeEntries(); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	for i, spec := range specs {
+		found := false
+		for _, list := range [][]*indexEntry{source, idx.entries} {
+			for _, e := range list {
+				found = found || spec.matches(e.path)
+			}
+		}
+		if !found {
+			log.Panicf("error: pathspec '%v' did not match any file(s) known to git", paths[i])
+		}
+	}
+
+	var tracked []*indexEntry
+	for _, e := range idx.entries {
+		if e.stage() == 0 && matchPathspecs(specs, e.path) {
+			tracked = append(tracked, e)
+		}
+	}
+	if opts.Staged {
+		idx.resetEntries(source, specs)
+	}
+	if opts.Worktree {
+		if source == nil {
+			source = tracked
+		}
+		wanted := map[string]bool{}
+		for _, e := range source {
+			if !matchPathspecs(specs, e.path) {
+				continue
+			}
+			wanted[e.path] = true
+			// The index keeps the stat data of the file only when it
+			// stages the same content.
+			staged := idx.entry(e.path)
+			target := e
+			if staged != nil && staged.sha == e.sha && staged.mode == e.mode {
+				target = staged
+			}
+			if err := repo.checkoutEntry(target); err != nil {
+				log.Panic(err)
+			}
+		}
+		for _, e := range tracked {
+			if !wanted[e.path] {
+				if err := repo.removeWorkTreeFile(e.path); err != nil {
+					log.Panic(err)
+				}
+			}
+		}
+	}
+	if err := idx.write(); err != nil {
+		log.Panic(err)
+	}
+}
+
+// TagOptions controls how CreateTag builds a tag.
+type TagOptions struct {
+	Annotate   bool
+	Message    string
+	Force      bool
+	Sign       bool
+	NoSign     bool
+	SigningKey string
+}
+
+// CreateTag points refs/tags/<tagName> at rev, through a tag object when
+// the tag is annotated.
+func CreateTag(tagName string, rev string, opts TagOptions) string {
+	repo := findRepo(".")
+	if err := checkRefName("refs/tags/" + tagName); err != nil {
+		log.Panic(err)
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	objSHA, err := resolveName(repo, rev)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	refName := path.Join("refs", "tags", tagName)
+	old, err := repo.readRef(refName, map[string]string{})
+	if err == nil && !opts.Force {
+		log.Panicf("tag '%v' already exists", tagName)
+	}
+
+	sha := objSHA
+	if !opts.NoSign && (opts.SigningKey != "" || repo.configBool("tag.gpgsign", false)) {
+		opts.Sign = true
+	}
+	if opts.Annotate || opts.Message != "" || opts.Sign {
+		sha, err = repo.createTagObject(tagName, objSHA, opts)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	if err := repo.writeRef(refName, sha); err != nil {
+		log.Panic(err)
+	}
+	if old != "" && old != sha {
+		return fmt.Sprintf("Updated tag '%v' (was %v)\n", tagName, old[:7])
+	}
+	return ""
+}
+
+func (r *Repository) createTagObject(tagName string, objSHA string, opts TagOptions) (string, error) {
+	target, err := r.readObject(objSHA)
+	if err != nil {
+		return "", err
+	}
+	tagger, err := r.ident(RoleCommitter)
+	if err != nil {
+		return "", err
+	}
+
+	message := opts.Message
+	if message == "" {
+		template := fmt.Sprintf("\n#\n# Write a message for tag:\n#   %v\n# Lines starting with '#' will be ignored.\n", tagName)
+		message, err = r.editMessage("TAG_EDITMSG", template)
+		if err != nil {
+			return "", err
+		}
+		if message == "" {
+			return "", errors.New("no tag message?")
+		}
+	} else {
+		message = cleanupMessage(message, true)
+	}
+
+	tag := createTag(r, nil)
+	tag.kvlm = []KList{
+		KList{key: "object", list: []string{objSHA}},
+		KList{key: "type", list: []string{target.GetFormat()}},
+		KList{key: "tag", list: []string{tagName}},
+		KList{key: "tagger", list: []string{tagger}},
+		KList{key: "", list: []string{message}},
+	}
+	if opts.Sign {
+		if err := r.signTag(tag, opts.SigningKey); err != nil {
+			return "", err
+		}
+	}
+	return tag.Save()
+}
+
+// CommitTree creates a commit object for treeRev with the given parents.
+// The commit is signed when sign is set, or when commit.gpgSign is on and
+// noSign isn't.
+func CommitTree(treeRev string, parentRevs []string, message string, sign bool, noSign bool, keyID string) string {
+	repo := findRepo(".")
+	treeSHA, err := resolveRev(repo, treeRev, TypeTree)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	commit := createCommit(repo, nil)
+	commit.kvlm = append(commit.kvlm, KList{key: "tree", list: []string{treeSHA}})
+	parents := KList{key: "parent", list: []string{}}
+	for _, p := range parentRevs {
+		parentSHA, err := resolveRev(repo, p, TypeCommit)
+		if err != nil {
+			log.Panic(err)
+		}
+		parents.list = append(parents.list, parentSHA)
+	}
+	if len(parents.list) > 0 {
+		commit.kvlm = append(commit.kvlm, parents)
+	}
+
+	author, err := repo.ident(RoleAuthor)
+	if err != nil {
+		log.Panic(err)
+	}
+	committer, err := repo.ident(RoleCommitter)
+	if err != nil {
+		log.Panic(err)
+	}
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	commit.kvlm = append(commit.kvlm,
+		KList{key: "author", list: []string{author}},
+		KList{key: "committer", list: []string{committer}},
+		KList{key: "", list: []string{message}},
+	)
+
+	if !noSign && (sign || keyID != "" || repo.configBool("commit.gpgsign", false)) {
+		if err := repo.signCommit(commit, keyID); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	sha, err := commit.Save()
+	if err != nil {
+		log.Panic(err)
+	}
+	return sha
+}
+
+// DeleteTags removes the given tags and reports what each one pointed to.
+func DeleteTags(tagNames []string) string {
+	repo := findRepo(".")
+	var sb strings.Builder
+	for _, tagName := range tagNames {
+		refName := path.Join("refs", "tags", tagName)
+		sha, err := repo.readRef(refName, map[string]string{})
+		if err != nil {
+			log.Panicf("tag '%v' not found.", tagName)
+		}
+		if err := repo.deleteRef(refName); err != nil {
+			log.Panic(err)
+		}
+		sb.WriteString(fmt.Sprintf("Deleted tag '%v' (was %v)\n", tagName, sha[:7]))
+	}
+	return sb.String()
+}
+
+// ListTags prints the tags matching any of patterns, or all of them when
+// there is no pattern. sortKey follows git's --sort: "refname",
+// "version:refname", "creatordate" or "objectname", with a leading "-"
+// reversing the order.
+func ListTags(patterns []string, sortKey string) string {
+	repo := findRepo(".")
+	refs, err := repo.getRefs()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	names := []string{}
+	for k := range refs {
+		if !strings.HasPrefix(k, "refs/tags/") {
+			continue
+		}
+		name := strings.TrimPrefix(k, "refs/tags/")
+		if len(patterns) == 0 {
+			names = append(names, name)
+			continue
+		}
+		for _, p := range patterns {
+			if util.MatchGlob(p, name, false) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+
+	if err := repo.sortTags(names, refs, sortKey); err != nil {
+		log.Panic(err)
+	}
+
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func (r *Repository) sortTags(names []string, refs map[string]string, sortKey string) error {
+	reverse := strings.HasPrefix(sortKey, "-")
+	sortKey = strings.TrimPrefix(sortKey, "-")
+
+	var less func(a, b string) bool
+	switch sortKey {
+	case "", "refname":
+		less = func(a, b string) bool { return a < b }
+	case "version:refname", "v:refname":
+		less = func(a, b string) bool { return util.CompareVersions(a, b) < 0 }
+	case "objectname":
+		less = func(a, b string) bool { return refs["refs/tags/"+a] < refs["refs/tags/"+b] }
+	case "creatordate", "taggerdate":
+		dates := map[string]int64{}
+		for _, name := range names {
+			dates[name] = r.creatorDate(refs["refs/tags/"+name])
+		}
+		less = func(a, b string) bool {
+			if dates[a] != dates[b] {
+				return dates[a] < dates[b]
+			}
+			return a < b
+		}
+	default:
+		return fmt.Errorf("unsupported sort specification '%v'", sortKey)
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		if reverse {
+			return less(names[j], names[i])
+		}
+		return less(names[i], names[j])
+	})
+	return nil
+}
+
+// creatorDate returns the tagger date of a tag object, or the committer
+// date of a commit, as a unix timestamp.
+func (r *Repository) creatorDate(sha string) int64 {
+	obj, err := r.readObject(sha)
+	if err != nil {
+		return 0
+	}
+	var sig Signature
+	switch o := obj.(type) {
+	case *Tag:
+		sig, err = o.Tagger()
+	case *Commit:
+		sig, err = o.Committer()
+	default:
+		return 0
+	}
+	if err != nil || sig.When.IsZero() {
+		return 0
+	}
+	return sig.When.Unix()
+}
+
+func RevParse(objRev, revType string) string {
+	repo := findRepo(".")
+	hash, err := resolveRev(repo, objRev, revType)
+	if err != nil {
+		log.Panic(err)
+	}
+	return hash
+}
+
+// resolveName turns a revision name into an object name, without peeling
+// tags.
+func resolveName(repo *Repository, objRev string) (string, error) {
+	if i := strings.Index(objRev, ":"); i >= 0 {
+		return resolveTreePath(repo, objRev[:i], objRev[i+1:])
+	}
+	name, suffix := objRev, ""
+	if i := strings.IndexAny(objRev, "~^"); i > 0 {
+		name, suffix = objRev[:i], objRev[i:]
+	}
+	candidates, err := resolveObjectRev(repo, name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("No such reference %v.", objRev)
+	}
+	if len(candidates) > 1 {
+		return "", &ambiguousError{rev: objRev, candidates: candidates}
+	}
+	return resolveRevSuffix(repo, candidates[0], suffix)
+}
+
+// ambiguousError is returned when a revision names several objects.
+type ambiguousError struct {
+	rev        string
+	candidates []string
+}
+
+func (e *ambiguousError) Error() string {
+	return fmt.Sprintf("Ambiguous reference %v: Candidates are:\n%v.", e.rev, strings.Join(e.candidates, "\n"))
+}
+
+// resolveTreePath resolves "<rev>:<path>", the object at path in the tree
+// of rev. Paths starting with "./" or "../" are relative to the current
+// directory, others to the root of the tree.
+func resolveTreePath(repo *Repository, rev string, filePath string) (string, error) {
+	if rev == "" {
+		return "", fmt.Errorf("No index to read %v from.", ":"+filePath)
+	}
+	tree, err := resolveRev(repo, rev, TypeTree)
+	if err != nil {
+		return "", err
+	}
+	if filePath == "." || filePath == ".." || strings.HasPrefix(filePath, "./") || strings.HasPrefix(filePath, "../") {
+		if filePath, err = repo.relativePath(filePath); err != nil {
+			return "", err
+		}
+		if filePath == "." {
+			filePath = ""
+		}
+	}
+	leaf, ok, err := repo.lookupPath(tree, filePath)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("Path '%v' does not exist in '%v'.", filePath, rev)
+	}
+	return leaf.sha, nil
+}
+
+var revSuffixRegex = regexp.MustCompile(`^(?:~(\d*)|\^\{(\w*)\}|\^(\d*))`)
+
+// resolveRevSuffix applies the navigation suffixes of a revision to hash:
+// "~<n>" for the n-th first-parent ancestor, "^<n>" for the n-th parent,
+// "^{<type>}" to peel to a type and "^{}" to peel tags.
+func resolveRevSuffix(repo *Repository, hash string, suffix string) (string, error) {
+	for suffix != "" {
+		m := revSuffixRegex.FindStringSubmatch(suffix)
+		if m == nil {
+			return "", fmt.Errorf("Invalid revision suffix %v", suffix)
+		}
+		suffix = suffix[len(m[0]):]
+
+		var err error
+		switch {
+		case strings.HasPrefix(m[0], "^{"):
+			hash, err = peelRev(repo, hash, m[2])
+		case strings.HasPrefix(m[0], "~"):
+			n := 1
+			if m[1] != "" {
+				n, _ = strconv.Atoi(m[1])
+			}
+			// Even "~0" names a commit, not the tag pointing to it.
+			hash, err = peelRev(repo, hash, TypeCommit)
+			for ; n > 0 && err == nil; n-- {
+				hash, err = nthParent(repo, hash, 1)
+			}
+		default:
+			n := 1
+			if m[3] != "" {
+				n, _ = strconv.Atoi(m[3])
+			}
+			if n > 0 {
+				hash, err = nthParent(repo, hash, n)
+			} else {
+				hash, err = peelRev(repo, hash, TypeCommit)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hash, nil
+}
+
+func nthParent(repo *Repository, hash string, n int) (string, error) {
+	hash, err := peelRev(repo, hash, TypeCommit)
+	if err != nil {
+		return "", err
+	}
+	commit := createCommit(repo, nil)
+	if err := commit.Read(hash); err != nil {
+		return "", err
+	}
+	parents := commit.Parents()
+	if n > len(parents) {
+		return "", fmt.Errorf("Commit %v has no parent %v", hash, n)
+	}
+	return parents[n-1], nil
+}
+
+// resolveRev resolves objRev and peels it to revType.
+func resolveRev(repo *Repository, objRev, revType string) (string, error) {
+	hash, err := resolveName(repo, objRev)
+	if err != nil {
+		return "", err
+	}
+	return peelRev(repo, hash, revType)
+}
+
+// peelRev follows tags, and commits to their tree, until it reaches an
+// object of revType, or a non-tag object when revType is empty.
+func peelRev(repo *Repository, hash, revType string) (string, error) {
+	for {
+		obj, err := repo.readObject(hash)
+		if err != nil {
+			return "", err
+		}
+
+		if revType != "" && obj.GetFormat() == revType {
+			break
+		}
+
+		if obj.GetFormat() == TypeTag {
+			if target := obj.(*Tag).Object(); target != "" {
+				hash = target
+				continue
+			}
+		}
+		if obj.GetFormat() == TypeCommit && revType == TypeTree {
+			if tree := obj.(*Commit).Tree(); tree != "" {
+				hash = tree
+				continue
+			}
+		}
+
+		if revType != "" && obj.GetFormat() != revType {
+			return "", errors.New("wrong rev type")
+		}
+		break
+	}
+	return hash, nil
+}
+
+func resolveObjectRev(repo *Repository, objRev string) ([]string, error) {
+	if objRev == "" {
+		return []string{}, nil
+	}
+
+	if objRev == "HEAD" {
+		hash, err := repo.readRef(objRev, make(map[string]string))
+		if err != nil {
+			return nil, err
+		}
+		return []string{hash}, nil
+	}
+
+	// ORIG_HEAD and the other pseudo-refs are in the git directory of the
+	// worktree, next to HEAD. A missing one may still name a branch or tag.
+	if isPseudoRefName(objRev) {
+		hash, err := repo.readRef(objRev, make(map[string]string))
+		if err == nil {
+			return []string{hash}, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	// The refs of other worktrees are named through main-worktree/ and
+	// worktrees/<id>/.
+	if strings.HasPrefix(objRev, "main-worktree/") || strings.HasPrefix(objRev, "worktrees/") {
+		if hash, err := repo.readRef(objRev, make(map[string]string)); err == nil {
+			return []string{hash}, nil
+		}
+	}
+
+	if objRev == "master" {
+		objRev = path.Join("refs", "heads", "master")
+	}
+
+	split := func(c rune) bool {
+		return c == '/' || c == '\\'
+	}
+	inputSegments := strings.FieldsFunc(objRev, split)
+
+	refs, err := repo.getRefs()
+	if err != nil {
+		return nil, err
+	}
+	candidates := []string{}
+	for key, hash := range refs {
+		keySegments := strings.FieldsFunc(key, split)
+		if matchSegments(inputSegments, keySegments) {
+			candidates = append(candidates, hash)
+		}
+	}
+
+	hexSize := repo.objectFormat().HexSize()
+	hashRegex := regexp.MustCompile(fmt.Sprintf(`^[0-9A-Fa-f]{4,%v}$`, hexSize))
+	if hashRegex.MatchString(objRev) {
+		objRev = strings.ToLower(objRev)
+		if len(objRev) == hexSize {
+			candidates = append(candidates, objRev)
+		} else {
+			prefix := objRev[:2]
+			dir := path.Join(repo.commonDir, "objects", prefix)
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			for _, e := range entries {
+				if strings.HasPrefix(e.Name(), objRev[2:]) {
+					candidates = append(candidates, prefix+e.Name())
+				}
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// isPseudoRefName tells whether name is that of a ref at the top of the
+// git directory, like ORIG_HEAD or FETCH_HEAD: upper case letters and
+// underscores.
+func isPseudoRefName(name string) bool {
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && c != '_' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func matchSegments(srcSegments, dstSegments []string) bool {
+	i, j := len(srcSegments)-1, len(dstSegments)-1
+	for i >= 0 && j >= 0 {
+		if srcSegments[i] != dstSegments[j] {
+			return false
+		}
+		i, j = i-1, j-1
+	}
+	if i >= 0 {
+		return false
+	}
+	return true
+}
+
+// repoPaths makes pathspecs given relative to the current directory
+// relative to the top of the work tree.
+func repoPaths(repo *Repository, paths []string) []string {
+	out := []string{}
+	for _, p := range paths {
+		repoPath, err := repo.relativePath(p)
+		if err != nil {
+			log.Panic(err)
+		}
+		out = append(out, repoPath)
+	}
+	return out
+}
+
+func signatureLines(repo *Repository, sha string) []string {
+	result, err := repo.verifyObject(sha, TypeCommit)
+	if err != nil {
+		result = err.Error()
+	}
+	return strings.Split(strings.TrimRight(result, "\n"), "\n")
+}
+
+// textLog writes each commit of the walk like "git log", followed by its
+// diffstat and patch against its parent, with the history graph on the
+// left when asked for. Merges are only diffed against their first parent
+// when the walk follows first parents, or else against all of them in the
+// combined format when asked for.
+func textLog(sb *strings.Builder, repo *Repository, walk *RevWalk, opts LogOptions) {
+	var graph *logGraph
+	if opts.Graph {
+		graph = newLogGraph(func(sha string) []string {
+			return walk.rewriteParents(walk.commits[sha])
+		})
+	}
+	decorations := map[string][]string{}
+	if opts.Decorate {
+		var err error
+		if decorations, err = repo.decorations(); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	first := true
+	for {
+		c, err := walk.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		commit, err := c.Commit()
+		if err != nil {
+			log.Panic(err)
+		}
+		graph.update(c.SHA)
+
+		if opts.Oneline {
+			graph.showCommit(sb)
+			sb.WriteString(c.SHA[:7] + formatDecorations(decorations[c.SHA]) + " ")
+			graph.showText(sb, commitSubject(commit.Message()))
+			sb.WriteString("\n")
+		} else {
+			if !first {
+				sb.WriteString(graph.paddingLine() + "\n")
+			}
+			first = false
+			graph.showCommit(sb)
+			sb.WriteString(fmt.Sprintf("commit %v%v\n", c.SHA, formatDecorations(decorations[c.SHA])))
+			prefix, _ := graph.nextLine()
+			sb.WriteString(prefix)
+
+			var header strings.Builder
+			if opts.ShowSignature {
+				for _, line := range signatureLines(repo, c.SHA) {
+					header.WriteString(line + "\n")
 				}
 			}
-			if isFollowed {
-				continue
+			if len(c.Parents) > 1 {
+				short := []string{}
+				for _, p := range c.Parents {
+					short = append(short, p[:7])
+				}
+				header.WriteString(fmt.Sprintf("Merge: %v\n", strings.Join(short, " ")))
+			}
+			if author, err := commit.Author(); err == nil {
+				header.WriteString(fmt.Sprintf("Author: %v <%v>\n", author.Name, author.Email))
+				header.WriteString(fmt.Sprintf("Date:   %v\n", author.When.Format("Mon Jan 2 15:04:05 2006 -0700")))
 			}
+			header.WriteString("\n")
+			for _, line := range strings.Split(strings.TrimRight(commit.Message(), "\n"), "\n") {
+				header.WriteString("    " + line + "\n")
+			}
+			graph.showText(sb, header.String())
 		}
-		if obj.GetFormat() == TypeCommit && revType == TypeTree {
-			isFollowed := false
-			commit := obj.(*Commit)
-			for _, kv := range commit.kvlm {
-				if kv.key == "tree" {
-					hash = kv.list[0]
-					isFollowed = true
-					break
-				}
+
+		if !opts.Patch && !opts.Stat {
+			continue
+		}
+		// Merges show no diff, unless with --first-parent, or with --cc:
+		// their combined patch, or with --stat only their stat against
+		// the first parent.
+		merge := len(c.Parents) > 1 && (!opts.FirstParent || opts.Combined)
+		patch := opts.Patch
+		if merge {
+			if !opts.Combined {
+				continue
 			}
-			if isFollowed {
+			if !opts.Stat {
+				graph.showLines(sb, combinedLog(repo, walk, c))
 				continue
 			}
+			patch = false
+		}
+		parent, parentTree := "", ""
+		if len(c.Parents) > 0 {
+			p, err := walk.lookup(c.Parents[0])
+			if err != nil {
+				log.Panic(err)
+			}
+			parent, parentTree = p.SHA, p.Tree
+		}
+		files, err := repo.diffFiles(parentTree, c.Tree, walk.diffSpecs(c, parent))
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(files) == 0 {
+			continue
+		}
+		var diff strings.Builder
+		if !opts.Oneline || merge {
+			if opts.Stat && patch {
+				diff.WriteString("---")
+			}
+			diff.WriteString("\n")
+		}
+		if opts.Stat {
+			if err := repo.writeStat(&diff, files); err != nil {
+				log.Panic(err)
+			}
+		}
+		if patch {
+			if opts.Stat {
+				diff.WriteString("\n")
+			}
+			if err := repo.writePatch(&diff, files); err != nil {
+				log.Panic(err)
+			}
 		}
+		graph.showLines(sb, diff.String())
+	}
+}
 
-		if revType != "" && obj.GetFormat() != revType {
-			log.Panic("wrong rev type")
+// combinedLog returns the combined patch of a merge, if any. Like git, it
+// is separated from the message by a blank line even when it is empty.
+func combinedLog(repo *Repository, walk *RevWalk, c *RevCommit) string {
+	parentTrees := []string{}
+	for _, sha := range c.Parents {
+		p, err := walk.lookup(sha)
+		if err != nil {
+			log.Panic(err)
 		}
-		break
+		parentTrees = append(parentTrees, p.Tree)
 	}
-	return hash
+	files, err := repo.combinedFiles(c.Tree, parentTrees)
+	if err != nil {
+		log.Panic(err)
+	}
+	var patch strings.Builder
+	if err := repo.writeCombinedPatch(&patch, files); err != nil {
+		log.Panic(err)
+	}
+	return "\n" + patch.String()
 }
 
-func resolveObjectRev(repo *Repository, objRev string) ([]string, error) {
-	if objRev == "" {
-		return []string{}, nil
+// commitSubject joins the lines of the first paragraph of a message.
+func commitSubject(message string) string {
+	paragraph := strings.SplitN(strings.TrimLeft(message, "\n"), "\n\n", 2)[0]
+	return strings.Join(strings.Fields(strings.Replace(paragraph, "\n", " ", -1)), " ")
+}
+
+type RevListOptions struct {
+	RevWalkOptions
+	Count     bool
+	Objects   bool
+	LeftRight bool
+}
+
+func RevList(revs []string, opts RevListOptions) string {
+	repo := findRepo(".")
+	opts.Paths = repoPaths(repo, opts.Paths)
+	walk := newRevWalk(repo, opts.RevWalkOptions)
+	for _, rev := range revs {
+		if err := walk.Push(rev); err != nil {
+			log.Panic(err)
+		}
 	}
 
-	if objRev == "HEAD" {
-		hash, err := repo.readRef(objRev, make(map[string]string))
+	var sb strings.Builder
+	commits := []*RevCommit{}
+	for {
+		c, err := walk.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return nil, err
+			log.Panic(err)
 		}
-		return []string{hash}, nil
+		commits = append(commits, c)
 	}
 
-	if objRev == "master" {
-		objRev = path.Join("refs", "heads", "master")
+	if opts.Count {
+		return fmt.Sprintf("%v\n", len(commits))
+	}
+	for _, c := range commits {
+		if opts.LeftRight {
+			if c.Left {
+				sb.WriteString("<")
+			} else {
+				sb.WriteString(">")
+			}
+		}
+		sb.WriteString(c.SHA + "\n")
+	}
+	if opts.Objects {
+		if err := walk.listObjects(&sb, commits); err != nil {
+			log.Panic(err)
+		}
 	}
+	return sb.String()
+}
 
-	split := func(c rune) bool {
-		return c == '/' || c == '\\'
+func CommitGraphWrite(changedPaths bool) string {
+	repo := findRepo(".")
+	n, err := repo.writeCommitGraph(changedPaths)
+	if err != nil {
+		log.Panic(err)
 	}
-	inputSegments := strings.FieldsFunc(objRev, split)
+	return fmt.Sprintf("Wrote a commit-graph with %v commits\n", n)
+}
 
-	refs, err := repo.getRefs()
+// CommitGraphVerify checks the commit-graph file. The second result is
+// false when it has errors.
+func CommitGraphVerify() (string, bool) {
+	repo := findRepo(".")
+	var sb strings.Builder
+	errs := repo.verifyCommitGraph()
+	for _, err := range errs {
+		sb.WriteString(fmt.Sprintf("error: %v\n", err))
+	}
+	return sb.String(), len(errs) == 0
+}
+
+func MergeBase(revA, revB string, all bool) string {
+	repo := findRepo(".")
+	a, err := resolveRev(repo, revA, TypeCommit)
 	if err != nil {
-		return nil, err
+		log.Panic(err)
 	}
-	candidates := []string{}
-	for key, hash := range refs {
-		keySegments := strings.FieldsFunc(key, split)
-		if matchSegments(inputSegments, keySegments) {
-			candidates = append(candidates, hash)
+	b, err := resolveRev(repo, revB, TypeCommit)
+	if err != nil {
+		log.Panic(err)
+	}
+	bases, err := repo.mergeBases(a, b)
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(bases) == 0 {
+		return ""
+	}
+	if !all {
+		bases = bases[:1]
+	}
+	return strings.Join(bases, "\n") + "\n"
+}
+
+// IsAncestor reports whether revA is an ancestor of revB.
+func IsAncestor(revA, revB string) bool {
+	repo := findRepo(".")
+	a, err := resolveRev(repo, revA, TypeCommit)
+	if err != nil {
+		log.Panic(err)
+	}
+	b, err := resolveRev(repo, revB, TypeCommit)
+	if err != nil {
+		log.Panic(err)
+	}
+	lookup := revCommitCache(repo)
+	ca, err := lookup(a)
+	if err != nil {
+		log.Panic(err)
+	}
+	cb, err := lookup(b)
+	if err != nil {
+		log.Panic(err)
+	}
+	reachable, err := isAncestor(ca, cb, lookup)
+	if err != nil {
+		log.Panic(err)
+	}
+	return reachable
+}
+
+// Blame attributes each line of filePath, as of rev, to the commit that
+// last changed it.
+func Blame(filePath string, rev string, opts BlameOptions) string {
+	repo := findRepo(".")
+	repoPath, err := repo.relativePath(filePath)
+	if err != nil {
+		log.Panic(err)
+	}
+	sha, err := resolveRev(repo, rev, TypeCommit)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	b := newBlamer(repo)
+	if opts.IgnoreWhitespace {
+		b.normalize = stripSpaces
+	}
+	ignoreRevs := opts.IgnoreRevs
+	ignoreFile := opts.IgnoreRevsFile
+	if ignoreFile == "" {
+		ignoreFile, _ = repo.configString("blame.ignorerevsfile")
+	}
+	if ignoreFile != "" {
+		revs, err := readIgnoreRevs(expandConfigPath(ignoreFile))
+		if err != nil {
+			log.Panic(err)
+		}
+		ignoreRevs = append(ignoreRevs, revs...)
+	}
+	for _, r := range ignoreRevs {
+		ignored, err := resolveRev(repo, r, TypeCommit)
+		if err != nil {
+			log.Panic(err)
 		}
+		b.ignore[ignored] = true
 	}
 
-	hashRegex := regexp.MustCompile(`^[0-9A-Fa-f]{1,16}$`)
-	if hashRegex.MatchString(objRev) {
-		objRev = strings.ToLower(objRev)
-		if len(objRev) == 40 {
-			candidates = append(candidates, objRev)
-		} else if len(objRev) >= 4 {
-			prefix := objRev[:2]
-			dir := path.Join(repo.gitDir, "objects", prefix)
-			entries, err := ioutil.ReadDir(dir)
+	commit, err := b.lookup(sha)
+	if err != nil {
+		log.Panic(err)
+	}
+	leaf, ok, err := repo.lookupPath(commit.Tree, repoPath)
+	if err != nil {
+		log.Panic(err)
+	}
+	if !ok {
+		log.Panicf("no such path %v in %v", repoPath, rev)
+	}
+	lines, err := b.blobLines(leaf.sha)
+	if err != nil {
+		log.Panic(err)
+	}
+	ranges := [][2]int{{0, len(lines)}}
+	if len(opts.Ranges) > 0 {
+		ranges = ranges[:0]
+		for _, spec := range opts.Ranges {
+			r, err := parseBlameRange(spec, lines)
 			if err != nil {
-				return nil, err
-			}
-			for _, e := range entries {
-				if strings.HasPrefix(e.Name(), objRev[2:]) {
-					candidates = append(candidates, prefix+e.Name())
-				}
+				log.Panic(err)
 			}
+			ranges = append(ranges, r)
 		}
 	}
-	return candidates, nil
+	final, err := b.run(commit, repoPath, ranges)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var sb strings.Builder
+	if opts.Porcelain {
+		err = b.formatBlamePorcelain(&sb, final)
+	} else {
+		err = b.formatBlame(&sb, final, repoPath)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	return sb.String()
 }
 
-func matchSegments(srcSegments, dstSegments []string) bool {
-	i, j := len(srcSegments)-1, len(dstSegments)-1
-	for i >= 0 && j >= 0 {
-		if srcSegments[i] != dstSegments[j] {
-			return false
+// Verify checks the signatures of the named commits or tags. The second
+// result is false when any of them isn't validly signed.
+func Verify(revs []string, objType string) (string, bool) {
+	repo := findRepo(".")
+	var sb strings.Builder
+	ok := true
+	for _, rev := range revs {
+		sha, err := resolveName(repo, rev)
+		if err == nil && objType == TypeCommit {
+			sha, err = resolveRev(repo, rev, TypeCommit)
 		}
-		i, j = i-1, j-1
-	}
-	if i >= 0 {
-		return false
+		if err != nil {
+			log.Panic(err)
+		}
+		result, err := repo.verifyObject(sha, objType)
+		if err != nil {
+			ok = false
+			result = fmt.Sprintf("%v: %v\n", rev, err)
+		}
+		sb.WriteString(result)
 	}
-	return true
+	return sb.String(), ok
+}
+
+// ConfigOptions selects which config file a config command reads or
+// writes, and how values are printed.
+type ConfigOptions struct {
+	Scope      string
+	File       string
+	Type       string
+	ShowOrigin bool
 }
 
-func findRepo(repoPath string) *Repository {
-	gitPath := path.Join(repoPath, ".git")
-	isDir, _ := util.IsDir(gitPath)
-	if isDir {
-		return createRepository(repoPath, false)
+func (opts ConfigOptions) load() *Config {
+	repo := tryFindRepo(".")
+	gitDir := ""
+	if repo != nil {
+		gitDir = repo.gitDir
 	}
-	parentPath := path.Join(repoPath, "..")
 
-	absRepoPath, err := filepath.Abs(repoPath)
-	if err != nil {
-		log.Panic(err)
+	// Like git, include directives are only followed when reading every
+	// file, not a chosen one.
+	l := &configLoader{gitDir: gitDir, noIncludes: opts.File != "" || opts.Scope != ""}
+	var cfg *Config
+	var err error
+	if opts.File != "" {
+		err = l.loadFile(opts.File, ScopeCommand, 0)
+		cfg = &Config{entries: l.entries}
+	} else {
+		cfg, err = l.loadLayers()
 	}
-	absParentPath, err := filepath.Abs(parentPath)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	if absParentPath == absRepoPath {
-		log.Panic("No git directory.")
+	scoped := &Config{}
+	for _, e := range cfg.entries {
+		if opts.Scope == "" || e.scope == opts.Scope {
+			if e.origin != "" {
+				e.origin = configOriginName(repo, e.origin, opts.File != "")
+			}
+			scoped.entries = append(scoped.entries, e)
+		}
 	}
-	return findRepo(parentPath)
+	return scoped
 }
 
-func graphvizLog(sb *strings.Builder, repo *Repository, sha string, seen map[string]bool) {
-	if _, ok := seen[sha]; ok {
-		return
+// configOriginName names a config file as git does for --show-origin. git
+// reads config from the top of the work tree, so that files in the .git
+// directory there, and files given relative to the current directory, are
+// named from the top of the work tree.
+func configOriginName(repo *Repository, origin string, given bool) string {
+	if repo == nil || repo.isBare() {
+		return origin
+	}
+	if given {
+		if filepath.IsAbs(origin) {
+			return origin
+		}
+		prefix, err := repo.relativePath(".")
+		if err != nil || prefix == "." {
+			return origin
+		}
+		return prefix + "/" + origin
 	}
-	seen[sha] = true
+	dotGit := path.Join(repo.workTree, ".git")
+	if repo.commonDir == dotGit && strings.HasPrefix(origin, dotGit+"/") {
+		return ".git/" + strings.TrimPrefix(origin, dotGit+"/")
+	}
+	return origin
+}
 
-	commit := createCommit(repo, nil)
-	err := commit.Read(sha)
+func (opts ConfigOptions) writePath() string {
+	if opts.File != "" {
+		return opts.File
+	}
+	switch opts.Scope {
+	case ScopeSystem:
+		p := systemConfigPath()
+		if p == "" {
+			log.Panic("System config is disabled by GIT_CONFIG_NOSYSTEM")
+		}
+		return p
+	case ScopeGlobal:
+		p, err := globalConfigWritePath()
+		if err != nil {
+			log.Panic(err)
+		}
+		return p
+	}
+
+	repo := findRepo(".")
+	if opts.Scope == ScopeWorktree {
+		if repo.configBool("extensions.worktreeconfig", false) {
+			return path.Join(repo.gitDir, "config.worktree")
+		}
+		if names, _ := repo.linkedWorktreeNames(); len(names) > 0 {
+			log.Panic("--worktree cannot be used with multiple working trees unless the config\n" +
+				"extension worktreeConfig is enabled. Please read \"CONFIGURATION FILE\"\n" +
+				"section in \"git help worktree\" for details")
+		}
+	}
+	return path.Join(repo.commonDir, "config")
+}
+
+func (opts ConfigOptions) format(sb *strings.Builder, e *configEntry, withKey bool) {
+	if opts.ShowOrigin {
+		if e.scope == ScopeCommand && e.origin == "" {
+			sb.WriteString("command line:\t")
+		} else {
+			sb.WriteString(fmt.Sprintf("file:%v\t", e.origin))
+		}
+	}
+	if withKey {
+		sb.WriteString(e.key())
+		if !e.hasValue {
+			sb.WriteString("\n")
+			return
+		}
+		sb.WriteString("=")
+	}
+	value, err := formatConfigValue(e.value, e.hasValue, opts.Type)
 	if err != nil {
 		log.Panic(err)
 	}
+	if !e.hasValue && opts.Type == "" {
+		value = "true"
+	}
+	sb.WriteString(value)
+	sb.WriteString("\n")
+}
+
+// ConfigGet prints the value of key, or all of its values when all is
+// set. The second result is false when the key isn't set at all.
+func ConfigGet(opts ConfigOptions, key string, all bool) (string, bool) {
+	cfg := opts.load()
+	found := cfg.lookup(key)
+	if len(found) == 0 {
+		return "", false
+	}
+	if !all {
+		found = found[len(found)-1:]
+	}
 
-	isInit := true
-	var parentValue []string
-	for _, kl := range commit.kvlm {
-		if kl.key == "parent" {
-			isInit = false
-			parentValue = kl.list
-			break
+	var sb strings.Builder
+	for _, e := range found {
+		opts.format(&sb, e, false)
+	}
+	return sb.String(), true
+}
+
+func ConfigList(opts ConfigOptions) string {
+	cfg := opts.load()
+	var sb strings.Builder
+	for i := range cfg.entries {
+		opts.format(&sb, &cfg.entries[i], true)
+	}
+	return sb.String()
+}
+
+func ConfigSet(opts ConfigOptions, key string, value string, isAdd bool) {
+	if opts.Type != "" {
+		normalized, err := formatConfigValue(value, true, opts.Type)
+		if err != nil {
+			log.Panic(err)
+		}
+		if opts.Type != "color" {
+			value = normalized
 		}
 	}
-	// Base case: the initial commit.
-	if isInit {
-		return
+	mode := configSet
+	if isAdd {
+		mode = configAdd
+	}
+	if err := editConfigFile(opts.writePath(), key, value, mode); err != nil {
+		log.Panic(err)
 	}
+}
 
-	for _, v := range parentValue {
-		sb.WriteString(fmt.Sprintf("c_%v -> c_%v;\n", sha, v))
-		graphvizLog(sb, repo, v, seen)
+func ConfigUnset(opts ConfigOptions, key string, all bool) {
+	mode := configUnset
+	if all {
+		mode = configUnsetAll
 	}
+	if err := editConfigFile(opts.writePath(), key, "", mode); err != nil {
+		log.Panic(err)
+	}
+}
+
+// ConfigAlias returns the expansion of alias.<name>, if there is one.
+func ConfigAlias(name string) (string, bool) {
+	return tryFindRepo(".").configString("alias." + name)
 }
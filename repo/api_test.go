@@ -2,8 +2,11 @@ package repo
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -15,3 +18,141 @@ func TestPath(t *testing.T) {
 	}
 	fmt.Println(parent)
 }
+
+// saveObject stores obj in its repository, failing the test on error.
+func saveObject(t *testing.T, obj Object) string {
+	sha, err := obj.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func TestCheckoutModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	tree := createTree(repo, nil)
+	tree.leaves = []Leaf{
+		{ModeBlob, "file", saveObject(t, createBlob(repo, []byte("data\n")))},
+		{ModeExec, "run.sh", saveObject(t, createBlob(repo, []byte("#!/bin/sh\n")))},
+		{ModeSymlink, "link", saveObject(t, createBlob(repo, []byte("file")))},
+		{ModeGitlink, "sub", strings.Repeat("12", 20)},
+	}
+
+	out := path.Join(dir, "out")
+	if err := ensureEmptyDir(out); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutTree(tree, out); err != nil {
+		t.Fatal(err)
+	}
+
+	if st, err := os.Lstat(path.Join(out, "file")); err != nil || st.Mode()&0111 != 0 {
+		t.Errorf("file: unexpected mode %v (%v)", st, err)
+	}
+	if st, err := os.Lstat(path.Join(out, "run.sh")); err != nil || st.Mode()&0100 == 0 {
+		t.Errorf("run.sh: expected executable (%v)", err)
+	}
+	if target, err := os.Readlink(path.Join(out, "link")); err != nil || target != "file" {
+		t.Errorf("link: got %q (%v)", target, err)
+	}
+	if st, err := os.Lstat(path.Join(out, "sub")); err != nil || !st.IsDir() {
+		t.Errorf("sub: expected a directory (%v)", err)
+	}
+}
+
+func TestCheckoutDuplicateEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	escape := path.Join(dir, "esc")
+	if err := os.Mkdir(escape, 0777); err != nil {
+		t.Fatal(err)
+	}
+	sub := createTree(repo, nil)
+	sub.leaves = []Leaf{{ModeBlob, "pwned", saveObject(t, createBlob(repo, []byte("pwned\n")))}}
+
+	// A symbolic link to the outside, then a tree of the same name.
+	tree := createTree(repo, nil)
+	tree.leaves = []Leaf{
+		{ModeSymlink, "a", saveObject(t, createBlob(repo, []byte(escape)))},
+		{ModeTree, "a", saveObject(t, sub)},
+	}
+	data, err := tree.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createTree(repo, nil).Deserialize([]byte(data)); err == nil {
+		t.Error("expected the duplicate entry to be rejected")
+	}
+
+	out := path.Join(dir, "out")
+	if err := ensureEmptyDir(out); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutTree(tree, out); err == nil {
+		t.Error("expected the checkout to be refused")
+	}
+	if _, err := os.Lstat(path.Join(escape, "pwned")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing written through the link (%v)", err)
+	}
+}
+
+func TestSymbolicRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "trunk", false)
+	if target, err := repo.resolveSymbolicRef("HEAD"); err != nil || target != "refs/heads/trunk" {
+		t.Fatalf("HEAD: got %q (%v)", target, err)
+	}
+
+	sha := strings.Repeat("ab", 20)
+	if err := repo.writeRef("refs/heads/main", sha); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.writeSymbolicRef("refs/heads/alias", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.writeSymbolicRef("HEAD", "refs/heads/alias"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.readRef("HEAD", map[string]string{}); err != nil || got != sha {
+		t.Errorf("readRef(HEAD) = %q (%v), want %v", got, err, sha)
+	}
+	if target, err := repo.resolveSymbolicRef("HEAD"); err != nil || target != "refs/heads/main" {
+		t.Errorf("resolveSymbolicRef(HEAD) = %q (%v)", target, err)
+	}
+	if target, err := repo.resolveSymbolicRef("refs/heads/main"); err != nil || target != "" {
+		t.Errorf("resolveSymbolicRef(refs/heads/main) = %q (%v)", target, err)
+	}
+	if err := repo.writeSymbolicRef("HEAD", "main"); err == nil {
+		t.Error("expected HEAD pointing outside of refs/ to be refused")
+	}
+
+	// Loops must end in an error rather than recursing forever.
+	if err := repo.writeSymbolicRef("refs/heads/a", "refs/heads/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.writeSymbolicRef("refs/heads/b", "refs/heads/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.readRef("refs/heads/a", map[string]string{}); err == nil {
+		t.Error("expected a loop of symbolic refs to be reported")
+	}
+	if _, err := repo.resolveSymbolicRef("refs/heads/a"); err == nil {
+		t.Error("expected a loop of symbolic refs to be reported")
+	}
+}
@@ -0,0 +1,174 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Values of an attribute besides a string: set with "attr", unset with
+// "-attr".
+const (
+	attrSet   = "\x00set"
+	attrUnset = "\x00unset"
+)
+
+// attrMacros are the built-in macro attributes.
+var attrMacros = map[string][]string{
+	"binary": {"-diff", "-merge", "-text"},
+}
+
+// attrRule is a line of a .gitattributes file: a pattern and the
+// attributes it assigns. Patterns without a slash match the basename at
+// any depth; the others match the path relative to base, the directory of
+// the file.
+type attrRule struct {
+	base    string
+	pattern *regexp.Regexp
+	name    bool
+	attrs   []string
+}
+
+// parseAttributes reads the rules of a .gitattributes file found in the
+// directory base of the tree.
+func parseAttributes(content string, base string) []attrRule {
+	rules := []attrRule{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		pattern := fields[0]
+		// Negative patterns are forbidden, and directory patterns never
+		// match files.
+		if strings.HasPrefix(pattern, "!") || strings.HasSuffix(pattern, "/") {
+			continue
+		}
+		name := !strings.Contains(pattern, "/")
+		re, err := wildmatchRegexp(strings.TrimPrefix(pattern, "/"))
+		if err != nil {
+			continue
+		}
+		rules = append(rules, attrRule{base: base, pattern: re, name: name, attrs: fields[1:]})
+	}
+	return rules
+}
+
+func (rule attrRule) matches(filePath string) bool {
+	if rule.base != "" {
+		if !strings.HasPrefix(filePath, rule.base+"/") {
+			return false
+		}
+		filePath = filePath[len(rule.base)+1:]
+	}
+	if rule.name {
+		filePath = path.Base(filePath)
+	}
+	return rule.pattern.MatchString(filePath)
+}
+
+// wildmatchRegexp translates a pattern in which "*" doesn't cross
+// slashes, and "**" between slashes matches any number of directories.
+func wildmatchRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**" && i > 0 && pattern[i-1] == '/':
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// attributes returns the attributes of a path relative to the root of the
+// work tree. They come from the .gitattributes files of the directories
+// leading to it, the deeper ones taking precedence, and then from
+// info/attributes in the git directory. Unspecified attributes are left
+// out.
+func (r *Repository) attributes(filePath string) (map[string]string, error) {
+	files := []struct{ name, base string }{{".gitattributes", ""}}
+	dirs := strings.Split(path.Dir(filePath), "/")
+	for i := range dirs {
+		if dirs[0] == "." {
+			break
+		}
+		base := strings.Join(dirs[:i+1], "/")
+		files = append(files, struct{ name, base string }{path.Join(base, ".gitattributes"), base})
+	}
+
+	// A bare repository has no .gitattributes files to read.
+	if r.isBare() {
+		files = nil
+	}
+
+	rules := []attrRule{}
+	for _, f := range files {
+		bs, err := ioutil.ReadFile(path.Join(r.workTree, f.name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		rules = append(rules, parseAttributes(string(bs), f.base)...)
+	}
+	bs, err := ioutil.ReadFile(path.Join(r.commonDir, "info", "attributes"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	rules = append(rules, parseAttributes(string(bs), "")...)
+
+	attrs := map[string]string{}
+	var assign func(attr string)
+	assign = func(attr string) {
+		switch {
+		case strings.HasPrefix(attr, "-"):
+			attrs[attr[1:]] = attrUnset
+		case strings.HasPrefix(attr, "!"):
+			delete(attrs, attr[1:])
+		case strings.Contains(attr, "="):
+			kv := strings.SplitN(attr, "=", 2)
+			attrs[kv[0]] = kv[1]
+		default:
+			attrs[attr] = attrSet
+			for _, a := range attrMacros[attr] {
+				assign(a)
+			}
+		}
+	}
+	for _, rule := range rules {
+		if rule.matches(filePath) {
+			for _, attr := range rule.attrs {
+				assign(attr)
+			}
+		}
+	}
+	return attrs, nil
+}
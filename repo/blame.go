@@ -0,0 +1,616 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type BlameOptions struct {
+	Ranges           []string
+	Porcelain        bool
+	IgnoreWhitespace bool
+	IgnoreRevs       []string
+	IgnoreRevsFile   string
+}
+
+// blameLine is a line of the blamed file: final is its 0-based number in
+// the file, orig its number in the version of the suspect holding it.
+type blameLine struct {
+	final int
+	orig  int
+}
+
+// blameSuspect is the file as of some commit. Its lines are blamed on
+// that commit until they are found unchanged in a parent.
+type blameSuspect struct {
+	commit *RevCommit
+	path   string
+	blob   string
+	lines  []blameLine
+
+	previous *blameSuspect
+	queued   bool
+}
+
+type blameEntry struct {
+	blameLine
+	suspect *blameSuspect
+}
+
+type blamer struct {
+	repo      *Repository
+	lookup    func(string) (*RevCommit, error)
+	ignore    map[string]bool
+	normalize func(string) string
+
+	suspects map[string]*blameSuspect
+	pending  []*blameSuspect
+	blobs    map[string][]string
+	entries  []blameEntry
+}
+
+func newBlamer(repo *Repository) *blamer {
+	return &blamer{
+		repo:     repo,
+		lookup:   revCommitCache(repo),
+		ignore:   map[string]bool{},
+		suspects: map[string]*blameSuspect{},
+		blobs:    map[string][]string{},
+	}
+}
+
+func (b *blamer) blobLines(sha string) ([]string, error) {
+	if lines, ok := b.blobs[sha]; ok {
+		return lines, nil
+	}
+	content, err := b.repo.readBlob(sha)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(content)
+	b.blobs[sha] = lines
+	return lines, nil
+}
+
+// suspect returns the suspect for a path in a commit, creating it once.
+func (b *blamer) suspect(commit *RevCommit, path string, blob string) *blameSuspect {
+	key := commit.SHA + "\x00" + path
+	s, ok := b.suspects[key]
+	if !ok {
+		s = &blameSuspect{commit: commit, path: path, blob: blob}
+		b.suspects[key] = s
+	}
+	return s
+}
+
+// give hands lines over to a suspect and queues it.
+func (b *blamer) give(s *blameSuspect, lines ...blameLine) {
+	s.lines = append(s.lines, lines...)
+	if !s.queued {
+		s.queued = true
+		b.pending = append(b.pending, s)
+	}
+}
+
+// next takes the pending suspect from the most recent commit, so that
+// every child is done before its parents.
+func (b *blamer) next() *blameSuspect {
+	best := 0
+	for i, s := range b.pending {
+		c, o := s.commit, b.pending[best].commit
+		if c.generation > o.generation || (c.generation == o.generation && c.commitTime > o.commitTime) {
+			best = i
+		}
+	}
+	s := b.pending[best]
+	b.pending = append(b.pending[:best], b.pending[best+1:]...)
+	s.queued = false
+	return s
+}
+
+// run blames the lines of filePath in commit, and returns the content of
+// the file.
+func (b *blamer) run(commit *RevCommit, filePath string, ranges [][2]int) ([]string, error) {
+	leaf, ok, err := b.repo.lookupPath(commit.Tree, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || leaf.mode == ModeTree || leaf.mode == ModeGitlink {
+		return nil, fmt.Errorf("no such path %v in %v", filePath, commit.SHA)
+	}
+	final, err := b.blobLines(leaf.sha)
+	if err != nil {
+		return nil, err
+	}
+
+	s := b.suspect(commit, filePath, leaf.sha)
+	wanted := make([]bool, len(final))
+	for _, r := range ranges {
+		for i := r[0]; i < r[1]; i++ {
+			wanted[i] = true
+		}
+	}
+	for i, ok := range wanted {
+		if ok {
+			b.give(s, blameLine{i, i})
+		}
+	}
+	for len(b.pending) > 0 {
+		if err := b.pass(b.next()); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(b.entries, func(i, j int) bool { return b.entries[i].final < b.entries[j].final })
+	return final, nil
+}
+
+// pass hands the lines of s which are unchanged in a parent over to that
+// parent, and blames the rest on s.
+func (b *blamer) pass(s *blameSuspect) error {
+	lines := s.lines
+	s.lines = nil
+	current, err := b.blobLines(s.blob)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range s.commit.Parents {
+		if len(lines) == 0 {
+			break
+		}
+		parent, err := b.lookup(p)
+		if err != nil {
+			return err
+		}
+		leaf, ok, err := b.findInParent(s, parent, current)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		ps := b.suspect(parent, leaf.path, leaf.sha)
+		if s.previous == nil {
+			s.previous = ps
+		}
+		if leaf.sha == s.blob {
+			b.give(ps, lines...)
+			lines = nil
+			break
+		}
+
+		old, err := b.blobLines(leaf.sha)
+		if err != nil {
+			return err
+		}
+		ops := diffLines(old, current, b.normalize)
+		newToOld := make([]int, len(current))
+		for i := range newToOld {
+			newToOld[i] = -1
+		}
+		for _, op := range ops {
+			if op.kind == '=' {
+				newToOld[op.newLine] = op.oldLine
+			}
+		}
+		if b.ignore[s.commit.SHA] {
+			guessIgnoredLines(old, current, ops, newToOld)
+		}
+
+		kept := []blameLine{}
+		for _, l := range lines {
+			if o := newToOld[l.orig]; o >= 0 {
+				b.give(ps, blameLine{l.final, o})
+			} else {
+				kept = append(kept, l)
+			}
+		}
+		lines = kept
+	}
+
+	for _, l := range lines {
+		b.entries = append(b.entries, blameEntry{l, s})
+	}
+	return nil
+}
+
+// guessIgnoredLines maps the lines changed by an ignored commit to the
+// lines they replaced within each hunk. Like git, lines are matched by the
+// pairs of characters they share, most similar first and keeping their
+// order; a line sharing none with the replaced ones stays with the ignored
+// commit.
+func guessIgnoredLines(old, current []string, ops []diffOp, newToOld []int) {
+	dels, adds := []int{}, []int{}
+	flush := func() {
+		if len(dels) > 0 && len(adds) > 0 {
+			delPrints, addPrints := []lineFingerprint{}, []lineFingerprint{}
+			for _, d := range dels {
+				delPrints = append(delPrints, fingerprintLine(old[d]))
+			}
+			for _, a := range adds {
+				addPrints = append(addPrints, fingerprintLine(current[a]))
+			}
+			matchIgnoredLines(dels, adds, delPrints, addPrints, newToOld)
+		}
+		dels, adds = dels[:0], adds[:0]
+	}
+	for _, op := range ops {
+		switch op.kind {
+		case '-':
+			if len(adds) > 0 {
+				flush()
+			}
+			dels = append(dels, op.oldLine)
+		case '+':
+			adds = append(adds, op.newLine)
+		default:
+			flush()
+		}
+	}
+	flush()
+}
+
+// matchIgnoredLines maps the most similar pair of an added and a deleted
+// line, then does the same with the lines before that pair and after it.
+func matchIgnoredLines(dels, adds []int, delPrints, addPrints []lineFingerprint, newToOld []int) {
+	best, bestDel, bestAdd := 0, 0, 0
+	for i := range adds {
+		for j := range dels {
+			if score := addPrints[i].similarity(delPrints[j]); score > best {
+				best, bestDel, bestAdd = score, j, i
+			}
+		}
+	}
+	if best == 0 {
+		return
+	}
+	newToOld[adds[bestAdd]] = dels[bestDel]
+	matchIgnoredLines(dels[:bestDel], adds[:bestAdd], delPrints[:bestDel], addPrints[:bestAdd], newToOld)
+	matchIgnoredLines(dels[bestDel+1:], adds[bestAdd+1:], delPrints[bestDel+1:], addPrints[bestAdd+1:], newToOld)
+}
+
+// lineFingerprint counts the pairs of adjacent bytes of a line.
+type lineFingerprint map[[2]byte]int
+
+// fingerprintLine takes the fingerprint of a line, ignoring case, with
+// spaces and the ends of the line as zeros.
+func fingerprintLine(line string) lineFingerprint {
+	fp := lineFingerprint{}
+	var prev byte
+	for i := 0; i <= len(line); i++ {
+		var c byte
+		if i < len(line) {
+			c = line[i]
+			switch {
+			case c == ' ' || c >= '\t' && c <= '\r':
+				c = 0
+			case c >= 'A' && c <= 'Z':
+				c += 'a' - 'A'
+			}
+		}
+		if prev != 0 || c != 0 {
+			fp[[2]byte{prev, c}]++
+		}
+		prev = c
+	}
+	return fp
+}
+
+// similarity counts the pairs of bytes two lines share.
+func (a lineFingerprint) similarity(b lineFingerprint) int {
+	common := 0
+	for pair, n := range a {
+		if m := b[pair]; m < n {
+			common += m
+		} else {
+			common += n
+		}
+	}
+	return common
+}
+
+// findInParent finds the version of the file of s in parent: at the same
+// path, or else at the path of the most similar file deleted by s's
+// commit, which is then taken as renamed.
+func (b *blamer) findInParent(s *blameSuspect, parent *RevCommit, current []string) (Leaf, bool, error) {
+	leaf, ok, err := b.repo.lookupPath(parent.Tree, s.path)
+	if err != nil {
+		return Leaf{}, false, err
+	}
+	if ok && leaf.mode != ModeTree && leaf.mode != ModeGitlink {
+		return leaf, true, nil
+	}
+
+	changes, err := b.repo.diffTrees(parent.Tree, s.commit.Tree)
+	if err != nil {
+		return Leaf{}, false, err
+	}
+	best, bestScore := Leaf{}, 0
+	for _, ch := range changes {
+		if ch.newSHA != "" || ch.oldMode == ModeGitlink {
+			continue
+		}
+		if ch.oldSHA == s.blob {
+			return Leaf{ch.oldMode, ch.path, ch.oldSHA}, true, nil
+		}
+		old, err := b.blobLines(ch.oldSHA)
+		if err != nil {
+			return Leaf{}, false, err
+		}
+		if score := similarity(old, current); score > bestScore {
+			best, bestScore = Leaf{ch.oldMode, ch.path, ch.oldSHA}, score
+		}
+	}
+	return best, bestScore >= 50, nil
+}
+
+// similarity is the percentage of lines two files have in common.
+func similarity(a, b []string) int {
+	if len(a)+len(b) == 0 {
+		return 100
+	}
+	common := 0
+	for _, op := range diffLines(a, b, nil) {
+		if op.kind == '=' {
+			common++
+		}
+	}
+	return 200 * common / (len(a) + len(b))
+}
+
+// stripSpaces is the line normalization used by "blame -w".
+func stripSpaces(line string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, line)
+}
+
+// parseBlameRange parses the argument of -L into a 0-based half open
+// range of lines. Both ends may be line numbers or /regex/, and the end
+// may be relative: "+count" or "-count".
+func parseBlameRange(spec string, lines []string) ([2]int, error) {
+	startSpec, endSpec := spec, ""
+	if i := blameRangeSplit(spec); i >= 0 {
+		startSpec, endSpec = spec[:i], spec[i+1:]
+	}
+
+	start := 1
+	if startSpec != "" {
+		n, err := blameRangeLine(startSpec, lines, 0)
+		if err != nil {
+			return [2]int{}, err
+		}
+		start = n
+	}
+	if start < 1 || start > len(lines) {
+		return [2]int{}, fmt.Errorf("file has only %v lines", len(lines))
+	}
+
+	end := len(lines)
+	switch {
+	case endSpec == "":
+	case strings.HasPrefix(endSpec, "+"):
+		n, err := strconv.Atoi(endSpec[1:])
+		if err != nil || n < 0 {
+			return [2]int{}, fmt.Errorf("invalid -L argument %v", spec)
+		}
+		end = start + n - 1
+		if n == 0 {
+			end = start
+		}
+	case strings.HasPrefix(endSpec, "-"):
+		n, err := strconv.Atoi(endSpec[1:])
+		if err != nil || n < 0 {
+			return [2]int{}, fmt.Errorf("invalid -L argument %v", spec)
+		}
+		start, end = start-n+1, start
+		if n == 0 {
+			start = end
+		}
+		if start < 1 {
+			start = 1
+		}
+	default:
+		n, err := blameRangeLine(endSpec, lines, start)
+		if err != nil {
+			return [2]int{}, err
+		}
+		end = n
+	}
+	if end < start {
+		start, end = end, start
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return [2]int{start - 1, end}, nil
+}
+
+// blameRangeSplit finds the comma between the two ends of a -L range,
+// skipping over a leading /regex/.
+func blameRangeSplit(spec string) int {
+	from := 0
+	if strings.HasPrefix(spec, "/") {
+		if i := strings.Index(spec[1:], "/"); i >= 0 {
+			from = i + 2
+		}
+	}
+	if i := strings.Index(spec[from:], ","); i >= 0 {
+		return from + i
+	}
+	return -1
+}
+
+// blameRangeLine resolves one end of a -L range to a 1-based line number.
+// A /regex/ matches from the line after the 1-based line from.
+func blameRangeLine(spec string, lines []string, from int) (int, error) {
+	if len(spec) >= 2 && strings.HasPrefix(spec, "/") && strings.HasSuffix(spec, "/") {
+		re, err := regexp.Compile(spec[1 : len(spec)-1])
+		if err != nil {
+			return 0, err
+		}
+		for i := from; i < len(lines); i++ {
+			if re.MatchString(lines[i]) {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("-L parameter '%v': no match", spec)
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid -L argument %v", spec)
+	}
+	return n, nil
+}
+
+// readIgnoreRevs reads a blame.ignoreRevsFile: one revision per line,
+// with '#' comments.
+func readIgnoreRevs(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	revs := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			revs = append(revs, line)
+		}
+	}
+	return revs, scanner.Err()
+}
+
+// formatBlame renders blame entries like "git blame".
+func (b *blamer) formatBlame(sb *strings.Builder, final []string, finalPath string) error {
+	showName := false
+	nameWidth, authorWidth, numWidth := 0, 0, 0
+	authors := map[*blameSuspect]Signature{}
+	for _, e := range b.entries {
+		if e.suspect.path != finalPath {
+			showName = true
+		}
+		if len(e.suspect.path) > nameWidth {
+			nameWidth = len(e.suspect.path)
+		}
+		if _, ok := authors[e.suspect]; !ok {
+			commit, err := e.suspect.commit.Commit()
+			if err != nil {
+				return err
+			}
+			author, _ := commit.Author()
+			authors[e.suspect] = author
+		}
+		if n := len([]rune(authors[e.suspect].Name)); n > authorWidth {
+			authorWidth = n
+		}
+		if n := len(strconv.Itoa(e.final + 1)); n > numWidth {
+			numWidth = n
+		}
+	}
+
+	for _, e := range b.entries {
+		c := e.suspect.commit
+		if len(c.Parents) == 0 {
+			sb.WriteString("^" + c.SHA[:7])
+		} else {
+			sb.WriteString(c.SHA[:8])
+		}
+		if showName {
+			sb.WriteString(fmt.Sprintf(" %-*v", nameWidth, e.suspect.path))
+		}
+		author := authors[e.suspect]
+		pad := strings.Repeat(" ", authorWidth-len([]rune(author.Name)))
+		sb.WriteString(fmt.Sprintf(" (%v%v %v %*d) %v\n", author.Name, pad,
+			author.When.Format("2006-01-02 15:04:05 -0700"), numWidth, e.final+1,
+			strings.TrimSuffix(final[e.final], "\n")))
+	}
+	return nil
+}
+
+// formatBlamePorcelain renders blame entries in the machine readable
+// format of "git blame --porcelain": consecutive lines from the same
+// commit form a group, and details of a commit come with its first group.
+func (b *blamer) formatBlamePorcelain(sb *strings.Builder, final []string) error {
+	paths := map[string]map[string]bool{}
+	for _, e := range b.entries {
+		if paths[e.suspect.commit.SHA] == nil {
+			paths[e.suspect.commit.SHA] = map[string]bool{}
+		}
+		paths[e.suspect.commit.SHA][e.suspect.path] = true
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < len(b.entries); {
+		e := b.entries[i]
+		n := 1
+		for i+n < len(b.entries) {
+			f := b.entries[i+n]
+			if f.suspect != e.suspect || f.final != e.final+n || f.orig != e.orig+n {
+				break
+			}
+			n++
+		}
+
+		sha := e.suspect.commit.SHA
+		sb.WriteString(fmt.Sprintf("%v %v %v %v\n", sha, e.orig+1, e.final+1, n))
+		if !seen[sha] {
+			seen[sha] = true
+			if err := writeBlameDetails(sb, e.suspect); err != nil {
+				return err
+			}
+			sb.WriteString(fmt.Sprintf("filename %v\n", e.suspect.path))
+		} else if len(paths[sha]) > 1 {
+			sb.WriteString(fmt.Sprintf("filename %v\n", e.suspect.path))
+		}
+		for j := 0; j < n; j++ {
+			l := b.entries[i+j]
+			if j > 0 {
+				sb.WriteString(fmt.Sprintf("%v %v %v\n", sha, l.orig+1, l.final+1))
+			}
+			sb.WriteString("\t" + strings.TrimSuffix(final[l.final], "\n") + "\n")
+		}
+		i += n
+	}
+	return nil
+}
+
+func writeBlameDetails(sb *strings.Builder, s *blameSuspect) error {
+	commit, err := s.commit.Commit()
+	if err != nil {
+		return err
+	}
+	for _, role := range []string{"author", "committer"} {
+		sig, err := parseSignature(commit.value(role))
+		if err != nil {
+			return err
+		}
+		sb.WriteString(fmt.Sprintf("%v %v\n", role, sig.Name))
+		sb.WriteString(fmt.Sprintf("%v-mail <%v>\n", role, sig.Email))
+		sb.WriteString(fmt.Sprintf("%v-time %v\n", role, sig.When.Unix()))
+		sb.WriteString(fmt.Sprintf("%v-tz %v\n", role, sig.When.Format("-0700")))
+	}
+	summary := strings.SplitN(strings.TrimLeft(commit.Message(), "\n"), "\n", 2)[0]
+	sb.WriteString(fmt.Sprintf("summary %v\n", summary))
+	if len(s.commit.Parents) == 0 {
+		sb.WriteString("boundary\n")
+	}
+	if s.previous != nil {
+		sb.WriteString(fmt.Sprintf("previous %v %v\n", s.previous.commit.SHA, s.previous.path))
+	}
+	return nil
+}
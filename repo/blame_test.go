@@ -0,0 +1,63 @@
+package repo
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseBlameRange(t *testing.T) {
+	lines := splitLines("a\nb\nfunc x\nc\nd\n")
+	cases := map[string][2]int{
+		"2,4":       {1, 4},
+		"4,2":       {1, 4},
+		"2,+2":      {1, 3},
+		"4,-2":      {2, 4},
+		"3":         {2, 5},
+		",2":        {0, 2},
+		"/func/,+2": {2, 4},
+		"/^b/,/d/":  {1, 5},
+		"1,100":     {0, 5},
+	}
+	for spec, want := range cases {
+		got, err := parseBlameRange(spec, lines)
+		if err != nil || got != want {
+			t.Errorf("%v: got %v (%v), want %v", spec, got, err, want)
+		}
+	}
+	for _, spec := range []string{"0", "6", "/nope/", "x,y"} {
+		if _, err := parseBlameRange(spec, lines); err == nil {
+			t.Errorf("%v: expected an error", spec)
+		}
+	}
+}
+
+func TestGuessIgnoredLines(t *testing.T) {
+	cases := []struct {
+		old, cur string
+		want     []int
+	}{
+		{"a\nb\nc\nd\n", "a\nB\nC\nX\nd\n", []int{0, 1, 2, -1, 3}},
+		// Lines sharing no pair of characters stay with the ignored commit.
+		{"1\n2\n5\n", "1\n2\nfive\n", []int{0, 1, -1}},
+		{"alpha beta\nx\ngamma delta\n", "Alpha Beta!\ny\nGAMMA  delta\n", []int{0, -1, 2}},
+		// The most similar lines are matched, whatever their positions.
+		{"x\nfoo bar\n", "foo baz\n", []int{1}},
+	}
+	for _, c := range cases {
+		old, cur := splitLines(c.old), splitLines(c.cur)
+		ops := diffLines(old, cur, nil)
+		newToOld := make([]int, len(cur))
+		for i := range newToOld {
+			newToOld[i] = -1
+		}
+		for _, op := range ops {
+			if op.kind == '=' {
+				newToOld[op.newLine] = op.oldLine
+			}
+		}
+		guessIgnoredLines(old, cur, ops, newToOld)
+		if fmt.Sprint(newToOld) != fmt.Sprint(c.want) {
+			t.Errorf("%q -> %q: got %v, want %v", c.old, c.cur, newToOld, c.want)
+		}
+	}
+}
@@ -35,3 +35,12 @@ func (b *Blob) Deserialize(data []byte) error {
 	b.data = data
 	return nil
 }
+
+// readBlob returns the content of a blob.
+func (r *Repository) readBlob(sha string) (string, error) {
+	blob := createBlob(r, nil)
+	if err := blob.Read(sha); err != nil {
+		return "", err
+	}
+	return string(blob.data), nil
+}
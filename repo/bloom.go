@@ -0,0 +1,130 @@
+package repo
+
+import (
+	"strings"
+)
+
+// Settings of the changed-path Bloom filters, the same as git's defaults.
+const (
+	bloomHashVersion     = 1
+	bloomNumHashes       = 7
+	bloomBitsPerEntry    = 10
+	bloomMaxChangedPaths = 512
+)
+
+// murmur3 is the 32-bit MurmurHash3 used by changed-path Bloom filters.
+// Version 1 filters reproduce a bug of git, which sign-extended bytes
+// above 0x7f.
+func murmur3(seed uint32, data []byte, version int) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	b := func(i int) uint32 {
+		if version == 1 {
+			return uint32(int32(int8(data[i])))
+		}
+		return uint32(data[i])
+	}
+	rotl := func(x uint32, r uint) uint32 {
+		return x<<r | x>>(32-r)
+	}
+
+	h := seed
+	n := len(data) / 4
+	for i := 0; i < n; i++ {
+		k := b(4*i) | b(4*i+1)<<8 | b(4*i+2)<<16 | b(4*i+3)<<24
+		k *= c1
+		k = rotl(k, 15)
+		k *= c2
+		h ^= k
+		h = rotl(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := 4 * n
+	switch len(data) & 3 {
+	case 3:
+		k ^= b(tail+2) << 16
+		fallthrough
+	case 2:
+		k ^= b(tail+1) << 8
+		fallthrough
+	case 1:
+		k ^= b(tail)
+		k *= c1
+		k = rotl(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+func bloomKey(path string, version int) [bloomNumHashes]uint32 {
+	h0 := murmur3(0x293ae76f, []byte(path), version)
+	h1 := murmur3(0x7e646e2c, []byte(path), version)
+	var key [bloomNumHashes]uint32
+	for i := range key {
+		key[i] = h0 + uint32(i)*h1
+	}
+	return key
+}
+
+// newBloomFilter builds the filter of a commit from the paths it changed.
+// Every leading directory of a path counts as changed too. Commits that
+// change too many paths get a filter that matches everything.
+func newBloomFilter(changed []string, version int) []byte {
+	if len(changed) > bloomMaxChangedPaths {
+		return []byte{0xff}
+	}
+	paths := map[string]bool{}
+	for _, p := range changed {
+		for {
+			paths[p] = true
+			i := strings.LastIndex(p, "/")
+			if i < 0 {
+				break
+			}
+			p = p[:i]
+		}
+	}
+	if len(paths) > bloomMaxChangedPaths {
+		return []byte{0xff}
+	}
+
+	size := (len(paths)*bloomBitsPerEntry + 7) / 8
+	if size == 0 {
+		size = 1
+	}
+	filter := make([]byte, size)
+	for p := range paths {
+		for _, h := range bloomKey(p, version) {
+			pos := uint64(h) % uint64(size*8)
+			filter[pos/8] |= 1 << (pos % 8)
+		}
+	}
+	return filter
+}
+
+// bloomContains reports whether path may have been changed. False answers
+// are certain, true ones may not be.
+func bloomContains(filter []byte, path string, version int) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, h := range bloomKey(path, version) {
+		pos := uint64(h) % uint64(len(filter)*8)
+		if filter[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// objectHeader returns the type and size of an object without reading
+// its content.
+func (r *Repository) objectHeader(sha string) (string, int64, error) {
+	or, err := r.openObject(sha)
+	if err != nil {
+		return "", 0, err
+	}
+	defer or.Close()
+	return or.format, or.size, nil
+}
+
+// formatTreeLeaves lists the entries of a tree like "git ls-tree".
+func formatTreeLeaves(leaves []Leaf) string {
+	var sb strings.Builder
+	for _, leaf := range leaves {
+		sb.WriteString(fmt.Sprintf("%v %v %v\t%v\n", leaf.mode, leafType(leaf.mode), leaf.sha, leaf.path))
+	}
+	return sb.String()
+}
+
+// leafType returns the type of the object a tree entry points to.
+func leafType(mode string) string {
+	switch mode {
+	case ModeTree:
+		return TypeTree
+	case ModeGitlink:
+		return TypeCommit
+	}
+	return TypeBlob
+}
+
+// resolveBatchName resolves a line of "cat-file --batch" input. Full
+// object names are taken as they are, without looking at the refs.
+func (r *Repository) resolveBatchName(name string) (string, error) {
+	if len(name) == r.objectFormat().HexSize() && isHex(name) {
+		return strings.ToLower(name), nil
+	}
+	return resolveName(r, name)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// looseObjects lists the names of the objects of the repository, sorted.
+func (r *Repository) looseObjects() ([]string, error) {
+	objRoot := path.Join(r.commonDir, "objects")
+	dirs, err := ioutil.ReadDir(objRoot)
+	if err != nil {
+		return nil, err
+	}
+	hexSize := r.objectFormat().HexSize()
+	shas := []string{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+		entries, err := ioutil.ReadDir(path.Join(objRoot, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if sha := dir.Name() + e.Name(); len(sha) == hexSize && isHex(sha) {
+				shas = append(shas, sha)
+			}
+		}
+	}
+	sort.Strings(shas)
+	return shas, nil
+}
+
+// writeBatchObject writes the header of an object, and its content when
+// asked for, streaming it so that large blobs aren't held in memory. An
+// object which can't be found is reported as missing under name.
+func (r *Repository) writeBatchObject(w *bufio.Writer, name string, sha string, contents bool) error {
+	or, err := r.openObject(sha)
+	if err != nil {
+		if _, statErr := os.Stat(path.Join(r.commonDir, "objects", sha[:2], sha[2:])); os.IsNotExist(statErr) {
+			_, err := fmt.Fprintf(w, "%v missing\n", name)
+			return err
+		}
+		return err
+	}
+	defer or.Close()
+
+	if _, err := fmt.Fprintf(w, "%v %v %v\n", sha, or.format, or.size); err != nil {
+		return err
+	}
+	if !contents {
+		return nil
+	}
+	if _, err := io.Copy(w, or); err != nil {
+		return fmt.Errorf("Malformed object %v: %v", sha, err)
+	}
+	return w.WriteByte('\n')
+}
@@ -0,0 +1,520 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// combinedFile is a file of a merge which differs from every parent. The
+// sha of a side is empty when the file doesn't exist there.
+type combinedFile struct {
+	path    string
+	mode    string
+	sha     string
+	parents []Leaf
+}
+
+// combinedLine is a line of the merge result, as in git's combine-diff.c.
+// Bit i of flag is set when the line isn't in parent i; the bits above
+// mark the lines to show. lost holds the lines of the parents removed just
+// before this one, and parentLine the number of the matching line in each
+// parent. A last line past the end of the result holds the lines removed
+// at the end.
+type combinedLine struct {
+	text       string
+	flag       uint
+	lost       []*lostLine
+	parentLine []int
+}
+
+// lostLine is a removed line, with a bit set for each parent it was
+// removed from.
+type lostLine struct {
+	text    string
+	parents uint
+}
+
+// combinedFiles lists the files of a merge which differ from all of its
+// parents, sorted by path.
+func (r *Repository) combinedFiles(tree string, parentTrees []string) ([]combinedFile, error) {
+	byPath := map[string]*combinedFile{}
+	counts := map[string]int{}
+	for i, parentTree := range parentTrees {
+		changes, err := r.diffTrees(parentTree, tree)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, change := range changes {
+			f := byPath[change.path]
+			if f == nil {
+				f = &combinedFile{path: change.path, parents: make([]Leaf, len(parentTrees))}
+				byPath[change.path] = f
+			}
+			if change.newSHA != "" {
+				f.mode, f.sha = change.newMode, change.newSHA
+			}
+			if change.oldSHA != "" {
+				f.parents[i] = Leaf{mode: change.oldMode, sha: change.oldSHA}
+			}
+			if !seen[change.path] {
+				seen[change.path] = true
+				counts[change.path]++
+			}
+		}
+	}
+
+	files := []combinedFile{}
+	for filePath, f := range byPath {
+		if counts[filePath] == len(parentTrees) {
+			files = append(files, *f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// writeCombinedPatch writes files in the dense combined format of "git
+// diff --cc": only the hunks where the result differs from every parent
+// are shown.
+func (r *Repository) writeCombinedPatch(sb *strings.Builder, files []combinedFile) error {
+	for _, f := range files {
+		result, err := r.diffContent(f.sha, f.mode)
+		if err != nil {
+			return err
+		}
+		binary := isBinary(result)
+		modeDiffers := false
+		parents := [][]string{}
+		for _, p := range f.parents {
+			content, err := r.diffContent(p.sha, p.mode)
+			if err != nil {
+				return err
+			}
+			binary = binary || isBinary(content)
+			modeDiffers = modeDiffers || p.mode != f.mode
+			parents = append(parents, splitLines(content))
+		}
+
+		if binary {
+			writeCombinedHeader(sb, f, modeDiffers, false)
+			sb.WriteString("Binary files differ\n")
+			continue
+		}
+		lines := combineDiffs(splitLines(result), parents)
+		show := makeCombinedHunks(lines, len(parents))
+		if show || modeDiffers {
+			writeCombinedHeader(sb, f, modeDiffers, true)
+			if show {
+				writeCombinedHunks(sb, lines, len(parents))
+			}
+		}
+	}
+	return nil
+}
+
+func writeCombinedHeader(sb *strings.Builder, f combinedFile, modeDiffers bool, fileHeader bool) {
+	sb.WriteString(fmt.Sprintf("diff --cc %v\n", f.path))
+	shas := []string{}
+	for _, p := range f.parents {
+		shas = append(shas, abbrevOrZero(p.sha))
+	}
+	sb.WriteString(fmt.Sprintf("index %v..%v\n", strings.Join(shas, ","), abbrevOrZero(f.sha)))
+
+	// A file is added when no parent has it.
+	deleted := f.sha == ""
+	added := !deleted
+	for _, p := range f.parents {
+		added = added && p.sha == ""
+	}
+	if modeDiffers {
+		if added {
+			sb.WriteString(fmt.Sprintf("new file mode %v\n", f.mode))
+		} else {
+			if deleted {
+				sb.WriteString("deleted file ")
+			}
+			modes := []string{}
+			for _, p := range f.parents {
+				mode := p.mode
+				if mode == "" {
+					mode = "000000"
+				}
+				modes = append(modes, mode)
+			}
+			sb.WriteString("mode " + strings.Join(modes, ","))
+			if !deleted {
+				sb.WriteString(".." + f.mode)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	if !fileHeader {
+		return
+	}
+	oldName, newName := "a/"+f.path, "b/"+f.path
+	if added {
+		oldName = "/dev/null"
+	}
+	if deleted {
+		newName = "/dev/null"
+	}
+	sb.WriteString(fmt.Sprintf("--- %v\n+++ %v\n", oldName, newName))
+}
+
+// combineDiffs diffs the result against each parent, recording which
+// lines each parent lacks and which lines each parent lost.
+func combineDiffs(result []string, parents [][]string) []*combinedLine {
+	cnt := len(result)
+	lines := make([]*combinedLine, cnt+2)
+	for i := range lines {
+		lines[i] = &combinedLine{parentLine: make([]int, len(parents))}
+		if i < cnt {
+			lines[i].text = strings.TrimSuffix(result[i], "\n")
+		}
+	}
+
+	for n, parent := range parents {
+		bit := uint(1) << uint(n)
+		// Lines removed by a change hang on the first result line of the
+		// change, or on the line after it when it only removes lines.
+		lost := map[int][]string{}
+		pos, bucket := 0, -1
+		for _, op := range diffLines(parent, result, nil) {
+			switch op.kind {
+			case '=':
+				pos, bucket = pos+1, -1
+			case '+':
+				if bucket < 0 {
+					bucket = pos
+				}
+				lines[pos].flag |= bit
+				pos++
+			default:
+				if bucket < 0 {
+					bucket = pos
+				}
+				lost[bucket] = append(lost[bucket], strings.TrimSuffix(parent[op.oldLine], "\n"))
+			}
+		}
+
+		parentLine := 1
+		for i := 0; i <= cnt; i++ {
+			lines[i].parentLine[n] = parentLine
+			if removed, ok := lost[i]; ok {
+				lines[i].lost = coalesceLost(lines[i].lost, removed, bit)
+			}
+			for _, l := range lines[i].lost {
+				if l.parents&bit != 0 {
+					parentLine++
+				}
+			}
+			if i < cnt && lines[i].flag&bit == 0 {
+				parentLine++
+			}
+		}
+		lines[cnt+1].parentLine[n] = parentLine
+	}
+	return lines
+}
+
+// coalesceLost merges the lines a parent lost into the ones the previous
+// parents lost, sharing the lines of their longest common subsequence.
+func coalesceLost(base []*lostLine, removed []string, bit uint) []*lostLine {
+	const (
+		fromBase = iota
+		fromNew
+		match
+	)
+	lcs := make([][]int, len(base)+1)
+	dirs := make([][]int, len(base)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(removed)+1)
+		dirs[i] = make([]int, len(removed)+1)
+		dirs[i][0] = fromBase
+	}
+	for j := 1; j <= len(removed); j++ {
+		dirs[0][j] = fromNew
+	}
+	for i := 1; i <= len(base); i++ {
+		for j := 1; j <= len(removed); j++ {
+			switch {
+			case base[i-1].text == removed[j-1]:
+				lcs[i][j], dirs[i][j] = lcs[i-1][j-1]+1, match
+			case lcs[i][j-1] >= lcs[i-1][j]:
+				lcs[i][j], dirs[i][j] = lcs[i][j-1], fromNew
+			default:
+				lcs[i][j], dirs[i][j] = lcs[i-1][j], fromBase
+			}
+		}
+	}
+
+	// The lines are found from the end.
+	out := []*lostLine{}
+	i, j := len(base), len(removed)
+	for i > 0 || j > 0 {
+		switch dirs[i][j] {
+		case match:
+			base[i-1].parents |= bit
+			out = append(out, base[i-1])
+			i, j = i-1, j-1
+		case fromNew:
+			out = append(out, &lostLine{text: removed[j-1], parents: bit})
+			j--
+		default:
+			out = append(out, base[i-1])
+			i--
+		}
+	}
+	for l, r := 0, len(out)-1; l < r; l, r = l+1, r-1 {
+		out[l], out[r] = out[r], out[l]
+	}
+	return out
+}
+
+// interesting tells whether a line differs from some parent.
+func (l *combinedLine) interesting(allMask uint) bool {
+	return l.flag&allMask != 0 || len(l.lost) > 0
+}
+
+// adjustHunkTail moves the end of a hunk before its last line when that
+// line is only there to hang removed lines on, since it is shown anyway.
+func adjustHunkTail(lines []*combinedLine, allMask uint, hunkBegin, i int) int {
+	if hunkBegin+1 <= i && lines[i-1].flag&allMask == 0 {
+		i--
+	}
+	return i
+}
+
+// findNextCombined finds the next line from i which is marked, or not
+// marked when uninteresting is set.
+func findNextCombined(lines []*combinedLine, mark uint, i, cnt int, uninteresting bool) int {
+	for i <= cnt {
+		if (lines[i].flag&mark == 0) == uninteresting {
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// makeCombinedHunks marks the lines to show, dropping the hunks where the
+// result matches one of the parents, and tells whether any is left.
+func makeCombinedHunks(lines []*combinedLine, numParents int) bool {
+	cnt := len(lines) - 2
+	allMask := uint(1)<<uint(numParents) - 1
+	mark := uint(1) << uint(numParents)
+	for i := 0; i <= cnt; i++ {
+		if lines[i].interesting(allMask) {
+			lines[i].flag |= mark
+		} else {
+			lines[i].flag &^= mark
+		}
+	}
+
+	i := 0
+	for i <= cnt {
+		for i <= cnt && lines[i].flag&mark == 0 {
+			i++
+		}
+		if i > cnt {
+			break
+		}
+		hunkBegin := i
+		j := i + 1
+		for ; j <= cnt; j++ {
+			if lines[j].flag&mark != 0 {
+				continue
+			}
+			// Look past the end for an interesting line within the
+			// context of this hunk.
+			la := adjustHunkTail(lines, allMask, hunkBegin, j) + diffContext
+			if la > cnt+1 {
+				la = cnt + 1
+			}
+			contin := false
+			for la > 0 {
+				la--
+				if la < j {
+					break
+				}
+				if lines[la].flag&mark != 0 {
+					contin = true
+					break
+				}
+			}
+			if !contin {
+				break
+			}
+			j = la
+		}
+		hunkEnd := j
+
+		// The hunk is only interesting when it shows more than two
+		// versions, or when the result matches none of the parents:
+		// every line then differs from the same set of parents, all of
+		// them.
+		var sameDiff uint
+		hasInteresting := false
+		for j := i; j < hunkEnd && !hasInteresting; j++ {
+			if diff := lines[j].flag & allMask; diff != 0 {
+				if sameDiff == 0 {
+					sameDiff = diff
+				} else if sameDiff != diff {
+					hasInteresting = true
+					break
+				}
+			}
+			for _, l := range lines[j].lost {
+				if hasInteresting {
+					break
+				}
+				if sameDiff == 0 {
+					sameDiff = l.parents
+				} else if sameDiff != l.parents {
+					hasInteresting = true
+				}
+			}
+		}
+		if !hasInteresting && sameDiff != allMask {
+			for j := hunkBegin; j < hunkEnd; j++ {
+				lines[j].flag &^= mark
+			}
+		}
+		i = hunkEnd
+	}
+	return giveCombinedContext(lines, numParents)
+}
+
+// giveCombinedContext marks the context lines around the marked lines,
+// joining hunks separated by a short gap.
+func giveCombinedContext(lines []*combinedLine, numParents int) bool {
+	cnt := len(lines) - 2
+	allMask := uint(1)<<uint(numParents) - 1
+	mark := uint(1) << uint(numParents)
+	noPreDelete := uint(2) << uint(numParents)
+
+	i := findNextCombined(lines, mark, 0, cnt, false)
+	if i > cnt {
+		return false
+	}
+	for i <= cnt {
+		j := i - diffContext
+		if j < 0 {
+			j = 0
+		}
+		// Paint a few lines before the first interesting one.
+		for ; j < i; j++ {
+			if lines[j].flag&mark == 0 {
+				lines[j].flag |= noPreDelete
+			}
+			lines[j].flag |= mark
+		}
+
+		for {
+			j = findNextCombined(lines, mark, i, cnt, true)
+			if j > cnt {
+				return true
+			}
+			k := findNextCombined(lines, mark, j, cnt, false)
+			j = adjustHunkTail(lines, allMask, i, j)
+			if k < j+diffContext {
+				// The gap is small enough to join the hunks.
+				for ; j < k; j++ {
+					lines[j].flag |= mark
+				}
+				i = k
+				continue
+			}
+			// Paint the trailing context.
+			i = k
+			end := j + diffContext
+			if end > cnt+1 {
+				end = cnt + 1
+			}
+			for ; j < end; j++ {
+				lines[j].flag |= mark
+			}
+			break
+		}
+	}
+	return true
+}
+
+// writeCombinedHunks writes the marked lines, with a column of markers
+// for each parent.
+func writeCombinedHunks(sb *strings.Builder, lines []*combinedLine, numParents int) {
+	cnt := len(lines) - 2
+	mark := uint(1) << uint(numParents)
+	noPreDelete := uint(2) << uint(numParents)
+	markers := strings.Repeat("@", numParents+1)
+
+	lno := 0
+	for {
+		comment := ""
+		for lno <= cnt && lines[lno].flag&mark == 0 {
+			if lno < cnt && isFuncLine(lines[lno].text) {
+				comment = lines[lno].text
+			}
+			lno++
+		}
+		if lno > cnt {
+			break
+		}
+		hunkEnd := lno + 1
+		for hunkEnd <= cnt && lines[hunkEnd].flag&mark != 0 {
+			hunkEnd++
+		}
+		rlines := hunkEnd - lno
+		if hunkEnd > cnt {
+			rlines--
+		}
+
+		sb.WriteString(markers)
+		for n := 0; n < numParents; n++ {
+			l0, l1 := lines[lno].parentLine[n], lines[hunkEnd].parentLine[n]
+			sb.WriteString(fmt.Sprintf(" -%v,%v", l0, l1-l0))
+		}
+		sb.WriteString(fmt.Sprintf(" +%v,%v %v", lno+1, rlines, markers))
+		if comment != "" {
+			if len(comment) > 40 {
+				comment = comment[:40]
+			}
+			// Like git, the last non-blank character is left out.
+			end := len(strings.TrimRight(comment, " \t\r\v\f")) - 1
+			if end > 0 {
+				sb.WriteString(" " + comment[:end])
+			}
+		}
+		sb.WriteString("\n")
+
+		for lno < hunkEnd {
+			l := lines[lno]
+			lno++
+			if l.flag&noPreDelete == 0 {
+				for _, lost := range l.lost {
+					for n := 0; n < numParents; n++ {
+						if lost.parents&(1<<uint(n)) != 0 {
+							sb.WriteString("-")
+						} else {
+							sb.WriteString(" ")
+						}
+					}
+					sb.WriteString(lost.text + "\n")
+				}
+			}
+			if lno > cnt {
+				break
+			}
+			for n := 0; n < numParents; n++ {
+				if l.flag&(1<<uint(n)) != 0 {
+					sb.WriteString("+")
+				} else {
+					sb.WriteString(" ")
+				}
+			}
+			sb.WriteString(l.text + "\n")
+		}
+	}
+}
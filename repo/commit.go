@@ -3,6 +3,7 @@ package repo
 import (
 	"fmt"
 	"log"
+	"regexp"
 	"strings"
 
 	"github.com/pencil001/pit/util"
@@ -56,19 +57,19 @@ func (c *Commit) Serialize() (string, error) {
 }
 
 func (c *Commit) Deserialize(data []byte) error {
-	return c.parse([]rune(string(data)))
+	return c.parse(data)
 }
 
-func (c *Commit) parse(rs []rune) error {
-	idxSpace := util.FindInRunes(rs, ' ', 0)
-	idxNewLine := util.FindInRunes(rs, '\n', 0)
+func (c *Commit) parse(rs []byte) error {
+	idxSpace := util.FindInBytes(rs, ' ', 0)
+	idxNewLine := util.FindInBytes(rs, '\n', 0)
 
 	// Base case
 	// =========
 	// If newline appears first (or there's no space at all, in which
 	// case find returns -1), we assume a blank line.  A blank line
 	// means the remainder of the data is the message.
-	if idxSpace < 0 || idxNewLine < idxSpace {
+	if idxSpace < 0 || (idxNewLine >= 0 && idxNewLine < idxSpace) {
 		if idxNewLine != 0 {
 			return fmt.Errorf("No blank line")
 		}
@@ -86,10 +87,13 @@ func (c *Commit) parse(rs []rune) error {
 
 	// Find the end of the value.  Continuation lines begin with a
 	// space, so we loop until we find a "\n" not followed by a space.
-	end := 0
+	end := idxSpace
 	for {
-		end = util.FindInRunes(rs, '\n', end+1)
-		if rs[end+1] != ' ' {
+		end = util.FindInBytes(rs, '\n', end+1)
+		if end < 0 {
+			return fmt.Errorf("Unterminated header %v", key)
+		}
+		if end+1 >= len(rs) || rs[end+1] != ' ' {
 			break
 		}
 	}
@@ -97,15 +101,12 @@ func (c *Commit) parse(rs []rune) error {
 	// Grab the value
 	// Also, drop the leading space on continuation lines
 	value := strings.ReplaceAll(string(rs[idxSpace+1:end]), "\n ", "\n")
-	exist := false
-	for _, kl := range c.kvlm {
-		if kl.key == key {
-			kl.list = append(kl.list, value)
-			exist = true
-			break
-		}
-	}
-	if !exist {
+
+	// Repeated keys such as "parent" are kept together as long as they
+	// are adjacent, so that serializing gives back the same bytes.
+	if n := len(c.kvlm); n > 0 && c.kvlm[n-1].key == key {
+		c.kvlm[n-1].list = append(c.kvlm[n-1].list, value)
+	} else {
 		c.kvlm = append(c.kvlm, KList{
 			key:  key,
 			list: []string{value},
@@ -113,3 +114,104 @@ func (c *Commit) parse(rs []rune) error {
 	}
 	return c.parse(rs[end+1:])
 }
+
+// values returns every value of a header, in order.
+func (c *Commit) values(key string) []string {
+	values := []string{}
+	for _, kl := range c.kvlm {
+		if kl.key == key {
+			values = append(values, kl.list...)
+		}
+	}
+	return values
+}
+
+func (c *Commit) value(key string) string {
+	values := c.values(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Header is a header line of a commit or tag that has no dedicated
+// accessor, such as "encoding", "mergetag" or "gpgsig".
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Trailer is a "Key: value" line from the last paragraph of a message,
+// such as "Signed-off-by: A U Thor <author@example.com>".
+type Trailer struct {
+	Key   string
+	Value string
+}
+
+func (c *Commit) Tree() string {
+	return c.value("tree")
+}
+
+func (c *Commit) Parents() []string {
+	return c.values("parent")
+}
+
+func (c *Commit) Author() (Signature, error) {
+	return parseSignature(c.value("author"))
+}
+
+func (c *Commit) Committer() (Signature, error) {
+	return parseSignature(c.value("committer"))
+}
+
+func (c *Commit) Message() string {
+	return c.value("")
+}
+
+func (c *Commit) ExtraHeaders() []Header {
+	return c.extraHeaders("tree", "parent", "author", "committer")
+}
+
+func (c *Commit) Trailers() []Trailer {
+	return parseTrailers(c.Message())
+}
+
+func (c *Commit) extraHeaders(known ...string) []Header {
+	headers := []Header{}
+	for _, kl := range c.kvlm {
+		if kl.key == "" || util.ObjectIn(known, kl.key) {
+			continue
+		}
+		for _, v := range kl.list {
+			headers = append(headers, Header{Key: kl.key, Value: v})
+		}
+	}
+	return headers
+}
+
+var trailerRegex = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*)\s*:\s*(.*)$`)
+
+// parseTrailers reads the trailers of a message. Like git, it only looks
+// at the last paragraph, and only when every line of it is a trailer or
+// the continuation of one.
+func parseTrailers(message string) []Trailer {
+	paragraphs := strings.Split(strings.TrimRight(message, "\n"), "\n\n")
+	if len(paragraphs) < 2 {
+		return nil
+	}
+	last := paragraphs[len(paragraphs)-1]
+
+	trailers := []Trailer{}
+	for _, line := range strings.Split(last, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(trailers) > 0 {
+			trailers[len(trailers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		m := trailerRegex.FindStringSubmatch(line)
+		if m == nil {
+			return nil
+		}
+		trailers = append(trailers, Trailer{Key: m[1], Value: strings.TrimSpace(m[2])})
+	}
+	return trailers
+}
@@ -0,0 +1,589 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/pencil001/pit/util"
+)
+
+// The commit-graph file caches the parents, root tree, commit date and
+// generation number of commits, so that history can be walked without
+// inflating commit objects. See gitformat-commit-graph(5).
+const (
+	graphSignature  = "CGPH"
+	graphVersion    = 1
+	graphHeaderSize = 8
+
+	graphChunkFanout    = "OIDF"
+	graphChunkLookup    = "OIDL"
+	graphChunkData      = "CDAT"
+	graphChunkEdges     = "EDGE"
+	graphChunkBloomIdx  = "BIDX"
+	graphChunkBloomData = "BDAT"
+
+	graphChunkIDSize = 12
+	graphFanoutSize  = 256 * 4
+	bloomHeaderSize  = 12
+
+	graphParentNone = 0x70000000
+	graphEdgeFlag   = 0x80000000
+	graphLastEdge   = 0x80000000
+	graphMaxGen     = 0x3fffffff
+	graphNoGen      = 0xffffffff
+)
+
+type commitGraph struct {
+	format *ObjectFormat
+	data   []byte
+	chunks map[string][]byte
+
+	numCommits   uint32
+	bloomVersion int
+}
+
+// graphCommit is what the commit-graph knows about a commit.
+type graphCommit struct {
+	tree       string
+	parents    []string
+	generation uint32
+	commitTime int64
+}
+
+func (r *Repository) commitGraphPath() string {
+	return path.Join(r.commonDir, "objects", "info", "commit-graph")
+}
+
+// commitGraph loads the commit-graph file once. It returns nil when there
+// is none, when core.commitGraph is off or when the file is unusable, in
+// which case callers fall back to parsing objects.
+func (r *Repository) commitGraph() *commitGraph {
+	if r.graphLoaded {
+		return r.graph
+	}
+	r.graphLoaded = true
+	if !r.configBool("core.commitgraph", true) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(r.commitGraphPath())
+	if err != nil {
+		return nil
+	}
+	graph, err := parseCommitGraph(data, r.objectFormat())
+	if err != nil {
+		return nil
+	}
+	r.graph = graph
+	return graph
+}
+
+func parseCommitGraph(data []byte, format *ObjectFormat) (*commitGraph, error) {
+	if len(data) < graphHeaderSize+graphChunkIDSize+format.Size {
+		return nil, errors.New("commit-graph file is too small")
+	}
+	if string(data[:4]) != graphSignature {
+		return nil, errors.New("commit-graph signature does not match")
+	}
+	if data[4] != graphVersion {
+		return nil, fmt.Errorf("commit-graph version %v does not match version %v", data[4], graphVersion)
+	}
+	if want := graphHashVersion(format); data[5] != want {
+		return nil, fmt.Errorf("commit-graph hash version %v does not match version %v", data[5], want)
+	}
+	if data[7] != 0 {
+		return nil, errors.New("commit-graph chains are not supported")
+	}
+
+	g := &commitGraph{format: format, data: data, chunks: map[string][]byte{}}
+	numChunks := int(data[6])
+	end := uint64(len(data) - format.Size)
+	table := data[graphHeaderSize:]
+	if len(table) < (numChunks+1)*graphChunkIDSize {
+		return nil, errors.New("commit-graph chunk table is truncated")
+	}
+	for i := 0; i < numChunks; i++ {
+		entry := table[i*graphChunkIDSize:]
+		next := table[(i+1)*graphChunkIDSize:]
+		id := string(entry[:4])
+		start := binary.BigEndian.Uint64(entry[4:12])
+		stop := binary.BigEndian.Uint64(next[4:12])
+		if start > stop || stop > end {
+			return nil, fmt.Errorf("commit-graph chunk %v is out of bounds", id)
+		}
+		g.chunks[id] = data[start:stop]
+	}
+
+	fanout := g.chunks[graphChunkFanout]
+	if len(fanout) != graphFanoutSize {
+		return nil, errors.New("commit-graph is missing the OID fanout chunk")
+	}
+	g.numCommits = binary.BigEndian.Uint32(fanout[255*4:])
+	if uint64(len(g.chunks[graphChunkLookup])) != uint64(g.numCommits)*uint64(format.Size) {
+		return nil, errors.New("commit-graph OID lookup chunk is the wrong size")
+	}
+	if uint64(len(g.chunks[graphChunkData])) != uint64(g.numCommits)*uint64(format.Size+16) {
+		return nil, errors.New("commit-graph commit data chunk is the wrong size")
+	}
+
+	// Bloom filters are optional; ignore them when they look wrong.
+	bidx, bdat := g.chunks[graphChunkBloomIdx], g.chunks[graphChunkBloomData]
+	if uint64(len(bidx)) == uint64(g.numCommits)*4 && len(bdat) >= bloomHeaderSize {
+		version := binary.BigEndian.Uint32(bdat)
+		if (version == 1 || version == 2) &&
+			binary.BigEndian.Uint32(bdat[4:]) == bloomNumHashes &&
+			binary.BigEndian.Uint32(bdat[8:]) == bloomBitsPerEntry {
+			g.bloomVersion = int(version)
+		}
+	}
+	return g, nil
+}
+
+func graphHashVersion(format *ObjectFormat) byte {
+	if format == FormatSHA256 {
+		return 2
+	}
+	return 1
+}
+
+func (g *commitGraph) oid(pos uint32) string {
+	size := uint32(g.format.Size)
+	return util.BytesToHexStr(g.chunks[graphChunkLookup][pos*size : (pos+1)*size])
+}
+
+// lookup finds the position of a commit in the graph.
+func (g *commitGraph) lookup(sha string) (uint32, bool) {
+	raw := util.HexStrToBytes(sha)
+	if len(raw) != g.format.Size {
+		return 0, false
+	}
+	fanout := g.chunks[graphChunkFanout]
+	lo := uint32(0)
+	if raw[0] > 0 {
+		lo = binary.BigEndian.Uint32(fanout[(int(raw[0])-1)*4:])
+	}
+	hi := binary.BigEndian.Uint32(fanout[int(raw[0])*4:])
+	if hi > g.numCommits || lo > hi {
+		return 0, false
+	}
+
+	oids := g.chunks[graphChunkLookup]
+	size := uint32(g.format.Size)
+	i := sort.Search(int(hi-lo), func(i int) bool {
+		pos := lo + uint32(i)
+		return bytes.Compare(oids[pos*size:(pos+1)*size], raw) >= 0
+	})
+	pos := lo + uint32(i)
+	if pos < hi && bytes.Equal(oids[pos*size:(pos+1)*size], raw) {
+		return pos, true
+	}
+	return 0, false
+}
+
+func (g *commitGraph) parentAt(value uint32) (string, error) {
+	if value >= g.numCommits {
+		return "", fmt.Errorf("commit-graph has an invalid parent position %v", value)
+	}
+	return g.oid(value), nil
+}
+
+// commit decodes the commit data at pos.
+func (g *commitGraph) commit(pos uint32) (graphCommit, error) {
+	size := uint32(g.format.Size)
+	entry := g.chunks[graphChunkData][pos*(size+16) : (pos+1)*(size+16)]
+	c := graphCommit{tree: util.BytesToHexStr(entry[:size]), parents: []string{}}
+
+	p1 := binary.BigEndian.Uint32(entry[size:])
+	p2 := binary.BigEndian.Uint32(entry[size+4:])
+	if p1 != graphParentNone {
+		sha, err := g.parentAt(p1)
+		if err != nil {
+			return c, err
+		}
+		c.parents = append(c.parents, sha)
+	}
+	if p2&graphEdgeFlag != 0 {
+		edges := g.chunks[graphChunkEdges]
+		for i := p2 &^ graphEdgeFlag; ; i++ {
+			if uint64(i+1)*4 > uint64(len(edges)) {
+				return c, errors.New("commit-graph extra edge list is truncated")
+			}
+			value := binary.BigEndian.Uint32(edges[i*4:])
+			sha, err := g.parentAt(value &^ graphLastEdge)
+			if err != nil {
+				return c, err
+			}
+			c.parents = append(c.parents, sha)
+			if value&graphLastEdge != 0 {
+				break
+			}
+		}
+	} else if p2 != graphParentNone {
+		sha, err := g.parentAt(p2)
+		if err != nil {
+			return c, err
+		}
+		c.parents = append(c.parents, sha)
+	}
+
+	genTime := binary.BigEndian.Uint32(entry[size+8:])
+	c.generation = genTime >> 2
+	c.commitTime = int64(genTime&3)<<32 | int64(binary.BigEndian.Uint32(entry[size+12:]))
+	return c, nil
+}
+
+// bloomFilter returns the changed-path filter of the commit at pos, or
+// false when the graph has none.
+func (g *commitGraph) bloomFilter(pos uint32) ([]byte, bool) {
+	if g.bloomVersion == 0 {
+		return nil, false
+	}
+	bidx, bdat := g.chunks[graphChunkBloomIdx], g.chunks[graphChunkBloomData][bloomHeaderSize:]
+	start := uint32(0)
+	if pos > 0 {
+		start = binary.BigEndian.Uint32(bidx[(pos-1)*4:])
+	}
+	end := binary.BigEndian.Uint32(bidx[pos*4:])
+	if start > end || int(end) > len(bdat) {
+		return nil, false
+	}
+	return bdat[start:end], true
+}
+
+// maybeChangedPath reports whether the commit sha may have changed path
+// compared to its first parent. It answers true whenever the graph can't
+// tell.
+func (r *Repository) maybeChangedPath(sha string, path string) bool {
+	g := r.commitGraph()
+	if g == nil {
+		return true
+	}
+	pos, ok := g.lookup(sha)
+	if !ok {
+		return true
+	}
+	filter, ok := g.bloomFilter(pos)
+	if !ok {
+		return true
+	}
+	return bloomContains(filter, path, g.bloomVersion)
+}
+
+// writeCommitGraph writes a commit-graph file for every commit reachable
+// from the refs and HEAD, with changed-path Bloom filters when asked to.
+func (r *Repository) writeCommitGraph(changedPaths bool) (int, error) {
+	tips := []string{}
+	if head, err := r.readRef("HEAD", map[string]string{}); err == nil && head != "" {
+		tips = append(tips, head)
+	}
+	refs, err := r.getRefs()
+	if err != nil {
+		return 0, err
+	}
+	for _, sha := range refs {
+		tips = append(tips, sha)
+	}
+
+	// Collect the commits, peeling tags.
+	commits := map[string]graphCommit{}
+	stack := []string{}
+	for _, sha := range tips {
+		sha, err := peelRev(r, sha, "")
+		if err != nil {
+			continue
+		}
+		if obj, err := r.readObject(sha); err == nil && obj.GetFormat() == TypeCommit {
+			stack = append(stack, sha)
+		}
+	}
+	for len(stack) > 0 {
+		sha := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := commits[sha]; ok {
+			continue
+		}
+		commit := createCommit(r, nil)
+		if err := commit.Read(sha); err != nil {
+			return 0, err
+		}
+		committer, _ := commit.Committer()
+		c := graphCommit{tree: commit.Tree(), parents: commit.Parents(), generation: graphNoGen}
+		if !committer.When.IsZero() {
+			c.commitTime = committer.When.Unix()
+		}
+		commits[sha] = c
+		stack = append(stack, c.parents...)
+	}
+
+	oids := make([]string, 0, len(commits))
+	for sha := range commits {
+		oids = append(oids, sha)
+	}
+	sort.Strings(oids)
+	positions := map[string]uint32{}
+	for i, sha := range oids {
+		positions[sha] = uint32(i)
+	}
+	for _, sha := range oids {
+		computeGeneration(commits, sha)
+	}
+
+	var filters [][]byte
+	if changedPaths {
+		for _, sha := range oids {
+			c := commits[sha]
+			parentTree := ""
+			if len(c.parents) > 0 {
+				parentTree = commits[c.parents[0]].tree
+			}
+			changes, err := r.diffTrees(parentTree, c.tree)
+			if err != nil {
+				return 0, err
+			}
+			paths := make([]string, len(changes))
+			for i, ch := range changes {
+				paths[i] = ch.path
+			}
+			filters = append(filters, newBloomFilter(paths, bloomHashVersion))
+		}
+	}
+
+	data := encodeCommitGraph(r.objectFormat(), oids, commits, positions, filters)
+	graphPath := r.commitGraphPath()
+	if err := util.CreateDir(path.Dir(graphPath)); err != nil {
+		return 0, err
+	}
+	lockPath := graphPath + ".lock"
+	if err := ioutil.WriteFile(lockPath, data, 0444); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(lockPath, graphPath); err != nil {
+		os.Remove(lockPath)
+		return 0, err
+	}
+	r.graph, r.graphLoaded = nil, false
+	return len(oids), nil
+}
+
+// computeGeneration sets the topological level of a commit: one more than
+// the highest level of its parents, and 1 for root commits.
+func computeGeneration(commits map[string]graphCommit, sha string) uint32 {
+	stack := []string{sha}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		c := commits[top]
+		if c.generation != graphNoGen {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		gen, ready := uint32(0), true
+		for _, p := range c.parents {
+			pc := commits[p]
+			if pc.generation == graphNoGen {
+				stack = append(stack, p)
+				ready = false
+			} else if pc.generation > gen {
+				gen = pc.generation
+			}
+		}
+		if !ready {
+			continue
+		}
+		if gen < graphMaxGen {
+			gen++
+		}
+		c.generation = gen
+		commits[top] = c
+		stack = stack[:len(stack)-1]
+	}
+	return commits[sha].generation
+}
+
+func encodeCommitGraph(format *ObjectFormat, oids []string, commits map[string]graphCommit, positions map[string]uint32, filters [][]byte) []byte {
+	type chunk struct {
+		id   string
+		data []byte
+	}
+	chunks := []chunk{}
+	be := binary.BigEndian
+
+	counts := [256]uint32{}
+	for _, sha := range oids {
+		counts[util.HexStrToBytes(sha[:2])[0]]++
+	}
+	fanout := make([]byte, graphFanoutSize)
+	total := uint32(0)
+	for i, n := range counts {
+		total += n
+		be.PutUint32(fanout[i*4:], total)
+	}
+	chunks = append(chunks, chunk{graphChunkFanout, fanout})
+
+	var lookup, cdat, edges bytes.Buffer
+	var word [4]byte
+	put := func(buf *bytes.Buffer, v uint32) {
+		be.PutUint32(word[:], v)
+		buf.Write(word[:])
+	}
+	for _, sha := range oids {
+		lookup.Write(util.HexStrToBytes(sha))
+
+		c := commits[sha]
+		cdat.Write(util.HexStrToBytes(c.tree))
+		switch len(c.parents) {
+		case 0:
+			put(&cdat, graphParentNone)
+			put(&cdat, graphParentNone)
+		case 1:
+			put(&cdat, positions[c.parents[0]])
+			put(&cdat, graphParentNone)
+		case 2:
+			put(&cdat, positions[c.parents[0]])
+			put(&cdat, positions[c.parents[1]])
+		default:
+			put(&cdat, positions[c.parents[0]])
+			put(&cdat, graphEdgeFlag|uint32(edges.Len()/4))
+			for i, p := range c.parents[1:] {
+				v := positions[p]
+				if i == len(c.parents)-2 {
+					v |= graphLastEdge
+				}
+				put(&edges, v)
+			}
+		}
+		put(&cdat, c.generation<<2|uint32(c.commitTime>>32)&3)
+		put(&cdat, uint32(c.commitTime))
+	}
+	chunks = append(chunks, chunk{graphChunkLookup, lookup.Bytes()}, chunk{graphChunkData, cdat.Bytes()})
+	if edges.Len() > 0 {
+		chunks = append(chunks, chunk{graphChunkEdges, edges.Bytes()})
+	}
+
+	if filters != nil {
+		var bidx, bdat bytes.Buffer
+		put(&bdat, bloomHashVersion)
+		put(&bdat, bloomNumHashes)
+		put(&bdat, bloomBitsPerEntry)
+		total = 0
+		for _, f := range filters {
+			bdat.Write(f)
+			total += uint32(len(f))
+			put(&bidx, total)
+		}
+		chunks = append(chunks, chunk{graphChunkBloomIdx, bidx.Bytes()}, chunk{graphChunkBloomData, bdat.Bytes()})
+	}
+
+	var out bytes.Buffer
+	out.WriteString(graphSignature)
+	out.Write([]byte{graphVersion, graphHashVersion(format), byte(len(chunks)), 0})
+	offset := uint64(graphHeaderSize + (len(chunks)+1)*graphChunkIDSize)
+	var entry [graphChunkIDSize]byte
+	for _, c := range chunks {
+		copy(entry[:4], c.id)
+		be.PutUint64(entry[4:], offset)
+		out.Write(entry[:])
+		offset += uint64(len(c.data))
+	}
+	copy(entry[:4], []byte{0, 0, 0, 0})
+	be.PutUint64(entry[4:], offset)
+	out.Write(entry[:])
+	for _, c := range chunks {
+		out.Write(c.data)
+	}
+
+	h := format.New()
+	h.Write(out.Bytes())
+	out.Write(h.Sum(nil))
+	return out.Bytes()
+}
+
+// verifyCommitGraph checks the checksum of the commit-graph file and that
+// every commit in it matches its object.
+func (r *Repository) verifyCommitGraph() []error {
+	data, err := ioutil.ReadFile(r.commitGraphPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return []error{err}
+	}
+	format := r.objectFormat()
+	g, err := parseCommitGraph(data, format)
+	if err != nil {
+		return []error{err}
+	}
+
+	errs := []error{}
+	h := format.New()
+	h.Write(data[:len(data)-format.Size])
+	if !bytes.Equal(h.Sum(nil), data[len(data)-format.Size:]) {
+		errs = append(errs, errors.New("the commit-graph file has incorrect checksum and is likely corrupt"))
+	}
+
+	fanout := g.chunks[graphChunkFanout]
+	for i := 1; i < 256; i++ {
+		if binary.BigEndian.Uint32(fanout[(i-1)*4:]) > binary.BigEndian.Uint32(fanout[i*4:]) {
+			errs = append(errs, fmt.Errorf("commit-graph fanout values out of order"))
+			break
+		}
+	}
+
+	prev := ""
+	for pos := uint32(0); pos < g.numCommits; pos++ {
+		sha := g.oid(pos)
+		if prev != "" && sha <= prev {
+			errs = append(errs, fmt.Errorf("commit-graph has incorrect OID order: %v then %v", prev, sha))
+		}
+		prev = sha
+		if found, ok := g.lookup(sha); !ok || found != pos {
+			errs = append(errs, fmt.Errorf("commit-graph has incorrect fanout value for %v", sha))
+		}
+
+		gc, err := g.commit(pos)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		commit := createCommit(r, nil)
+		if err := commit.Read(sha); err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse commit %v from object database for commit-graph", sha))
+			continue
+		}
+		if gc.tree != commit.Tree() {
+			errs = append(errs, fmt.Errorf("root tree OID for commit %v in commit-graph is %v != %v", sha, gc.tree, commit.Tree()))
+		}
+		parents := commit.Parents()
+		if fmt.Sprint(parents) != fmt.Sprint(gc.parents) {
+			errs = append(errs, fmt.Errorf("commit-graph parent list for commit %v is %v != %v", sha, gc.parents, parents))
+		}
+		committer, _ := commit.Committer()
+		if committer.When.IsZero() || gc.commitTime != committer.When.Unix() {
+			errs = append(errs, fmt.Errorf("commit date for commit %v in commit-graph is %v != %v", sha, gc.commitTime, committer.When.Unix()))
+		}
+
+		want := uint32(0)
+		for _, p := range gc.parents {
+			ppos, ok := g.lookup(p)
+			if !ok {
+				errs = append(errs, fmt.Errorf("commit-graph is missing parent %v of commit %v", p, sha))
+				continue
+			}
+			pc, err := g.commit(ppos)
+			if err == nil && pc.generation > want {
+				want = pc.generation
+			}
+		}
+		if want < graphMaxGen {
+			want++
+		}
+		if gc.generation != want {
+			errs = append(errs, fmt.Errorf("commit-graph generation for commit %v is %v != %v", sha, gc.generation, want))
+		}
+	}
+	return errs
+}
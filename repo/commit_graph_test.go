@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMurmur3(t *testing.T) {
+	// Version 1 sign-extends bytes above 0x7f, like git's implementation.
+	cases := []struct {
+		data    string
+		version int
+		want    uint32
+	}{
+		{"", 2, 0x00000000},
+		{"Hello world!", 2, 0x627b0c2c},
+		{"The quick brown fox jumps over the lazy dog", 2, 0x2e4ff723},
+		{"\x99\xaa\xbb\xcc\xdd\xee\xff", 1, 0xdd92776e},
+		{"\x99\xaa\xbb\xcc\xdd\xee\xff", 2, 0xa183ccfd},
+	}
+	for _, c := range cases {
+		if got := murmur3(0, []byte(c.data), c.version); got != c.want {
+			t.Errorf("murmur3(%q, v%v) = %#08x, want %#08x", c.data, c.version, got, c.want)
+		}
+	}
+}
+
+func TestCommitGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	shas := buildHistory(t, repo)
+	if err := repo.writeRef("refs/heads/master", shas["7"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.writeRef("refs/heads/side", shas["4"]); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := repo.mergeBases(shas["5"], shas["4"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := repo.writeCommitGraph(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 7 {
+		t.Errorf("wrote %v commits, want 7", n)
+	}
+	if errs := repo.verifyCommitGraph(); len(errs) != 0 {
+		t.Errorf("verify: %v", errs)
+	}
+
+	g := repo.commitGraph()
+	if g == nil {
+		t.Fatal("commit-graph not loaded")
+	}
+	generations := map[string]uint32{"1": 1, "2": 2, "3": 3, "5": 3, "4": 4, "6": 5, "7": 6}
+	for name, want := range generations {
+		pos, ok := g.lookup(shas[name])
+		if !ok {
+			t.Fatalf("commit %v missing from the graph", name)
+		}
+		gc, err := g.commit(pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gc.generation != want {
+			t.Errorf("commit %v: generation %v, want %v", name, gc.generation, want)
+		}
+	}
+	if gc, _ := g.commit(mustLookup(t, g, shas["6"])); len(gc.parents) != 2 || gc.parents[1] != shas["4"] {
+		t.Errorf("commit 6 parents = %v", gc.parents)
+	}
+	// Every commit has an empty tree, so nothing changed.
+	if repo.maybeChangedPath(shas["7"], "file") {
+		t.Error("the Bloom filter should rule out any path")
+	}
+
+	after, err := repo.mergeBases(shas["5"], shas["4"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 1 || before[0] != shas["2"] || len(after) != 1 || after[0] != shas["2"] {
+		t.Errorf("merge bases: %v without the graph, %v with it", before, after)
+	}
+}
+
+func mustLookup(t *testing.T, g *commitGraph, sha string) uint32 {
+	pos, ok := g.lookup(sha)
+	if !ok {
+		t.Fatalf("commit %v missing from the graph", sha)
+	}
+	return pos
+}
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter([]string{"a/b/c.txt", "d"}, 1)
+	for _, p := range []string{"a", "a/b", "a/b/c.txt", "d"} {
+		if !bloomContains(filter, p, 1) {
+			t.Errorf("%v should be in the filter", p)
+		}
+	}
+	if len(newBloomFilter(make([]string, bloomMaxChangedPaths+1), 1)) != 1 {
+		t.Error("too many changes should give a one byte filter")
+	}
+}
@@ -37,3 +37,84 @@ Create first draft
 	}
 	fmt.Println(string(bs))
 }
+
+func TestCommitRoundTrip(t *testing.T) {
+	str := "tree 29ff16c9c14e2652b22f8b78bb08a5a07930c147\n" +
+		"parent 206941306e8a8af65b66eaaaea388a7ae24d49a0\n" +
+		"parent 3a5b6e0b4a1a1ad1ab5c8a6d8c1bb2cd1f1dd0e1\n" +
+		"author A U Thor <author@example.com> 1527025023 +0200\n" +
+		"committer C O Mitter <committer@example.com> 1527025044 -0530\n" +
+		"encoding ISO-8859-1\n" +
+		"mergetag object 3a5b6e0b4a1a1ad1ab5c8a6d8c1bb2cd1f1dd0e1\n" +
+		" type commit\n" +
+		"\n" +
+		"Merge side\n" +
+		"\n" +
+		"Body text.\n" +
+		"\n" +
+		"Signed-off-by: A U Thor <author@example.com>\n" +
+		"Fixes: the thing\n" +
+		"  that was broken\n"
+
+	c := createCommit(nil, []byte(str))
+	bs, err := c.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs != str {
+		t.Errorf("round trip mismatch:\n%q\nwant:\n%q", bs, str)
+	}
+
+	if got := c.Parents(); len(got) != 2 || got[1] != "3a5b6e0b4a1a1ad1ab5c8a6d8c1bb2cd1f1dd0e1" {
+		t.Errorf("Parents() = %v", got)
+	}
+	committer, err := c.Committer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if committer.Name != "C O Mitter" || committer.Email != "committer@example.com" ||
+		committer.When.Unix() != 1527025044 || committer.When.Format("-0700") != "-0530" {
+		t.Errorf("Committer() = %#v", committer)
+	}
+	if committer.String() != "C O Mitter <committer@example.com> 1527025044 -0530" {
+		t.Errorf("String() = %v", committer.String())
+	}
+	headers := c.ExtraHeaders()
+	if len(headers) != 2 || headers[1].Key != "mergetag" || headers[1].Value != "object 3a5b6e0b4a1a1ad1ab5c8a6d8c1bb2cd1f1dd0e1\ntype commit" {
+		t.Errorf("ExtraHeaders() = %#v", headers)
+	}
+	trailers := c.Trailers()
+	if len(trailers) != 2 || trailers[1].Value != "the thing that was broken" {
+		t.Errorf("Trailers() = %#v", trailers)
+	}
+}
+
+func TestTagAccessors(t *testing.T) {
+	str := "object 29ff16c9c14e2652b22f8b78bb08a5a07930c147\n" +
+		"type commit\n" +
+		"tag v1.0\n" +
+		"tagger T Agger <tagger@example.com> 1527025023 +0000\n" +
+		"\n" +
+		"Release 1.0\n" +
+		"-----BEGIN PGP SIGNATURE-----\n" +
+		"abc\n" +
+		"-----END PGP SIGNATURE-----\n"
+
+	tag := createTag(nil, []byte(str))
+	bs, err := tag.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs != str {
+		t.Errorf("round trip mismatch:\n%q", bs)
+	}
+	if tag.Object() != "29ff16c9c14e2652b22f8b78bb08a5a07930c147" || tag.ObjectType() != TypeCommit || tag.TagName() != "v1.0" {
+		t.Errorf("unexpected headers %v %v %v", tag.Object(), tag.ObjectType(), tag.TagName())
+	}
+	if tag.Message() != "Release 1.0\n" {
+		t.Errorf("Message() = %q", tag.Message())
+	}
+	if tagger, err := tag.Tagger(); err != nil || tagger.Email != "tagger@example.com" {
+		t.Errorf("Tagger() = %#v, %v", tagger, err)
+	}
+}
@@ -0,0 +1,991 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pencil001/pit/util"
+)
+
+const (
+	ScopeSystem   = "system"
+	ScopeGlobal   = "global"
+	ScopeLocal    = "local"
+	ScopeWorktree = "worktree"
+	ScopeCommand  = "command"
+)
+
+const maxIncludeDepth = 10
+
+// configEntry is one "name = value" line of a config file. section and
+// name are lower-cased, subsection keeps its case like git does.
+type configEntry struct {
+	section    string
+	subsection string
+	name       string
+	value      string
+	hasValue   bool
+	origin     string
+	scope      string
+
+	// first and last line of the entry in its file, used when rewriting
+	firstLine int
+	lastLine  int
+}
+
+func (e *configEntry) key() string {
+	if e.subsection == "" {
+		return e.section + "." + e.name
+	}
+	return e.section + "." + e.subsection + "." + e.name
+}
+
+// configSection records where a section header appears in a file, so that
+// new keys can be inserted at the end of an existing section.
+type configSection struct {
+	section    string
+	subsection string
+	lastLine   int
+}
+
+// Config is the merged view of every config file that applies to a
+// repository, in increasing order of precedence.
+type Config struct {
+	entries []configEntry
+}
+
+// configKey is a parsed "section.subsection.name" key.
+type configKey struct {
+	section    string
+	subsection string
+	name       string
+}
+
+func parseConfigKey(key string) (configKey, error) {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 {
+		return configKey{}, fmt.Errorf("Key does not contain a section: %v", key)
+	}
+	ck := configKey{
+		section: strings.ToLower(key[:first]),
+		name:    strings.ToLower(key[last+1:]),
+	}
+	if first != last {
+		ck.subsection = key[first+1 : last]
+	}
+	if !isConfigName(ck.name) {
+		return configKey{}, fmt.Errorf("Invalid key: %v", key)
+	}
+	return ck, nil
+}
+
+func (ck configKey) match(e *configEntry) bool {
+	return ck.section == e.section && ck.subsection == e.subsection && ck.name == e.name
+}
+
+func isConfigName(name string) bool {
+	if name == "" || !isAlpha(name[0]) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isAlpha(name[i]) && !isDigit(name[i]) && name[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// configFileParser parses the git config syntax. It keeps track of line
+// numbers so that the same parser can drive rewriting a file.
+type configFileParser struct {
+	data       []byte
+	pos        int
+	line       int
+	origin     string
+	section    string
+	subsection string
+
+	entries  []configEntry
+	sections []configSection
+}
+
+func parseConfigData(data []byte, origin string) (*configFileParser, error) {
+	p := &configFileParser{data: data, origin: origin}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *configFileParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Bad config line %v in %v: %v", p.line+1, p.origin, fmt.Sprintf(format, args...))
+}
+
+func (p *configFileParser) peek() int {
+	if p.pos >= len(p.data) {
+		return -1
+	}
+	return int(p.data[p.pos])
+}
+
+func (p *configFileParser) next() int {
+	c := p.peek()
+	if c < 0 {
+		return c
+	}
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *configFileParser) skipComment() {
+	for c := p.peek(); c >= 0 && c != '\n'; c = p.peek() {
+		p.next()
+	}
+}
+
+func (p *configFileParser) parse() error {
+	for {
+		c := p.peek()
+		switch {
+		case c < 0:
+			return nil
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			p.next()
+		case c == '#' || c == ';':
+			p.skipComment()
+		case c == '[':
+			if err := p.parseSection(); err != nil {
+				return err
+			}
+		case isAlpha(byte(c)):
+			if err := p.parseEntry(); err != nil {
+				return err
+			}
+		default:
+			return p.errorf("unexpected character %q", c)
+		}
+	}
+}
+
+func (p *configFileParser) parseSection() error {
+	p.next()
+	start := p.pos
+	for c := p.peek(); c >= 0 && (isAlpha(byte(c)) || isDigit(byte(c)) || c == '-' || c == '.'); c = p.peek() {
+		p.next()
+	}
+	name := string(p.data[start:p.pos])
+	if name == "" {
+		return p.errorf("empty section name")
+	}
+
+	subsection := ""
+	switch p.peek() {
+	case ']':
+		p.next()
+		// Deprecated [section.subsection] syntax.
+		if idx := strings.Index(name, "."); idx >= 0 {
+			subsection = strings.ToLower(name[idx+1:])
+			name = name[:idx]
+		}
+	case ' ', '\t':
+		for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+			p.next()
+		}
+		if p.next() != '"' {
+			return p.errorf("missing quote in section header")
+		}
+		var sb strings.Builder
+		for {
+			c := p.next()
+			if c < 0 || c == '\n' {
+				return p.errorf("unterminated section header")
+			}
+			if c == '"' {
+				break
+			}
+			if c == '\\' {
+				c = p.next()
+				if c < 0 || c == '\n' {
+					return p.errorf("unterminated section header")
+				}
+			}
+			sb.WriteByte(byte(c))
+		}
+		if p.next() != ']' {
+			return p.errorf("missing ] in section header")
+		}
+		subsection = sb.String()
+	default:
+		return p.errorf("invalid section header")
+	}
+
+	p.section = strings.ToLower(name)
+	p.subsection = subsection
+	p.sections = append(p.sections, configSection{
+		section:    p.section,
+		subsection: p.subsection,
+		lastLine:   p.line,
+	})
+	return nil
+}
+
+func (p *configFileParser) parseEntry() error {
+	if p.section == "" {
+		return p.errorf("key outside of any section")
+	}
+	firstLine := p.line
+	start := p.pos
+	for c := p.peek(); c >= 0 && (isAlpha(byte(c)) || isDigit(byte(c)) || c == '-'); c = p.peek() {
+		p.next()
+	}
+	entry := configEntry{
+		section:    p.section,
+		subsection: p.subsection,
+		name:       strings.ToLower(string(p.data[start:p.pos])),
+		origin:     p.origin,
+		firstLine:  firstLine,
+	}
+
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.next()
+	}
+	switch c := p.peek(); {
+	case c == '=':
+		p.next()
+		value, err := p.parseValue()
+		if err != nil {
+			return err
+		}
+		entry.value = value
+		entry.hasValue = true
+	case c < 0 || c == '\n' || c == '\r' || c == '#' || c == ';':
+		p.skipComment()
+	default:
+		return p.errorf("invalid key %q", string(p.data[start:p.pos+1]))
+	}
+
+	entry.lastLine = p.line
+	p.entries = append(p.entries, entry)
+	p.sections[len(p.sections)-1].lastLine = p.line
+	return nil
+}
+
+// parseValue reads a value up to the end of line, handling quotes,
+// escapes, comments and backslash-newline continuations.
+func (p *configFileParser) parseValue() (string, error) {
+	var sb strings.Builder
+	quoted := false
+	spaces := 0
+	for {
+		c := p.peek()
+		if c < 0 || c == '\n' {
+			if quoted {
+				return "", p.errorf("unterminated quote")
+			}
+			return sb.String(), nil
+		}
+		p.next()
+
+		if !quoted && (c == '#' || c == ';') {
+			p.skipComment()
+			return sb.String(), nil
+		}
+		if !quoted && (c == ' ' || c == '\t' || c == '\r') {
+			if sb.Len() > 0 {
+				spaces++
+			}
+			continue
+		}
+		for ; spaces > 0; spaces-- {
+			sb.WriteByte(' ')
+		}
+
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			e := p.next()
+			switch e {
+			case '\n':
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'b':
+				sb.WriteByte('\b')
+			case '\\', '"':
+				sb.WriteByte(byte(e))
+			default:
+				return "", p.errorf("invalid escape sequence")
+			}
+		default:
+			sb.WriteByte(byte(c))
+		}
+	}
+}
+
+// configLoader reads config files and follows their include directives,
+// unless noIncludes is set.
+type configLoader struct {
+	gitDir     string
+	noIncludes bool
+	entries    []configEntry
+}
+
+func (l *configLoader) loadFile(filePath string, scope string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("Exceeded maximum include depth (%v) while including %v", maxIncludeDepth, filePath)
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p, err := parseConfigData(data, filePath)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range p.entries {
+		e.scope = scope
+		l.entries = append(l.entries, e)
+
+		if l.noIncludes || e.name != "path" || !e.hasValue {
+			continue
+		}
+		include := false
+		switch {
+		case e.section == "include" && e.subsection == "":
+			include = true
+		case e.section == "includeif":
+			include = l.matchCondition(e.subsection, filePath)
+		}
+		if !include {
+			continue
+		}
+		incPath := expandConfigPath(e.value)
+		if !filepath.IsAbs(incPath) {
+			incPath = path.Join(path.Dir(filePath), incPath)
+		}
+		if err := l.loadFile(incPath, scope, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchCondition evaluates the condition of an [includeIf] section.
+func (l *configLoader) matchCondition(cond string, filePath string) bool {
+	icase := false
+	var pattern string
+	switch {
+	case strings.HasPrefix(cond, "gitdir:"):
+		pattern = strings.TrimPrefix(cond, "gitdir:")
+	case strings.HasPrefix(cond, "gitdir/i:"):
+		pattern = strings.TrimPrefix(cond, "gitdir/i:")
+		icase = true
+	default:
+		return false
+	}
+	if l.gitDir == "" || pattern == "" {
+		return false
+	}
+
+	gitDir, err := filepath.Abs(l.gitDir)
+	if err != nil {
+		return false
+	}
+	if real, err := filepath.EvalSymlinks(gitDir); err == nil {
+		gitDir = real
+	}
+
+	pattern = expandConfigPath(pattern)
+	if strings.HasPrefix(pattern, "./") {
+		pattern = path.Join(path.Dir(filePath), pattern[2:])
+	}
+	if !path.IsAbs(pattern) && !strings.HasPrefix(pattern, "**/") {
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return util.MatchGlob(pattern, gitDir, icase)
+}
+
+// expandConfigPath expands a leading "~/" the way git does for paths in
+// config files.
+func expandConfigPath(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return path.Join(home, p[2:])
+		}
+	}
+	return p
+}
+
+// systemConfigPath returns the system wide config file, or "" when it is
+// disabled by GIT_CONFIG_NOSYSTEM.
+func systemConfigPath() string {
+	if v := os.Getenv("GIT_CONFIG_NOSYSTEM"); v != "" {
+		if noSystem, err := parseBool(v); err == nil && noSystem {
+			return ""
+		}
+	}
+	if p := os.Getenv("GIT_CONFIG_SYSTEM"); p != "" {
+		return p
+	}
+	return "/etc/gitconfig"
+}
+
+// globalConfigPaths returns the per-user config files in the order they
+// are read: the XDG one first, then ~/.gitconfig.
+func globalConfigPaths() []string {
+	if p := os.Getenv("GIT_CONFIG_GLOBAL"); p != "" {
+		return []string{p}
+	}
+	paths := []string{}
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	home, _ := os.UserHomeDir()
+	if xdg == "" && home != "" {
+		xdg = path.Join(home, ".config")
+	}
+	if xdg != "" {
+		paths = append(paths, path.Join(xdg, "git", "config"))
+	}
+	if home != "" {
+		paths = append(paths, path.Join(home, ".gitconfig"))
+	}
+	return paths
+}
+
+// globalConfigWritePath picks the per-user file that "--global" writes to:
+// ~/.gitconfig, unless only the XDG file exists.
+func globalConfigWritePath() (string, error) {
+	paths := globalConfigPaths()
+	if len(paths) == 0 {
+		return "", fmt.Errorf("Unable to locate the global config file")
+	}
+	last := paths[len(paths)-1]
+	if isExist, _ := util.IsExist(last); !isExist && len(paths) > 1 {
+		if isExist, _ := util.IsExist(paths[0]); isExist {
+			return paths[0], nil
+		}
+	}
+	return last, nil
+}
+
+// loadConfig reads every config layer that applies to gitDir. gitDir may
+// be empty outside of a repository, in which case only the system and
+// global files are read. The local config is shared by all worktrees,
+// while config.worktree belongs to the one of gitDir.
+func loadConfig(gitDir string) (*Config, error) {
+	return (&configLoader{gitDir: gitDir}).loadLayers()
+}
+
+func (l *configLoader) loadLayers() (*Config, error) {
+	gitDir := l.gitDir
+	if p := systemConfigPath(); p != "" {
+		if err := l.loadFile(p, ScopeSystem, 0); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range globalConfigPaths() {
+		if err := l.loadFile(p, ScopeGlobal, 0); err != nil {
+			return nil, err
+		}
+	}
+	if gitDir != "" {
+		if err := l.loadFile(path.Join(commonDirOf(gitDir), "config"), ScopeLocal, 0); err != nil {
+			return nil, err
+		}
+		cfg := &Config{entries: l.entries}
+		if cfg.getBool("extensions.worktreeconfig", false) {
+			if err := l.loadFile(path.Join(gitDir, "config.worktree"), ScopeWorktree, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := l.loadEnv(); err != nil {
+		return nil, err
+	}
+	return &Config{entries: l.entries}, nil
+}
+
+// loadEnv reads the GIT_CONFIG_COUNT/GIT_CONFIG_KEY_<n>/GIT_CONFIG_VALUE_<n>
+// variables, which behave like "-c key=value" on the command line.
+func (l *configLoader) loadEnv() error {
+	strCount := os.Getenv("GIT_CONFIG_COUNT")
+	if strCount == "" {
+		return nil
+	}
+	count, err := strconv.Atoi(strCount)
+	if err != nil {
+		return fmt.Errorf("Bogus GIT_CONFIG_COUNT: %v", strCount)
+	}
+	for i := 0; i < count; i++ {
+		key := os.Getenv(fmt.Sprintf("GIT_CONFIG_KEY_%v", i))
+		ck, err := parseConfigKey(key)
+		if err != nil {
+			return err
+		}
+		l.entries = append(l.entries, configEntry{
+			section:    ck.section,
+			subsection: ck.subsection,
+			name:       ck.name,
+			value:      os.Getenv(fmt.Sprintf("GIT_CONFIG_VALUE_%v", i)),
+			hasValue:   true,
+			origin:     "",
+			scope:      ScopeCommand,
+		})
+	}
+	return nil
+}
+
+// loadConfigFile reads a single file, as "--file" does.
+func loadConfigFile(filePath string, gitDir string) (*Config, error) {
+	l := &configLoader{gitDir: gitDir}
+	if err := l.loadFile(filePath, ScopeCommand, 0); err != nil {
+		return nil, err
+	}
+	return &Config{entries: l.entries}, nil
+}
+
+func (c *Config) lookup(key string) []*configEntry {
+	if c == nil {
+		return nil
+	}
+	ck, err := parseConfigKey(key)
+	if err != nil {
+		return nil
+	}
+	found := []*configEntry{}
+	for i := range c.entries {
+		if ck.match(&c.entries[i]) {
+			found = append(found, &c.entries[i])
+		}
+	}
+	return found
+}
+
+// get returns the last value of key, which is the one that wins.
+func (c *Config) get(key string) (string, bool) {
+	found := c.lookup(key)
+	if len(found) == 0 {
+		return "", false
+	}
+	last := found[len(found)-1]
+	if !last.hasValue {
+		return "true", true
+	}
+	return last.value, true
+}
+
+func (c *Config) getAll(key string) []string {
+	values := []string{}
+	for _, e := range c.lookup(key) {
+		if !e.hasValue {
+			values = append(values, "true")
+		} else {
+			values = append(values, e.value)
+		}
+	}
+	return values
+}
+
+func (c *Config) getBool(key string, def bool) bool {
+	found := c.lookup(key)
+	if len(found) == 0 {
+		return def
+	}
+	last := found[len(found)-1]
+	if !last.hasValue {
+		return true
+	}
+	v, err := parseBool(last.value)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func (c *Config) getInt(key string, def int64) int64 {
+	v, ok := c.get(key)
+	if !ok {
+		return def
+	}
+	i, err := parseInt(v)
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+// subsections lists the distinct subsections of section, in the order
+// they first appear, e.g. the names of all remotes.
+func (c *Config) subsections(section string) []string {
+	section = strings.ToLower(section)
+	seen := map[string]bool{}
+	names := []string{}
+	for _, e := range c.entries {
+		if e.section == section && e.subsection != "" && !seen[e.subsection] {
+			seen[e.subsection] = true
+			names = append(names, e.subsection)
+		}
+	}
+	return names
+}
+
+func parseBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	i, err := parseInt(v)
+	if err != nil {
+		return false, fmt.Errorf("Bad boolean config value '%v'", v)
+	}
+	return i != 0, nil
+}
+
+// parseInt accepts the k, m and g suffixes that git understands.
+func parseInt(v string) (int64, error) {
+	factor := int64(1)
+	if v != "" {
+		switch strings.ToLower(v[len(v)-1:]) {
+		case "k":
+			factor = 1 << 10
+		case "m":
+			factor = 1 << 20
+		case "g":
+			factor = 1 << 30
+		}
+		if factor != 1 {
+			v = v[:len(v)-1]
+		}
+	}
+	i, err := strconv.ParseInt(v, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Bad numeric config value '%v'", v)
+	}
+	return i * factor, nil
+}
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+var colorAttrs = map[string]int{
+	"bold":    1,
+	"dim":     2,
+	"italic":  3,
+	"ul":      4,
+	"blink":   5,
+	"reverse": 7,
+	"strike":  9,
+}
+
+// parseColor turns a git color description such as "bold red blue" into
+// the matching ANSI escape sequence.
+func parseColor(v string) (string, error) {
+	attrs := []string{}
+	colors := []string{}
+	for _, word := range strings.Fields(strings.ToLower(v)) {
+		if code, ok := colorAttrs[word]; ok {
+			attrs = append(attrs, strconv.Itoa(code))
+			continue
+		}
+		if strings.HasPrefix(word, "no") {
+			neg := strings.TrimPrefix(strings.TrimPrefix(word, "no"), "-")
+			if code, ok := colorAttrs[neg]; ok {
+				if code == 1 {
+					code = 2
+				}
+				attrs = append(attrs, strconv.Itoa(20+code))
+				continue
+			}
+		}
+		if len(colors) == 2 {
+			return "", fmt.Errorf("Invalid color value: %v", v)
+		}
+		fg := len(colors) == 0
+		code, err := colorCode(word, fg)
+		if err != nil {
+			return "", fmt.Errorf("Invalid color value: %v", v)
+		}
+		colors = append(colors, code)
+	}
+
+	codes := append(attrs, colors...)
+	codes = removeEmpty(codes)
+	if len(codes) == 0 {
+		return "", nil
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m", nil
+}
+
+var hexColorRegex = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+func colorCode(word string, fg bool) (string, error) {
+	base := 30
+	if !fg {
+		base = 40
+	}
+	switch word {
+	case "normal":
+		return "", nil
+	case "default":
+		return strconv.Itoa(base + 9), nil
+	}
+	bright := false
+	if strings.HasPrefix(word, "bright") {
+		bright = true
+		word = strings.TrimPrefix(word, "bright")
+	}
+	for i, name := range colorNames {
+		if word == name {
+			if bright {
+				return strconv.Itoa(base + 60 + i), nil
+			}
+			return strconv.Itoa(base + i), nil
+		}
+	}
+	if bright {
+		return "", fmt.Errorf("Invalid color %v", word)
+	}
+	if hexColorRegex.MatchString(word) {
+		rgb := util.HexStrToBytes(word[1:])
+		return fmt.Sprintf("%v;2;%v;%v;%v", base+8, rgb[0], rgb[1], rgb[2]), nil
+	}
+	n, err := strconv.Atoi(word)
+	if err != nil || n < 0 || n > 255 {
+		return "", fmt.Errorf("Invalid color %v", word)
+	}
+	return fmt.Sprintf("%v;5;%v", base+8, n), nil
+}
+
+func removeEmpty(list []string) []string {
+	out := []string{}
+	for _, s := range list {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// formatConfigValue applies a "--type" to a raw value.
+func formatConfigValue(value string, hasValue bool, typ string) (string, error) {
+	switch typ {
+	case "":
+		return value, nil
+	case "bool":
+		if !hasValue {
+			return "true", nil
+		}
+		v, err := parseBool(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(v), nil
+	case "int":
+		v, err := parseInt(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(v, 10), nil
+	case "bool-or-int":
+		if !hasValue {
+			return "true", nil
+		}
+		if v, err := parseInt(value); err == nil {
+			return strconv.FormatInt(v, 10), nil
+		}
+		v, err := parseBool(value)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(v), nil
+	case "path":
+		return expandConfigPath(value), nil
+	case "color":
+		return parseColor(value)
+	}
+	return "", fmt.Errorf("Unrecognized --type argument, %v", typ)
+}
+
+// quoteConfigValue escapes a value so that it reads back unchanged.
+func quoteConfigValue(value string) string {
+	needQuote := value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;")
+	var sb strings.Builder
+	if needQuote {
+		sb.WriteByte('"')
+	}
+	for _, c := range value {
+		switch c {
+		case '\\':
+			sb.WriteString(`\\`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\b':
+			sb.WriteString(`\b`)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	if needQuote {
+		sb.WriteByte('"')
+	}
+	return sb.String()
+}
+
+func formatSectionHeader(ck configKey) string {
+	if ck.subsection == "" {
+		return fmt.Sprintf("[%v]", ck.section)
+	}
+	sub := strings.ReplaceAll(ck.subsection, `\`, `\\`)
+	sub = strings.ReplaceAll(sub, `"`, `\"`)
+	return fmt.Sprintf("[%v \"%v\"]", ck.section, sub)
+}
+
+// Modes of editConfigFile.
+const (
+	configSet = iota
+	configAdd
+	configUnset
+	configUnsetAll
+)
+
+// editConfigFile rewrites a single config file in place, keeping every
+// line that isn't touched exactly as it was. The file is replaced
+// atomically through a lock file.
+func editConfigFile(filePath string, key string, value string, mode int) error {
+	ck, err := parseConfigKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	p, err := parseConfigData(data, filePath)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		lines[len(lines)-1] += "\n"
+	}
+
+	matches := []configEntry{}
+	for _, e := range p.entries {
+		if ck.match(&e) {
+			matches = append(matches, e)
+		}
+	}
+	newLine := fmt.Sprintf("\t%v = %v\n", key[strings.LastIndex(key, ".")+1:], quoteConfigValue(value))
+
+	switch mode {
+	case configSet:
+		if len(matches) > 1 {
+			return fmt.Errorf("Cannot overwrite multiple values with a single value: %v", key)
+		}
+		if len(matches) == 1 {
+			lines = replaceLines(lines, matches[0].firstLine, matches[0].lastLine, []string{newLine})
+			break
+		}
+		lines = insertConfigLine(lines, p.sections, ck, newLine)
+	case configAdd:
+		lines = insertConfigLine(lines, p.sections, ck, newLine)
+	case configUnset, configUnsetAll:
+		if len(matches) == 0 {
+			return fmt.Errorf("No such key: %v", key)
+		}
+		if mode == configUnset && len(matches) > 1 {
+			return fmt.Errorf("Key %v has multiple values", key)
+		}
+		for i := len(matches) - 1; i >= 0; i-- {
+			lines = replaceLines(lines, matches[i].firstLine, matches[i].lastLine, nil)
+		}
+	}
+
+	if err := util.CreateDir(path.Dir(filePath)); err != nil {
+		return err
+	}
+	lockPath := filePath + ".lock"
+	fLock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return fmt.Errorf("Could not lock config file %v: %v", filePath, err)
+	}
+	var buf bytes.Buffer
+	for _, l := range lines {
+		buf.WriteString(l)
+	}
+	if _, err := fLock.Write(buf.Bytes()); err != nil {
+		fLock.Close()
+		os.Remove(lockPath)
+		return err
+	}
+	if err := fLock.Close(); err != nil {
+		os.Remove(lockPath)
+		return err
+	}
+	return os.Rename(lockPath, filePath)
+}
+
+// insertConfigLine adds a line at the end of the last section matching
+// ck, or appends a new section when there is none.
+func insertConfigLine(lines []string, sections []configSection, ck configKey, newLine string) []string {
+	for i := len(sections) - 1; i >= 0; i-- {
+		s := sections[i]
+		if s.section == ck.section && s.subsection == ck.subsection {
+			at := s.lastLine + 1
+			if at > len(lines) {
+				at = len(lines)
+			}
+			return replaceLines(lines, at, at-1, []string{newLine})
+		}
+	}
+	return append(lines, formatSectionHeader(ck)+"\n", newLine)
+}
+
+// replaceLines replaces lines first..last (inclusive) with repl. When
+// last < first, repl is inserted before first.
+func replaceLines(lines []string, first, last int, repl []string) []string {
+	if last >= len(lines) {
+		last = len(lines) - 1
+	}
+	out := make([]string, 0, len(lines)+len(repl))
+	out = append(out, lines[:first]...)
+	out = append(out, repl...)
+	out = append(out, lines[last+1:]...)
+	return out
+}
@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	data := `# comment
+[core]
+	bare
+	filemode = false ; trailing comment
+[remote "origin"]
+	url = "https://example.com/a;b"
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[Branch.Master]
+	Remote = origin
+[alias]
+	lg = log \
+--oneline
+	say = "tab\tnew\nline \"quoted\""
+`
+	p, err := parseConfigData([]byte(data), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{entries: p.entries}
+
+	cases := map[string]string{
+		"core.bare":            "true",
+		"CORE.FileMode":        "false",
+		"remote.origin.url":    "https://example.com/a;b",
+		"remote.origin.fetch":  "+refs/tags/*:refs/tags/*",
+		"branch.master.remote": "origin",
+		"alias.lg":             "log --oneline",
+		"alias.say":            "tab\tnew\nline \"quoted\"",
+	}
+	for key, want := range cases {
+		got, ok := cfg.get(key)
+		if !ok || got != want {
+			t.Errorf("%v: got %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := cfg.get("remote.Origin.url"); ok {
+		t.Error("subsections must be case sensitive")
+	}
+	if all := cfg.getAll("remote.origin.fetch"); len(all) != 2 {
+		t.Errorf("expected two fetch values, got %v", all)
+	}
+	if cfg.getBool("core.filemode", true) {
+		t.Error("core.filemode should be false")
+	}
+}
+
+func TestConfigTypes(t *testing.T) {
+	if v, err := parseInt("2k"); err != nil || v != 2048 {
+		t.Errorf("parseInt(2k) = %v, %v", v, err)
+	}
+	for _, s := range []string{"yes", "on", "true", "1", "42"} {
+		if v, err := parseBool(s); err != nil || !v {
+			t.Errorf("parseBool(%v) = %v, %v", s, v, err)
+		}
+	}
+	if _, err := parseBool("maybe"); err == nil {
+		t.Error("parseBool(maybe) should fail")
+	}
+	if c, err := parseColor("bold red blue"); err != nil || c != "\x1b[1;31;44m" {
+		t.Errorf("parseColor = %q, %v", c, err)
+	}
+	if c, err := parseColor("#ff0000 nobold"); err != nil || c != "\x1b[22;38;2;255;0;0m" {
+		t.Errorf("parseColor = %q, %v", c, err)
+	}
+}
+
+func TestConfigIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gitDir := path.Join(dir, "work", ".git")
+	main := path.Join(dir, "main")
+	ioutil.WriteFile(main, []byte(`[user]
+	name = main
+[include]
+	path = inc
+[includeIf "gitdir:work/"]
+	path = work
+[includeIf "gitdir:other/"]
+	path = other
+`), 0666)
+	ioutil.WriteFile(path.Join(dir, "inc"), []byte("[user]\n\temail = inc@example.com\n"), 0666)
+	ioutil.WriteFile(path.Join(dir, "work"), []byte("[user]\n\tname = work\n"), 0666)
+	ioutil.WriteFile(path.Join(dir, "other"), []byte("[user]\n\tname = other\n"), 0666)
+
+	cfg, err := loadConfigFile(main, gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := cfg.get("user.email"); v != "inc@example.com" {
+		t.Errorf("user.email = %q", v)
+	}
+	if v, _ := cfg.get("user.name"); v != "work" {
+		t.Errorf("user.name = %q", v)
+	}
+
+	ioutil.WriteFile(main, []byte("[include]\n\tpath = main\n"), 0666)
+	if _, err := loadConfigFile(main, gitDir); err == nil {
+		t.Error("expected include depth error")
+	}
+}
+
+func TestEditConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfgPath := path.Join(dir, "config")
+	ioutil.WriteFile(cfgPath, []byte("# keep me\n[core]\n\tbare = false # and me\n\n[user]\n\tname = a\n"), 0666)
+
+	steps := []struct {
+		key, value string
+		mode       int
+	}{
+		{"core.bare", "true", configSet},
+		{"core.editor", "vim -f", configSet},
+		{"remote.origin.fetch", "a", configAdd},
+		{"remote.origin.fetch", "b", configAdd},
+		{"user.name", "", configUnset},
+		{"user.email", " padded ", configSet},
+	}
+	for _, s := range steps {
+		if err := editConfigFile(cfgPath, s.key, s.value, s.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := editConfigFile(cfgPath, "remote.origin.fetch", "c", configSet); err == nil {
+		t.Error("setting a multi-valued key should fail")
+	}
+
+	bs, _ := ioutil.ReadFile(cfgPath)
+	want := "# keep me\n[core]\n\tbare = true\n\teditor = vim -f\n\n[user]\n\temail = \" padded \"\n[remote \"origin\"]\n\tfetch = a\n\tfetch = b\n"
+	if string(bs) != want {
+		t.Errorf("got:\n%v\nwant:\n%v", string(bs), want)
+	}
+}
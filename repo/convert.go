@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Line ending conversions of a file: none, always from CRLF to LF, or
+// only when the content looks like text.
+const (
+	crlfNone = iota
+	crlfText
+	crlfAuto
+)
+
+// cleanFilter turns the content of a file of the work tree into the
+// content stored in the repository, like git's convert_to_git: a filter
+// driver runs first, then line endings are normalized and "$Id$" keywords
+// collapsed.
+type cleanFilter struct {
+	path     string
+	driver   string
+	command  string
+	required bool
+	crlf     int
+	ident    bool
+}
+
+// cleanFilterFor sets up the filter of a path relative to the root of the
+// work tree from its attributes and the config.
+func (r *Repository) cleanFilterFor(filePath string) (*cleanFilter, error) {
+	attrs, err := r.attributes(filePath)
+	if err != nil {
+		return nil, err
+	}
+	f := &cleanFilter{path: filePath, ident: attrs["ident"] == attrSet}
+
+	switch text, eol := attrs["text"], attrs["eol"]; {
+	case text == attrSet:
+		f.crlf = crlfText
+	case text == attrUnset:
+		f.crlf = crlfNone
+	case text == "auto":
+		f.crlf = crlfAuto
+	case eol == "lf" || eol == "crlf":
+		f.crlf = crlfText
+	default:
+		autocrlf, _ := r.configString("core.autocrlf")
+		if strings.ToLower(autocrlf) == "input" || r.configBool("core.autocrlf", false) {
+			f.crlf = crlfAuto
+		}
+	}
+
+	if driver := attrs["filter"]; driver != "" && driver != attrSet && driver != attrUnset {
+		f.driver = driver
+		f.command, _ = r.configString("filter." + driver + ".clean")
+		f.required = r.configBool("filter."+driver+".required", false)
+	}
+	return f, nil
+}
+
+// active tells whether the filter may change anything.
+func (f *cleanFilter) active() bool {
+	return f.command != "" || f.required || f.crlf != crlfNone || f.ident
+}
+
+func (f *cleanFilter) apply(workTree string, content []byte) ([]byte, error) {
+	if f.command != "" || f.required {
+		out, err := f.runDriver(workTree, content)
+		switch {
+		case err == nil:
+			content = out
+		case f.required:
+			return nil, fmt.Errorf("%v: clean filter '%v' failed: %v", f.path, f.driver, err)
+		default:
+			fmt.Fprintf(os.Stderr, "error: external filter '%v' failed: %v\n", f.command, err)
+		}
+	}
+	if f.crlf == crlfText || (f.crlf == crlfAuto && !looksBinary(content)) {
+		content = bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+	}
+	if f.ident {
+		content = identRegex.ReplaceAll(content, []byte("$$Id$$"))
+	}
+	return content, nil
+}
+
+// runDriver pipes content through the clean command of the filter driver,
+// in which "%f" stands for the path of the file.
+func (f *cleanFilter) runDriver(workTree string, content []byte) ([]byte, error) {
+	if f.command == "" {
+		return nil, fmt.Errorf("filter.%v.clean is not set", f.driver)
+	}
+	quoted := "'" + strings.Replace(f.path, "'", `'\''`, -1) + "'"
+	cmd := exec.Command("sh", "-c", strings.Replace(f.command, "%f", quoted, -1))
+	cmd.Dir = workTree
+	cmd.Stdin = bytes.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %v", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// identRegex finds expanded "$Id: ... $" keywords.
+var identRegex = regexp.MustCompile(`\$Id:[^$\n]*\$`)
+
+// looksBinary is git's guess that content isn't text when converting line
+// endings: it has NUL bytes or lone CRs, or too many control characters.
+func looksBinary(content []byte) bool {
+	printable, nonPrintable := 0, 0
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '\r':
+			if i+1 < len(content) && content[i+1] == '\n' {
+				i++
+				continue
+			}
+			return true
+		case c == '\n':
+		case c == 0:
+			return true
+		case c == 127:
+			nonPrintable++
+		case c < 32:
+			if c == '\b' || c == '\t' || c == '\033' || c == '\014' {
+				printable++
+			} else {
+				nonPrintable++
+			}
+		default:
+			printable++
+		}
+	}
+	// A trailing EOF character doesn't count.
+	if len(content) > 0 && content[len(content)-1] == '\032' {
+		nonPrintable--
+	}
+	return printable>>7 < nonPrintable
+}
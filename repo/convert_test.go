@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCleanFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+
+	attrs := "*.txt text\n*.id ident\n*.bin binary\nsub/*.auto text=auto\n"
+	if err := ioutil.WriteFile(path.Join(repo.workTree, ".gitattributes"), []byte(attrs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path.Join(repo.workTree, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(repo.workTree, "sub", ".gitattributes"), []byte("*.txt -text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, content, want string
+	}{
+		{"a.txt", "a\r\nb\r\n", "a\nb\n"},
+		{"sub/a.txt", "a\r\nb\r\n", "a\r\nb\r\n"},
+		{"a.bin", "a\r\nb\r\n", "a\r\nb\r\n"},
+		{"sub/a.auto", "a\r\nb\r\n", "a\nb\n"},
+		{"sub/b.auto", "a\r\x00\r\n", "a\r\x00\r\n"},
+		{"deep/sub/a.auto", "a\r\n", "a\r\n"},
+		{"a.id", "$Id: 1234 $\n$Id$\n", "$Id$\n$Id$\n"},
+		{"other", "a\r\n", "a\r\n"},
+	}
+	for _, tt := range tests {
+		f, err := repo.cleanFilterFor(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := f.apply(repo.workTree, []byte(tt.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestValidateObject(t *testing.T) {
+	zero := "0000000000000000000000000000000000000000"
+	ident := "A U Thor <a@example.com> 1600000000 +0200"
+	tests := []struct {
+		format, data string
+		valid        bool
+	}{
+		{TypeCommit, "tree " + zero + "\nparent " + zero + "\nauthor " + ident + "\ncommitter " + ident + "\n\nmsg\n", true},
+		{TypeCommit, "tree zz\nauthor " + ident + "\ncommitter " + ident + "\n\nmsg\n", false},
+		{TypeCommit, "tree " + zero + "\ncommitter " + ident + "\n\nmsg\n", false},
+		{TypeCommit, "tree " + zero + "\nauthor A <a> now\ncommitter " + ident + "\n\nmsg\n", false},
+		{TypeTag, "object " + zero + "\ntype commit\ntag v1\ntagger " + ident + "\n\nmsg\n", true},
+		{TypeTag, "object " + zero + "\ntype bogus\ntag v1\n\nmsg\n", false},
+		{TypeTag, "object " + zero + "\ntype commit\n\nmsg\n", false},
+		{TypeTree, "100644 a.b\x0001234567890123456789" + "40000 a\x0001234567890123456789", true},
+		{TypeTree, "100644 b\x0001234567890123456789" + "100644 a\x0001234567890123456789", false},
+		{TypeTree, "100644 a\x0001234567890123456789" + "100644 a\x0001234567890123456789", false},
+		{TypeTree, "100664 a\x0001234567890123456789", false},
+		{TypeTree, "100644 a\x0001234567890123456789" + "100644 a.b\x0001234567890123456789" + "40000 a\x0001234567890123456789", false},
+		{"bogus", "", false},
+	}
+	for i, tt := range tests {
+		err := (*Repository)(nil).validateObject(tt.format, []byte(tt.data))
+		if (err == nil) != tt.valid {
+			t.Errorf("%v: got %v, want valid=%v", i, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package repo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Formats of the commit graph written by "log --format".
+const (
+	DAGFormatDot       = "dot"
+	DAGFormatMermaid   = "mermaid"
+	DAGFormatJSONGraph = "json-graph"
+)
+
+// Kinds of the nodes of a dag.
+const (
+	dagCommit = "commit"
+	dagRef    = "ref"
+	dagTag    = "tag"
+	dagTree   = "tree"
+	dagBlob   = "blob"
+)
+
+type dagNode struct {
+	id      string
+	kind    string
+	sha     string
+	label   []string
+	cluster int // index in dag.clusters, or -1
+	// subject, author and signature are only set for commits.
+	subject   string
+	author    string
+	signature []string
+}
+
+type dagEdge struct {
+	from  string
+	to    string
+	label string
+}
+
+// dag is the part of the history shown by a walk, ready to be written as a
+// graph: commits pointing to their parents, refs pointing to commits, and
+// optionally commits pointing to their trees.
+type dag struct {
+	nodes    []*dagNode
+	edges    []dagEdge
+	clusters []string
+	byID     map[string]*dagNode
+}
+
+func (d *dag) add(n *dagNode) *dagNode {
+	if old, ok := d.byID[n.id]; ok {
+		return old
+	}
+	d.nodes = append(d.nodes, n)
+	d.byID[n.id] = n
+	return n
+}
+
+// buildDAG reads the commits of walk and the objects pointing to them.
+func buildDAG(repo *Repository, walk *RevWalk, opts LogOptions) (*dag, error) {
+	d := &dag{byID: map[string]*dagNode{}}
+	commits := []*RevCommit{}
+	for {
+		c, err := walk.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, c)
+	}
+
+	for _, c := range commits {
+		commit, err := c.Commit()
+		if err != nil {
+			return nil, err
+		}
+		author, err := commit.Author()
+		if err != nil {
+			return nil, err
+		}
+		n := d.add(&dagNode{
+			id:      "c_" + c.SHA,
+			kind:    dagCommit,
+			sha:     c.SHA,
+			cluster: -1,
+			subject: commitSubject(commit.Message()),
+			author:  author.Name,
+		})
+		if opts.ShowSignature {
+			n.signature = signatureLines(repo, c.SHA)
+		}
+	}
+
+	// Parents hidden by path limiting are replaced by the ancestors shown
+	// in their place; parents not shown at all are left out.
+	for _, c := range commits {
+		for _, p := range walk.rewriteParents(c) {
+			if d.byID["c_"+p] != nil {
+				d.edges = append(d.edges, dagEdge{from: "c_" + c.SHA, to: "c_" + p})
+			}
+		}
+	}
+
+	if err := d.addRefs(repo, opts.Clusters, walk); err != nil {
+		return nil, err
+	}
+	if opts.Trees {
+		for _, c := range commits {
+			if err := d.addTree(repo, "c_"+c.SHA, c.Tree, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	d.shortenIDs()
+	return d, nil
+}
+
+// addRefs adds a node for each ref pointing to a commit of the dag, and
+// one for HEAD, and puts commits into clusters when asked to.
+func (d *dag) addRefs(repo *Repository, clusters bool, walk *RevWalk) error {
+	refs, err := repo.getRefs()
+	if err != nil {
+		return err
+	}
+	headRef, err := repo.headTarget()
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// peel returns the commit a ref points to once its tags are peeled.
+	peel := func(sha string) string {
+		for {
+			obj, err := repo.readObject(sha)
+			if err != nil || obj.GetFormat() != TypeTag {
+				return sha
+			}
+			sha = obj.(*Tag).Object()
+		}
+	}
+
+	refNodes := map[string]string{}
+	for _, name := range names {
+		target := peel(refs[name])
+		if d.byID["c_"+target] == nil {
+			continue
+		}
+		kind, label := dagRef, name
+		switch {
+		case strings.HasPrefix(name, "refs/heads/"):
+			label = strings.TrimPrefix(name, "refs/heads/")
+		case strings.HasPrefix(name, "refs/tags/"):
+			kind, label = dagTag, "tag: "+strings.TrimPrefix(name, "refs/tags/")
+		case strings.HasPrefix(name, "refs/remotes/"):
+			label = strings.TrimPrefix(name, "refs/remotes/")
+		}
+		refNodes[name] = "r_" + name
+		d.add(&dagNode{id: "r_" + name, kind: kind, label: []string{label}, cluster: -1})
+		d.edges = append(d.edges, dagEdge{from: "r_" + name, to: "c_" + target})
+	}
+
+	head, err := repo.readRef("HEAD", map[string]string{})
+	if err == nil && d.byID["c_"+head] != nil {
+		d.add(&dagNode{id: "r_HEAD", kind: dagRef, label: []string{"HEAD"}, cluster: -1})
+		if id, ok := refNodes[headRef]; ok {
+			d.edges = append(d.edges, dagEdge{from: "r_HEAD", to: id})
+		} else {
+			d.edges = append(d.edges, dagEdge{from: "r_HEAD", to: "c_" + head})
+		}
+	}
+
+	if !clusters {
+		return nil
+	}
+	branches := []string{}
+	if strings.HasPrefix(headRef, "refs/heads/") && refs[headRef] != "" {
+		branches = append(branches, headRef)
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, "refs/heads/") && name != headRef {
+			branches = append(branches, name)
+		}
+	}
+	for _, name := range branches {
+		index := len(d.clusters)
+		used := false
+		for sha := refs[name]; sha != ""; {
+			c := walk.commits[sha]
+			if c == nil {
+				break
+			}
+			if n := d.byID["c_"+sha]; n != nil {
+				if n.cluster >= 0 {
+					break
+				}
+				n.cluster, used = index, true
+			}
+			sha = ""
+			if len(c.Parents) > 0 {
+				sha = c.Parents[0]
+			}
+		}
+		if used {
+			d.clusters = append(d.clusters, strings.TrimPrefix(name, "refs/heads/"))
+		}
+	}
+	return nil
+}
+
+// addTree adds a tree and everything below it, with an edge from the node
+// from. Objects shared between trees are added once.
+func (d *dag) addTree(repo *Repository, from string, sha string, name string) error {
+	d.edges = append(d.edges, dagEdge{from: from, to: "t_" + sha, label: name})
+	if d.byID["t_"+sha] != nil {
+		return nil
+	}
+	d.add(&dagNode{id: "t_" + sha, kind: dagTree, sha: sha, cluster: -1})
+
+	tree := createTree(repo, nil)
+	if err := tree.Read(sha); err != nil {
+		return err
+	}
+	for _, leaf := range tree.leaves {
+		switch leaf.mode {
+		case ModeGitlink:
+			continue
+		case ModeTree:
+			if err := d.addTree(repo, "t_"+sha, leaf.sha, leaf.path); err != nil {
+				return err
+			}
+		default:
+			d.add(&dagNode{id: "b_" + leaf.sha, kind: dagBlob, sha: leaf.sha, cluster: -1})
+			d.edges = append(d.edges, dagEdge{from: "t_" + sha, to: "b_" + leaf.sha, label: leaf.path})
+		}
+	}
+	return nil
+}
+
+// shortenIDs abbreviates the object names in node IDs, using the shortest
+// length from 7 up that keeps them unique, and sets the labels of object
+// nodes. Ref IDs are numbered, since ref names may not be valid IDs.
+func (d *dag) shortenIDs() {
+	length := 7
+	for {
+		seen := map[string]bool{}
+		unique := true
+		for _, n := range d.nodes {
+			if n.sha == "" || len(n.sha) <= length {
+				continue
+			}
+			if seen[n.sha[:length]] {
+				unique = false
+				break
+			}
+			seen[n.sha[:length]] = true
+		}
+		if unique {
+			break
+		}
+		length++
+	}
+	abbrev := func(sha string) string {
+		if len(sha) > length {
+			return sha[:length]
+		}
+		return sha
+	}
+
+	ids := map[string]string{}
+	refs := 0
+	for _, n := range d.nodes {
+		old := n.id
+		if n.sha != "" {
+			n.id = old[:2] + abbrev(n.sha)
+		} else {
+			n.id = fmt.Sprintf("r_%v", refs)
+			refs++
+		}
+		ids[old] = n.id
+		switch n.kind {
+		case dagCommit:
+			n.label = []string{abbrev(n.sha), n.subject, n.author}
+		case dagTree, dagBlob:
+			n.label = []string{abbrev(n.sha)}
+		}
+	}
+	d.byID = map[string]*dagNode{}
+	for _, n := range d.nodes {
+		d.byID[n.id] = n
+	}
+	for i := range d.edges {
+		d.edges[i].from = ids[d.edges[i].from]
+		d.edges[i].to = ids[d.edges[i].to]
+	}
+}
+
+// writeDot writes the dag as a graphviz digraph.
+func (d *dag) writeDot(sb *strings.Builder) {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+	}
+	shapes := map[string]string{
+		dagCommit: "ellipse",
+		dagRef:    "box",
+		dagTag:    "cds",
+		dagTree:   "folder",
+		dagBlob:   "note",
+	}
+	writeNode := func(n *dagNode) {
+		for _, line := range n.signature {
+			sb.WriteString(fmt.Sprintf("// %v: %v\n", n.id, line))
+		}
+		sb.WriteString(fmt.Sprintf("%v [label=%v, shape=%v];\n",
+			n.id, quote(strings.Join(n.label, "\n")), shapes[n.kind]))
+	}
+
+	sb.WriteString("digraph pit{\n")
+	for i, name := range d.clusters {
+		sb.WriteString(fmt.Sprintf("subgraph cluster_%v{\nlabel=%v;\n", i, quote(name)))
+		for _, n := range d.nodes {
+			if n.cluster == i {
+				writeNode(n)
+			}
+		}
+		sb.WriteString("}\n")
+	}
+	for _, n := range d.nodes {
+		if n.cluster < 0 {
+			writeNode(n)
+		}
+	}
+	for _, e := range d.edges {
+		if e.label != "" {
+			sb.WriteString(fmt.Sprintf("%v -> %v [label=%v];\n", e.from, e.to, quote(e.label)))
+		} else {
+			sb.WriteString(fmt.Sprintf("%v -> %v;\n", e.from, e.to))
+		}
+	}
+	sb.WriteString("}\n")
+}
+
+// writeMermaid writes the dag as a mermaid flowchart.
+func (d *dag) writeMermaid(sb *strings.Builder) {
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", "<br/>").Replace(s) + `"`
+	}
+	shapes := map[string][2]string{
+		dagCommit: {"([", "])"},
+		dagRef:    {">", "]"},
+		dagTag:    {">", "]"},
+		dagTree:   {"[/", "/]"},
+		dagBlob:   {"[", "]"},
+	}
+	writeNode := func(n *dagNode) {
+		for _, line := range n.signature {
+			sb.WriteString(fmt.Sprintf("    %%%% %v: %v\n", n.id, line))
+		}
+		shape := shapes[n.kind]
+		sb.WriteString(fmt.Sprintf("    %v%v%v%v\n", n.id, shape[0], quote(strings.Join(n.label, "\n")), shape[1]))
+	}
+
+	sb.WriteString("flowchart TD\n")
+	for i, name := range d.clusters {
+		sb.WriteString(fmt.Sprintf("    subgraph cluster_%v [%v]\n", i, quote(name)))
+		for _, n := range d.nodes {
+			if n.cluster == i {
+				writeNode(n)
+			}
+		}
+		sb.WriteString("    end\n")
+	}
+	for _, n := range d.nodes {
+		if n.cluster < 0 {
+			writeNode(n)
+		}
+	}
+	for _, e := range d.edges {
+		if e.label != "" {
+			sb.WriteString(fmt.Sprintf("    %v -->|%v| %v\n", e.from, quote(e.label), e.to))
+		} else {
+			sb.WriteString(fmt.Sprintf("    %v --> %v\n", e.from, e.to))
+		}
+	}
+}
+
+// writeJSONGraph writes the dag in the JSON Graph Format.
+func (d *dag) writeJSONGraph(sb *strings.Builder) error {
+	type jsonNode struct {
+		ID       string                 `json:"id"`
+		Label    string                 `json:"label"`
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	type jsonEdge struct {
+		Source string `json:"source"`
+		Target string `json:"target"`
+		Label  string `json:"label,omitempty"`
+	}
+	type jsonGraph struct {
+		Directed bool       `json:"directed"`
+		Nodes    []jsonNode `json:"nodes"`
+		Edges    []jsonEdge `json:"edges"`
+	}
+
+	g := jsonGraph{Directed: true, Nodes: []jsonNode{}, Edges: []jsonEdge{}}
+	for _, n := range d.nodes {
+		metadata := map[string]interface{}{"type": n.kind}
+		if n.sha != "" {
+			metadata["sha"] = n.sha
+		}
+		if n.kind == dagCommit {
+			metadata["subject"] = n.subject
+			metadata["author"] = n.author
+		}
+		if n.cluster >= 0 {
+			metadata["branch"] = d.clusters[n.cluster]
+		}
+		if len(n.signature) > 0 {
+			metadata["signature"] = n.signature
+		}
+		g.Nodes = append(g.Nodes, jsonNode{ID: n.id, Label: strings.Join(n.label, "\n"), Metadata: metadata})
+	}
+	for _, e := range d.edges {
+		g.Edges = append(g.Edges, jsonEdge{Source: e.from, Target: e.to, Label: e.label})
+	}
+
+	bs, err := json.MarshalIndent(map[string]jsonGraph{"graph": g}, "", "  ")
+	if err != nil {
+		return err
+	}
+	sb.Write(bs)
+	sb.WriteString("\n")
+	return nil
+}
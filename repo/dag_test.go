@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestBuildDAG(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	shas := buildHistory(t, repo)
+	if err := repo.writeRef("refs/heads/master", shas["7"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.writeRef("refs/heads/side", shas["4"]); err != nil {
+		t.Fatal(err)
+	}
+
+	walk := newRevWalk(repo, RevWalkOptions{Sort: SortTopo, MaxCount: -1})
+	if err := walk.Push(shas["7"]); err != nil {
+		t.Fatal(err)
+	}
+	d, err := buildDAG(repo, walk, LogOptions{Clusters: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]string{}
+	for name, sha := range shas {
+		names["c_"+sha[:7]] = name
+	}
+	for _, n := range d.nodes {
+		if n.kind == dagRef {
+			names[n.id] = n.label[0]
+		}
+	}
+	got := []string{}
+	for _, n := range d.nodes {
+		if n.kind == dagCommit {
+			got = append(got, n.label[1]+"@"+d.clusters[n.cluster])
+		}
+	}
+	if want := "7@master 6@master 4@side 3@side 5@master 2@master 1@master"; strings.Join(got, " ") != want {
+		t.Errorf("got nodes %v, want %v", strings.Join(got, " "), want)
+	}
+	got = []string{}
+	for _, e := range d.edges {
+		got = append(got, names[e.from]+">"+names[e.to])
+	}
+	if want := "7>6 6>5 6>4 4>3 3>2 5>2 2>1 master>7 side>4 HEAD>master"; strings.Join(got, " ") != want {
+		t.Errorf("got edges %v, want %v", strings.Join(got, " "), want)
+	}
+
+	var sb strings.Builder
+	d.writeDot(&sb)
+	node := "c_" + shas["1"][:7] + ` [label="` + shas["1"][:7] + `\n1\nA U Thor", shape=ellipse];`
+	if !strings.Contains(sb.String(), node+"\n") {
+		t.Errorf("root commit %q missing from\n%v", node, sb.String())
+	}
+}
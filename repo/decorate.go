@@ -0,0 +1,78 @@
+package repo
+
+import (
+	"sort"
+	"strings"
+)
+
+// headTarget returns the ref HEAD points to, or "" when it is detached.
+func (r *Repository) headTarget() (string, error) {
+	return r.symbolicRefTarget("HEAD")
+}
+
+// decorations returns the names of the refs pointing to each object, as
+// shown by "log --decorate": HEAD first, then the other refs in reverse
+// order of their full names. A tag also decorates the objects it peels to.
+func (r *Repository) decorations() (map[string][]string, error) {
+	refs, err := r.getRefs()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	headRef, err := r.headTarget()
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.readRef("HEAD", map[string]string{})
+	if err != nil {
+		head = ""
+	}
+
+	decorations := map[string][]string{}
+	add := func(sha, name string) {
+		for sha != "" {
+			decorations[sha] = append(decorations[sha], name)
+			obj, err := r.readObject(sha)
+			if err != nil || obj.GetFormat() != TypeTag {
+				return
+			}
+			sha = obj.(*Tag).Object()
+		}
+	}
+	if head != "" {
+		add(head, "HEAD")
+	}
+	for _, name := range names {
+		if name == headRef && refs[name] == head {
+			continue
+		}
+		short := name
+		switch {
+		case strings.HasPrefix(name, "refs/heads/"):
+			short = strings.TrimPrefix(name, "refs/heads/")
+		case strings.HasPrefix(name, "refs/tags/"):
+			short = "tag: " + strings.TrimPrefix(name, "refs/tags/")
+		case strings.HasPrefix(name, "refs/remotes/"):
+			short = strings.TrimPrefix(name, "refs/remotes/")
+		}
+		add(refs[name], short)
+	}
+
+	// HEAD on a branch shows as "HEAD -> branch".
+	if head != "" && strings.HasPrefix(headRef, "refs/heads/") && refs[headRef] == head {
+		decorations[head][0] = "HEAD -> " + strings.TrimPrefix(headRef, "refs/heads/")
+	}
+	return decorations, nil
+}
+
+func formatDecorations(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(names, ", ") + ")"
+}
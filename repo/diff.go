@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"strings"
+)
+
+// diffOp is one line of an edit script: kept ('='), deleted from the old
+// side ('-') or inserted from the new side ('+'). oldLine and newLine are
+// 0-based indexes, or -1 when the line doesn't exist on that side.
+type diffOp struct {
+	kind    byte
+	oldLine int
+	newLine int
+}
+
+// splitLines splits content into lines, keeping their terminating
+// newline so that a missing newline at the end of file is a change.
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b with Myers'
+// algorithm. When normalize isn't nil, lines are compared by the result
+// of normalize.
+func diffLines(a, b []string, normalize func(string) string) []diffOp {
+	// Lines are compared as integers.
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			if normalize != nil {
+				line = normalize(line)
+			}
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	ai, bi := intern(a), intern(b)
+
+	matches := make([]int, len(a))
+	for i := range matches {
+		matches[i] = -1
+	}
+	myersLCS(ai, bi, 0, 0, matches)
+
+	ops := []diffOp{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && matches[i] == -1:
+			ops = append(ops, diffOp{'-', i, -1})
+			i++
+		case i < len(a) && matches[i] == j:
+			ops = append(ops, diffOp{'=', i, j})
+			i, j = i+1, j+1
+		default:
+			ops = append(ops, diffOp{'+', -1, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// myersLCS records in matches the line of b matched by each line of a, with
+// the linear space refinement of Myers' algorithm: find the middle snake of
+// an optimal path, then solve both halves recursively.
+func myersLCS(a, b []int, aOff, bOff int, matches []int) {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		matches[aOff] = bOff
+		a, b, aOff, bOff = a[1:], b[1:], aOff+1, bOff+1
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		matches[aOff+len(a)-1] = bOff + len(b) - 1
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return
+	}
+
+	x, y, u, v := middleSnake(a, b)
+	myersLCS(a[:x], b[:y], aOff, bOff, matches)
+	for i := 0; i < u-x; i++ {
+		matches[aOff+x+i] = bOff + y + i
+	}
+	myersLCS(a[u:], b[v:], aOff+u, bOff+v, matches)
+}
+
+// middleSnake finds the snake (x, y) -> (u, v) in the middle of an optimal
+// edit path from a to b by searching forward from the start and backward
+// from the end at the same time.
+func middleSnake(a, b []int) (int, int, int, int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta&1 != 0
+	max := (n + m + 1) / 2
+	offset := max + 1
+	vf := make([]int, 2*max+3)
+	vb := make([]int, 2*max+3)
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			vf[offset+k] = x
+			if odd && delta-k >= -(d-1) && delta-k <= d-1 && vf[offset+k]+vb[offset+delta-k] >= n {
+				return sx, sy, x, y
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x, y = x+1, y+1
+			}
+			vb[offset+k] = x
+			if !odd && delta-k >= -d && delta-k <= d && vb[offset+k]+vf[offset+delta-k] >= n {
+				return n - x, m - y, n - sx, m - sy
+			}
+		}
+	}
+	// Unreachable: a path of length n+m always exists.
+	return 0, 0, n, m
+}
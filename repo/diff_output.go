@@ -0,0 +1,551 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	diffContext     = 3
+	diffStatWidth   = 80
+	renameMinScore  = 50
+	binaryCheckSize = 8000
+)
+
+// fileDiff is a change to one file between two trees. A rename pairs a
+// deleted file with an added one, score being their similarity in
+// percent. The old or new side is empty when the file was added or deleted.
+type fileDiff struct {
+	oldPath string
+	newPath string
+	oldMode string
+	newMode string
+	oldSHA  string
+	newSHA  string
+	score   int
+}
+
+func (f fileDiff) path() string {
+	if f.newSHA == "" {
+		return f.oldPath
+	}
+	return f.newPath
+}
+
+func (f fileDiff) renamed() bool {
+	return f.oldSHA != "" && f.newSHA != "" && f.oldPath != f.newPath
+}
+
+// diffFiles lists the files matching specs that differ between two trees,
+// with renames detected among them, sorted by path.
+func (r *Repository) diffFiles(oldTree, newTree string, specs []pathspec) ([]fileDiff, error) {
+	changes, err := r.diffTrees(oldTree, newTree)
+	if err != nil {
+		return nil, err
+	}
+	files := []fileDiff{}
+	for _, ch := range changes {
+		if !matchPathspecs(specs, ch.path) {
+			continue
+		}
+		files = append(files, fileDiff{
+			oldPath: ch.path,
+			newPath: ch.path,
+			oldMode: ch.oldMode,
+			newMode: ch.newMode,
+			oldSHA:  ch.oldSHA,
+			newSHA:  ch.newSHA,
+		})
+	}
+	files, err = r.detectRenames(files)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].path() < files[j].path() })
+	return files, nil
+}
+
+// detectRenames pairs added files with deleted ones: first those with the
+// same content, then the most similar ones.
+func (r *Repository) detectRenames(files []fileDiff) ([]fileDiff, error) {
+	added, deleted := []int{}, []int{}
+	for i, f := range files {
+		switch {
+		case f.oldSHA == "" && f.newMode != ModeGitlink:
+			added = append(added, i)
+		case f.newSHA == "" && f.oldMode != ModeGitlink:
+			deleted = append(deleted, i)
+		}
+	}
+	if len(added) == 0 || len(deleted) == 0 {
+		return files, nil
+	}
+
+	used := map[int]bool{}
+	pair := func(a, d, score int) {
+		files[a].oldPath = files[d].oldPath
+		files[a].oldMode = files[d].oldMode
+		files[a].oldSHA = files[d].oldSHA
+		files[a].score = score
+		used[d] = true
+	}
+	unpaired := []int{}
+	for _, a := range added {
+		found := false
+		for _, d := range deleted {
+			if !used[d] && files[d].oldSHA == files[a].newSHA {
+				pair(a, d, 100)
+				found = true
+				break
+			}
+		}
+		if !found {
+			unpaired = append(unpaired, a)
+		}
+	}
+	for _, a := range unpaired {
+		newContent, err := r.readBlob(files[a].newSHA)
+		if err != nil {
+			return nil, err
+		}
+		best, bestScore := -1, 0
+		for _, d := range deleted {
+			if used[d] {
+				continue
+			}
+			oldContent, err := r.readBlob(files[d].oldSHA)
+			if err != nil {
+				return nil, err
+			}
+			if score := renameScore(oldContent, newContent); score > bestScore {
+				best, bestScore = d, score
+			}
+		}
+		if best >= 0 && bestScore >= renameMinScore {
+			pair(a, best, bestScore)
+		}
+	}
+
+	out := []fileDiff{}
+	for i, f := range files {
+		if !used[i] {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// renameScore is the percentage of the bigger file made of lines the two
+// files have in common, which is close to what git reports.
+func renameScore(a, b string) int {
+	size := len(a)
+	if len(b) > size {
+		size = len(b)
+	}
+	if size == 0 {
+		return 0
+	}
+	aLines := splitLines(a)
+	common := 0
+	for _, op := range diffLines(aLines, splitLines(b), nil) {
+		if op.kind == '=' {
+			common += len(aLines[op.oldLine])
+		}
+	}
+	return 100 * common / size
+}
+
+// diffContent returns the content of one side of a fileDiff, empty when
+// the file doesn't exist on that side.
+func (r *Repository) diffContent(sha string, mode string) (string, error) {
+	switch {
+	case sha == "":
+		return "", nil
+	case mode == ModeGitlink:
+		return fmt.Sprintf("Subproject commit %v\n", sha), nil
+	}
+	return r.readBlob(sha)
+}
+
+func isBinary(content string) bool {
+	if len(content) > binaryCheckSize {
+		content = content[:binaryCheckSize]
+	}
+	return strings.IndexByte(content, 0) >= 0
+}
+
+// writePatch writes files in the unified format of "git diff".
+func (r *Repository) writePatch(sb *strings.Builder, files []fileDiff) error {
+	for _, f := range files {
+		sb.WriteString(fmt.Sprintf("diff --git a/%v b/%v\n", f.oldPath, f.newPath))
+		switch {
+		case f.oldSHA == "":
+			sb.WriteString(fmt.Sprintf("new file mode %v\n", f.newMode))
+		case f.newSHA == "":
+			sb.WriteString(fmt.Sprintf("deleted file mode %v\n", f.oldMode))
+		case f.oldMode != f.newMode:
+			sb.WriteString(fmt.Sprintf("old mode %v\nnew mode %v\n", f.oldMode, f.newMode))
+		}
+		if f.renamed() {
+			sb.WriteString(fmt.Sprintf("similarity index %v%%\nrename from %v\nrename to %v\n", f.score, f.oldPath, f.newPath))
+		}
+		if f.oldSHA == f.newSHA {
+			continue
+		}
+
+		index := fmt.Sprintf("index %v..%v", abbrevOrZero(f.oldSHA), abbrevOrZero(f.newSHA))
+		if f.oldMode == f.newMode {
+			index += " " + f.oldMode
+		}
+		sb.WriteString(index + "\n")
+
+		oldName, newName := "a/"+f.oldPath, "b/"+f.newPath
+		if f.oldSHA == "" {
+			oldName = "/dev/null"
+		}
+		if f.newSHA == "" {
+			newName = "/dev/null"
+		}
+		oldContent, err := r.diffContent(f.oldSHA, f.oldMode)
+		if err != nil {
+			return err
+		}
+		newContent, err := r.diffContent(f.newSHA, f.newMode)
+		if err != nil {
+			return err
+		}
+		if isBinary(oldContent) || isBinary(newContent) {
+			sb.WriteString(fmt.Sprintf("Binary files %v and %v differ\n", oldName, newName))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("--- %v\n+++ %v\n", oldName, newName))
+		a, b := splitLines(oldContent), splitLines(newContent)
+		writeHunks(sb, a, b, diffLines(a, b, nil))
+	}
+	return nil
+}
+
+func abbrevOrZero(sha string) string {
+	if sha == "" {
+		return "0000000"
+	}
+	return sha[:7]
+}
+
+// writeHunks writes an edit script as hunks with three lines of context.
+// Changes separated by no more than twice the context share a hunk.
+func writeHunks(sb *strings.Builder, a, b []string, ops []diffOp) {
+	// oldPos and newPos count the lines before ops[i].
+	oldPos, newPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if op.kind != '+' {
+			oldPos[i+1]++
+		}
+		if op.kind != '-' {
+			newPos[i+1]++
+		}
+	}
+
+	i := 0
+	for i < len(ops) {
+		for i < len(ops) && ops[i].kind == '=' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != '=' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == '=' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		stop := end + diffContext
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		oldCount, newCount := oldPos[stop]-oldPos[start], newPos[stop]-newPos[start]
+		header := fmt.Sprintf("@@ -%v +%v @@", hunkRange(oldPos[start], oldCount), hunkRange(newPos[start], newCount))
+		if fn := funcName(a, oldPos[start]); fn != "" {
+			header += " " + fn
+		}
+		sb.WriteString(header + "\n")
+		for _, op := range ops[start:stop] {
+			var line string
+			switch op.kind {
+			case '=':
+				line = " " + a[op.oldLine]
+			case '-':
+				line = "-" + a[op.oldLine]
+			default:
+				line = "+" + b[op.newLine]
+			}
+			sb.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+}
+
+// hunkRange formats the lines of one side of a hunk. An empty range starts
+// at the line before it.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%v,0", before)
+	case 1:
+		return fmt.Sprintf("%v", before+1)
+	}
+	return fmt.Sprintf("%v,%v", before+1, count)
+}
+
+// funcName finds the line shown after a hunk header: the last line before
+// the hunk starting with a letter, "_" or "$".
+func funcName(lines []string, before int) string {
+	for i := before - 1; i >= 0; i-- {
+		if line := lines[i]; isFuncLine(line) {
+			line = strings.TrimRight(line, " \t\r\n")
+			if len(line) > 80 {
+				line = line[:80]
+			}
+			return line
+		}
+	}
+	return ""
+}
+
+func isFuncLine(line string) bool {
+	if line == "" {
+		return false
+	}
+	c := line[0]
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// diffStat is the number of lines added and deleted in a file, or its
+// sizes when it is binary.
+type diffStat struct {
+	name    string
+	added   int
+	deleted int
+	binary  bool
+}
+
+// writeStat writes a histogram of the changes, like "git diff --stat".
+func (r *Repository) writeStat(sb *strings.Builder, files []fileDiff) error {
+	stats := []diffStat{}
+	for _, f := range files {
+		s := diffStat{name: f.path()}
+		if f.renamed() {
+			s.name = renameName(f.oldPath, f.newPath)
+		}
+		if f.oldSHA != f.newSHA {
+			oldContent, err := r.diffContent(f.oldSHA, f.oldMode)
+			if err != nil {
+				return err
+			}
+			newContent, err := r.diffContent(f.newSHA, f.newMode)
+			if err != nil {
+				return err
+			}
+			if isBinary(oldContent) || isBinary(newContent) {
+				s.binary, s.added, s.deleted = true, len(newContent), len(oldContent)
+			} else {
+				for _, op := range diffLines(splitLines(oldContent), splitLines(newContent), nil) {
+					switch op.kind {
+					case '+':
+						s.added++
+					case '-':
+						s.deleted++
+					}
+				}
+			}
+		}
+		stats = append(stats, s)
+	}
+	writeStatLines(sb, stats)
+	return nil
+}
+
+// writeStatLines lays out the stat lines within diffStatWidth columns the
+// way git does.
+func writeStatLines(sb *strings.Builder, stats []diffStat) {
+	maxLen, maxChange, numberWidth, binWidth := 0, 0, 0, 0
+	for _, s := range stats {
+		if len(s.name) > maxLen {
+			maxLen = len(s.name)
+		}
+		if s.binary {
+			if w := 14 + len(fmt.Sprint(s.added)) + len(fmt.Sprint(s.deleted)); w > binWidth {
+				binWidth = w
+			}
+			numberWidth = 3
+			continue
+		}
+		if s.added+s.deleted > maxChange {
+			maxChange = s.added + s.deleted
+		}
+	}
+	if w := len(fmt.Sprint(maxChange)); w > numberWidth {
+		numberWidth = w
+	}
+
+	width := diffStatWidth
+	if width < 16+6+numberWidth {
+		width = 16 + 6 + numberWidth
+	}
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxLen
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = width*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	files, insertions, deletions := 0, 0, 0
+	for _, s := range stats {
+		files++
+		name, prefix := s.name, ""
+		if nameWidth < len(name) {
+			prefix = "..."
+			keep := nameWidth - 3
+			if keep < 0 {
+				keep = 0
+			}
+			name = name[len(name)-keep:]
+			if i := strings.IndexByte(name, '/'); i >= 0 {
+				name = name[i:]
+			}
+		}
+		padding := nameWidth - len(prefix) - len(name)
+		if padding < 0 {
+			padding = 0
+		}
+		line := fmt.Sprintf(" %v%v%v | ", prefix, name, strings.Repeat(" ", padding))
+
+		if s.binary {
+			sb.WriteString(line + fmt.Sprintf("%*v", numberWidth, "Bin"))
+			if s.added != 0 || s.deleted != 0 {
+				sb.WriteString(fmt.Sprintf(" %v -> %v bytes", s.deleted, s.added))
+			}
+			sb.WriteString("\n")
+			continue
+		}
+		insertions += s.added
+		deletions += s.deleted
+
+		add, del := s.added, s.deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add != 0 && del != 0 {
+				total = 2
+			}
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+		line += fmt.Sprintf("%*v", numberWidth, s.added+s.deleted)
+		if s.added+s.deleted != 0 {
+			line += " "
+		}
+		sb.WriteString(line + strings.Repeat("+", add) + strings.Repeat("-", del) + "\n")
+	}
+
+	summary := fmt.Sprintf(" %v %v changed", files, plural(files, "file", "files"))
+	if insertions != 0 || deletions == 0 {
+		summary += fmt.Sprintf(", %v %v(+)", insertions, plural(insertions, "insertion", "insertions"))
+	}
+	if deletions != 0 || insertions == 0 {
+		summary += fmt.Sprintf(", %v %v(-)", deletions, plural(deletions, "deletion", "deletions"))
+	}
+	sb.WriteString(summary + "\n")
+}
+
+func scaleLinear(n, width, max int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/max
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// renameName shows a rename with the directories both paths have in
+// common outside of braces, as in "dir/{a => b}".
+func renameName(a, b string) string {
+	at := func(s string, i int) byte {
+		if i < len(s) {
+			return s[i]
+		}
+		return 0
+	}
+
+	pfx := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			pfx = i + 1
+		}
+	}
+
+	// When there is a common prefix, it ends with a slash that the suffix
+	// may start with.
+	adjust := 0
+	if pfx > 0 {
+		adjust = 1
+	}
+	sfx := 0
+	for i, j := len(a), len(b); pfx-adjust <= i && pfx-adjust <= j && at(a, i) == at(b, j); i, j = i-1, j-1 {
+		if at(a, i) == '/' {
+			sfx = len(a) - i
+		}
+	}
+
+	aMid, bMid := len(a)-pfx-sfx, len(b)-pfx-sfx
+	if aMid < 0 {
+		aMid = 0
+	}
+	if bMid < 0 {
+		bMid = 0
+	}
+	if pfx+sfx == 0 {
+		return a[:aMid] + " => " + b[:bMid]
+	}
+	return a[:pfx] + "{" + a[pfx:pfx+aMid] + " => " + b[pfx:pfx+bMid] + "}" + a[len(a)-sfx:]
+}
@@ -0,0 +1,149 @@
+package repo
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lcsLength is the textbook dynamic programming solution, to check that
+// diffLines finds a shortest edit script.
+func lcsLength(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] > dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func TestDiffLines(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func() []string {
+		lines := make([]string, r.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.Intn(4)))
+		}
+		return lines
+	}
+	for n := 0; n < 500; n++ {
+		a, b := random(), random()
+		ops := diffLines(a, b, nil)
+
+		// Replaying the script on a must give b.
+		got := []string{}
+		common := 0
+		i, j := 0, 0
+		for _, op := range ops {
+			switch op.kind {
+			case '=':
+				if op.oldLine != i || op.newLine != j || a[i] != b[j] {
+					t.Fatalf("%v -> %v: bad match %+v", a, b, op)
+				}
+				got = append(got, a[i])
+				i, j, common = i+1, j+1, common+1
+			case '-':
+				if op.oldLine != i {
+					t.Fatalf("%v -> %v: bad deletion %+v", a, b, op)
+				}
+				i++
+			case '+':
+				if op.newLine != j {
+					t.Fatalf("%v -> %v: bad insertion %+v", a, b, op)
+				}
+				got = append(got, b[j])
+				j++
+			}
+		}
+		if i != len(a) || j != len(b) || len(got) != len(b) {
+			t.Fatalf("%v -> %v: incomplete script %+v", a, b, ops)
+		}
+		if want := lcsLength(a, b); common != want {
+			t.Fatalf("%v -> %v: kept %v lines, want %v", a, b, common, want)
+		}
+	}
+}
+
+func TestWriteHunks(t *testing.T) {
+	a := splitLines("func f\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\nend")
+	b := splitLines("func f\n1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\nend\n")
+	var sb strings.Builder
+	writeHunks(&sb, a, b, diffLines(a, b, nil))
+	want := "@@ -3,7 +3,7 @@ func f\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n" +
+		"@@ -14,4 +14,4 @@ func f\n 13\n 14\n 15\n-end\n\\ No newline at end of file\n+end\n"
+	if sb.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", sb.String(), want)
+	}
+
+	sb.Reset()
+	writeHunks(&sb, nil, []string{"x\n"}, diffLines(nil, []string{"x\n"}, nil))
+	if got := sb.String(); got != "@@ -0,0 +1 @@\n+x\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRenameName(t *testing.T) {
+	cases := [][3]string{
+		{"a.txt", "b.txt", "a.txt => b.txt"},
+		{"dir/sub/f", "dir/sub/g", "dir/sub/{f => g}"},
+		{"a/x/file", "b/x/file", "{a => b}/x/file"},
+		{"dir/file", "dir/new/file", "dir/{ => new}/file"},
+	}
+	for _, c := range cases {
+		if got := renameName(c[0], c[1]); got != c[2] {
+			t.Errorf("renameName(%q, %q) = %q, want %q", c[0], c[1], got, c[2])
+		}
+	}
+}
+
+func TestCombinedHunks(t *testing.T) {
+	numbers := func(from, to int) []string {
+		lines := []string{}
+		for i := from; i <= to; i++ {
+			lines = append(lines, strconv.Itoa(i)+"\n")
+		}
+		return lines
+	}
+	join := func(parts ...[]string) []string {
+		lines := []string{}
+		for _, part := range parts {
+			lines = append(lines, part...)
+		}
+		return lines
+	}
+	ours := join(numbers(1, 4), []string{"FIVE\n"}, numbers(6, 17), []string{"eighteen\n"}, numbers(19, 20))
+	theirs := join(numbers(1, 4), []string{"five\n"}, numbers(6, 14), []string{"fifteen\n"}, numbers(16, 20))
+	result := join(numbers(1, 4), []string{"merged5\n"}, numbers(6, 14), []string{"fifteen\n"},
+		numbers(16, 17), []string{"eighteen\n"}, numbers(19, 21))
+
+	lines := combineDiffs(result, [][]string{ours, theirs})
+	if !makeCombinedHunks(lines, 2) {
+		t.Fatal("no hunks")
+	}
+	var sb strings.Builder
+	writeCombinedHunks(&sb, lines, 2)
+	want := "@@@ -2,7 -2,7 +2,7 @@@\n" +
+		"  2\n  3\n  4\n- FIVE\n -five\n++merged5\n  6\n  7\n  8\n" +
+		"@@@ -12,9 -12,9 +12,10 @@@\n" +
+		"  12\n  13\n  14\n- 15\n+ fifteen\n  16\n  17\n -18\n +eighteen\n  19\n  20\n++21\n"
+	if sb.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", sb.String(), want)
+	}
+
+	// A hunk taking the result from one parent isn't shown.
+	lines = combineDiffs(theirs, [][]string{ours, theirs})
+	if makeCombinedHunks(lines, 2) {
+		t.Error("got hunks for a result matching a parent")
+	}
+}
@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"path"
+)
+
+// treeChange is a file that differs between two trees. The old or new
+// side is empty when the file was added or deleted.
+type treeChange struct {
+	path    string
+	oldMode string
+	oldSHA  string
+	newMode string
+	newSHA  string
+}
+
+// readTreeLeaves returns the entries of a tree by name. An empty sha
+// stands for the empty tree.
+func (r *Repository) readTreeLeaves(sha string) (map[string]Leaf, []string, error) {
+	leaves := map[string]Leaf{}
+	names := []string{}
+	if sha == "" {
+		return leaves, names, nil
+	}
+	tree := createTree(r, nil)
+	if err := tree.Read(sha); err != nil {
+		return nil, nil, err
+	}
+	for _, leaf := range tree.leaves {
+		leaves[leaf.path] = leaf
+		names = append(names, leaf.path)
+	}
+	return leaves, names, nil
+}
+
+// diffTrees lists the files that differ between two trees, recursing into
+// subtrees, in the order of the new tree followed by deletions.
+func (r *Repository) diffTrees(oldSHA, newSHA string) ([]treeChange, error) {
+	changes := []treeChange{}
+	err := r.diffTreesAt(oldSHA, newSHA, "", &changes)
+	return changes, err
+}
+
+func (r *Repository) diffTreesAt(oldSHA, newSHA string, prefix string, changes *[]treeChange) error {
+	if oldSHA == newSHA {
+		return nil
+	}
+	oldLeaves, oldNames, err := r.readTreeLeaves(oldSHA)
+	if err != nil {
+		return err
+	}
+	newLeaves, newNames, err := r.readTreeLeaves(newSHA)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range newNames {
+		n := newLeaves[name]
+		o, ok := oldLeaves[name]
+		if !ok {
+			o = Leaf{}
+		}
+		if err := r.diffLeaves(o, n, path.Join(prefix, name), changes); err != nil {
+			return err
+		}
+	}
+	for _, name := range oldNames {
+		if _, ok := newLeaves[name]; !ok {
+			if err := r.diffLeaves(oldLeaves[name], Leaf{}, path.Join(prefix, name), changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// diffLeaves compares two entries of the same name. A change between a
+// tree and a file is reported as the deletion of one and the addition of
+// the other.
+func (r *Repository) diffLeaves(o, n Leaf, leafPath string, changes *[]treeChange) error {
+	if o.sha == n.sha && o.mode == n.mode {
+		return nil
+	}
+	oTree, nTree := o.mode == ModeTree, n.mode == ModeTree
+	if oTree || nTree {
+		oldSHA, newSHA := "", ""
+		if oTree {
+			oldSHA = o.sha
+		}
+		if nTree {
+			newSHA = n.sha
+		}
+		if err := r.diffTreesAt(oldSHA, newSHA, leafPath, changes); err != nil {
+			return err
+		}
+		if !oTree && o.sha != "" {
+			*changes = append(*changes, treeChange{path: leafPath, oldMode: o.mode, oldSHA: o.sha})
+		}
+		if !nTree && n.sha != "" {
+			*changes = append(*changes, treeChange{path: leafPath, newMode: n.mode, newSHA: n.sha})
+		}
+		return nil
+	}
+	*changes = append(*changes, treeChange{
+		path:    leafPath,
+		oldMode: o.mode,
+		oldSHA:  o.sha,
+		newMode: n.mode,
+		newSHA:  n.sha,
+	})
+	return nil
+}
@@ -0,0 +1,286 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pencil001/pit/util"
+)
+
+// findRepo finds the repository that repoPath belongs to, and panics when
+// there is none.
+func findRepo(repoPath string) *Repository {
+	repo, err := discoverRepo(repoPath)
+	if err != nil {
+		log.Panic(err)
+	}
+	return repo
+}
+
+// tryFindRepo is like findRepo, but returns nil outside of a repository.
+func tryFindRepo(repoPath string) *Repository {
+	repo, err := discoverRepo(repoPath)
+	if err != nil {
+		return nil
+	}
+	return repo
+}
+
+// discoverRepo finds a repository like git does. $GIT_DIR names the git
+// directory, whose work tree is then the current directory. Otherwise
+// each directory from repoPath up is searched for a .git directory, a
+// .git file pointing to one, or is itself a bare repository; a repository
+// found this way must belong to the current user, or be trusted by
+// safe.directory. $GIT_WORK_TREE overrides the work tree either way.
+func discoverRepo(repoPath string) (*Repository, error) {
+	gitDir, workTree := os.Getenv("GIT_DIR"), ""
+	if gitDir != "" {
+		if !isGitDirectory(gitDir) {
+			return nil, fmt.Errorf("not a git repository: '%v'", gitDir)
+		}
+		workTree = "."
+	} else {
+		found, err := searchGitDir(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOwnership(found); err != nil {
+			return nil, err
+		}
+		gitDir, workTree = found.gitDir, found.workTree
+	}
+
+	gitDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	if workTree != "" {
+		if workTree, err = filepath.Abs(workTree); err != nil {
+			return nil, err
+		}
+	}
+	repo, err := openRepository(workTree, gitDir)
+	if err != nil {
+		return nil, err
+	}
+	if wt := os.Getenv("GIT_WORK_TREE"); wt != "" {
+		if repo.workTree, err = filepath.Abs(wt); err != nil {
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+// discovered is where searchGitDir found a repository: its git directory,
+// the .git file that led to it if any, and its work tree, empty for a bare
+// repository.
+type discovered struct {
+	gitDir   string
+	gitFile  string
+	workTree string
+}
+
+// searchGitDir walks up from dir to find a repository. It doesn't enter
+// the directories of $GIT_CEILING_DIRECTORIES, and stops at the boundary
+// of the filesystem unless $GIT_DISCOVERY_ACROSS_FILESYSTEM is true.
+func searchGitDir(dir string) (*discovered, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	ceiling := ceilingOf(dir, ceilingDirectories())
+	acrossFS, _ := parseBool(os.Getenv("GIT_DISCOVERY_ACROSS_FILESYSTEM"))
+	device, hasDevice := uint64(0), false
+	if st, err := os.Stat(dir); err == nil {
+		device, hasDevice = fileDevice(st)
+	}
+
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		st, err := os.Stat(dotGit)
+		switch {
+		case err == nil && st.IsDir() && isGitDirectory(dotGit):
+			return &discovered{gitDir: dotGit, workTree: dir}, nil
+		case err == nil && st.Mode().IsRegular():
+			gitDir, err := readGitFile(dotGit)
+			if err != nil {
+				return nil, err
+			}
+			return &discovered{gitDir: gitDir, gitFile: dotGit, workTree: dir}, nil
+		case isGitDirectory(dir):
+			return &discovered{gitDir: dir}, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir || (ceiling != "" && len(parent) <= len(ceiling)) {
+			return nil, fmt.Errorf("not a git repository (or any of the parent directories): .git")
+		}
+		if hasDevice && !acrossFS {
+			if st, err := os.Stat(parent); err == nil {
+				if dev, ok := fileDevice(st); ok && dev != device {
+					return nil, fmt.Errorf("not a git repository (or any parent up to mount point %v)\n"+
+						"Stopping at filesystem boundary (GIT_DISCOVERY_ACROSS_FILESYSTEM not set).", dir)
+				}
+			}
+		}
+		dir = parent
+	}
+}
+
+// ceilingDirectories reads $GIT_CEILING_DIRECTORIES, a list of absolute
+// paths. Relative ones are ignored, and symbolic links are resolved in
+// those before an empty entry.
+func ceilingDirectories() []string {
+	dirs := []string{}
+	resolve := true
+	for _, d := range filepath.SplitList(os.Getenv("GIT_CEILING_DIRECTORIES")) {
+		if d == "" {
+			resolve = false
+			continue
+		}
+		if !filepath.IsAbs(d) {
+			continue
+		}
+		d = filepath.Clean(d)
+		if resolve {
+			if real, err := filepath.EvalSymlinks(d); err == nil {
+				d = real
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs
+}
+
+// ceilingOf returns the deepest of the ceilings above dir, which the
+// search must not reach, or "". dir itself is never a ceiling.
+func ceilingOf(dir string, ceilings []string) string {
+	best := ""
+	for _, c := range ceilings {
+		if c == dir || len(c) <= len(best) {
+			continue
+		}
+		if c == string(filepath.Separator) || strings.HasPrefix(dir, c+string(filepath.Separator)) {
+			best = c
+		}
+	}
+	return best
+}
+
+// checkOwnership refuses a discovered repository that belongs to another
+// user, as it might have been planted to run commands through its config,
+// unless safe.directory trusts it. The work tree, the .git file and the
+// git directory are all checked.
+func checkOwnership(found *discovered) error {
+	paths := []string{found.workTree, found.gitFile, found.gitDir}
+	owned := true
+	for _, p := range paths {
+		if p != "" && !isOwnedByCurrentUser(p) {
+			owned = false
+			break
+		}
+	}
+	if owned {
+		return nil
+	}
+
+	dir := found.workTree
+	if dir == "" {
+		dir = found.gitDir
+	}
+	cfg, err := loadConfig("")
+	if err != nil {
+		return err
+	}
+	if isSafeDirectory(cfg.getAll("safe.directory"), dir) {
+		return nil
+	}
+	return fmt.Errorf("detected dubious ownership in repository at '%v'\n"+
+		"To add an exception for this directory, call:\n\n"+
+		"\tpit config --global --add safe.directory %v", dir, dir)
+}
+
+// isSafeDirectory tells whether the safe.directory values trust dir: "*"
+// trusts every directory, and an empty value forgets the ones before.
+func isSafeDirectory(values []string, dir string) bool {
+	safe := false
+	for _, v := range values {
+		switch {
+		case v == "":
+			safe = false
+		case v == "*":
+			safe = true
+		default:
+			v = expandConfigPath(v)
+			safe = safe || filepath.Clean(v) == filepath.Clean(dir)
+		}
+	}
+	return safe
+}
+
+// isOwnedByCurrentUser tells whether the file at filePath belongs to the
+// user running us. When run as root through sudo, the user who called sudo
+// counts instead.
+func isOwnedByCurrentUser(filePath string) bool {
+	st, err := os.Lstat(filePath)
+	if err != nil {
+		return false
+	}
+	owner, ok := fileOwner(st)
+	if !ok {
+		return true
+	}
+	uid := os.Geteuid()
+	if uid == 0 {
+		if sudoUID, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+			uid = sudoUID
+		}
+	}
+	return owner == uid
+}
+
+// readGitFile reads a .git file, "gitdir: <path>", which stands for the
+// git directory of a work tree kept elsewhere, as worktrees and submodules
+// do. A relative path is relative to the directory of the file.
+func readGitFile(filePath string) (string, error) {
+	bs, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	content := strings.TrimRight(string(bs), "\r\n")
+	if !strings.HasPrefix(content, "gitdir: ") {
+		return "", fmt.Errorf("invalid gitfile format: %v", filePath)
+	}
+	gitDir := strings.TrimPrefix(content, "gitdir: ")
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(filePath), gitDir)
+	}
+	if !isGitDirectory(gitDir) {
+		return "", fmt.Errorf("not a git repository: %v", gitDir)
+	}
+	return gitDir, nil
+}
+
+// isGitDirectory tells whether dir looks like a git directory: it has a
+// HEAD, and objects and refs directories, which are in the common
+// directory for a linked worktree.
+func isGitDirectory(dir string) bool {
+	if ok, err := util.IsExist(filepath.Join(dir, "HEAD")); err != nil || !ok {
+		return false
+	}
+	common := commonDirOf(dir)
+	for _, sub := range []string{"objects", "refs"} {
+		if ok, err := util.IsDir(filepath.Join(common, sub)); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscoverRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	Init(filepath.Join(dir, "work"), "", "", false)
+	Init(filepath.Join(dir, "bare.git"), "", "", true)
+	linked := filepath.Join(dir, "linked", "sub")
+	if err := os.MkdirAll(linked, 0777); err != nil {
+		t.Fatal(err)
+	}
+	gitFile := filepath.Join(dir, "linked", ".git")
+	if err := ioutil.WriteFile(gitFile, []byte("gitdir: ../work/.git\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		start, gitDir, workTree string
+	}{
+		{"work", "work/.git", "work"},
+		{"work/.git", "work/.git", ""},
+		{"bare.git", "bare.git", ""},
+		{"bare.git/refs/heads", "bare.git", ""},
+		{"linked/sub", "work/.git", "linked"},
+	}
+	for _, c := range cases {
+		repo, err := discoverRepo(filepath.Join(dir, c.start))
+		if err != nil {
+			t.Errorf("%v: %v", c.start, err)
+			continue
+		}
+		workTree := ""
+		if c.workTree != "" {
+			workTree = filepath.Join(dir, c.workTree)
+		}
+		if repo.gitDir != filepath.Join(dir, c.gitDir) || repo.workTree != workTree {
+			t.Errorf("%v: got %v and %q", c.start, repo.gitDir, repo.workTree)
+		}
+	}
+
+	if err := ioutil.WriteFile(gitFile, []byte("../work/.git\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := discoverRepo(linked); err == nil {
+		t.Errorf("expected an invalid gitfile error")
+	}
+}
+
+func TestDiscoveryBoundaries(t *testing.T) {
+	ceilings := []string{"/srv", "/srv/build", "/home/user", "/"}
+	for dir, want := range map[string]string{
+		"/srv/build/src": "/srv/build",
+		"/srv/build":     "/srv",
+		"/srv/buildx":    "/srv",
+		"/home/user/a":   "/home/user",
+		"/home/users":    "/",
+		"/srv":           "/",
+	} {
+		if got := ceilingOf(dir, ceilings); got != want {
+			t.Errorf("ceilingOf(%v) = %v, want %v", dir, got, want)
+		}
+	}
+
+	cases := []struct {
+		values []string
+		safe   bool
+	}{
+		{nil, false},
+		{[]string{"/srv/repo"}, true},
+		{[]string{"/srv/repo/"}, true},
+		{[]string{"/srv"}, false},
+		{[]string{"*"}, true},
+		{[]string{"*", ""}, false},
+		{[]string{"", "/srv/repo"}, true},
+	}
+	for _, c := range cases {
+		if got := isSafeDirectory(c.values, "/srv/repo"); got != c.safe {
+			t.Errorf("isSafeDirectory(%q) = %v", c.values, got)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package repo
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the user id owning a file.
+func fileOwner(st os.FileInfo) (int, bool) {
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(sys.Uid), true
+}
+
+// fileDevice returns the id of the filesystem a file is on.
+func fileDevice(st os.FileInfo) (uint64, bool) {
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(sys.Dev), true
+}
@@ -0,0 +1,15 @@
+package repo
+
+import "os"
+
+// fileOwner isn't known on Windows, where every file is taken to belong
+// to the current user.
+func fileOwner(st os.FileInfo) (int, bool) {
+	return 0, false
+}
+
+// fileDevice isn't known on Windows, so discovery isn't stopped at
+// filesystem boundaries.
+func fileDevice(st os.FileInfo) (uint64, bool) {
+	return 0, false
+}
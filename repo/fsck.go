@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validModes are the modes a tree entry may have.
+var validModes = map[string]bool{
+	ModeTree:    true,
+	ModeBlob:    true,
+	ModeExec:    true,
+	ModeSymlink: true,
+	ModeGitlink: true,
+}
+
+// validateObject checks that data is a well-formed object of the given
+// type, in the spirit of "git fsck", so that malformed objects are
+// rejected before they are named or stored.
+func (r *Repository) validateObject(format string, data []byte) error {
+	switch format {
+	case TypeBlob:
+		return nil
+	case TypeTree:
+		return r.validateTree(data)
+	case TypeCommit:
+		return r.validateCommit(data)
+	case TypeTag:
+		return r.validateTag(data)
+	}
+	return fmt.Errorf("Unknown type: %v", format)
+}
+
+// validateTree checks the modes and the order of the entries of a tree.
+// Names used twice, even by a file and a directory which aren't next to
+// each other like "a" in "a", "a.b" and "a/", are rejected by Deserialize.
+func (r *Repository) validateTree(data []byte) error {
+	tree := createTree(r, nil)
+	if err := tree.Deserialize(data); err != nil {
+		return err
+	}
+	// Entries are sorted as if the names of trees ended with a slash.
+	sortName := func(leaf Leaf) string {
+		if leaf.mode == ModeTree {
+			return leaf.path + "/"
+		}
+		return leaf.path
+	}
+	for i, leaf := range tree.leaves {
+		if !validModes[leaf.mode] {
+			return fmt.Errorf("Malformed tree: bad mode %v for %q", leaf.mode, leaf.path)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := tree.leaves[i-1]
+		if sortName(prev) > sortName(leaf) {
+			return fmt.Errorf("Malformed tree: %q is not sorted after %q", leaf.path, prev.path)
+		}
+	}
+	return nil
+}
+
+func (r *Repository) validateCommit(data []byte) error {
+	commit := createCommit(r, nil)
+	if err := commit.Deserialize(data); err != nil {
+		return fmt.Errorf("Malformed commit: %v", err)
+	}
+
+	// The known headers come first, in this order.
+	keys := []string{}
+	for _, kl := range commit.kvlm {
+		keys = append(keys, kl.key)
+	}
+	next := func(key string) bool {
+		if len(keys) > 0 && keys[0] == key {
+			keys = keys[1:]
+			return true
+		}
+		return false
+	}
+	if !next("tree") || len(commit.values("tree")) != 1 {
+		return fmt.Errorf("Malformed commit: missing tree line")
+	}
+	if !r.isObjectName(commit.Tree()) {
+		return fmt.Errorf("Malformed commit: bad tree %q", commit.Tree())
+	}
+	if next("parent") {
+		for _, p := range commit.Parents() {
+			if !r.isObjectName(p) {
+				return fmt.Errorf("Malformed commit: bad parent %q", p)
+			}
+		}
+	}
+	for _, key := range []string{"author", "committer"} {
+		if !next(key) || len(commit.values(key)) != 1 {
+			return fmt.Errorf("Malformed commit: missing %v line", key)
+		}
+		if err := validateIdent(commit.value(key)); err != nil {
+			return fmt.Errorf("Malformed commit: bad %v: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func (r *Repository) validateTag(data []byte) error {
+	tag := createTag(r, nil)
+	if err := tag.Deserialize(data); err != nil {
+		return fmt.Errorf("Malformed tag: %v", err)
+	}
+	keys := []string{}
+	for _, kl := range tag.kvlm {
+		keys = append(keys, kl.key)
+	}
+	for i, key := range []string{"object", "type", "tag"} {
+		if len(keys) <= i || keys[i] != key || len(tag.values(key)) != 1 {
+			return fmt.Errorf("Malformed tag: missing %v line", key)
+		}
+	}
+	if !r.isObjectName(tag.Object()) {
+		return fmt.Errorf("Malformed tag: bad object %q", tag.Object())
+	}
+	switch tag.ObjectType() {
+	case TypeBlob, TypeTree, TypeCommit, TypeTag:
+	default:
+		return fmt.Errorf("Malformed tag: bad type %q", tag.ObjectType())
+	}
+	if name := tag.TagName(); name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("Malformed tag: bad tag name %q", name)
+	}
+	if tagger := tag.values("tagger"); len(tagger) > 0 {
+		if err := validateIdent(tagger[0]); err != nil {
+			return fmt.Errorf("Malformed tag: bad tagger: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateIdent checks an identity line: "Name <email> <seconds> <tz>".
+func validateIdent(line string) error {
+	lt := strings.Index(line, "<")
+	gt := strings.Index(line, ">")
+	if lt < 0 || gt < lt || strings.ContainsAny(line[lt+1:gt], "<>") {
+		return fmt.Errorf("bad email in %q", line)
+	}
+	if lt > 0 && line[lt-1] != ' ' {
+		return fmt.Errorf("missing space before email in %q", line)
+	}
+	fields := strings.Fields(line[gt+1:])
+	if len(fields) != 2 {
+		return fmt.Errorf("bad date in %q", line)
+	}
+	if _, err := parseSignature(line); err != nil {
+		return err
+	}
+	return nil
+}
+
+// isObjectName tells whether s is a full object name of the repository's
+// hash algorithm, in lowercase.
+func (r *Repository) isObjectName(s string) bool {
+	return len(s) == r.objectFormat().HexSize() && isHex(s) && strings.ToLower(s) == s
+}
@@ -0,0 +1,544 @@
+package repo
+
+import (
+	"strings"
+)
+
+// States of a logGraph, after the kind of line it outputs next.
+const (
+	linePadding = iota
+	lineSkip
+	linePreCommit
+	lineCommit
+	linePostMerge
+	lineCollapsing
+)
+
+// logGraph draws the history next to "log --graph" output, one line at a
+// time, with the same layout as git: each commit is a "*" in the column of
+// the line of history it is on, and branch lines are drawn with "|", "/",
+// "\" and "_" as they fork and merge.
+//
+// columns are the lines of history entering the current commit's row,
+// newColumns the ones leaving it. mapping tells, for each screen column of
+// the row being drawn, which of newColumns the line there goes to.
+type logGraph struct {
+	// parents returns the parents of a commit that are shown.
+	parents func(sha string) []string
+
+	commit          string
+	commitParents   []string
+	width           int
+	expansionRow    int
+	state           int
+	prevState       int
+	commitIndex     int
+	prevCommitIndex int
+	mergeLayout     int
+	edgesAdded      int
+	prevEdgesAdded  int
+	columns         []string
+	newColumns      []string
+	mapping         []int
+	oldMapping      []int
+	mappingSize     int
+}
+
+func newLogGraph(parents func(sha string) []string) *logGraph {
+	return &logGraph{parents: parents, state: linePadding, prevState: linePadding}
+}
+
+func (g *logGraph) setState(state int) {
+	g.prevState = g.state
+	g.state = state
+}
+
+func (g *logGraph) findNewColumn(sha string) int {
+	for i, c := range g.newColumns {
+		if c == sha {
+			return i
+		}
+	}
+	return -1
+}
+
+// update moves the graph to the next commit to show.
+func (g *logGraph) update(sha string) {
+	if g == nil {
+		return
+	}
+	g.commit = sha
+	g.commitParents = g.parents(sha)
+	g.prevCommitIndex = g.commitIndex
+	g.updateColumns()
+	g.expansionRow = 0
+
+	// A commit whose output wasn't finished leaves a gap in the graph.
+	switch {
+	case g.state != linePadding:
+		g.state = lineSkip
+	case g.needsPreCommitLine():
+		g.state = linePreCommit
+	default:
+		g.state = lineCommit
+	}
+}
+
+func (g *logGraph) insertIntoNewColumns(sha string, idx int) {
+	i := g.findNewColumn(sha)
+	if i < 0 {
+		i = len(g.newColumns)
+		g.newColumns = append(g.newColumns, sha)
+	}
+
+	var mappingIdx int
+	numParents := len(g.commitParents)
+	switch {
+	case numParents > 1 && idx > -1 && g.mergeLayout == -1:
+		// The first parent of a merge: the merge line leans left when
+		// the parent is in a column left of the merge.
+		dist := idx - i
+		shift := 1
+		if dist > 1 {
+			shift = 2*dist - 3
+		}
+		g.mergeLayout = 1
+		if dist > 0 {
+			g.mergeLayout = 0
+		}
+		g.edgesAdded = numParents + g.mergeLayout - 2
+		mappingIdx = g.width + (g.mergeLayout-1)*shift
+		g.width += 2 * g.mergeLayout
+	case g.edgesAdded > 0 && i == g.mapping[g.width-2]:
+		// The commit is in the last column a merge added, so both edges
+		// join right away.
+		mappingIdx = g.width - 2
+		g.edgesAdded = -1
+	default:
+		mappingIdx = g.width
+		g.width += 2
+	}
+	g.mapping[mappingIdx] = i
+}
+
+func (g *logGraph) updateColumns() {
+	g.columns, g.newColumns = g.newColumns, g.columns[:0]
+
+	maxNewColumns := len(g.columns) + len(g.commitParents)
+	if len(g.mapping) < 2*maxNewColumns {
+		mapping := make([]int, 2*maxNewColumns)
+		oldMapping := make([]int, 2*maxNewColumns)
+		copy(oldMapping, g.oldMapping)
+		g.mapping, g.oldMapping = mapping, oldMapping
+	}
+	g.mappingSize = 2 * maxNewColumns
+	for i := 0; i < g.mappingSize; i++ {
+		g.mapping[i] = -1
+	}
+	g.width = 0
+	g.prevEdgesAdded = g.edgesAdded
+	g.edgesAdded = 0
+
+	seen := false
+	for i := 0; i <= len(g.columns); i++ {
+		var sha string
+		if i == len(g.columns) {
+			if seen {
+				break
+			}
+			sha = g.commit
+		} else {
+			sha = g.columns[i]
+		}
+
+		if sha != g.commit {
+			g.insertIntoNewColumns(sha, -1)
+			continue
+		}
+		seen = true
+		g.commitIndex = i
+		g.mergeLayout = -1
+		for _, p := range g.commitParents {
+			g.insertIntoNewColumns(p, i)
+		}
+		// The commit takes two screen columns even without parents.
+		if len(g.commitParents) == 0 {
+			g.width += 2
+		}
+	}
+
+	for g.mappingSize > 1 && g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize--
+	}
+}
+
+func (g *logGraph) numDashedParents() int {
+	return len(g.commitParents) + g.mergeLayout - 3
+}
+
+// needsPreCommitLine reports whether the lines right of an octopus merge
+// must move away to make room for its edges.
+func (g *logGraph) needsPreCommitLine() bool {
+	return len(g.commitParents) >= 3 &&
+		g.commitIndex < len(g.columns)-1 &&
+		g.expansionRow < g.numDashedParents()*2
+}
+
+// isMappingCorrect reports whether every line is in its column, or just
+// one to the right of it where a "/" gets it there.
+func (g *logGraph) isMappingCorrect() bool {
+	for i := 0; i < g.mappingSize; i++ {
+		if target := g.mapping[i]; target >= 0 && target != i/2 {
+			return false
+		}
+	}
+	return true
+}
+
+// finished reports whether the graph lines of the current commit have all
+// been output.
+func (g *logGraph) finished() bool {
+	return g.state == linePadding
+}
+
+func (g *logGraph) pad(sb *strings.Builder) string {
+	if sb.Len() < g.width {
+		sb.WriteString(strings.Repeat(" ", g.width-sb.Len()))
+	}
+	return sb.String()
+}
+
+// nextLine returns the next line of the graph, and whether it is the one
+// with the commit. The methods of a nil logGraph output nothing, for logs
+// without a graph.
+func (g *logGraph) nextLine() (string, bool) {
+	if g == nil {
+		return "", false
+	}
+	var sb strings.Builder
+	commitLine := false
+	switch g.state {
+	case linePadding:
+		for range g.newColumns {
+			sb.WriteString("| ")
+		}
+	case lineSkip:
+		sb.WriteString("...")
+		if g.needsPreCommitLine() {
+			g.setState(linePreCommit)
+		} else {
+			g.setState(lineCommit)
+		}
+	case linePreCommit:
+		g.preCommitLine(&sb)
+	case lineCommit:
+		g.commitLine(&sb)
+		commitLine = true
+	case linePostMerge:
+		g.postMergeLine(&sb)
+	case lineCollapsing:
+		g.collapsingLine(&sb)
+	}
+	return g.pad(&sb), commitLine
+}
+
+func (g *logGraph) preCommitLine(sb *strings.Builder) {
+	seen := false
+	for i, c := range g.columns {
+		switch {
+		case c == g.commit:
+			seen = true
+			sb.WriteString("|" + strings.Repeat(" ", g.expansionRow))
+		case seen && g.expansionRow == 0:
+			// Lines drawn as "\" after a merge keep going that way.
+			if g.prevState == linePostMerge && g.prevCommitIndex < i {
+				sb.WriteString("\\")
+			} else {
+				sb.WriteString("|")
+			}
+		case seen:
+			sb.WriteString("\\")
+		default:
+			sb.WriteString("|")
+		}
+		sb.WriteString(" ")
+	}
+
+	g.expansionRow++
+	if !g.needsPreCommitLine() {
+		g.setState(lineCommit)
+	}
+}
+
+func (g *logGraph) commitLine(sb *strings.Builder) {
+	seen := false
+	for i := 0; i <= len(g.columns); i++ {
+		var sha string
+		if i == len(g.columns) {
+			if seen {
+				break
+			}
+			sha = g.commit
+		} else {
+			sha = g.columns[i]
+		}
+
+		switch {
+		case sha == g.commit:
+			seen = true
+			sb.WriteString("*")
+			// The edges to the parents of an octopus merge.
+			if dashed := g.numDashedParents(); len(g.commitParents) > 2 && dashed > 0 {
+				sb.WriteString(strings.Repeat("--", dashed-1) + "-.")
+			}
+		case seen && g.edgesAdded > 1:
+			sb.WriteString("\\")
+		case seen && g.edgesAdded == 1:
+			if g.prevState == linePostMerge && g.prevEdgesAdded > 0 && g.prevCommitIndex < i {
+				sb.WriteString("\\")
+			} else {
+				sb.WriteString("|")
+			}
+		case g.prevState == lineCollapsing && g.oldMapping[2*i+1] == i && g.mapping[2*i] < i:
+			sb.WriteString("/")
+		default:
+			sb.WriteString("|")
+		}
+		sb.WriteString(" ")
+	}
+
+	switch {
+	case len(g.commitParents) > 1:
+		g.setState(linePostMerge)
+	case g.isMappingCorrect():
+		g.setState(linePadding)
+	default:
+		g.setState(lineCollapsing)
+	}
+}
+
+func (g *logGraph) postMergeLine(sb *strings.Builder) {
+	mergeChars := []string{"/", "|", "\\"}
+	firstParent := g.commitParents[0]
+	seenParent := false
+	seen := false
+	for i := 0; i <= len(g.columns); i++ {
+		var sha string
+		if i == len(g.columns) {
+			if seen {
+				break
+			}
+			sha = g.commit
+		} else {
+			sha = g.columns[i]
+		}
+
+		switch {
+		case sha == g.commit:
+			seen = true
+			idx := g.mergeLayout
+			for j := range g.commitParents {
+				sb.WriteString(mergeChars[idx])
+				if idx == 2 {
+					if g.edgesAdded > 0 || j < len(g.commitParents)-1 {
+						sb.WriteString(" ")
+					}
+				} else {
+					idx++
+				}
+			}
+			if g.edgesAdded == 0 {
+				sb.WriteString(" ")
+			}
+		case seen:
+			if g.edgesAdded > 0 {
+				sb.WriteString("\\ ")
+			} else {
+				sb.WriteString("| ")
+			}
+		default:
+			sb.WriteString("|")
+			if g.mergeLayout != 0 || i != g.commitIndex-1 {
+				if seenParent {
+					sb.WriteString("_")
+				} else {
+					sb.WriteString(" ")
+				}
+			}
+		}
+		if sha == firstParent {
+			seenParent = true
+		}
+	}
+
+	if g.isMappingCorrect() {
+		g.setState(linePadding)
+	} else {
+		g.setState(lineCollapsing)
+	}
+}
+
+// collapsingLine moves lines one column to the left towards their
+// targets. Only one line may move horizontally per row, drawn with "_".
+func (g *logGraph) collapsingLine(sb *strings.Builder) {
+	usedHorizontal := false
+	horizontalEdge, horizontalEdgeTarget := -1, -1
+
+	g.mapping, g.oldMapping = g.oldMapping, g.mapping
+	for i := 0; i < g.mappingSize; i++ {
+		g.mapping[i] = -1
+	}
+
+	for i := 0; i < g.mappingSize; i++ {
+		target := g.oldMapping[i]
+		switch {
+		case target < 0:
+		case target*2 == i:
+			g.mapping[i] = target
+		case g.mapping[i-1] < 0:
+			// Nothing to the left: move left by one.
+			g.mapping[i-1] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		case g.mapping[i-1] == target:
+			// Joins the line to the left, which has the same target.
+		default:
+			// Crosses over the line to the left.
+			g.mapping[i-2] = target
+			if horizontalEdge == -1 {
+				horizontalEdge, horizontalEdgeTarget = i-1, target
+				for j := target*2 + 3; j < i-2; j += 2 {
+					g.mapping[j] = target
+				}
+			}
+		}
+	}
+
+	copy(g.oldMapping, g.mapping[:g.mappingSize])
+	if g.mapping[g.mappingSize-1] < 0 {
+		g.mappingSize--
+	}
+
+	for i := 0; i < g.mappingSize; i++ {
+		target := g.mapping[i]
+		switch {
+		case target < 0:
+			sb.WriteString(" ")
+		case target*2 == i:
+			sb.WriteString("|")
+		case target == horizontalEdgeTarget && i != horizontalEdge-1:
+			// Only the first segment of the horizontal line continues
+			// on the next row.
+			if i != target*2+3 {
+				g.mapping[i] = -1
+			}
+			usedHorizontal = true
+			sb.WriteString("_")
+		default:
+			if usedHorizontal && i < horizontalEdge {
+				g.mapping[i] = -1
+			}
+			sb.WriteString("/")
+		}
+	}
+
+	if g.isMappingCorrect() {
+		g.setState(linePadding)
+	}
+}
+
+// paddingLine returns the line separating two commits, which continues
+// every branch line.
+func (g *logGraph) paddingLine() string {
+	if g == nil {
+		return ""
+	}
+	if g.state != lineCommit {
+		line, _ := g.nextLine()
+		return line
+	}
+	var sb strings.Builder
+	for _, c := range g.columns {
+		sb.WriteString("|")
+		if c == g.commit && len(g.commitParents) > 2 {
+			sb.WriteString(strings.Repeat(" ", (len(g.commitParents)-2)*2))
+		} else {
+			sb.WriteString(" ")
+		}
+	}
+	g.prevState = linePadding
+	return g.pad(&sb)
+}
+
+// showCommit writes the graph lines up to the commit line included,
+// without a newline after it.
+func (g *logGraph) showCommit(sb *strings.Builder) {
+	if g == nil {
+		return
+	}
+	if g.finished() {
+		sb.WriteString(g.paddingLine())
+		return
+	}
+	for !g.finished() {
+		line, commitLine := g.nextLine()
+		sb.WriteString(line)
+		if commitLine {
+			return
+		}
+		sb.WriteString("\n")
+	}
+}
+
+// showLines writes text with a graph line before each of its lines.
+func (g *logGraph) showLines(sb *strings.Builder, text string) {
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line != "" {
+			prefix, _ := g.nextLine()
+			sb.WriteString(prefix + line)
+		}
+	}
+}
+
+// showRemainder writes the lines left for the current commit, without a
+// newline after the last one.
+func (g *logGraph) showRemainder(sb *strings.Builder) {
+	for !g.finished() {
+		line, _ := g.nextLine()
+		sb.WriteString(line)
+		if !g.finished() {
+			sb.WriteString("\n")
+		}
+	}
+}
+
+// showText writes text with a graph line before each of its lines but the
+// first, and then the rest of the graph of the commit.
+func (g *logGraph) showText(sb *strings.Builder, text string) {
+	if g == nil {
+		sb.WriteString(text)
+		return
+	}
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if i > 0 && line != "" {
+			prefix, _ := g.nextLine()
+			sb.WriteString(prefix)
+		}
+		sb.WriteString(line)
+	}
+	if !g.finished() {
+		terminated := strings.HasSuffix(text, "\n")
+		if !terminated {
+			sb.WriteString("\n")
+		}
+		g.showRemainder(sb)
+		if terminated {
+			sb.WriteString("\n")
+		}
+	}
+}
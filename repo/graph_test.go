@@ -0,0 +1,78 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogGraph(t *testing.T) {
+	cases := []struct {
+		order   []string
+		parents map[string][]string
+		want    string
+	}{
+		{
+			[]string{"7", "6", "4", "3", "5", "2", "1"},
+			map[string][]string{
+				"7": {"6"}, "6": {"5", "4"}, "4": {"3"}, "3": {"2"},
+				"5": {"2"}, "2": {"1"},
+			},
+			"* 7\n" +
+				"*   6\n" +
+				"|\\  \n" +
+				"| * 4\n" +
+				"| * 3\n" +
+				"* | 5\n" +
+				"|/  \n" +
+				"* 2\n" +
+				"* 1\n",
+		},
+		{
+			[]string{"m", "x", "o", "f2", "f", "d2", "d", "c2", "c", "b2", "b", "a", "r"},
+			map[string][]string{
+				"m": {"o", "x"}, "x": {"r"}, "o": {"a", "b2", "c2", "d2", "f2"},
+				"f2": {"f"}, "d2": {"d"}, "c2": {"c"}, "b2": {"b"},
+				"f": {"r"}, "d": {"r"}, "c": {"r"}, "b": {"r"}, "a": {"r"},
+			},
+			"*   m\n" +
+				"|\\  \n" +
+				"| * x\n" +
+				"| |         \n" +
+				"|  \\        \n" +
+				"|   \\       \n" +
+				"|    \\      \n" +
+				"|     \\     \n" +
+				"|      \\    \n" +
+				"*-----. \\   o\n" +
+				"|\\ \\ \\ \\ \\  \n" +
+				"| | | | * | f2\n" +
+				"| | | | * | f\n" +
+				"| | | | |/  \n" +
+				"| | | * | d2\n" +
+				"| | | * | d\n" +
+				"| | | |/  \n" +
+				"| | * | c2\n" +
+				"| | * | c\n" +
+				"| | |/  \n" +
+				"| * | b2\n" +
+				"| * | b\n" +
+				"| |/  \n" +
+				"* / a\n" +
+				"|/  \n" +
+				"* r\n",
+		},
+	}
+	for _, c := range cases {
+		graph := newLogGraph(func(sha string) []string { return c.parents[sha] })
+		sb := &strings.Builder{}
+		for _, sha := range c.order {
+			graph.update(sha)
+			graph.showCommit(sb)
+			graph.showText(sb, sha)
+			sb.WriteString("\n")
+		}
+		if sb.String() != c.want {
+			t.Errorf("got\n%v\nwant\n%v", sb.String(), c.want)
+		}
+	}
+}
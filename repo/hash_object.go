@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// repo finds the repository objects are hashed for. Hashing alone works
+// outside of a repository, without filters and with the default hash
+// algorithm.
+func (opts HashOptions) repo() *Repository {
+	if opts.Write {
+		return findRepo(".")
+	}
+	return tryFindRepo(".")
+}
+
+// hashFile names the object whose content is the file at filePath.
+func (r *Repository) hashFile(filePath string, opts HashOptions) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if st.IsDir() {
+		return "", fmt.Errorf("%v is a directory", filePath)
+	}
+
+	filterPath := opts.Path
+	if filterPath == "" {
+		filterPath = filePath
+	}
+	return r.hashObject(f, st.Size(), filterPath, opts)
+}
+
+// hashReader names the object whose content is read from in. Its clean
+// filter is only applied when a path is given for it.
+func (r *Repository) hashReader(in io.Reader, opts HashOptions) (string, error) {
+	return r.hashObject(in, -1, opts.Path, opts)
+}
+
+// hashObject names an object whose content is read from src, and stores
+// it when asked to. Blobs are streamed when their size is known and no
+// filter applies; other objects are read in full to be validated.
+func (r *Repository) hashObject(src io.Reader, size int64, filterPath string, opts HashOptions) (string, error) {
+	if !opts.Literally {
+		switch opts.Type {
+		case TypeBlob, TypeTree, TypeCommit, TypeTag:
+		default:
+			return "", fmt.Errorf("Unknown type: %v", opts.Type)
+		}
+	}
+
+	var filter *cleanFilter
+	if opts.Type == TypeBlob && !opts.NoFilters && filterPath != "" && r != nil {
+		f, err := r.filterFor(filterPath)
+		if err != nil {
+			return "", err
+		}
+		if f != nil && f.active() {
+			filter = f
+		}
+	}
+
+	if size < 0 || filter != nil || (opts.Type != TypeBlob && !opts.Literally) {
+		data, err := ioutil.ReadAll(src)
+		if err != nil {
+			return "", err
+		}
+		if filter != nil {
+			if data, err = filter.apply(r.workTree, data); err != nil {
+				return "", err
+			}
+		}
+		if !opts.Literally {
+			if err := r.validateObject(opts.Type, data); err != nil {
+				return "", err
+			}
+		}
+		src, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	if opts.Write {
+		return r.writeObjectStream(opts.Type, size, src)
+	}
+	return hashObjectStream(r.objectFormat(), opts.Type, size, src, nil)
+}
+
+// filterFor returns the clean filter of a path given on the command line,
+// or nil when it is outside of the work tree.
+func (r *Repository) filterFor(filePath string) (*cleanFilter, error) {
+	rel, err := r.relativePath(filePath)
+	if err != nil {
+		return nil, nil
+	}
+	return r.cleanFilterFor(rel)
+}
@@ -0,0 +1,229 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RoleAuthor    = "AUTHOR"
+	RoleCommitter = "COMMITTER"
+)
+
+// ident builds the "Name <email> timestamp timezone" line that identifies
+// the author or committer of an object. GIT_<role>_NAME, GIT_<role>_EMAIL
+// and GIT_<role>_DATE take precedence over user.name and user.email.
+func (r *Repository) ident(role string) (string, error) {
+	name := os.Getenv("GIT_" + role + "_NAME")
+	if name == "" {
+		name, _ = r.configString("user.name")
+	}
+	email := os.Getenv("GIT_" + role + "_EMAIL")
+	if email == "" {
+		email, _ = r.configString("user.email")
+	}
+	if name == "" || email == "" {
+		return "", errors.New("Please tell me who you are: set user.name and user.email")
+	}
+	if strings.ContainsAny(name+email, "<>\n") {
+		return "", fmt.Errorf("Invalid identity %v <%v>", name, email)
+	}
+
+	when := time.Now()
+	if strDate := os.Getenv("GIT_" + role + "_DATE"); strDate != "" {
+		var err error
+		when, err = parseIdentDate(strDate)
+		if err != nil {
+			return "", err
+		}
+	}
+	return Signature{Name: name, Email: email, When: when}.String(), nil
+}
+
+// Signature is the identity of the author, committer or tagger of an
+// object, together with the time it was made. When keeps the original
+// timezone offset.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+func (s Signature) String() string {
+	return fmt.Sprintf("%v <%v> %v", s.Name, s.Email, formatIdentDate(s.When))
+}
+
+// parseSignature reads a "Name <email> timestamp timezone" line. Like git,
+// it is lenient about the name and reports an error only when the email
+// or the date cannot be found.
+func parseSignature(line string) (Signature, error) {
+	lt := strings.Index(line, "<")
+	gt := strings.LastIndex(line, ">")
+	if lt < 0 || gt < lt {
+		return Signature{}, fmt.Errorf("Invalid identity %v", line)
+	}
+	sig := Signature{
+		Name:  strings.TrimSpace(line[:lt]),
+		Email: line[lt+1 : gt],
+	}
+	strDate := strings.TrimSpace(line[gt+1:])
+	if strDate == "" {
+		return sig, nil
+	}
+	fields := strings.Fields(strDate)
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Signature{}, fmt.Errorf("Invalid date in identity %v", line)
+	}
+	offset := 0
+	if len(fields) > 1 {
+		tz := fields[1]
+		if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+			return Signature{}, fmt.Errorf("Invalid timezone in identity %v", line)
+		}
+		hhmm, err := strconv.Atoi(tz[1:])
+		if err != nil {
+			return Signature{}, fmt.Errorf("Invalid timezone in identity %v", line)
+		}
+		offset = (hhmm/100*60 + hhmm%100) * 60
+		if tz[0] == '-' {
+			offset = -offset
+		}
+	}
+	sig.When = time.Unix(ts, 0).In(time.FixedZone("", offset))
+	return sig, nil
+}
+
+// formatIdentDate renders a time the way git stores it in objects.
+func formatIdentDate(when time.Time) string {
+	return fmt.Sprintf("%v %v", when.Unix(), when.Format("-0700"))
+}
+
+// parseIdentDate accepts git's internal "<unix> <tz>" format, optionally
+// prefixed by "@", as well as RFC 2822 and ISO 8601 dates.
+func parseIdentDate(strDate string) (time.Time, error) {
+	fields := strings.Fields(strings.TrimPrefix(strDate, "@"))
+	if len(fields) >= 1 && len(fields) <= 2 {
+		if ts, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			loc := time.UTC
+			if len(fields) == 2 {
+				tz, err := time.Parse("-0700", fields[1])
+				if err != nil {
+					return time.Time{}, fmt.Errorf("Invalid date format: %v", strDate)
+				}
+				loc = tz.Location()
+			}
+			return time.Unix(ts, 0).In(loc), nil
+		}
+	}
+	for _, layout := range []string{time.RFC1123Z, time.RFC3339, "2006-01-02 15:04:05 -0700", "2006-01-02T15:04:05"} {
+		if when, err := time.Parse(layout, strDate); err == nil {
+			return when, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid date format: %v", strDate)
+}
+
+var relativeDateRegex = regexp.MustCompile(`^(\d+)\s+(second|minute|hour|day|week|month|year)s?\s+ago$`)
+
+// ParseDate parses a date given on the command line, such as the argument
+// of --since. On top of the formats of parseIdentDate, it understands
+// relative dates like "2 weeks ago".
+func ParseDate(strDate string) (time.Time, error) {
+	m := relativeDateRegex.FindStringSubmatch(strings.TrimSpace(strDate))
+	if m == nil {
+		return parseIdentDate(strDate)
+	}
+	n, _ := strconv.Atoi(m[1])
+	now := time.Now()
+	switch m[2] {
+	case "second":
+		return now.Add(-time.Duration(n) * time.Second), nil
+	case "minute":
+		return now.Add(-time.Duration(n) * time.Minute), nil
+	case "hour":
+		return now.Add(-time.Duration(n) * time.Hour), nil
+	case "day":
+		return now.AddDate(0, 0, -n), nil
+	case "week":
+		return now.AddDate(0, 0, -7*n), nil
+	case "month":
+		return now.AddDate(0, -n, 0), nil
+	}
+	return now.AddDate(-n, 0, 0), nil
+}
+
+// editor picks the editor the way git does: GIT_EDITOR, core.editor,
+// VISUAL, EDITOR and finally vi.
+func (r *Repository) editor() string {
+	if e := os.Getenv("GIT_EDITOR"); e != "" {
+		return e
+	}
+	if e, ok := r.configString("core.editor"); ok && e != "" {
+		return e
+	}
+	if e := os.Getenv("VISUAL"); e != "" {
+		return e
+	}
+	if e := os.Getenv("EDITOR"); e != "" {
+		return e
+	}
+	return "vi"
+}
+
+// editMessage lets the user write a message in their editor, starting
+// from template, and returns it with comments stripped.
+func (r *Repository) editMessage(fileName string, template string) (string, error) {
+	msgPath := path.Join(r.gitDir, fileName)
+	if err := ioutil.WriteFile(msgPath, []byte(template), 0666); err != nil {
+		return "", err
+	}
+
+	editor := r.editor()
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, msgPath)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("There was a problem with the editor '%v': %v", editor, err)
+	}
+
+	bs, err := ioutil.ReadFile(msgPath)
+	if err != nil {
+		return "", err
+	}
+	return cleanupMessage(string(bs), true), nil
+}
+
+// cleanupMessage strips trailing whitespace and surrounding blank lines,
+// collapses runs of blank lines and, when stripComments is set, drops
+// lines starting with '#'. A non-empty result ends with a newline.
+func cleanupMessage(msg string, stripComments bool) string {
+	lines := []string{}
+	blank := false
+	for _, line := range strings.Split(msg, "\n") {
+		if stripComments && strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,52 @@
+package repo
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIdent(t *testing.T) {
+	os.Setenv("GIT_COMMITTER_NAME", "A U Thor")
+	os.Setenv("GIT_COMMITTER_EMAIL", "author@example.com")
+	os.Setenv("GIT_COMMITTER_DATE", "1527025044 +0200")
+	defer os.Unsetenv("GIT_COMMITTER_NAME")
+	defer os.Unsetenv("GIT_COMMITTER_EMAIL")
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+
+	var repo *Repository
+	ident, err := repo.ident(RoleCommitter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "A U Thor <author@example.com> 1527025044 +0200"; ident != want {
+		t.Fatalf("got %q, want %q", ident, want)
+	}
+}
+
+func TestCleanupMessage(t *testing.T) {
+	cases := map[string]string{
+		"":                            "",
+		"\n\n# only a comment\n":      "",
+		"subject  \n\n\n\nbody\t\n\n": "subject\n\nbody\n",
+		"\n# comment\nkept\n# more\n": "kept\n",
+		"  indented stays\n":          "  indented stays\n",
+	}
+	for in, want := range cases {
+		if got := cleanupMessage(in, true); got != want {
+			t.Errorf("cleanupMessage(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCheckRefName(t *testing.T) {
+	for _, name := range []string{"refs/tags/v1.0", "refs/heads/feature/x"} {
+		if err := checkRefName(name); err != nil {
+			t.Errorf("%v: %v", name, err)
+		}
+	}
+	for _, name := range []string{"refs/tags/a..b", "refs/tags/.hidden", "refs/tags/x.lock", "refs/tags/a b", "refs/tags/a~1", "refs/tags/"} {
+		if err := checkRefName(name); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
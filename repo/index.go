@@ -0,0 +1,398 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/pencil001/pit/util"
+)
+
+// Flags of an index entry, next to the length of its path.
+const (
+	indexFlagExtended = 0x4000
+	indexFlagStage    = 0x3000
+	indexNameMask     = 0x0fff
+)
+
+// indexEntry is a file of the index: the object staged for it, and the
+// stat data of the file in the work tree when it was last known to match.
+type indexEntry struct {
+	ctimeSec, ctimeNsec uint32
+	mtimeSec, mtimeNsec uint32
+	dev, ino            uint32
+	mode                uint32
+	uid, gid            uint32
+	size                uint32
+	sha                 string
+	flags               uint16
+	extFlags            uint16
+	path                string
+}
+
+// stage is 0 for a merged entry, or else the side of a conflict.
+func (e *indexEntry) stage() int {
+	return int(e.flags&indexFlagStage) >> 12
+}
+
+// leafMode returns the mode of the entry as written in trees.
+func (e *indexEntry) leafMode() string {
+	return fmt.Sprintf("%06o", e.mode)
+}
+
+// setStat records the stat data of the file of the entry.
+func (e *indexEntry) setStat(st os.FileInfo) {
+	mtime := st.ModTime()
+	e.mtimeSec, e.mtimeNsec = uint32(mtime.Unix()), uint32(mtime.Nanosecond())
+	e.ctimeSec, e.ctimeNsec = e.mtimeSec, e.mtimeNsec
+	e.size = uint32(st.Size())
+	fillIndexStat(e, st)
+}
+
+// index is the staging area of a worktree, its "index" file. Only
+// versions 2 and 3 are read, and extensions are dropped when it is
+// written back, as they are all optional caches.
+type index struct {
+	repo    *Repository
+	entries []*indexEntry
+}
+
+func (r *Repository) indexFile() string {
+	return path.Join(r.gitDir, "index")
+}
+
+// readIndex reads the index of the worktree, which is empty when it
+// doesn't exist yet.
+func (r *Repository) readIndex() (*index, error) {
+	idx := &index{repo: r}
+	data, err := ioutil.ReadFile(r.indexFile())
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hashSize := r.objectFormat().Size
+	if len(data) < 12+hashSize {
+		return nil, fmt.Errorf("index file smaller than expected")
+	}
+	body, sum := data[:len(data)-hashSize], data[len(data)-hashSize:]
+	if r.objectFormat().Sum(body) != util.BytesToHexStr(sum) {
+		return nil, fmt.Errorf("index file corrupt: bad signature")
+	}
+	if string(body[:4]) != "DIRC" {
+		return nil, fmt.Errorf("index file corrupt: bad signature 0x%08x", binary.BigEndian.Uint32(body))
+	}
+	version := binary.BigEndian.Uint32(body[4:])
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("index file version %v is not supported", version)
+	}
+	count := binary.BigEndian.Uint32(body[8:])
+
+	pos := 12
+	for i := uint32(0); i < count; i++ {
+		e, n, err := parseIndexEntry(body[pos:], hashSize, version)
+		if err != nil {
+			return nil, err
+		}
+		idx.entries = append(idx.entries, e)
+		pos += n
+	}
+
+	// Extensions start with their signature and size. Those whose
+	// signature isn't in uppercase can't be ignored.
+	for pos+8 <= len(body) {
+		sig := body[pos : pos+4]
+		if sig[0] < 'A' || sig[0] > 'Z' {
+			return nil, fmt.Errorf("index uses %v extension, which we do not understand", string(sig))
+		}
+		pos += 8 + int(binary.BigEndian.Uint32(body[pos+4:]))
+	}
+	return idx, nil
+}
+
+// parseIndexEntry reads the entry at the start of data, and returns its
+// size with padding.
+func parseIndexEntry(data []byte, hashSize int, version uint32) (*indexEntry, int, error) {
+	fixed := 40 + hashSize + 2
+	if len(data) < fixed {
+		return nil, 0, fmt.Errorf("index file corrupt: truncated entry")
+	}
+	fields := make([]uint32, 10)
+	for i := range fields {
+		fields[i] = binary.BigEndian.Uint32(data[i*4:])
+	}
+	e := &indexEntry{
+		ctimeSec: fields[0], ctimeNsec: fields[1],
+		mtimeSec: fields[2], mtimeNsec: fields[3],
+		dev: fields[4], ino: fields[5], mode: fields[6],
+		uid: fields[7], gid: fields[8], size: fields[9],
+		sha:   util.BytesToHexStr(data[40 : 40+hashSize]),
+		flags: binary.BigEndian.Uint16(data[40+hashSize:]),
+	}
+	if e.flags&indexFlagExtended != 0 {
+		if version < 3 || len(data) < fixed+2 {
+			return nil, 0, fmt.Errorf("index file corrupt: unexpected extended flags")
+		}
+		e.extFlags = binary.BigEndian.Uint16(data[fixed:])
+		fixed += 2
+	}
+	end := bytes.IndexByte(data[fixed:], 0)
+	if end < 0 {
+		return nil, 0, fmt.Errorf("index file corrupt: unterminated path")
+	}
+	e.path = string(data[fixed : fixed+end])
+	return e, (fixed + end + 8) &^ 7, nil
+}
+
+// write stores the index, sorted, through a lock file so that it is
+// replaced at once.
+func (idx *index) write() error {
+	idx.sort()
+	version := uint32(2)
+	for _, e := range idx.entries {
+		if e.flags&indexFlagExtended != 0 {
+			version = 3
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, version)
+	binary.Write(&buf, binary.BigEndian, uint32(len(idx.entries)))
+	for _, e := range idx.entries {
+		start := buf.Len()
+		for _, v := range []uint32{e.ctimeSec, e.ctimeNsec, e.mtimeSec, e.mtimeNsec,
+			e.dev, e.ino, e.mode, e.uid, e.gid, e.size} {
+			binary.Write(&buf, binary.BigEndian, v)
+		}
+		buf.Write(util.HexStrToBytes(e.sha))
+		nameLen := len(e.path)
+		if nameLen > indexNameMask {
+			nameLen = indexNameMask
+		}
+		binary.Write(&buf, binary.BigEndian, e.flags&^indexNameMask|uint16(nameLen))
+		if e.flags&indexFlagExtended != 0 {
+			binary.Write(&buf, binary.BigEndian, e.extFlags)
+		}
+		buf.WriteString(e.path)
+		size := buf.Len() - start
+		buf.Write(make([]byte, (size+8)&^7-size))
+	}
+	h := idx.repo.objectFormat().New()
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+
+	lockPath := idx.repo.indexFile() + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return fmt.Errorf("Unable to create '%v': File exists.", lockPath)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(lockPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(lockPath)
+		return err
+	}
+	return os.Rename(lockPath, idx.repo.indexFile())
+}
+
+// sort orders the entries by path, then by stage, as git requires.
+func (idx *index) sort() {
+	sort.SliceStable(idx.entries, func(i, j int) bool {
+		a, b := idx.entries[i], idx.entries[j]
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return a.stage() < b.stage()
+	})
+}
+
+// entry returns the merged entry of a path, if there is one.
+func (idx *index) entry(filePath string) *indexEntry {
+	for _, e := range idx.entries {
+		if e.path == filePath && e.stage() == 0 {
+			return e
+		}
+	}
+	return nil
+}
+
+// treeEntries lists the files of a tree as index entries, without stat
+// data.
+func (r *Repository) treeEntries(treeSHA string, base string) ([]*indexEntry, error) {
+	tree := createTree(r, nil)
+	if err := tree.Read(treeSHA); err != nil {
+		return nil, err
+	}
+	entries := []*indexEntry{}
+	for _, leaf := range tree.leaves {
+		leafPath := path.Join(base, leaf.path)
+		if leaf.mode == ModeTree {
+			children, err := r.treeEntries(leaf.sha, leafPath)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, children...)
+			continue
+		}
+		mode, err := strconv.ParseUint(leaf.mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad mode %v of %v", leaf.mode, leafPath)
+		}
+		entries = append(entries, &indexEntry{mode: uint32(mode), sha: leaf.sha, path: leafPath})
+	}
+	return entries, nil
+}
+
+// isWorkTreeClean tells whether the worktree has nothing that its HEAD
+// doesn't: no staged change, no modified file and no untracked file.
+func (r *Repository) isWorkTreeClean() (bool, error) {
+	idx, err := r.readIndex()
+	if err != nil {
+		return false, err
+	}
+
+	head := map[string]*indexEntry{}
+	if sha, err := r.readRef("HEAD", nil); err == nil {
+		treeSHA, err := resolveRev(r, sha, TypeTree)
+		if err != nil {
+			return false, err
+		}
+		entries, err := r.treeEntries(treeSHA, "")
+		if err != nil {
+			return false, err
+		}
+		for _, e := range entries {
+			head[e.path] = e
+		}
+	}
+	if len(head) != len(idx.entries) {
+		return false, nil
+	}
+
+	tracked := map[string]bool{}
+	for _, e := range idx.entries {
+		if h, ok := head[e.path]; !ok || e.stage() != 0 || h.sha != e.sha || h.mode != e.mode {
+			return false, nil
+		}
+		tracked[e.path] = true
+		if changed, err := r.isEntryModified(e); err != nil || changed {
+			return false, err
+		}
+	}
+	return !r.hasUntracked("", tracked), nil
+}
+
+// isEntryModified tells whether the file of an entry no longer holds what
+// is staged for it. The content is only hashed when the stat data changed.
+func (r *Repository) isEntryModified(e *indexEntry) (bool, error) {
+	filePath := path.Join(r.workTree, e.path)
+	st, err := os.Lstat(filePath)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch e.leafMode() {
+	case ModeGitlink:
+		return !st.IsDir(), nil
+	case ModeSymlink:
+		if st.Mode()&os.ModeSymlink == 0 && r.configBool("core.symlinks", true) {
+			return true, nil
+		}
+	default:
+		if !st.Mode().IsRegular() {
+			return true, nil
+		}
+		if r.configBool("core.filemode", true) && (st.Mode()&0100 != 0) != (e.leafMode() == ModeExec) {
+			return true, nil
+		}
+	}
+	mtime := st.ModTime()
+	if uint32(st.Size()) == e.size && uint32(mtime.Unix()) == e.mtimeSec && uint32(mtime.Nanosecond()) == e.mtimeNsec {
+		return false, nil
+	}
+
+	var content []byte
+	if st.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return false, err
+		}
+		content = []byte(target)
+	} else if content, err = ioutil.ReadFile(filePath); err != nil {
+		return false, err
+	}
+	if f, err := r.cleanFilterFor(e.path); err == nil && f.active() && e.leafMode() != ModeSymlink {
+		if content, err = f.apply(r.workTree, content); err != nil {
+			return false, err
+		}
+	}
+	sha, err := hashObjectStream(r.objectFormat(), TypeBlob, int64(len(content)), bytes.NewReader(content), nil)
+	if err != nil {
+		return false, err
+	}
+	return sha != e.sha, nil
+}
+
+// hasUntracked tells whether the directory dir of the work tree holds
+// files that aren't tracked.
+func (r *Repository) hasUntracked(dir string, tracked map[string]bool) bool {
+	entries, err := ioutil.ReadDir(path.Join(r.workTree, dir))
+	if err != nil {
+		return false
+	}
+	for _, f := range entries {
+		filePath := path.Join(dir, f.Name())
+		if f.Name() == ".git" || tracked[filePath] {
+			continue
+		}
+		if !f.IsDir() || r.hasUntracked(filePath, tracked) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkoutIndex writes the files of a tree into the empty work tree, and
+// makes them the index.
+func (r *Repository) checkoutIndex(treeSHA string) error {
+	tree := createTree(r, nil)
+	if err := tree.Read(treeSHA); err != nil {
+		return err
+	}
+	if err := checkoutTree(tree, r.workTree); err != nil {
+		return err
+	}
+	entries, err := r.treeEntries(treeSHA, "")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.leafMode() == ModeGitlink {
+			continue
+		}
+		st, err := os.Lstat(path.Join(r.workTree, e.path))
+		if err != nil {
+			return err
+		}
+		e.setStat(st)
+	}
+	idx := &index{repo: r, entries: entries}
+	return idx.write()
+}
@@ -1,12 +1,9 @@
 package repo
 
 import (
-	"compress/zlib"
 	"fmt"
 	"log"
-	"path"
-
-	"github.com/pencil001/pit/util"
+	"strings"
 )
 
 type Object interface {
@@ -45,28 +42,11 @@ func (bo *BaseObject) Save() (string, error) {
 	if bo.repo == nil {
 		log.Panic("Repo mustn't be null")
 	}
-	content, err := bo.Encode()
-	if err != nil {
-		return "", err
-	}
-	sha := util.CalcSHA(content)
-	objDir := path.Join(bo.repo.gitDir, "objects", sha[:2])
-	if err := util.CreateDir(objDir); err != nil {
-		return "", err
-	}
-	fObj, err := util.CreateFile(path.Join(objDir, sha[2:]))
-	if err != nil {
-		return "", err
-	}
-	defer fObj.Close()
-
-	w := zlib.NewWriter(fObj)
-	defer w.Close()
-	_, err = w.Write(content)
+	str, err := bo.Serialize()
 	if err != nil {
 		return "", err
 	}
-	return sha, nil
+	return bo.repo.writeObjectStream(bo.GetFormat(), int64(len(str)), strings.NewReader(str))
 }
 
 func (bo *BaseObject) Encode() ([]byte, error) {
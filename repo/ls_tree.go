@@ -0,0 +1,281 @@
+package repo
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Formats of "ls-tree" for its plain, long, name-only and object-only
+// outputs.
+const (
+	lsTreeDefaultFormat = "%(objectmode) %(objecttype) %(objectname)\t%(path)"
+	lsTreeLongFormat    = "%(objectmode) %(objecttype) %(objectname) %(objectsize:padded)\t%(path)"
+	lsTreeNameFormat    = "%(path)"
+	lsTreeObjectFormat  = "%(objectname)"
+)
+
+// lsTreeSpec is a path given to "ls-tree", relative to the root of the
+// tree. A spec ending with a slash names the content of a directory; the
+// empty spec matches everything.
+type lsTreeSpec string
+
+// matches tells whether the entry at filePath is named by the spec.
+func (s lsTreeSpec) matches(filePath string) bool {
+	spec := string(s)
+	if spec == "" || filePath == spec || strings.HasPrefix(filePath, spec+"/") {
+		return true
+	}
+	return strings.HasSuffix(spec, "/") && strings.HasPrefix(filePath, spec)
+}
+
+// leadsTo tells whether the tree at dirPath must be opened to reach the
+// spec.
+func (s lsTreeSpec) leadsTo(dirPath string) bool {
+	return strings.HasPrefix(string(s), dirPath+"/")
+}
+
+// lsTreeSpecs turns the path arguments of "ls-tree", relative to the
+// directory prefix, into specs. Without arguments, the whole prefix
+// directory is listed.
+func lsTreeSpecs(prefix string, args []string) ([]lsTreeSpec, error) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	specs := []lsTreeSpec{}
+	for _, arg := range args {
+		p := path.Clean(path.Join(prefix, arg))
+		if p == ".." || strings.HasPrefix(p, "../") {
+			return nil, fmt.Errorf("%v is outside repository", arg)
+		}
+		base := path.Base(arg)
+		isDir := strings.HasSuffix(arg, "/") || base == "." || base == ".."
+		switch {
+		case p == ".":
+			p = ""
+		case isDir:
+			p += "/"
+		}
+		specs = append(specs, lsTreeSpec(p))
+	}
+	return specs, nil
+}
+
+// listTreeEntries walks a tree in order, keeping the entries named by
+// specs, with their paths from the root of the tree. Trees are opened
+// when recursive, or when they lead to a spec; an opened tree is itself
+// listed only with ShowTrees.
+func (r *Repository) listTreeEntries(treeSHA string, base string, specs []lsTreeSpec, opts LsTreeOptions) ([]Leaf, error) {
+	obj, err := r.readObject(treeSHA)
+	if err != nil {
+		return nil, err
+	}
+	tree, ok := obj.(*Tree)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a tree", treeSHA)
+	}
+
+	entries := []Leaf{}
+	for _, leaf := range tree.leaves {
+		entryPath := path.Join(base, leaf.path)
+		isTree := leaf.mode == ModeTree
+		matched, leading := false, false
+		for _, spec := range specs {
+			matched = matched || spec.matches(entryPath)
+			leading = leading || (isTree && spec.leadsTo(entryPath))
+		}
+		if !matched && !leading {
+			continue
+		}
+
+		recurse := isTree && (leading || opts.Recursive)
+		show := (!recurse || opts.ShowTrees) && (isTree || !opts.TreesOnly)
+		if show {
+			entries = append(entries, Leaf{leaf.mode, entryPath, leaf.sha})
+		}
+		if recurse {
+			children, err := r.listTreeEntries(leaf.sha, entryPath, specs, opts)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, children...)
+		}
+	}
+	return entries, nil
+}
+
+// lsTreeFormatter expands the placeholders of a "ls-tree --format":
+// %(objectmode), %(objecttype), %(objectname), %(objectsize),
+// %(objectsize:padded) and %(path), as well as %%, %n and %xNN.
+type lsTreeFormatter struct {
+	repo   *Repository
+	parts  []string
+	prefix string
+	opts   LsTreeOptions
+}
+
+func (r *Repository) newLsTreeFormatter(format string, prefix string, opts LsTreeOptions) (*lsTreeFormatter, error) {
+	f := &lsTreeFormatter{repo: r, prefix: prefix, opts: opts}
+	literal := strings.Builder{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			literal.WriteByte(format[i])
+			continue
+		}
+		rest := format[i+1:]
+		switch {
+		case strings.HasPrefix(rest, "%"):
+			literal.WriteByte('%')
+			i++
+		case strings.HasPrefix(rest, "n"):
+			literal.WriteByte('\n')
+			i++
+		case strings.HasPrefix(rest, "x") && len(rest) >= 3 && isHex(rest[1:3]):
+			b, _ := strconv.ParseUint(rest[1:3], 16, 8)
+			literal.WriteByte(byte(b))
+			i += 3
+		case strings.HasPrefix(rest, "("):
+			end := strings.IndexByte(rest, ')')
+			if end < 0 {
+				return nil, fmt.Errorf("bad ls-tree format: %v", format[i:])
+			}
+			name := rest[1:end]
+			switch name {
+			case "objectmode", "objecttype", "objectname", "objectsize", "objectsize:padded", "path":
+			default:
+				return nil, fmt.Errorf("bad ls-tree format: %%(%v)", name)
+			}
+			f.parts = append(f.parts, literal.String(), name)
+			literal.Reset()
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("bad ls-tree format: element '%v' does not start with '('", format[i:])
+		}
+	}
+	f.parts = append(f.parts, literal.String())
+	return f, nil
+}
+
+// format renders an entry. Literal text and placeholders alternate in
+// parts.
+func (f *lsTreeFormatter) format(sb *strings.Builder, e Leaf) error {
+	for i, part := range f.parts {
+		if i%2 == 0 {
+			sb.WriteString(part)
+			continue
+		}
+		switch part {
+		case "objectmode":
+			sb.WriteString(e.mode)
+		case "objecttype":
+			sb.WriteString(leafType(e.mode))
+		case "objectname":
+			sha := e.sha
+			if f.opts.Abbrev > 0 && f.opts.Abbrev < len(sha) {
+				sha = sha[:f.opts.Abbrev]
+			}
+			sb.WriteString(sha)
+		case "objectsize", "objectsize:padded":
+			size := "-"
+			if leafType(e.mode) == TypeBlob {
+				_, n, err := f.repo.objectHeader(e.sha)
+				if err != nil {
+					return err
+				}
+				size = strconv.FormatInt(n, 10)
+			}
+			if part == "objectsize:padded" {
+				size = fmt.Sprintf("%7s", size)
+			}
+			sb.WriteString(size)
+		case "path":
+			name := e.path
+			if f.prefix != "" {
+				rel, err := filepath.Rel(f.prefix, name)
+				if err != nil {
+					return err
+				}
+				name = filepath.ToSlash(rel)
+				// The current directory and its parents are shown as
+				// directories.
+				if isAncestorPath(name) {
+					name += "/"
+				}
+			}
+			if !f.opts.NullTerminate {
+				name = quotePath(name)
+			}
+			sb.WriteString(name)
+		}
+	}
+	if f.opts.NullTerminate {
+		sb.WriteByte(0)
+	} else {
+		sb.WriteByte('\n')
+	}
+	return nil
+}
+
+// isAncestorPath tells whether a relative path is "." or made of ".."
+// only.
+func isAncestorPath(rel string) bool {
+	if rel == "." {
+		return true
+	}
+	for _, c := range strings.Split(rel, "/") {
+		if c != ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// quotePath quotes a path the way git does in its output when it holds
+// special characters: in double quotes, with C escapes, and non-ASCII
+// bytes in octal.
+func quotePath(name string) string {
+	needsQuote := false
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			needsQuote = true
+			break
+		}
+	}
+	if !needsQuote {
+		return name
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '\a':
+			sb.WriteString(`\a`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\v':
+			sb.WriteString(`\v`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				sb.WriteString(fmt.Sprintf("\\%03o", c))
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,166 @@
+package repo
+
+import (
+	"container/heap"
+	"sort"
+)
+
+const (
+	paintLeft = 1 << iota
+	paintRight
+	paintStale
+	paintResult
+)
+
+// graphQueue is a priority queue of commits by generation number, then
+// by commit date. Commits missing from the commit-graph have an infinite
+// generation number and come first.
+type graphQueue []*RevCommit
+
+func (q graphQueue) Len() int { return len(q) }
+
+func (q graphQueue) Less(i, j int) bool {
+	if q[i].generation != q[j].generation {
+		return q[i].generation > q[j].generation
+	}
+	return q[i].commitTime > q[j].commitTime
+}
+
+func (q graphQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *graphQueue) Push(x interface{}) { *q = append(*q, x.(*RevCommit)) }
+
+func (q *graphQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// revCommitCache returns a lookupRevCommit that remembers its results.
+func revCommitCache(repo *Repository) func(string) (*RevCommit, error) {
+	commits := map[string]*RevCommit{}
+	return func(sha string) (*RevCommit, error) {
+		if c, ok := commits[sha]; ok {
+			return c, nil
+		}
+		c, err := lookupRevCommit(repo, sha)
+		if err != nil {
+			return nil, err
+		}
+		commits[sha] = c
+		return c, nil
+	}
+}
+
+// mergeBases returns the best common ancestors of a and b: the common
+// ancestors that aren't ancestors of another common ancestor.
+func (r *Repository) mergeBases(a, b string) ([]string, error) {
+	if a == b {
+		return []string{a}, nil
+	}
+	lookup := revCommitCache(r)
+
+	// Paint the ancestors of each side until the queue only holds
+	// commits below a common ancestor.
+	flags := map[string]int{a: paintLeft, b: paintRight}
+	queue := &graphQueue{}
+	for _, sha := range []string{a, b} {
+		c, err := lookup(sha)
+		if err != nil {
+			return nil, err
+		}
+		heap.Push(queue, c)
+	}
+	candidates := []*RevCommit{}
+	for queue.Len() > 0 {
+		stale := true
+		for _, c := range *queue {
+			if flags[c.SHA]&paintStale == 0 {
+				stale = false
+				break
+			}
+		}
+		if stale {
+			break
+		}
+
+		c := heap.Pop(queue).(*RevCommit)
+		f := flags[c.SHA] & (paintLeft | paintRight | paintStale)
+		if f == paintLeft|paintRight {
+			if flags[c.SHA]&paintResult == 0 {
+				flags[c.SHA] |= paintResult
+				candidates = append(candidates, c)
+			}
+			f |= paintStale
+		}
+		for _, p := range c.Parents {
+			if flags[p]&f == f {
+				continue
+			}
+			flags[p] |= f
+			pc, err := lookup(p)
+			if err != nil {
+				return nil, err
+			}
+			heap.Push(queue, pc)
+		}
+	}
+
+	bases := []*RevCommit{}
+	for _, c := range candidates {
+		redundant := false
+		for _, other := range candidates {
+			if other == c {
+				continue
+			}
+			reachable, err := isAncestor(c, other, lookup)
+			if err != nil {
+				return nil, err
+			}
+			if reachable {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			bases = append(bases, c)
+		}
+	}
+	sort.SliceStable(bases, func(i, j int) bool { return bases[i].commitTime > bases[j].commitTime })
+
+	shas := make([]string, len(bases))
+	for i, c := range bases {
+		shas[i] = c.SHA
+	}
+	return shas, nil
+}
+
+// isAncestor reports whether a can be reached from b. Generation numbers
+// cut the search short: a commit can't reach commits of a higher or equal
+// generation other than itself.
+func isAncestor(a, b *RevCommit, lookup func(string) (*RevCommit, error)) (bool, error) {
+	seen := map[string]bool{}
+	stack := []*RevCommit{b}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if c.SHA == a.SHA {
+			return true, nil
+		}
+		if seen[c.SHA] {
+			continue
+		}
+		seen[c.SHA] = true
+		if a.generation != graphNoGen && c.generation != graphNoGen && c.generation <= a.generation {
+			continue
+		}
+		for _, p := range c.Parents {
+			pc, err := lookup(p)
+			if err != nil {
+				return false, err
+			}
+			stack = append(stack, pc)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,48 @@
+package repo
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"github.com/pencil001/pit/util"
+)
+
+// ObjectFormat describes the hash function used to name objects, as set by
+// extensions.objectFormat.
+type ObjectFormat struct {
+	Name    string
+	Size    int
+	newHash func() hash.Hash
+}
+
+var (
+	FormatSHA1   = &ObjectFormat{Name: "sha1", Size: sha1.Size, newHash: sha1.New}
+	FormatSHA256 = &ObjectFormat{Name: "sha256", Size: sha256.Size, newHash: sha256.New}
+)
+
+func lookupObjectFormat(name string) (*ObjectFormat, error) {
+	switch name {
+	case "", FormatSHA1.Name:
+		return FormatSHA1, nil
+	case FormatSHA256.Name:
+		return FormatSHA256, nil
+	}
+	return nil, fmt.Errorf("Unknown object format %v", name)
+}
+
+// HexSize is the length of an object name in hexadecimal.
+func (f *ObjectFormat) HexSize() int {
+	return f.Size * 2
+}
+
+func (f *ObjectFormat) New() hash.Hash {
+	return f.newHash()
+}
+
+func (f *ObjectFormat) Sum(content []byte) string {
+	h := f.New()
+	h.Write(content)
+	return util.BytesToHexStr(h.Sum(nil))
+}
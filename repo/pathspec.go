@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"regexp"
+	"strings"
+)
+
+// pathspec limits a command to some paths of the tree. A literal pathspec
+// matches a path and everything under it; one with glob characters is
+// matched like fnmatch without FNM_PATHNAME, so "*" crosses slashes.
+type pathspec struct {
+	literal string
+	glob    *regexp.Regexp
+}
+
+func parsePathspecs(specs []string) ([]pathspec, error) {
+	out := []pathspec{}
+	for _, spec := range specs {
+		spec = strings.TrimPrefix(spec, "./")
+		if !strings.ContainsAny(spec, "*?[") {
+			out = append(out, pathspec{literal: strings.TrimSuffix(spec, "/")})
+			continue
+		}
+		var sb strings.Builder
+		sb.WriteString("^")
+		for i := 0; i < len(spec); i++ {
+			switch c := spec[i]; c {
+			case '*':
+				sb.WriteString(".*")
+			case '?':
+				sb.WriteString(".")
+			case '[':
+				end := strings.IndexByte(spec[i:], ']')
+				if end < 0 {
+					sb.WriteString(regexp.QuoteMeta("["))
+					continue
+				}
+				class := spec[i+1 : i+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				sb.WriteString("[" + class + "]")
+				i += end
+			default:
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		sb.WriteString("(/.*)?$")
+		re, err := regexp.Compile(sb.String())
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pathspec{glob: re})
+	}
+	return out, nil
+}
+
+func (p pathspec) matches(path string) bool {
+	if p.glob != nil {
+		return p.glob.MatchString(path)
+	}
+	return p.literal == "" || p.literal == "." || path == p.literal || strings.HasPrefix(path, p.literal+"/")
+}
+
+func matchPathspecs(specs []pathspec, path string) bool {
+	if len(specs) == 0 {
+		return true
+	}
+	for _, p := range specs {
+		if p.matches(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathsChanged reports whether any path matching specs differs between
+// two trees. Literal pathspecs only look up their own entry.
+func (r *Repository) pathsChanged(oldTree, newTree string, specs []pathspec) (bool, error) {
+	globs := false
+	for _, p := range specs {
+		if p.glob != nil || p.literal == "" || p.literal == "." {
+			globs = true
+			continue
+		}
+		oldLeaf, oldOK, err := r.lookupTreePath(oldTree, p.literal)
+		if err != nil {
+			return false, err
+		}
+		newLeaf, newOK, err := r.lookupTreePath(newTree, p.literal)
+		if err != nil {
+			return false, err
+		}
+		if oldOK != newOK || oldLeaf.sha != newLeaf.sha || oldLeaf.mode != newLeaf.mode {
+			return true, nil
+		}
+	}
+	if !globs {
+		return false, nil
+	}
+	changes, err := r.diffTrees(oldTree, newTree)
+	if err != nil {
+		return false, err
+	}
+	for _, ch := range changes {
+		for _, p := range specs {
+			if (p.glob != nil || p.literal == "" || p.literal == ".") && p.matches(ch.path) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// lookupTreePath is lookupPath where an empty treeSHA is the empty tree.
+func (r *Repository) lookupTreePath(treeSHA string, filePath string) (Leaf, bool, error) {
+	if treeSHA == "" {
+		return Leaf{}, false, nil
+	}
+	return r.lookupPath(treeSHA, filePath)
+}
@@ -0,0 +1,734 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pencil001/pit/util"
+)
+
+// refRuleFormats are the rules git tries, in order, to expand a short ref
+// name; shortening a ref reverses them.
+var refRuleFormats = []string{
+	"%v",
+	"refs/%v",
+	"refs/tags/%v",
+	"refs/heads/%v",
+	"refs/remotes/%v",
+	"refs/remotes/%v/HEAD",
+}
+
+// shortenRefName returns the shortest name which expands to refName and
+// not to another ref under any rule, like "%(refname:short)". The rule
+// for "refs/remotes/<remote>/HEAD" is never used for shortening.
+func shortenRefName(refName string, refs map[string]string) string {
+	rules := refRuleFormats[:len(refRuleFormats)-1]
+	for i := len(rules) - 1; i > 0; i-- {
+		short, ok := matchRefRule(rules[i], refName)
+		if !ok {
+			continue
+		}
+		ambiguous := false
+		for j, rule := range refRuleFormats {
+			if _, ok := refs[fmt.Sprintf(rule, short)]; ok && j != i {
+				ambiguous = true
+				break
+			}
+		}
+		if !ambiguous {
+			return short
+		}
+	}
+	return refName
+}
+
+// matchRefRule extracts the short name a rule expands into refName.
+func matchRefRule(rule string, refName string) (string, bool) {
+	i := strings.Index(rule, "%v")
+	prefix, suffix := rule[:i], rule[i+2:]
+	if !strings.HasPrefix(refName, prefix) || !strings.HasSuffix(refName, suffix) || len(refName) <= len(prefix)+len(suffix) {
+		return "", false
+	}
+	return refName[len(prefix) : len(refName)-len(suffix)], true
+}
+
+// showRefMatches tells whether a "show-ref" pattern names refName: it
+// must match the end of the name, on whole components.
+func showRefMatches(pattern string, refName string) bool {
+	return refName == pattern || strings.HasSuffix(refName, "/"+pattern)
+}
+
+// forEachRefMatches tells whether a "for-each-ref" pattern names refName:
+// either as a leading part of the name, on whole components, or as a
+// glob.
+func forEachRefMatches(pattern string, refName string) bool {
+	if refName == pattern || util.MatchGlob(pattern, refName, false) {
+		return true
+	}
+	if !strings.HasPrefix(refName, pattern) {
+		return false
+	}
+	return strings.HasSuffix(pattern, "/") || refName[len(pattern)] == '/'
+}
+
+// refAtom is a "%(name:modifier)" placeholder of a ref format. A leading
+// "*" applies it to the object a tag points to.
+type refAtom struct {
+	deref    bool
+	name     string
+	modifier string
+}
+
+// refAtomNames are the known atoms. Those ending with "date" sort by
+// time, and objectsize and numparent by number.
+var refAtomNames = map[string]bool{
+	"refname": true, "objecttype": true, "objectsize": true, "objectname": true,
+	"tree": true, "parent": true, "numparent": true,
+	"object": true, "type": true, "tag": true,
+	"author": true, "authorname": true, "authoremail": true, "authordate": true,
+	"committer": true, "committername": true, "committeremail": true, "committerdate": true,
+	"tagger": true, "taggername": true, "taggeremail": true, "taggerdate": true,
+	"creator": true, "creatordate": true,
+	"subject": true, "body": true, "contents": true,
+	"upstream": true, "symref": true, "HEAD": true,
+}
+
+func parseRefAtom(s string) (refAtom, error) {
+	atom := refAtom{}
+	if strings.HasPrefix(s, "*") {
+		atom.deref = true
+		s = s[1:]
+	}
+	kv := strings.SplitN(s, ":", 2)
+	atom.name = kv[0]
+	if len(kv) == 2 {
+		atom.modifier = kv[1]
+	}
+	if !refAtomNames[atom.name] {
+		return atom, fmt.Errorf("unknown field name: %v", atom.name)
+	}
+	return atom, nil
+}
+
+// refFormat is a parsed "for-each-ref --format": literal text and atoms
+// alternate in parts, starting with text.
+type refFormat struct {
+	texts []string
+	atoms []refAtom
+}
+
+// parseRefFormat parses a format like git: "%%" stands for "%", and "%xx"
+// for the byte of hexadecimal code xx.
+func parseRefFormat(format string) (*refFormat, error) {
+	f := &refFormat{}
+	var literal strings.Builder
+	for i := 0; i < len(format); i++ {
+		switch {
+		case strings.HasPrefix(format[i:], "%%"):
+			literal.WriteByte('%')
+			i++
+		case format[i] == '%' && i+2 < len(format) && isHexByte(format[i+1:i+3]):
+			b, _ := strconv.ParseUint(format[i+1:i+3], 16, 8)
+			literal.WriteByte(byte(b))
+			i += 2
+		case strings.HasPrefix(format[i:], "%("):
+			end := strings.IndexByte(format[i:], ')')
+			if end < 0 {
+				return nil, fmt.Errorf("malformed format string %v", format[i:])
+			}
+			atom, err := parseRefAtom(format[i+2 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			f.texts = append(f.texts, literal.String())
+			f.atoms = append(f.atoms, atom)
+			literal.Reset()
+			i += end
+		default:
+			literal.WriteByte(format[i])
+		}
+	}
+	f.texts = append(f.texts, literal.String())
+	return f, nil
+}
+
+// isHexByte tells whether s is two hexadecimal digits, as in the "%09"
+// escapes of a format.
+func isHexByte(s string) bool {
+	_, err := strconv.ParseUint(s, 16, 8)
+	return len(s) == 2 && err == nil
+}
+
+// refItem is a ref being listed, with the objects it points to read on
+// demand.
+type refItem struct {
+	repo    *Repository
+	refs    map[string]string
+	headRef string
+	name    string
+	sha     string
+
+	obj      Object
+	derefObj Object
+}
+
+func (it *refItem) object(deref bool) (Object, string, error) {
+	if it.obj == nil {
+		obj, err := it.repo.readObject(it.sha)
+		if err != nil {
+			return nil, "", err
+		}
+		it.obj = obj
+	}
+	if !deref {
+		return it.obj, it.sha, nil
+	}
+	tag, ok := it.obj.(*Tag)
+	if !ok {
+		return nil, "", nil
+	}
+	if it.derefObj == nil {
+		obj, err := it.repo.readObject(tag.Object())
+		if err != nil {
+			return nil, "", err
+		}
+		it.derefObj = obj
+	}
+	return it.derefObj, tag.Object(), nil
+}
+
+// format renders the item.
+func (it *refItem) format(f *refFormat) (string, error) {
+	var sb strings.Builder
+	for i, atom := range f.atoms {
+		sb.WriteString(f.texts[i])
+		value, err := it.atom(atom)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(value)
+	}
+	sb.WriteString(f.texts[len(f.texts)-1])
+	return sb.String(), nil
+}
+
+// atom returns the value of an atom for the item; atoms which don't apply
+// to the object are empty.
+func (it *refItem) atom(atom refAtom) (string, error) {
+	switch atom.name {
+	case "refname":
+		return formatRefName(it.name, atom.modifier, it.refs)
+	case "symref":
+		target, err := it.repo.symbolicRefTarget(it.name)
+		if err != nil || target == "" {
+			return "", err
+		}
+		return formatRefName(target, atom.modifier, it.refs)
+	case "HEAD":
+		if it.name == it.headRef {
+			return "*", nil
+		}
+		return " ", nil
+	case "upstream":
+		return it.upstream(atom.modifier)
+	}
+
+	obj, sha, err := it.object(atom.deref)
+	if err != nil || obj == nil {
+		return "", err
+	}
+	switch atom.name {
+	case "objecttype":
+		return obj.GetFormat(), nil
+	case "objectsize":
+		_, size, err := it.repo.objectHeader(sha)
+		return strconv.FormatInt(size, 10), err
+	case "objectname":
+		switch {
+		case atom.modifier == "short":
+			return sha[:7], nil
+		case strings.HasPrefix(atom.modifier, "short="):
+			n, err := strconv.Atoi(atom.modifier[len("short="):])
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("positive value expected '%v' in %%(objectname)", atom.modifier)
+			}
+			if n < 4 {
+				n = 4
+			}
+			if n > len(sha) {
+				n = len(sha)
+			}
+			return sha[:n], nil
+		}
+		return sha, nil
+	}
+
+	var message string
+	var headers func(key string) []string
+	switch o := obj.(type) {
+	case *Tag:
+		message, headers = o.Message(), o.values
+		switch atom.name {
+		case "object", "type", "tag":
+			return o.value(atom.name), nil
+		case "creator", "creatordate":
+			return formatIdentAtom(o.values("tagger"), strings.TrimPrefix(atom.name, "creator"), atom.modifier)
+		}
+	case *Commit:
+		message, headers = o.Message(), o.values
+		switch atom.name {
+		case "tree":
+			return o.Tree(), nil
+		case "parent":
+			return strings.Join(o.Parents(), " "), nil
+		case "numparent":
+			return strconv.Itoa(len(o.Parents())), nil
+		case "creator", "creatordate":
+			return formatIdentAtom(o.values("committer"), strings.TrimPrefix(atom.name, "creator"), atom.modifier)
+		}
+	default:
+		return "", nil
+	}
+
+	for _, header := range []string{"author", "committer", "tagger"} {
+		if strings.HasPrefix(atom.name, header) {
+			return formatIdentAtom(headers(header), atom.name[len(header):], atom.modifier)
+		}
+	}
+
+	subject := commitSubject(message)
+	body := ""
+	if parts := strings.SplitN(strings.TrimLeft(message, "\n"), "\n\n", 2); len(parts) == 2 {
+		body = strings.TrimLeft(parts[1], "\n")
+	}
+	switch {
+	case atom.name == "subject", atom.name == "contents" && atom.modifier == "subject":
+		return subject, nil
+	case atom.name == "body", atom.name == "contents" && atom.modifier == "body":
+		return body, nil
+	case atom.name == "contents" && atom.modifier == "signature":
+		if tag, ok := obj.(*Tag); ok {
+			return tag.PGPSignature(), nil
+		}
+		return "", nil
+	case atom.name == "contents":
+		return message, nil
+	}
+	return "", nil
+}
+
+// formatRefName applies the modifiers of %(refname): "short", and
+// "lstrip=<n>" or "rstrip=<n>" which drop components from the left or the
+// right, or keep that many when n is negative.
+func formatRefName(refName string, modifier string, refs map[string]string) (string, error) {
+	switch {
+	case modifier == "":
+		return refName, nil
+	case modifier == "short":
+		return shortenRefName(refName, refs), nil
+	case strings.HasPrefix(modifier, "lstrip="), strings.HasPrefix(modifier, "strip="), strings.HasPrefix(modifier, "rstrip="):
+		kv := strings.SplitN(modifier, "=", 2)
+		n, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return "", fmt.Errorf("Integer value expected refname:%v", modifier)
+		}
+		components := strings.Split(refName, "/")
+		if n < 0 {
+			n = len(components) + n
+			if n < 0 {
+				n = 0
+			}
+		}
+		if n > len(components) {
+			n = len(components)
+		}
+		if kv[0] == "rstrip" {
+			return strings.Join(components[:len(components)-n], "/"), nil
+		}
+		return strings.Join(components[n:], "/"), nil
+	}
+	return "", fmt.Errorf("unrecognized %%(refname) argument: %v", modifier)
+}
+
+// formatIdentAtom renders an identity header for the atoms "<header>",
+// "<header>name", "<header>email" and "<header>date".
+func formatIdentAtom(values []string, field string, modifier string) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	if field == "" {
+		return values[0], nil
+	}
+	sig, err := parseSignature(values[0])
+	if err != nil {
+		return "", err
+	}
+	switch field {
+	case "name":
+		return sig.Name, nil
+	case "email":
+		switch modifier {
+		case "trim":
+			return sig.Email, nil
+		case "localpart":
+			return strings.SplitN(sig.Email, "@", 2)[0], nil
+		}
+		return "<" + sig.Email + ">", nil
+	case "date":
+		return formatRefDate(sig.When, modifier)
+	}
+	return "", nil
+}
+
+// formatRefDate renders a date in one of git's formats.
+func formatRefDate(when time.Time, format string) (string, error) {
+	if when.IsZero() {
+		return "", nil
+	}
+	switch format {
+	case "", "default":
+		return when.Format("Mon Jan 2 15:04:05 2006 -0700"), nil
+	case "unix":
+		return strconv.FormatInt(when.Unix(), 10), nil
+	case "raw":
+		return formatIdentDate(when), nil
+	case "iso", "iso8601":
+		return when.Format("2006-01-02 15:04:05 -0700"), nil
+	case "iso-strict", "iso8601-strict":
+		return when.Format("2006-01-02T15:04:05-07:00"), nil
+	case "rfc", "rfc2822":
+		return when.Format("Mon, 2 Jan 2006 15:04:05 -0700"), nil
+	case "short":
+		return when.Format("2006-01-02"), nil
+	}
+	return "", fmt.Errorf("unknown date format %v", format)
+}
+
+// upstream renders %(upstream) and its modifiers: "short", "track",
+// "trackshort", "remotename" and "remoteref". "track" takes a
+// ",nobracket" option.
+func (it *refItem) upstream(modifier string) (string, error) {
+	remote, merge, upstream := it.repo.upstreamOf(it.name)
+	switch modifier {
+	case "":
+		return upstream, nil
+	case "short":
+		if upstream == "" {
+			return "", nil
+		}
+		return shortenRefName(upstream, it.refs), nil
+	case "remotename":
+		return remote, nil
+	case "remoteref":
+		return merge, nil
+	case "track", "track,nobracket", "trackshort":
+		if upstream == "" {
+			return "", nil
+		}
+		upstreamSHA, ok := it.refs[upstream]
+		if !ok {
+			if modifier == "trackshort" {
+				return "", nil
+			}
+			if modifier == "track" {
+				return "[gone]", nil
+			}
+			return "gone", nil
+		}
+		ahead, behind, err := it.repo.aheadBehind(it.sha, upstreamSHA)
+		if err != nil {
+			return "", err
+		}
+		if modifier == "trackshort" {
+			switch {
+			case ahead > 0 && behind > 0:
+				return "<>", nil
+			case ahead > 0:
+				return ">", nil
+			case behind > 0:
+				return "<", nil
+			}
+			return "=", nil
+		}
+		counts := []string{}
+		if ahead > 0 {
+			counts = append(counts, fmt.Sprintf("ahead %v", ahead))
+		}
+		if behind > 0 {
+			counts = append(counts, fmt.Sprintf("behind %v", behind))
+		}
+		if len(counts) == 0 {
+			return "", nil
+		}
+		if modifier == "track" {
+			return "[" + strings.Join(counts, ", ") + "]", nil
+		}
+		return strings.Join(counts, ", "), nil
+	}
+	return "", fmt.Errorf("unrecognized %%(upstream) argument: %v", modifier)
+}
+
+// upstreamOf finds the branch a local branch is set to follow, through
+// branch.<name>.remote and branch.<name>.merge, and the fetch refspecs of
+// the remote to know where the remote branch is tracked.
+func (r *Repository) upstreamOf(refName string) (remote string, merge string, upstream string) {
+	if !strings.HasPrefix(refName, "refs/heads/") {
+		return "", "", ""
+	}
+	branch := strings.TrimPrefix(refName, "refs/heads/")
+	remote, _ = r.configString("branch." + branch + ".remote")
+	merge, _ = r.configString("branch." + branch + ".merge")
+	if remote == "" || merge == "" {
+		return "", "", ""
+	}
+	if remote == "." {
+		return remote, merge, merge
+	}
+	for _, refspec := range r.config.getAll("remote." + remote + ".fetch") {
+		refspec = strings.TrimPrefix(refspec, "+")
+		kv := strings.SplitN(refspec, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		src, dst := kv[0], kv[1]
+		if !strings.Contains(src, "*") {
+			if src == merge {
+				return remote, merge, dst
+			}
+			continue
+		}
+		star := strings.Index(src, "*")
+		if strings.HasPrefix(merge, src[:star]) && strings.HasSuffix(merge, src[star+1:]) && len(merge) >= len(src)-1 {
+			matched := merge[star : len(merge)-len(src)+star+1]
+			return remote, merge, strings.Replace(dst, "*", matched, 1)
+		}
+	}
+	return remote, merge, ""
+}
+
+// aheadBehind counts the commits reachable from a but not from b, and
+// from b but not from a.
+func (r *Repository) aheadBehind(a, b string) (int, int, error) {
+	lookup := revCommitCache(r)
+	reach := func(sha string) (map[string]bool, error) {
+		commit, err := peelRev(r, sha, TypeCommit)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		stack := []string{commit}
+		for len(stack) > 0 {
+			sha := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[sha] {
+				continue
+			}
+			seen[sha] = true
+			c, err := lookup(sha)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, c.Parents...)
+		}
+		return seen, nil
+	}
+	fromA, err := reach(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	fromB, err := reach(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	ahead, behind := 0, 0
+	for sha := range fromA {
+		if !fromB[sha] {
+			ahead++
+		}
+	}
+	for sha := range fromB {
+		if !fromA[sha] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// refSortKey compares items on an atom. Dates compare as times, sizes and
+// counts as numbers, and "version:refname" as versions.
+type refSortKey struct {
+	atom    refAtom
+	version bool
+	reverse bool
+}
+
+func parseRefSortKey(s string) (refSortKey, error) {
+	key := refSortKey{}
+	if strings.HasPrefix(s, "-") {
+		key.reverse = true
+		s = s[1:]
+	}
+	for _, prefix := range []string{"version:", "v:"} {
+		if strings.HasPrefix(s, prefix) {
+			key.version = true
+			s = s[len(prefix):]
+		}
+	}
+	atom, err := parseRefAtom(s)
+	key.atom = atom
+	return key, err
+}
+
+// sortRefItems orders items on keys, the first one taking precedence, and
+// then on their names.
+func sortRefItems(items []*refItem, keys []refSortKey) error {
+	type sortValue struct {
+		str string
+		num int64
+	}
+	values := make([][]sortValue, len(items))
+	for i, it := range items {
+		for _, key := range keys {
+			atom := key.atom
+			numeric := atom.name == "objectsize" || atom.name == "numparent" || strings.HasSuffix(atom.name, "date")
+			if strings.HasSuffix(atom.name, "date") {
+				atom.modifier = "unix"
+			}
+			v, err := it.atom(atom)
+			if err != nil {
+				return err
+			}
+			sv := sortValue{str: v}
+			if numeric {
+				sv.num, _ = strconv.ParseInt(v, 10, 64)
+			}
+			values[i] = append(values[i], sv)
+		}
+	}
+
+	indexes := make([]int, len(items))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(x, y int) bool {
+		a, b := indexes[x], indexes[y]
+		for k, key := range keys {
+			va, vb := values[a][k], values[b][k]
+			cmp := 0
+			switch {
+			case key.version:
+				cmp = util.CompareVersions(va.str, vb.str)
+			case va.num != vb.num:
+				if va.num < vb.num {
+					cmp = -1
+				} else {
+					cmp = 1
+				}
+			default:
+				cmp = strings.Compare(va.str, vb.str)
+			}
+			if key.reverse {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return items[a].name < items[b].name
+	})
+
+	sorted := make([]*refItem, len(items))
+	for i, idx := range indexes {
+		sorted[i] = items[idx]
+	}
+	copy(items, sorted)
+	return nil
+}
+
+// newRefCommitFilter builds the filter of "for-each-ref" on what refs
+// point at and on their history. Refs which don't lead to a commit are
+// dropped by the history filters.
+func (r *Repository) newRefCommitFilter(opts ForEachRefOptions) (func(sha string) (bool, error), error) {
+	lookup := revCommitCache(r)
+	resolveCommit := func(rev string) (*RevCommit, error) {
+		if rev == "" {
+			return nil, nil
+		}
+		sha, err := resolveRev(r, rev, TypeCommit)
+		if err != nil {
+			return nil, err
+		}
+		return lookup(sha)
+	}
+	contains, err := resolveCommit(opts.Contains)
+	if err != nil {
+		return nil, err
+	}
+	noContains, err := resolveCommit(opts.NoContains)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := resolveCommit(opts.Merged)
+	if err != nil {
+		return nil, err
+	}
+	noMerged, err := resolveCommit(opts.NoMerged)
+	if err != nil {
+		return nil, err
+	}
+	pointsAt := ""
+	if opts.PointsAt != "" {
+		if pointsAt, err = resolveName(r, opts.PointsAt); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(sha string) (bool, error) {
+		if pointsAt != "" && sha != pointsAt {
+			obj, err := r.readObject(sha)
+			if err != nil {
+				return false, err
+			}
+			if tag, ok := obj.(*Tag); !ok || tag.Object() != pointsAt {
+				return false, nil
+			}
+		}
+		if contains == nil && noContains == nil && merged == nil && noMerged == nil {
+			return true, nil
+		}
+
+		commitSHA, err := peelRev(r, sha, TypeCommit)
+		if err != nil {
+			return false, nil
+		}
+		commit, err := lookup(commitSHA)
+		if err != nil {
+			return false, err
+		}
+		checks := []struct {
+			a, b *RevCommit
+			want bool
+		}{
+			{contains, commit, true},
+			{noContains, commit, false},
+			{commit, merged, true},
+			{commit, noMerged, false},
+		}
+		for _, c := range checks {
+			if c.a == nil || c.b == nil {
+				continue
+			}
+			ok, err := isAncestor(c.a, c.b, lookup)
+			if err != nil {
+				return false, err
+			}
+			if ok != c.want {
+				return false, nil
+			}
+		}
+		return true, nil
+	}, nil
+}
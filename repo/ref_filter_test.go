@@ -0,0 +1,159 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShortenRefName(t *testing.T) {
+	refs := map[string]string{
+		"refs/heads/main":           "",
+		"refs/heads/feat":           "",
+		"refs/tags/main":            "",
+		"refs/tags/v1":              "",
+		"refs/remotes/origin/main":  "",
+		"refs/remotes/origin/HEAD":  "",
+		"refs/remotes/origin/feat":  "",
+		"refs/heads/origin/feat":    "",
+		"refs/notes/commits":        "",
+		"refs/heads/refs/heads/odd": "",
+	}
+	tests := map[string]string{
+		"refs/heads/main":          "heads/main",
+		"refs/tags/main":           "tags/main",
+		"refs/heads/feat":          "feat",
+		"refs/tags/v1":             "v1",
+		"refs/remotes/origin/main": "origin/main",
+		"refs/remotes/origin/HEAD": "origin/HEAD",
+		"refs/remotes/origin/feat": "remotes/origin/feat",
+		"refs/notes/commits":       "notes/commits",
+	}
+	for refName, want := range tests {
+		if got := shortenRefName(refName, refs); got != want {
+			t.Errorf("shortenRefName(%v) = %v, want %v", refName, got, want)
+		}
+	}
+}
+
+func TestRefPatterns(t *testing.T) {
+	showRef := []struct {
+		pattern, refName string
+		want             bool
+	}{
+		{"main", "refs/heads/main", true},
+		{"heads/main", "refs/heads/main", true},
+		{"refs/heads/main", "refs/heads/main", true},
+		{"ain", "refs/heads/main", false},
+	}
+	for _, tt := range showRef {
+		if got := showRefMatches(tt.pattern, tt.refName); got != tt.want {
+			t.Errorf("showRefMatches(%v, %v) = %v", tt.pattern, tt.refName, got)
+		}
+	}
+
+	forEachRef := []struct {
+		pattern, refName string
+		want             bool
+	}{
+		{"refs/heads", "refs/heads/main", true},
+		{"refs/heads/", "refs/heads/main", true},
+		{"refs/heads/m", "refs/heads/main", false},
+		{"refs/*/m*", "refs/heads/main", true},
+		{"refs/*/m*", "refs/remotes/origin/main", false},
+		{"refs/heads/main", "refs/heads/main", true},
+	}
+	for _, tt := range forEachRef {
+		if got := forEachRefMatches(tt.pattern, tt.refName); got != tt.want {
+			t.Errorf("forEachRefMatches(%v, %v) = %v", tt.pattern, tt.refName, got)
+		}
+	}
+}
+
+func TestFormatRefName(t *testing.T) {
+	tests := map[string]string{
+		"":          "refs/remotes/origin/main",
+		"lstrip=2":  "origin/main",
+		"lstrip=-1": "main",
+		"rstrip=1":  "refs/remotes/origin",
+		"rstrip=-1": "refs",
+		"strip=9":   "",
+	}
+	for modifier, want := range tests {
+		got, err := formatRefName("refs/remotes/origin/main", modifier, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%%(refname:%v) = %q, want %q", modifier, got, want)
+		}
+	}
+	if _, err := parseRefFormat("%(refname"); err == nil {
+		t.Error("expected an unterminated atom to be rejected")
+	}
+	if _, err := parseRefFormat("%(bogus)"); err == nil {
+		t.Error("expected an unknown atom to be rejected")
+	}
+	f, err := parseRefFormat("%(refname)%09%%0a%(objecttype)%0a%zz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", "\t%0a", "\n%zz"}; strings.Join(f.texts, "|") != strings.Join(want, "|") {
+		t.Errorf("texts: got %q, want %q", f.texts, want)
+	}
+}
+
+func TestListRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	loose, packed, stale := strings.Repeat("1", 40), strings.Repeat("2", 40), strings.Repeat("3", 40)
+	if err := repo.writeRef("refs/heads/main", loose); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.writeRef("refs/heads/loop", "ref: refs/heads/loop"); err != nil {
+		t.Fatal(err)
+	}
+	packedRefs := "# pack-refs with: peeled fully-peeled sorted \n" +
+		stale + " refs/heads/main\n" +
+		packed + " refs/tags/v1\n" +
+		"^" + loose + "\n"
+	if err := ioutil.WriteFile(repo.packedRefsPath(), []byte(packedRefs), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, broken, err := repo.listRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"refs/heads/main": loose, "refs/tags/v1": packed}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("refs: got %v, want %v", refs, want)
+	}
+	if !reflect.DeepEqual(broken, []string{"refs/heads/loop"}) {
+		t.Errorf("broken: got %v", broken)
+	}
+	if sha, err := repo.readRef("refs/tags/v1", map[string]string{}); err != nil || sha != packed {
+		t.Errorf("readRef(refs/tags/v1) = %q (%v)", sha, err)
+	}
+
+	if err := repo.deleteRef("refs/tags/v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.readRef("refs/tags/v1", map[string]string{}); err == nil {
+		t.Error("expected the deleted packed ref to be gone")
+	}
+	if err := repo.deleteRef("refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.readRef("refs/heads/main", map[string]string{}); err == nil {
+		t.Error("expected the ref to be gone from packed-refs too")
+	}
+}
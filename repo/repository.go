@@ -1,61 +1,121 @@
 package repo
 
 import (
-	"bytes"
-	"compress/zlib"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/go-ini/ini"
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/pencil001/pit/util"
 )
 
 type Repository struct {
 	workTree string
 	gitDir   string
+	// commonDir holds what all the worktrees of a repository share: the
+	// objects, the config and most refs. It is gitDir but in a linked
+	// worktree.
+	commonDir string
+	config    *Config
+
+	objFormat *ObjectFormat
+
+	graph       *commitGraph
+	graphLoaded bool
+
+	keyring       openpgp.EntityList
+	keyringLoaded bool
 }
 
-func createRepository(repoPath string, force bool) *Repository {
-	repo := Repository{
-		workTree: repoPath,
-		gitDir:   path.Join(repoPath, ".git"),
+// createRepository sets up a repository whose git directory is gitDir.
+// workTree is empty for a bare repository.
+func createRepository(workTree string, gitDir string) *Repository {
+	return &Repository{
+		workTree:  workTree,
+		gitDir:    gitDir,
+		commonDir: commonDirOf(gitDir),
 	}
+}
 
-	if !force {
-		isDir, err := util.IsDir(repo.gitDir)
-		if err != nil || !isDir {
-			log.Panic(fmt.Sprintf("Not a Git repository %v", repoPath))
-		}
+// commonDirOf returns the common directory of a git directory, which the
+// git directory of a linked worktree names in its commondir file,
+// relative to itself.
+func commonDirOf(gitDir string) string {
+	bs, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	dir := strings.TrimSpace(string(bs))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitDir, dir)
+	}
+	return filepath.Clean(dir)
+}
 
-		cfgFile := path.Join(repo.gitDir, "config")
-		isExist, err := util.IsExist(cfgFile)
-		if err != nil || !isExist {
-			log.Panic("Configuration file missing")
-		}
+// openRepository reads the config of the existing git directory gitDir.
+// The work tree is then workTree, unless core.worktree names another one,
+// or core.bare says there is none.
+func openRepository(workTree string, gitDir string) (*Repository, error) {
+	repo := createRepository(workTree, gitDir)
+	isDir, err := util.IsDir(repo.gitDir)
+	if err != nil || !isDir {
+		return nil, fmt.Errorf("Not a Git repository %v", gitDir)
+	}
 
-		f, err := ini.Load(cfgFile)
-		if err != nil {
-			log.Panic(err)
-		}
-		ver, err := f.Section("core").Key("repositoryformatversion").Int()
-		if err != nil {
-			log.Panic(fmt.Sprintf("Unanalyzable repositoryformatversion: %v", err))
+	cfgFile := path.Join(repo.commonDir, "config")
+	isExist, err := util.IsExist(cfgFile)
+	if err != nil || !isExist {
+		return nil, fmt.Errorf("Configuration file missing")
+	}
+
+	cfg, err := loadConfig(repo.gitDir)
+	if err != nil {
+		return nil, err
+	}
+	repo.config = cfg
+	strVer, ok := cfg.get("core.repositoryformatversion")
+	if !ok {
+		strVer = "0"
+	}
+	ver, err := strconv.Atoi(strVer)
+	if err != nil {
+		return nil, fmt.Errorf("Unanalyzable repositoryformatversion: %v", err)
+	}
+	if ver != 0 && ver != 1 {
+		return nil, fmt.Errorf("Unsupported repositoryformatversion %v", ver)
+	}
+	repo.objFormat = FormatSHA1
+	if ver == 1 {
+		if err := repo.loadExtensions(); err != nil {
+			return nil, err
 		}
-		if ver != 0 {
-			log.Panic(fmt.Sprintf("Unsupported repositoryformatversion %v", ver))
+	}
+
+	if wt, ok := repo.configString("core.worktree"); ok && wt != "" {
+		if !filepath.IsAbs(wt) {
+			wt = filepath.Join(repo.gitDir, wt)
 		}
+		repo.workTree = filepath.Clean(wt)
+	} else if repo.configBool("core.bare", false) {
+		repo.workTree = ""
 	}
+	return repo, nil
+}
 
-	return &repo
+// isBare tells whether the repository has no work tree.
+func (r *Repository) isBare() bool {
+	return r.workTree == ""
 }
 
-func (r *Repository) initGitDir() error {
+// initGitDir lays out a new git directory whose HEAD points to the
+// branch initialBranch, yet to be born. The HEAD of an existing repository
+// is kept.
+func (r *Repository) initGitDir(initialBranch string) error {
 	if err := util.CreateDir(path.Join(r.gitDir, "branches")); err != nil {
 		return err
 	}
@@ -76,57 +136,174 @@ func (r *Repository) initGitDir() error {
 	defer fDesc.Close()
 	fDesc.WriteString("Unnamed repository; edit this file 'description' to name the repository.\n")
 
-	fHead, err := util.CreateFile(path.Join(r.gitDir, "HEAD"))
-	if err != nil {
+	if _, err := os.Stat(path.Join(r.gitDir, "HEAD")); os.IsNotExist(err) {
+		if err := r.writeSymbolicRef("HEAD", "refs/heads/"+initialBranch); err != nil {
+			return err
+		}
+	} else if err != nil {
 		return err
 	}
-	defer fHead.Close()
-	fHead.WriteString("ref: refs/heads/master\n")
 
 	cfgPath := path.Join(r.gitDir, "config")
 	fConfig, err := util.CreateFile(cfgPath)
 	if err != nil {
 		return err
 	}
-	defer fConfig.Close()
+	fConfig.Close()
 
-	iniFile, err := ini.Load(cfgPath)
-	if err != nil {
-		return err
+	ver := "0"
+	if r.objectFormat() != FormatSHA1 {
+		ver = "1"
+	}
+	settings := [][]string{
+		{"core.repositoryformatversion", ver},
+		{"core.filemode", strconv.FormatBool(probeFileMode(cfgPath))},
+		{"core.bare", strconv.FormatBool(r.isBare())},
+	}
+	if r.objectFormat() != FormatSHA1 {
+		settings = append(settings, []string{"extensions.objectformat", r.objectFormat().Name})
 	}
-	coreSect := iniFile.Section("core")
-	coreSect.NewKey("repositoryformatversion", "0")
-	coreSect.NewKey("filemode", "false")
-	coreSect.NewKey("bare", "false")
-	iniFile.WriteTo(fConfig)
+	for _, kv := range settings {
+		if err := editConfigFile(cfgPath, kv[0], kv[1], configSet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// loadExtensions applies the extensions.* options of a version 1
+// repository. Any extension we don't understand makes the repository
+// unusable, as git requires.
+func (r *Repository) loadExtensions() error {
+	for _, e := range r.config.entries {
+		if e.section != "extensions" || e.scope != ScopeLocal {
+			continue
+		}
+		switch e.name {
+		case "objectformat":
+			objFormat, err := lookupObjectFormat(strings.ToLower(e.value))
+			if err != nil {
+				return err
+			}
+			r.objFormat = objFormat
+		case "worktreeconfig":
+		default:
+			return fmt.Errorf("Unknown repository extension %v", e.name)
+		}
+	}
 	return nil
 }
 
+// objectFormat returns the hash used to name objects in the repository.
+// Objects which don't belong to a repository use SHA-1.
+func (r *Repository) objectFormat() *ObjectFormat {
+	if r == nil || r.objFormat == nil {
+		return FormatSHA1
+	}
+	return r.objFormat
+}
+
+// probeFileMode checks whether the filesystem keeps the executable bit,
+// the same way git decides the initial value of core.filemode.
+func probeFileMode(filePath string) bool {
+	st, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+	if err := os.Chmod(filePath, st.Mode()^0100); err != nil {
+		return false
+	}
+	defer os.Chmod(filePath, st.Mode())
+
+	changed, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+	return changed.Mode() != st.Mode()
+}
+
+// configBool reads a boolean option from the repository config, falling
+// back to def when the repository has no config or the key is unset.
+func (r *Repository) configBool(key string, def bool) bool {
+	if r == nil {
+		return def
+	}
+	return r.config.getBool(key, def)
+}
+
+// configString reads an option from the repository config, or from the
+// user's config when there is no repository.
+func (r *Repository) configString(key string) (string, bool) {
+	if r == nil || r.config == nil {
+		cfg, err := loadConfig("")
+		if err != nil {
+			return "", false
+		}
+		return cfg.get(key)
+	}
+	return r.config.get(key)
+}
+
+// getRefs returns the object name of every ref under refs/, loose or
+// packed. Broken refs, like symbolic refs which loop, are left out.
 func (r *Repository) getRefs() (map[string]string, error) {
+	refs, _, err := r.listRefs()
+	return refs, err
+}
+
+// listRefs is getRefs, also returning the names of the broken refs it
+// left out, for commands listing refs to warn about them.
+func (r *Repository) listRefs() (map[string]string, []string, error) {
 	refs := make(map[string]string)
-	if err := r.searchRefs("refs", refs); err != nil {
-		return nil, err
+	broken := []string{}
+	if err := r.searchRefs("refs", refs, &broken); err != nil {
+		return nil, nil, err
 	}
-	return refs, nil
+	packed, err := r.readPackedRefs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, sha := range packed {
+		if _, ok := refs[name]; !ok {
+			refs[name] = sha
+		}
+	}
+	sort.Strings(broken)
+	return refs, broken, nil
 }
 
-func (r *Repository) searchRefs(prefix string, refs map[string]string) error {
-	entries, err := ioutil.ReadDir(path.Join(r.gitDir, prefix))
+func (r *Repository) searchRefs(prefix string, refs map[string]string, broken *[]string) error {
+	entries, err := ioutil.ReadDir(r.refFilePath(prefix))
 	if err != nil {
 		return err
 	}
+	// The refs of a linked worktree are in its own git directory, in
+	// place of those of the main worktree.
+	linked := prefix == "refs" && r.gitDir != r.commonDir
+	if linked {
+		for _, dir := range perWorktreeRefDirs {
+			if st, err := os.Stat(r.refFilePath(dir)); err == nil && st.IsDir() {
+				if err := r.searchRefs(dir, refs, broken); err != nil {
+					return err
+				}
+			}
+		}
+	}
 
 	for _, f := range entries {
+		if linked && isPerWorktreeRef(path.Join(prefix, f.Name())) {
+			continue
+		}
 		if f.IsDir() {
-			if err := r.searchRefs(path.Join(prefix, f.Name()), refs); err != nil {
+			if err := r.searchRefs(path.Join(prefix, f.Name()), refs, broken); err != nil {
 				return err
 			}
 		} else {
 			key := path.Join(prefix, f.Name())
 			hash, err := r.readRef(key, refs)
-			if err != nil {
-				return err
+			if err != nil || !r.isObjectName(hash) {
+				*broken = append(*broken, key)
+				continue
 			}
 			refs[key] = hash
 		}
@@ -134,27 +311,150 @@ func (r *Repository) searchRefs(prefix string, refs map[string]string) error {
 	return nil
 }
 
+// perWorktreeRefDirs hold refs that each worktree has its own of, like
+// HEAD and the other refs outside of refs/.
+var perWorktreeRefDirs = []string{"refs/bisect", "refs/worktree", "refs/rewritten"}
+
+// refFilePath returns the file of a ref. Refs of the current worktree are
+// in its git directory, and shared ones in the common directory.
+// "main-worktree/<ref>" and "worktrees/<name>/<ref>" name the refs of
+// another worktree.
+func (r *Repository) refFilePath(name string) string {
+	switch {
+	case strings.HasPrefix(name, "main-worktree/"):
+		return path.Join(r.commonDir, strings.TrimPrefix(name, "main-worktree/"))
+	case strings.HasPrefix(name, "worktrees/"):
+		return path.Join(r.commonDir, name)
+	case isPerWorktreeRef(name):
+		return path.Join(r.gitDir, name)
+	}
+	return path.Join(r.commonDir, name)
+}
+
+// isPerWorktreeRef tells whether each worktree has its own ref of that
+// name.
+func isPerWorktreeRef(name string) bool {
+	if !strings.HasPrefix(name, "refs/") {
+		return name != "refs"
+	}
+	for _, dir := range perWorktreeRefDirs {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// maxSymrefDepth is how many symbolic refs may be followed in a row, like
+// git's SYMREF_MAXDEPTH. It also stops symbolic refs which loop.
+const maxSymrefDepth = 5
+
+// readRef returns the object name a ref holds, following symbolic refs.
+// Names already in refs are taken from there.
 func (r *Repository) readRef(prefix string, refs map[string]string) (string, error) {
-	if sha, ok := refs[prefix]; ok {
-		return sha, nil
+	name := prefix
+	for depth := 0; ; depth++ {
+		if sha, ok := refs[name]; ok {
+			return sha, nil
+		}
+		target, hash, err := r.readRefFile(name)
+		if err != nil {
+			return "", err
+		}
+		if target == "" {
+			return hash, nil
+		}
+		if depth == maxSymrefDepth {
+			return "", fmt.Errorf("Symbolic ref %v is a loop or nests too deeply", prefix)
+		}
+		name = target
 	}
+}
 
-	bs, err := ioutil.ReadFile(path.Join(r.gitDir, prefix))
+// readRefFile reads a ref without following it: either the ref it points
+// to, for a symbolic ref, or an object name.
+func (r *Repository) readRefFile(name string) (target string, hash string, err error) {
+	bs, err := ioutil.ReadFile(r.refFilePath(name))
+	if os.IsNotExist(err) && !isPerWorktreeRef(name) {
+		packed, perr := r.readPackedRefs()
+		if perr != nil {
+			return "", "", perr
+		}
+		if sha, ok := packed[name]; ok {
+			return "", sha, nil
+		}
+	}
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	content := strings.TrimSpace(string(bs))
+	if strings.HasPrefix(content, "ref: ") {
+		return strings.TrimSpace(content[5:]), "", nil
+	}
+	return "", content, nil
+}
+
+// symbolicRefTarget returns the ref a symbolic ref points to, or "" when
+// refName holds an object name.
+func (r *Repository) symbolicRefTarget(refName string) (string, error) {
+	target, _, err := r.readRefFile(refName)
+	return target, err
+}
+
+// resolveSymbolicRef follows a chain of symbolic refs from refName to the
+// last ref, which need not exist, as for a branch yet to be born. It
+// returns "" when refName isn't a symbolic ref.
+func (r *Repository) resolveSymbolicRef(refName string) (string, error) {
+	name := refName
+	for depth := 0; ; depth++ {
+		target, _, err := r.readRefFile(name)
+		if os.IsNotExist(err) && name != refName {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if target == "" {
+			if name == refName {
+				return "", nil
+			}
+			return name, nil
+		}
+		if depth == maxSymrefDepth {
+			return "", fmt.Errorf("Symbolic ref %v is a loop or nests too deeply", refName)
+		}
+		name = target
 	}
+}
 
-	// must drop \n at the end of line
-	// otherwise content[5:] will contain the newline, then the filename is incorrect
-	hash := strings.TrimSpace(string(bs))
-	if strings.HasPrefix(hash, "ref: ") {
-		return r.readRef(hash[5:], refs)
+// writeSymbolicRef makes refName point to the ref target. HEAD may only
+// point inside refs/.
+func (r *Repository) writeSymbolicRef(refName string, target string) error {
+	if refName == "HEAD" && !strings.HasPrefix(target, "refs/") {
+		return fmt.Errorf("Refusing to point HEAD outside of refs/")
 	}
-	return hash, nil
+	if err := checkRefName(target); err != nil {
+		return fmt.Errorf("Refusing to set '%v' to invalid ref '%v'", refName, target)
+	}
+	refPath := r.refFilePath(refName)
+	if err := util.CreateDir(path.Dir(refPath)); err != nil {
+		return err
+	}
+	fRef, err := util.CreateFileWithMode(refPath, 0666)
+	if err != nil {
+		return err
+	}
+	defer fRef.Close()
+	_, err = fRef.WriteString(fmt.Sprintf("ref: %v\n", target))
+	return err
 }
 
 func (r *Repository) writeRef(prefix string, hash string) error {
-	fRef, err := util.CreateFile(path.Join(r.gitDir, prefix))
+	refPath := r.refFilePath(prefix)
+	if err := util.CreateDir(path.Dir(refPath)); err != nil {
+		return err
+	}
+	fRef, err := util.CreateFileWithMode(refPath, 0666)
 	if err != nil {
 		return err
 	}
@@ -163,6 +463,101 @@ func (r *Repository) writeRef(prefix string, hash string) error {
 	return err
 }
 
+// deleteRef deletes a ref, both its file and its line in packed-refs.
+func (r *Repository) deleteRef(prefix string) error {
+	err := os.Remove(r.refFilePath(prefix))
+	packed, perr := r.readPackedRefs()
+	if perr != nil {
+		return perr
+	}
+	if _, ok := packed[prefix]; !ok {
+		return err
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return r.removePackedRef(prefix)
+}
+
+// packedRefsPath is the file where "git pack-refs" and "git gc" move refs,
+// which are then looked up there when they have no file of their own.
+func (r *Repository) packedRefsPath() string {
+	return path.Join(r.commonDir, "packed-refs")
+}
+
+// readPackedRefs reads packed-refs: a "<sha> <name>" line per ref, each
+// possibly followed by a "^<sha>" line with the object an annotated tag
+// peels to. It is fine for the file not to exist.
+func (r *Repository) readPackedRefs() (map[string]string, error) {
+	refs := map[string]string{}
+	bs, err := ioutil.ReadFile(r.packedRefsPath())
+	if os.IsNotExist(err) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || !r.isObjectName(fields[0]) {
+			return nil, fmt.Errorf("unexpected line in packed-refs: %v", line)
+		}
+		refs[fields[1]] = fields[0]
+	}
+	return refs, nil
+}
+
+// removePackedRef rewrites packed-refs without the ref name.
+func (r *Repository) removePackedRef(name string) error {
+	bs, err := ioutil.ReadFile(r.packedRefsPath())
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	skipping := false
+	for _, line := range strings.SplitAfter(string(bs), "\n") {
+		if strings.HasPrefix(line, "^") && skipping {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 2)
+		skipping = len(fields) == 2 && fields[1] == name && !strings.HasPrefix(line, "#")
+		if !skipping {
+			sb.WriteString(line)
+		}
+	}
+	lockPath := r.packedRefsPath() + ".lock"
+	if err := ioutil.WriteFile(lockPath, []byte(sb.String()), 0666); err != nil {
+		return err
+	}
+	return os.Rename(lockPath, r.packedRefsPath())
+}
+
+// checkRefName applies the rules of git check-ref-format to a full ref
+// name such as "refs/tags/v1.0".
+func checkRefName(name string) error {
+	invalid := fmt.Errorf("'%v' is not a valid ref name", name)
+	if name == "" || name == "@" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "//") ||
+		strings.Contains(name, "@{") {
+		return invalid
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return invalid
+		}
+	}
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return invalid
+		}
+	}
+	return nil
+}
+
 func (r *Repository) readObject(objSHA string) (Object, error) {
 	format, content, err := r.parseObject(objSHA)
 	if err != nil {
@@ -187,40 +582,44 @@ func (r *Repository) readObject(objSHA string) (Object, error) {
 }
 
 func (r *Repository) parseObject(objSHA string) (string, string, error) {
-	objFile := path.Join(r.gitDir, "objects", objSHA[:2], objSHA[2:])
-	isExist, err := util.IsExist(objFile)
-	if err != nil || !isExist {
-		return "", "", fmt.Errorf("Objects file %v missing", objFile)
-	}
-
-	fObj, err := os.Open(objFile)
+	or, err := r.openObject(objSHA)
 	if err != nil {
 		return "", "", err
 	}
-	defer fObj.Close()
+	defer or.Close()
 
-	var b bytes.Buffer
-	rd, err := zlib.NewReader(fObj)
-	io.Copy(&b, rd)
-	rd.Close()
-
-	objContent := b.String()
-
-	// fmt.Println(objContent)
-	// fmt.Println(hex.Dump(b.Bytes()))
-
-	idxSpace := strings.Index(objContent, " ")
-	format := objContent[:idxSpace]
-
-	idxZero := strings.Index(objContent, "\x00")
-	strSize := objContent[idxSpace+1 : idxZero]
-	size, err := strconv.Atoi(strSize)
+	content, err := ioutil.ReadAll(or)
 	if err != nil {
-		return "", "", err
+		return "", "", fmt.Errorf("Malformed object %v: %v", objSHA, err)
 	}
-	if size != len(objContent)-idxZero-1 {
+	if n, _ := or.br.Read(make([]byte, 1)); n > 0 {
 		return "", "", fmt.Errorf("Malformed object %v: bad length", objSHA)
 	}
+	return or.format, string(content), nil
+}
 
-	return format, objContent[idxZero+1:], nil
+// relativePath turns a path given on the command line into a slash
+// separated path relative to the root of the work tree.
+func (r *Repository) relativePath(p string) (string, error) {
+	// Without a work tree, paths are relative to the root of the tree.
+	if r.isBare() {
+		rel := path.Clean(filepath.ToSlash(p))
+		if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("%v is outside repository", p)
+		}
+		return rel, nil
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.Abs(r.workTree)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%v is outside repository at %v", p, root)
+	}
+	return filepath.ToSlash(rel), nil
 }
@@ -0,0 +1,212 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// requireWorkTree fails in a bare repository, for operations on files.
+func (r *Repository) requireWorkTree() error {
+	if r.isBare() {
+		return fmt.Errorf("this operation must be run in a work tree")
+	}
+	return nil
+}
+
+// headTreeEntries lists the files of the tree of HEAD, none when HEAD is
+// yet to be born.
+func (r *Repository) headTreeEntries() ([]*indexEntry, error) {
+	sha, err := r.readRef("HEAD", nil)
+	if os.IsNotExist(err) {
+		return []*indexEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	treeSHA, err := resolveRev(r, sha, TypeTree)
+	if err != nil {
+		return nil, err
+	}
+	return r.treeEntries(treeSHA, "")
+}
+
+// moveHead points the branch HEAD is on, or HEAD itself when detached, to
+// commit. The commit HEAD was at is kept in ORIG_HEAD.
+func (r *Repository) moveHead(commit string) error {
+	if old, err := r.readRef("HEAD", nil); err == nil {
+		if err := r.writeRef("ORIG_HEAD", old); err != nil {
+			return err
+		}
+	}
+	ref, err := r.resolveSymbolicRef("HEAD")
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return r.writeRef(ref, commit)
+}
+
+// resetEntries replaces the entries of the index matching specs, or all of
+// them without specs, with those of entries that match. The stat data of
+// unchanged entries is kept.
+func (idx *index) resetEntries(entries []*indexEntry, specs []pathspec) {
+	old := map[string]*indexEntry{}
+	kept := []*indexEntry{}
+	for _, e := range idx.entries {
+		if len(specs) > 0 && !matchPathspecs(specs, e.path) {
+			kept = append(kept, e)
+			continue
+		}
+		if e.stage() == 0 {
+			old[e.path] = e
+		}
+	}
+	for _, e := range entries {
+		if !matchPathspecs(specs, e.path) {
+			continue
+		}
+		if o, ok := old[e.path]; ok && o.sha == e.sha && o.mode == e.mode {
+			e = o
+		}
+		kept = append(kept, e)
+	}
+	idx.entries = kept
+	idx.sort()
+}
+
+// refreshIndex records the stat data of the files that still match the
+// index, and lists the others like "git reset" does: "M\t<path>" when
+// modified and "D\t<path>" when deleted.
+func (r *Repository) refreshIndex(idx *index) ([]string, error) {
+	changes := []string{}
+	for _, e := range idx.entries {
+		if e.stage() != 0 {
+			changes = append(changes, "U\t"+e.path)
+			continue
+		}
+		if e.leafMode() == ModeGitlink {
+			continue
+		}
+		st, err := os.Lstat(path.Join(r.workTree, e.path))
+		if os.IsNotExist(err) {
+			changes = append(changes, "D\t"+e.path)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		modified, err := r.isEntryModified(e)
+		if err != nil {
+			return nil, err
+		}
+		if modified {
+			changes = append(changes, "M\t"+e.path)
+			continue
+		}
+		e.setStat(st)
+	}
+	return changes, nil
+}
+
+// checkoutEntry writes the object of an index entry to its file in the
+// work tree, replacing whatever is there, and records the stat data of the
+// new file.
+func (r *Repository) checkoutEntry(e *indexEntry) error {
+	parts := strings.Split(e.path, "/")
+	dir := r.workTree
+	for i, part := range parts {
+		if err := verifyLeafPath(part); err != nil {
+			return err
+		}
+		if i == len(parts)-1 {
+			break
+		}
+		// Files in the way of the directories of the entry go.
+		dir = path.Join(dir, part)
+		if st, err := os.Lstat(dir); err == nil && !st.IsDir() {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	filePath := path.Join(r.workTree, e.path)
+	if err := os.RemoveAll(filePath); err != nil {
+		return err
+	}
+	if e.leafMode() == ModeGitlink {
+		return os.Mkdir(filePath, 0777)
+	}
+	if err := checkoutBlob(r, e.sha, e.leafMode(), filePath); err != nil {
+		return err
+	}
+	st, err := os.Lstat(filePath)
+	if err != nil {
+		return err
+	}
+	e.setStat(st)
+	return nil
+}
+
+// removeWorkTreeFile deletes the file of a path of the work tree, and its
+// directories once they are empty.
+func (r *Repository) removeWorkTreeFile(filePath string) error {
+	if err := os.RemoveAll(path.Join(r.workTree, filePath)); err != nil {
+		return err
+	}
+	for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(path.Join(r.workTree, dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// resetHard makes the index and the work tree match entries. Files which
+// are unchanged are left alone, and tracked files which aren't in entries
+// deleted; untracked files are kept.
+func (r *Repository) resetHard(idx *index, entries []*indexEntry) error {
+	old := map[string]*indexEntry{}
+	for _, e := range idx.entries {
+		old[e.path] = e
+	}
+	wanted := map[string]bool{}
+	for _, e := range entries {
+		wanted[e.path] = true
+	}
+	for _, e := range idx.entries {
+		if !wanted[e.path] {
+			if err := r.removeWorkTreeFile(e.path); err != nil {
+				return err
+			}
+		}
+	}
+
+	result := []*indexEntry{}
+	for _, e := range entries {
+		if o, ok := old[e.path]; ok && o.stage() == 0 && o.sha == e.sha && o.mode == e.mode {
+			modified, err := r.isEntryModified(o)
+			if err != nil {
+				return err
+			}
+			if !modified {
+				result = append(result, o)
+				continue
+			}
+		}
+		if err := r.checkoutEntry(e); err != nil {
+			return err
+		}
+		result = append(result, e)
+	}
+	idx.entries = result
+	idx.sort()
+	return nil
+}
@@ -0,0 +1,137 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResetIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Init(filepath.Join(dir, "repo"), "", "", false)
+	repo, err := discoverRepo(filepath.Join(dir, "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := func(content string) string {
+		sha, err := createBlob(repo, []byte(content)).Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	entries := func(files map[string]string) []*indexEntry {
+		list := []*indexEntry{}
+		for name, content := range files {
+			list = append(list, &indexEntry{mode: 0100644, sha: blob(content), path: name})
+		}
+		return list
+	}
+
+	idx := &index{repo: repo}
+	if err := repo.resetHard(idx, entries(map[string]string{"a": "a\n", "d/b": "b\n", "d/c": "c\n"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.write(); err != nil {
+		t.Fatal(err)
+	}
+	if clean, err := repo.isWorkTreeClean(); err != nil || clean {
+		t.Errorf("expected changes staged against the unborn HEAD (%v)", err)
+	}
+
+	// A hard reset rewrites changed files, and deletes those which are no
+	// longer tracked, along with their empty directories.
+	ioutil.WriteFile(filepath.Join(repo.workTree, "a"), []byte("changed\n"), 0666)
+	if err := repo.resetHard(idx, entries(map[string]string{"a": "a\n", "e": "e\n"})); err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := ioutil.ReadFile(filepath.Join(repo.workTree, "a")); err != nil || string(bs) != "a\n" {
+		t.Errorf("a: got %q (%v)", bs, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.workTree, "d")); !os.IsNotExist(err) {
+		t.Errorf("d: expected to be deleted (%v)", err)
+	}
+
+	// A mixed reset of some paths leaves the others staged, and reports
+	// the files that differ from the new index.
+	specs, err := parsePathspecs([]string{"e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.resetEntries(entries(map[string]string{"a": "other\n", "e": "e2\n"}), specs)
+	changes, err := repo.refreshIndex(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"M\te"}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes: got %q, want %q", changes, want)
+	}
+	if e := idx.entry("a"); e == nil || e.sha != blob("a\n") || e.size != 2 {
+		t.Errorf("a: expected to keep its entry, got %v", e)
+	}
+}
+
+func TestResetOrigHead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := Init(filepath.Join(dir, "repo"), "", "", false)
+	commit := func(content string, parents ...string) string {
+		tree := createTree(repo, nil)
+		tree.leaves = []Leaf{{ModeBlob, "f", saveObject(t, createBlob(repo, []byte(content)))}}
+		return saveCommit(t, repo, saveObject(t, tree), content, parents...)
+	}
+	first := commit("one\n")
+	second := commit("two\n", first)
+	if err := repo.writeRef("refs/heads/master", second); err != nil {
+		t.Fatal(err)
+	}
+	treeSHA, err := resolveRev(repo, second, TypeTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.checkoutIndex(treeSHA); err != nil {
+		t.Fatal(err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(repo.workTree); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(head, content string) {
+		t.Helper()
+		if sha, err := repo.readRef("HEAD", nil); err != nil || sha != head {
+			t.Errorf("HEAD: got %v, want %v (%v)", sha, head, err)
+		}
+		if bs, err := ioutil.ReadFile("f"); err != nil || string(bs) != content {
+			t.Errorf("f: got %q, want %q (%v)", bs, content, err)
+		}
+	}
+	Reset([]string{"HEAD~1"}, nil, ResetOptions{Mode: ResetHard, Quiet: true})
+	check(first, "one\n")
+	if sha, err := resolveRev(repo, "ORIG_HEAD", ""); err != nil || sha != second {
+		t.Errorf("ORIG_HEAD: got %v, want %v (%v)", sha, second, err)
+	}
+
+	// Resetting to ORIG_HEAD undoes the reset.
+	Reset([]string{"ORIG_HEAD"}, nil, ResetOptions{Mode: ResetHard, Quiet: true})
+	check(second, "two\n")
+}
@@ -0,0 +1,885 @@
+package repo
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Orders in which a RevWalk can return commits. All of them show
+// children before their parents. Without one, commits are returned as
+// they are walked, newest first, which only differs when commit dates go
+// back in time.
+const (
+	SortDate       = "date"
+	SortTopo       = "topo"
+	SortAuthorDate = "author-date"
+)
+
+type RevWalkOptions struct {
+	Sort         string
+	FirstParent  bool
+	MaxCount     int // negative means no limit
+	Since        time.Time
+	Until        time.Time
+	AncestryPath bool
+	Merges       bool
+	NoMerges     bool
+	// Paths limits the walk to the commits changing these pathspecs.
+	// FullHistory follows every parent of a merge instead of one it got
+	// the paths from, and Follow tracks the renames of a single file.
+	Paths       []string
+	FullHistory bool
+	Follow      bool
+	// NoWalk lists the given commits only, without their ancestors.
+	NoWalk bool
+}
+
+// RevCommit is a commit returned by a RevWalk. Left is set for commits
+// only reachable from the left side of a symmetric difference "A...B".
+// Its parents, tree and date come from the commit-graph when possible;
+// the commit object itself is only read when asked for.
+type RevCommit struct {
+	SHA     string
+	Tree    string
+	Parents []string
+	Left    bool
+
+	repo       *Repository
+	commit     *Commit
+	commitTime int64
+	generation uint32
+	index      int
+	date       int64
+	// treesame is set when the commit doesn't change the paths of the
+	// walk, and followed holds the parents history simplification kept.
+	treesame bool
+	followed []string
+}
+
+// Commit reads the commit object.
+func (c *RevCommit) Commit() (*Commit, error) {
+	if c.commit == nil {
+		commit := createCommit(c.repo, nil)
+		if err := commit.Read(c.SHA); err != nil {
+			return nil, err
+		}
+		c.commit = commit
+	}
+	return c.commit, nil
+}
+
+// RevWalk lists the commits reachable from a set of tips but not from a
+// set of bottoms, in the spirit of "git rev-list".
+type RevWalk struct {
+	repo    *Repository
+	opts    RevWalkOptions
+	tips    []string
+	bottoms []string
+	// symmetric holds the two sides of each "A...B" range.
+	symmetric [][2]string
+	// tags holds the tag objects met while peeling the tips, the outermost
+	// one named by the revision and the others by their tag name, and
+	// bottomTags the ones met while peeling the bottoms.
+	tags       []revTag
+	bottomTags []string
+
+	commits       map[string]*RevCommit
+	uninteresting map[string]bool
+	prepared      bool
+	// queue holds the commits to walk, newest first. queued tells the
+	// commits ever added to it, and walked the ones taken out.
+	queue  revHeap
+	queued map[string]bool
+	walked map[string]bool
+	// limited is set when the walk must find every commit to return
+	// before the first one, to sort them or to know which are on the
+	// ancestry path. They are then held in result. Otherwise commits are
+	// returned as they are walked, and count tells how many were.
+	limited bool
+	result  []*RevCommit
+	count   int
+
+	// rewrite is set when the parents of the commits are rewritten to
+	// draw the graph they form.
+	rewrite bool
+	// mergeDiffs is set when merges are shown with their combined diff.
+	// Following a file, like git, other merges are left out.
+	mergeDiffs bool
+	// shown holds the commits passing the filters of the walk. In a
+	// limited walk, it includes the ones past MaxCount.
+	shown map[string]bool
+	specs []pathspec
+	// followPath is the name of the followed file in each commit.
+	followPath map[string]string
+}
+
+type revTag struct {
+	sha  string
+	name string
+}
+
+func newRevWalk(repo *Repository, opts RevWalkOptions) *RevWalk {
+	return &RevWalk{
+		repo:          repo,
+		opts:          opts,
+		commits:       map[string]*RevCommit{},
+		uninteresting: map[string]bool{},
+		queued:        map[string]bool{},
+		walked:        map[string]bool{},
+		shown:         map[string]bool{},
+		followPath:    map[string]string{},
+	}
+}
+
+// Push adds a revision to the walk. It understands "^X" to exclude X and
+// its ancestors, "A..B" for "^A B" and "A...B" for the commits reachable
+// from either side but not from both. An empty side stands for HEAD.
+func (w *RevWalk) Push(rev string) error {
+	if strings.HasPrefix(rev, "^") {
+		sha, err := w.resolve(rev[1:], true)
+		if err != nil {
+			return err
+		}
+		w.bottoms = append(w.bottoms, sha)
+		return nil
+	}
+
+	sep := ""
+	if strings.Contains(rev, "...") {
+		sep = "..."
+	} else if strings.Contains(rev, "..") {
+		sep = ".."
+	}
+	if sep == "" {
+		sha, err := w.resolve(rev, false)
+		if err != nil {
+			return err
+		}
+		w.tips = append(w.tips, sha)
+		return nil
+	}
+
+	sides := strings.SplitN(rev, sep, 2)
+	shas := [2]string{}
+	for i, side := range sides {
+		if side == "" {
+			side = "HEAD"
+		}
+		sha, err := w.resolve(side, sep == ".." && i == 0)
+		if err != nil {
+			return err
+		}
+		shas[i] = sha
+	}
+	if sep == ".." {
+		w.bottoms = append(w.bottoms, shas[0])
+		w.tips = append(w.tips, shas[1])
+	} else {
+		w.symmetric = append(w.symmetric, shas)
+		w.tips = append(w.tips, shas[0], shas[1])
+	}
+	return nil
+}
+
+// resolve resolves a tip, or a bottom, to a commit, keeping the tags met on
+// the way for listing objects.
+func (w *RevWalk) resolve(rev string, bottom bool) (string, error) {
+	hash, err := resolveName(w.repo, rev)
+	if err != nil {
+		return "", err
+	}
+	name := rev
+	for depth := 0; ; depth++ {
+		obj, err := w.repo.readObject(hash)
+		if err != nil {
+			return "", err
+		}
+		tag, ok := obj.(*Tag)
+		if !ok || tag.Object() == "" {
+			break
+		}
+		if depth > 0 {
+			name = tag.TagName()
+		}
+		if bottom {
+			w.bottomTags = append(w.bottomTags, hash)
+		} else {
+			w.tags = append(w.tags, revTag{hash, name})
+		}
+		hash = tag.Object()
+	}
+	return peelRev(w.repo, hash, TypeCommit)
+}
+
+// Next returns the next commit of the walk, or io.EOF once all of them
+// have been returned.
+func (w *RevWalk) Next() (*RevCommit, error) {
+	if !w.prepared {
+		if err := w.prepare(); err != nil {
+			return nil, err
+		}
+		w.prepared = true
+	}
+	if w.limited {
+		if len(w.result) == 0 {
+			return nil, io.EOF
+		}
+		c := w.result[0]
+		w.result = w.result[1:]
+		return c, nil
+	}
+
+	for w.opts.MaxCount < 0 || w.count < w.opts.MaxCount {
+		c, err := w.walk()
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			break
+		}
+		if w.show(c) {
+			w.count++
+			return c, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+func (w *RevWalk) lookup(sha string) (*RevCommit, error) {
+	if c, ok := w.commits[sha]; ok {
+		return c, nil
+	}
+	c, err := lookupRevCommit(w.repo, sha)
+	if err != nil {
+		return nil, err
+	}
+	c.index = len(w.commits)
+	c.date = c.commitTime
+	if w.opts.Sort == SortAuthorDate {
+		commit, err := c.Commit()
+		if err != nil {
+			return nil, err
+		}
+		author, _ := commit.Author()
+		c.date = 0
+		if !author.When.IsZero() {
+			c.date = author.When.Unix()
+		}
+	}
+	w.commits[sha] = c
+	return c, nil
+}
+
+// lookupRevCommit reads the parents and date of a commit from the
+// commit-graph, or from the object when the graph doesn't have it.
+func lookupRevCommit(repo *Repository, sha string) (*RevCommit, error) {
+	c := &RevCommit{SHA: sha, repo: repo, generation: graphNoGen}
+	if g := repo.commitGraph(); g != nil {
+		if pos, ok := g.lookup(sha); ok {
+			if gc, err := g.commit(pos); err == nil {
+				c.Tree, c.Parents = gc.tree, gc.parents
+				c.commitTime, c.generation = gc.commitTime, gc.generation
+				return c, nil
+			}
+		}
+	}
+
+	commit, err := c.Commit()
+	if err != nil {
+		return nil, err
+	}
+	c.Tree, c.Parents = commit.Tree(), commit.Parents()
+	committer, _ := commit.Committer()
+	if !committer.When.IsZero() {
+		c.commitTime = committer.When.Unix()
+	}
+	return c, nil
+}
+
+// parents returns the parents followed by the walk.
+func (w *RevWalk) parents(c *RevCommit) []string {
+	if c.followed != nil {
+		return c.followed
+	}
+	parents := c.Parents
+	if w.opts.FirstParent && len(parents) > 1 {
+		return parents[:1]
+	}
+	return parents
+}
+
+// add queues the commit sha, once, for the walk. The parents of a commit
+// on the left side of "A...B" are on the left side too.
+func (w *RevWalk) add(sha string, left bool) error {
+	if w.queued[sha] {
+		return nil
+	}
+	c, err := w.lookup(sha)
+	if err != nil {
+		return err
+	}
+	w.queued[sha] = true
+	c.Left = left
+	heap.Push(&w.queue, c)
+	return nil
+}
+
+// walk takes the newest commit out of the queue, and queues the parents
+// it follows. Uninteresting commits make their parents uninteresting, and
+// are skipped. It returns nil when the queue is empty or only holds
+// uninteresting commits, as nothing else can be reached from them.
+func (w *RevWalk) walk() (*RevCommit, error) {
+	for len(w.queue) > 0 && !w.everybodyUninteresting() {
+		c := heap.Pop(&w.queue).(*RevCommit)
+		w.walked[c.SHA] = true
+		if w.uninteresting[c.SHA] {
+			w.markParentsUninteresting(c)
+			for _, p := range c.Parents {
+				if err := w.add(p, false); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if err := w.simplify(c); err != nil {
+			return nil, err
+		}
+		// Like git, the parents of a commit older than Since are left out,
+		// so that the walk ends there.
+		if w.opts.NoWalk || (!w.opts.Since.IsZero() && c.commitTime < w.opts.Since.Unix()) {
+			return c, nil
+		}
+		for _, p := range w.parents(c) {
+			if err := w.add(p, c.Left); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	}
+	return nil, nil
+}
+
+// everybodyUninteresting tells whether all the queued commits are
+// uninteresting.
+func (w *RevWalk) everybodyUninteresting() bool {
+	for _, c := range w.queue {
+		if !w.uninteresting[c.SHA] {
+			return false
+		}
+	}
+	return true
+}
+
+// markParentsUninteresting marks the parents of c as uninteresting, and
+// the ancestors of those already walked, which were taken for interesting
+// until now.
+func (w *RevWalk) markParentsUninteresting(c *RevCommit) {
+	stack := append([]string{}, c.Parents...)
+	for len(stack) > 0 {
+		sha := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if w.uninteresting[sha] {
+			continue
+		}
+		w.uninteresting[sha] = true
+		if w.walked[sha] {
+			stack = append(stack, w.commits[sha].Parents...)
+		}
+	}
+}
+
+func (w *RevWalk) prepare() error {
+	if len(w.opts.Paths) > 0 {
+		if w.opts.Follow && len(w.opts.Paths) != 1 {
+			return fmt.Errorf("--follow requires exactly one pathspec")
+		}
+		specs, err := parsePathspecs(w.opts.Paths)
+		if err != nil {
+			return err
+		}
+		w.specs = specs
+	}
+
+	// For "A...B", the merge bases and their ancestors are excluded, and
+	// the commits only reachable from A are on the left.
+	bottoms := append([]string{}, w.bottoms...)
+	left := map[string]bool{}
+	for _, pair := range w.symmetric {
+		bases, err := w.repo.mergeBases(pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+		bottoms = append(bottoms, bases...)
+		left[pair[0]] = true
+	}
+	for _, sha := range bottoms {
+		w.uninteresting[sha] = true
+		if err := w.add(sha, false); err != nil {
+			return err
+		}
+	}
+	for _, sha := range w.tips {
+		if err := w.add(sha, left[sha]); err != nil {
+			return err
+		}
+	}
+
+	// Commits are returned as they are walked, unless some of them may
+	// turn out to be uninteresting later, or they must be sorted.
+	w.limited = len(bottoms) > 0 || w.opts.Sort != "" || w.opts.AncestryPath || w.rewrite
+	if !w.limited {
+		return nil
+	}
+
+	walked := []*RevCommit{}
+	for {
+		c, err := w.walk()
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			break
+		}
+		walked = append(walked, c)
+	}
+	interesting := []*RevCommit{}
+	seen := map[string]bool{}
+	for _, c := range walked {
+		if !w.uninteresting[c.SHA] {
+			interesting = append(interesting, c)
+			seen[c.SHA] = true
+		}
+	}
+
+	sorted := w.sort(interesting, seen)
+	onPath := w.ancestryPath(seen)
+	for _, c := range sorted {
+		if onPath != nil && !onPath[c.SHA] {
+			continue
+		}
+		if !w.show(c) {
+			continue
+		}
+		if w.opts.MaxCount < 0 || len(w.result) < w.opts.MaxCount {
+			w.result = append(w.result, c)
+		}
+	}
+	return nil
+}
+
+// show tells whether c passes the filters of the walk, and records it in
+// shown when it does.
+func (w *RevWalk) show(c *RevCommit) bool {
+	if c.treesame && !w.joinsHistory(c) {
+		return false
+	}
+	nParents := len(c.Parents)
+	if (w.opts.Merges && nParents < 2) || (w.opts.NoMerges && nParents > 1) {
+		return false
+	}
+	if w.opts.Follow && nParents > 1 && !w.opts.FirstParent && !w.mergeDiffs && !w.rewrite {
+		return false
+	}
+	if !w.opts.Since.IsZero() && c.commitTime < w.opts.Since.Unix() {
+		return false
+	}
+	if !w.opts.Until.IsZero() && c.commitTime > w.opts.Until.Unix() {
+		return false
+	}
+	w.shown[c.SHA] = true
+	return true
+}
+
+// simplify finds out whether c changes the paths of the walk, and which
+// of its parents to follow. Like git's default history simplification, a
+// commit TREESAME to a parent, that is with the same content for these
+// paths, only follows that parent. With FullHistory every parent is
+// followed, and a merge is TREESAME when it is TREESAME to all of them.
+func (w *RevWalk) simplify(c *RevCommit) error {
+	if len(w.specs) == 0 {
+		return nil
+	}
+	specs := w.specs
+	if w.opts.Follow {
+		if _, ok := w.followPath[c.SHA]; !ok {
+			w.followPath[c.SHA] = w.specs[0].literal
+		}
+		specs = []pathspec{{literal: w.followPath[c.SHA]}}
+	}
+
+	parents := c.Parents
+	if w.opts.FirstParent && len(parents) > 1 {
+		parents = parents[:1]
+	}
+	if len(parents) == 0 {
+		changed, err := w.repo.pathsChanged("", c.Tree, specs)
+		c.treesame = !changed
+		return err
+	}
+
+	// Every parent learns the name of the file before any TREESAME check,
+	// so that none is left with the name the walk was given.
+	if w.opts.Follow {
+		for _, sha := range parents {
+			p, err := w.lookup(sha)
+			if err != nil {
+				return err
+			}
+			if err := w.followRename(c, p, specs[0].literal); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.treesame = true
+	followed := []string{}
+	for i, sha := range parents {
+		p, err := w.lookup(sha)
+		if err != nil {
+			return err
+		}
+		changed := true
+		if i == 0 && !w.maybeChanged(c, specs) {
+			changed = false
+		} else if changed, err = w.repo.pathsChanged(p.Tree, c.Tree, specs); err != nil {
+			return err
+		}
+		// A parent on the uninteresting side of the walk doesn't hide the
+		// changes brought by the other ones.
+		if !changed && !w.opts.FullHistory && !w.uninteresting[sha] {
+			c.treesame = true
+			c.followed = []string{sha}
+			return nil
+		}
+		if changed {
+			c.treesame = false
+		}
+		followed = append(followed, sha)
+	}
+	c.followed = followed
+	return nil
+}
+
+// maybeChanged uses the Bloom filters of the commit-graph to tell when
+// literal pathspecs can't have changed since the first parent.
+func (w *RevWalk) maybeChanged(c *RevCommit, specs []pathspec) bool {
+	for _, p := range specs {
+		if p.glob != nil || p.literal == "" || p.literal == "." || w.repo.maybeChangedPath(c.SHA, p.literal) {
+			return true
+		}
+	}
+	return false
+}
+
+// followRename records the name the followed file had in parent p of c:
+// the same name, or the one it was renamed from.
+func (w *RevWalk) followRename(c, p *RevCommit, filePath string) error {
+	if _, ok := w.followPath[p.SHA]; ok {
+		return nil
+	}
+	w.followPath[p.SHA] = filePath
+	_, inParent, err := w.repo.lookupTreePath(p.Tree, filePath)
+	if err != nil || inParent {
+		return err
+	}
+	files, err := w.repo.diffFiles(p.Tree, c.Tree, nil)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.newPath == filePath && f.renamed() {
+			w.followPath[p.SHA] = f.oldPath
+		}
+	}
+	return nil
+}
+
+// diffSpecs returns the pathspecs a diff of c against its parent is
+// limited to. With Follow, they are the names of the file on each side.
+func (w *RevWalk) diffSpecs(c *RevCommit, parent string) []pathspec {
+	if !w.opts.Follow || len(w.specs) == 0 {
+		return w.specs
+	}
+	specs := []pathspec{{literal: w.followPath[c.SHA]}}
+	if old, ok := w.followPath[parent]; ok && old != specs[0].literal {
+		specs = append(specs, pathspec{literal: old})
+	}
+	return specs
+}
+
+// joinsHistory reports whether c is a merge that must be shown to tie
+// together the lines of history of the graph, even though it doesn't
+// change the paths of the walk.
+func (w *RevWalk) joinsHistory(c *RevCommit) bool {
+	if !w.rewrite {
+		return false
+	}
+	n := 0
+	for _, p := range w.parents(c) {
+		if !w.uninteresting[p] {
+			n++
+		}
+	}
+	return n >= 2
+}
+
+// rewriteParents replaces the parents of c that don't change the paths
+// of the walk with their closest ancestors that do, so that the shown
+// commits still form a graph, and keeps the ones that are shown.
+func (w *RevWalk) rewriteParents(c *RevCommit) []string {
+	out := []string{}
+	added := map[string]bool{}
+	seen := map[string]bool{}
+	stack := append([]string{}, w.parents(c)...)
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	for len(stack) > 0 {
+		sha := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		p, ok := w.commits[sha]
+		if !ok || w.uninteresting[sha] {
+			continue
+		}
+		if w.shown[sha] {
+			if !added[sha] {
+				added[sha] = true
+				out = append(out, sha)
+			}
+			continue
+		}
+		if !p.treesame {
+			continue
+		}
+		parents := w.parents(p)
+		for i := len(parents) - 1; i >= 0; i-- {
+			stack = append(stack, parents[i])
+		}
+	}
+	return out
+}
+
+// ancestryPath returns the interesting commits that descend from one of
+// the bottoms, or nil when the walk isn't limited to the ancestry path.
+func (w *RevWalk) ancestryPath(interesting map[string]bool) map[string]bool {
+	if !w.opts.AncestryPath || len(w.bottoms) == 0 {
+		return nil
+	}
+	bottoms := map[string]bool{}
+	for _, sha := range w.bottoms {
+		bottoms[sha] = true
+	}
+
+	onPath := map[string]bool{}
+	done := map[string]bool{}
+	var visit func(sha string) bool
+	visit = func(sha string) bool {
+		if done[sha] {
+			return onPath[sha]
+		}
+		done[sha] = true
+		for _, p := range w.parents(w.commits[sha]) {
+			if bottoms[p] || (interesting[p] && visit(p)) {
+				onPath[sha] = true
+			}
+		}
+		return onPath[sha]
+	}
+	for sha := range interesting {
+		visit(sha)
+	}
+	return onPath
+}
+
+// sort orders commits so that no parent comes before one of its children.
+// Among the commits that are ready to be shown, the topo order follows the
+// line of history it was on, like git, while the other orders pick the
+// most recent one.
+func (w *RevWalk) sort(commits []*RevCommit, interesting map[string]bool) []*RevCommit {
+	children := map[string]int{}
+	for _, c := range commits {
+		for _, p := range w.sortParents(c) {
+			if interesting[p] {
+				children[p]++
+			}
+		}
+	}
+
+	tips := []*RevCommit{}
+	for _, c := range commits {
+		if children[c.SHA] == 0 {
+			tips = append(tips, c)
+		}
+	}
+	// Newest tips first, so that the topo order starts from them.
+	sort.SliceStable(tips, func(i, j int) bool { return revLess(tips[j], tips[i]) })
+	queue := &revQueue{lifo: w.opts.Sort == SortTopo}
+	for i := len(tips) - 1; i >= 0; i-- {
+		queue.push(tips[i])
+	}
+
+	sorted := make([]*RevCommit, 0, len(commits))
+	for queue.len() > 0 {
+		c := queue.pop()
+		sorted = append(sorted, c)
+		for _, p := range w.sortParents(c) {
+			if !interesting[p] {
+				continue
+			}
+			children[p]--
+			if children[p] == 0 {
+				queue.push(w.commits[p])
+			}
+		}
+	}
+	return sorted
+}
+
+// sortParents returns the parents of c that must come after it. Like git,
+// the order accounts for every parent of a merge even when the walk only
+// follows the first one.
+func (w *RevWalk) sortParents(c *RevCommit) []string {
+	if c.followed != nil && !w.opts.FirstParent {
+		return c.followed
+	}
+	return c.Parents
+}
+
+// revLess reports whether a should be shown after b.
+func revLess(a, b *RevCommit) bool {
+	if a.date != b.date {
+		return a.date < b.date
+	}
+	return a.index > b.index
+}
+
+// revQueue holds the commits ready to be shown: a priority queue by date,
+// or a stack when lifo is set.
+type revQueue struct {
+	heap revHeap
+	lifo bool
+}
+
+func (q *revQueue) len() int { return len(q.heap) }
+
+func (q *revQueue) push(c *RevCommit) {
+	if q.lifo {
+		q.heap = append(q.heap, c)
+		return
+	}
+	heap.Push(&q.heap, c)
+}
+
+func (q *revQueue) pop() *RevCommit {
+	if q.lifo {
+		c := q.heap[len(q.heap)-1]
+		q.heap = q.heap[:len(q.heap)-1]
+		return c
+	}
+	return heap.Pop(&q.heap).(*RevCommit)
+}
+
+type revHeap []*RevCommit
+
+func (h revHeap) Len() int            { return len(h) }
+func (h revHeap) Less(i, j int) bool  { return revLess(h[j], h[i]) }
+func (h revHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *revHeap) Push(x interface{}) { *h = append(*h, x.(*RevCommit)) }
+
+func (h *revHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// listObjects writes the tags of the tips, then the trees and blobs of
+// commits, that aren't reachable from the edge of the walk, each followed by
+// its name or path. The edge is made of the bottoms and the uninteresting
+// parents of interesting commits.
+func (w *RevWalk) listObjects(sb *strings.Builder, commits []*RevCommit) error {
+	edge := append([]string{}, w.bottoms...)
+	for _, c := range w.commits {
+		if w.uninteresting[c.SHA] {
+			continue
+		}
+		for _, p := range c.Parents {
+			if w.uninteresting[p] {
+				edge = append(edge, p)
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, sha := range w.bottomTags {
+		seen[sha] = true
+	}
+	for _, sha := range edge {
+		c, err := w.lookup(sha)
+		if err != nil {
+			return err
+		}
+		if err := w.walkTree(c.Tree, "", seen, nil); err != nil {
+			return err
+		}
+	}
+	for _, tag := range w.tags {
+		if !seen[tag.sha] {
+			seen[tag.sha] = true
+			sb.WriteString(fmt.Sprintf("%v %v\n", tag.sha, tag.name))
+		}
+	}
+	for _, c := range commits {
+		if err := w.walkTree(c.Tree, "", seen, sb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTree marks the objects of a tree as seen, and writes the ones not
+// seen before to sb when it isn't nil.
+func (w *RevWalk) walkTree(sha string, treePath string, seen map[string]bool, sb *strings.Builder) error {
+	if seen[sha] {
+		return nil
+	}
+	seen[sha] = true
+	if sb != nil {
+		sb.WriteString(fmt.Sprintf("%v %v\n", sha, treePath))
+	}
+
+	tree := createTree(w.repo, nil)
+	if err := tree.Read(sha); err != nil {
+		return err
+	}
+	for _, leaf := range tree.leaves {
+		leafPath := path.Join(treePath, leaf.path)
+		switch leaf.mode {
+		case ModeGitlink:
+			continue
+		case ModeTree:
+			if err := w.walkTree(leaf.sha, leafPath, seen, sb); err != nil {
+				return err
+			}
+		default:
+			if seen[leaf.sha] {
+				continue
+			}
+			seen[leaf.sha] = true
+			if sb != nil {
+				sb.WriteString(fmt.Sprintf("%v %v\n", leaf.sha, leafPath))
+			}
+		}
+	}
+	return nil
+}
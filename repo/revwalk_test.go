@@ -0,0 +1,318 @@
+package repo
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// buildHistory creates the history below, where each commit is named
+// after its message and dated in the order of the names.
+//
+//	1 - 2 - 5 - 6 - 7
+//	     \     /
+//	      3 - 4
+func buildHistory(t *testing.T, repo *Repository) map[string]string {
+	tree, err := createTree(repo, nil).Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shas := map[string]string{}
+	commit := func(name string, date int, parents ...string) {
+		c := createCommit(repo, nil)
+		c.kvlm = append(c.kvlm, KList{key: "tree", list: []string{tree}})
+		if len(parents) > 0 {
+			list := []string{}
+			for _, p := range parents {
+				list = append(list, shas[p])
+			}
+			c.kvlm = append(c.kvlm, KList{key: "parent", list: list})
+		}
+		ident := fmt.Sprintf("A U Thor <author@example.com> %v +0000", 1000+date)
+		c.kvlm = append(c.kvlm,
+			KList{key: "author", list: []string{ident}},
+			KList{key: "committer", list: []string{ident}},
+			KList{key: "", list: []string{name + "\n"}})
+		sha, err := c.Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		shas[name] = sha
+	}
+	commit("1", 1)
+	commit("2", 2, "1")
+	commit("3", 3, "2")
+	commit("5", 4, "2")
+	commit("4", 5, "3")
+	commit("6", 6, "5", "4")
+	commit("7", 7, "6")
+	return shas
+}
+
+func TestRevWalk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	shas := buildHistory(t, repo)
+	names := map[string]string{}
+	pairs := []string{}
+	for name, sha := range shas {
+		names[sha] = name
+		pairs = append(pairs, name, sha)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	cases := []struct {
+		revs []string
+		opts RevWalkOptions
+		want string
+	}{
+		{[]string{"7"}, RevWalkOptions{}, "7 6 4 5 3 2 1"},
+		{[]string{"7"}, RevWalkOptions{Sort: SortTopo}, "7 6 4 3 5 2 1"},
+		{[]string{"7"}, RevWalkOptions{FirstParent: true}, "7 6 5 2 1"},
+		{[]string{"^3", "7"}, RevWalkOptions{}, "7 6 4 5"},
+		{[]string{"3..7"}, RevWalkOptions{AncestryPath: true}, "7 6 4"},
+		{[]string{"5...4"}, RevWalkOptions{}, "4 <5 3"},
+		{[]string{"7"}, RevWalkOptions{Merges: true}, "6"},
+		{[]string{"7"}, RevWalkOptions{NoMerges: true, MaxCount: 2}, "7 4"},
+	}
+	for _, c := range cases {
+		if c.opts.MaxCount == 0 {
+			c.opts.MaxCount = -1
+		}
+		walk := newRevWalk(repo, c.opts)
+		for _, rev := range c.revs {
+			if err := walk.Push(replacer.Replace(rev)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := []string{}
+		for {
+			rc, err := walk.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := names[rc.SHA]
+			if rc.Left {
+				name = "<" + name
+			}
+			got = append(got, name)
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Errorf("%v %+v: got %v, want %v", c.revs, c.opts, strings.Join(got, " "), c.want)
+		}
+	}
+}
+
+func TestRevWalkStopsEarly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	shas := buildHistory(t, repo)
+
+	// Only the commits needed to return the first ones are read.
+	cases := []struct {
+		revs     []string
+		maxCount int
+		want     int
+	}{
+		{[]string{shas["7"]}, 1, 2},
+		{[]string{shas["7"]}, 3, 5},
+		{[]string{"^" + shas["6"], shas["7"]}, -1, 2},
+		{[]string{"^" + shas["4"], shas["7"]}, -1, 6},
+	}
+	for _, c := range cases {
+		walk := newRevWalk(repo, RevWalkOptions{MaxCount: c.maxCount})
+		for _, rev := range c.revs {
+			if err := walk.Push(rev); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for {
+			if _, err := walk.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(walk.commits) != c.want {
+			t.Errorf("%v -n%v: read %v commits, want %v", c.revs, c.maxCount, len(walk.commits), c.want)
+		}
+	}
+}
+
+func TestRevWalkPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	shas := map[string]string{}
+	names := map[string]string{}
+	// commit records a commit of files, given as sorted name and content
+	// pairs, with the space separated parents.
+	commit := func(name string, parents string, files ...string) {
+		tree := createTree(repo, nil)
+		for i := 0; i < len(files); i += 2 {
+			blob, err := createBlob(repo, []byte(files[i+1])).Save()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree.leaves = append(tree.leaves, Leaf{mode: ModeBlob, path: files[i], sha: blob})
+		}
+		treeSHA, err := tree.Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := createCommit(repo, nil)
+		c.kvlm = append(c.kvlm, KList{key: "tree", list: []string{treeSHA}})
+		if parents != "" {
+			list := []string{}
+			for _, p := range strings.Fields(parents) {
+				list = append(list, shas[p])
+			}
+			c.kvlm = append(c.kvlm, KList{key: "parent", list: list})
+		}
+		ident := fmt.Sprintf("A U Thor <author@example.com> %v +0000", 1000+len(shas))
+		c.kvlm = append(c.kvlm,
+			KList{key: "author", list: []string{ident}},
+			KList{key: "committer", list: []string{ident}},
+			KList{key: "", list: []string{name + "\n"}})
+		sha, err := c.Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		shas[name], names[sha] = sha, name
+	}
+	commit("1", "", "a", "one\n", "b", "one\n")
+	commit("2", "1", "a", "one\n", "b", "two\n")
+	commit("3", "2", "b", "two\n", "c", "one\n")
+	commit("4", "3", "b", "two\n", "c", "three\n")
+	// A merge changing f, which is renamed to g afterwards.
+	commit("5", "", "f", "1\n2\n3\n4\n")
+	commit("6", "5", "f", "one\n2\n3\n4\n")
+	commit("7", "5", "f", "1\n2\n3\nfour\n")
+	commit("8", "6 7", "f", "one\n2\n3\nfour\n")
+	commit("9", "8", "g", "one\n2\n3\nfour\n")
+	commit("10", "9", "g", "one\n2\n3\nfour\nfive\n")
+
+	cases := []struct {
+		tip  string
+		opts RevWalkOptions
+		want string
+	}{
+		{"4", RevWalkOptions{Paths: []string{"a"}}, "3 1"},
+		{"4", RevWalkOptions{Paths: []string{"b"}}, "2 1"},
+		{"4", RevWalkOptions{Paths: []string{"c"}}, "4 3"},
+		{"4", RevWalkOptions{Paths: []string{"c"}, Follow: true}, "4 3 1"},
+		{"4", RevWalkOptions{Paths: []string{"[ab]"}}, "3 2 1"},
+		{"4", RevWalkOptions{Paths: []string{"."}}, "4 3 2 1"},
+		{"10", RevWalkOptions{Paths: []string{"g"}}, "10 9"},
+		{"10", RevWalkOptions{Paths: []string{"g"}, Follow: true}, "10 9 7 6 5"},
+	}
+	for _, c := range cases {
+		c.opts.MaxCount = -1
+		walk := newRevWalk(repo, c.opts)
+		if err := walk.Push(shas[c.tip]); err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for {
+			rc, err := walk.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, names[rc.SHA])
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Errorf("%+v: got %v, want %v", c.opts, strings.Join(got, " "), c.want)
+		}
+	}
+}
+
+func TestRevWalkTagObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	shas := buildHistory(t, repo)
+	tag := func(name, target, targetType string) string {
+		data := fmt.Sprintf("object %v\ntype %v\ntag %v\ntagger A U Thor <author@example.com> 1000 +0000\n\n%v\n",
+			target, targetType, name, name)
+		sha, err := createTag(repo, []byte(data)).Save()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	inner := tag("inner", shas["6"], TypeCommit)
+	outer := tag("outer", inner, TypeTag)
+	if err := repo.writeRef("refs/tags/v2", outer); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tags of the tips come before the trees; the nested one is named
+	// by its tag name.
+	emptyTree, err := createTree(repo, nil).Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		revs []string
+		want string
+	}{
+		{[]string{"v2"}, fmt.Sprintf("%v v2\n%v inner\n%v \n", outer, inner, emptyTree)},
+		{[]string{shas["7"] + "..v2"}, fmt.Sprintf("%v v2\n%v inner\n", outer, inner)},
+		{[]string{"v2.." + shas["7"]}, ""},
+		{[]string{"v2~0", "^" + shas["5"]}, ""},
+	}
+	for _, c := range cases {
+		walk := newRevWalk(repo, RevWalkOptions{MaxCount: -1})
+		for _, rev := range c.revs {
+			if err := walk.Push(rev); err != nil {
+				t.Fatal(err)
+			}
+		}
+		commits := []*RevCommit{}
+		for {
+			rc, err := walk.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			commits = append(commits, rc)
+		}
+		var sb strings.Builder
+		if err := walk.listObjects(&sb, commits); err != nil {
+			t.Fatal(err)
+		}
+		if sb.String() != c.want {
+			t.Errorf("%v: got %q, want %q", c.revs, sb.String(), c.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const sshArmorWidth = 70
+
+// signingFormat returns gpg.format, which selects the kind of key used to
+// sign objects.
+func (r *Repository) signingFormat() (string, error) {
+	format, ok := r.configString("gpg.format")
+	if !ok || format == "" {
+		return "openpgp", nil
+	}
+	switch format {
+	case "openpgp", "ssh":
+		return format, nil
+	}
+	return "", fmt.Errorf("Unsupported signature format %v", format)
+}
+
+// signPayload signs payload with keyID, or with user.signingKey when keyID
+// is empty, and returns the armored signature.
+func (r *Repository) signPayload(payload []byte, keyID string) (string, error) {
+	if keyID == "" {
+		keyID, _ = r.configString("user.signingkey")
+	}
+	format, err := r.signingFormat()
+	if err != nil {
+		return "", err
+	}
+	if format == "ssh" {
+		return r.signSSH(payload, keyID)
+	}
+	return r.signPGP(payload, keyID)
+}
+
+// signPGP asks gpg for a detached armored signature, exactly like git. The
+// committer identity picks the key when none is configured.
+func (r *Repository) signPGP(payload []byte, keyID string) (string, error) {
+	if keyID == "" {
+		ident, err := r.ident(RoleCommitter)
+		if err != nil {
+			return "", err
+		}
+		keyID = ident[:strings.Index(ident, ">")+1]
+	}
+	program := "gpg"
+	if p, ok := r.configString("gpg.program"); ok && p != "" {
+		program = p
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, "--status-fd=2", "-bsau", keyID)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil || !strings.Contains(stderr.String(), "[GNUPG:] SIG_CREATED ") {
+		return "", fmt.Errorf("gpg failed to sign the data:\n%v", stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// signSSH signs payload with an SSH key. Unencrypted private keys are
+// used directly; anything else, such as a passphrase protected key or a
+// "key::" literal served by an agent, goes through ssh-keygen.
+func (r *Repository) signSSH(payload []byte, keyID string) (string, error) {
+	if keyID == "" {
+		return "", errors.New("user.signingKey needs to be set for ssh signing")
+	}
+	if strings.HasPrefix(keyID, "key::") || strings.HasPrefix(keyID, "ssh-") {
+		return r.signSSHWithKeygen(payload, strings.TrimPrefix(keyID, "key::"), true)
+	}
+
+	keyPath := expandConfigPath(keyID)
+	privPath := strings.TrimSuffix(keyPath, ".pub")
+	pemBytes, err := ioutil.ReadFile(privPath)
+	if err != nil {
+		return r.signSSHWithKeygen(payload, keyPath, false)
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return r.signSSHWithKeygen(payload, keyPath, false)
+	}
+	return signSSHPayload(signer, payload)
+}
+
+func signSSHPayload(signer ssh.Signer, payload []byte) (string, error) {
+	digest := sha512.Sum512(payload)
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: "sha512",
+		Hash:          digest[:],
+	})...)
+
+	var sig *ssh.Signature
+	var err error
+	// ssh-rsa signatures use SHA-1, which ssh-keygen refuses to verify.
+	if algSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algSigner.SignWithAlgorithm(rand.Reader, signed, ssh.SigAlgoRSASHA2512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	blob := append([]byte(sshSigMagic), ssh.Marshal(sshSignature{
+		Version:       1,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})...)
+	return armorSSHSignature(blob), nil
+}
+
+func armorSSHSignature(blob []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var sb strings.Builder
+	sb.WriteString(sshSignatureBegin + "\n")
+	for len(encoded) > sshArmorWidth {
+		sb.WriteString(encoded[:sshArmorWidth] + "\n")
+		encoded = encoded[sshArmorWidth:]
+	}
+	sb.WriteString(encoded + "\n")
+	sb.WriteString(sshSignatureEnd + "\n")
+	return sb.String()
+}
+
+// signSSHWithKeygen runs "ssh-keygen -Y sign", which writes the signature
+// next to the signed file. When literal is set, key holds a public key
+// whose private half lives in the ssh agent.
+func (r *Repository) signSSHWithKeygen(payload []byte, key string, literal bool) (string, error) {
+	program := "ssh-keygen"
+	if p, ok := r.configString("gpg.ssh.program"); ok && p != "" {
+		program = p
+	}
+
+	fPayload, err := ioutil.TempFile("", "pit_signing_buffer_")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(fPayload.Name())
+	defer os.Remove(fPayload.Name() + ".sig")
+	if _, err := fPayload.Write(payload); err != nil {
+		fPayload.Close()
+		return "", err
+	}
+	fPayload.Close()
+
+	args := []string{"-Y", "sign", "-n", sshSigNamespace, "-f", key}
+	if literal {
+		fKey, err := ioutil.TempFile("", "pit_signing_key_")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(fKey.Name())
+		fKey.WriteString(key + "\n")
+		fKey.Close()
+		args = []string{"-Y", "sign", "-n", sshSigNamespace, "-U", "-f", fKey.Name()}
+	}
+	args = append(args, fPayload.Name())
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(program, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("ssh-keygen failed to sign the data:\n%v", stderr.String())
+	}
+	sig, err := ioutil.ReadFile(fPayload.Name() + ".sig")
+	if err != nil {
+		return "", err
+	}
+	return string(sig), nil
+}
+
+// signCommit signs a commit and stores the signature in its gpgsig
+// header, right after the committer.
+func (r *Repository) signCommit(commit *Commit, keyID string) error {
+	payload, err := commit.Serialize()
+	if err != nil {
+		return err
+	}
+	sig, err := r.signPayload([]byte(payload), keyID)
+	if err != nil {
+		return err
+	}
+
+	header := KList{key: r.signatureHeader(), list: []string{strings.TrimSuffix(sig, "\n")}}
+	kvlm := []KList{}
+	inserted := false
+	for _, kl := range commit.kvlm {
+		if kl.key == "" && !inserted {
+			kvlm = append(kvlm, header)
+			inserted = true
+		}
+		kvlm = append(kvlm, kl)
+		if kl.key == "committer" && !inserted {
+			kvlm = append(kvlm, header)
+			inserted = true
+		}
+	}
+	if !inserted {
+		kvlm = append(kvlm, header)
+	}
+	commit.kvlm = kvlm
+	return nil
+}
+
+// signTag appends a signature to the message of a tag.
+func (r *Repository) signTag(tag *Tag, keyID string) error {
+	payload, err := tag.Serialize()
+	if err != nil {
+		return err
+	}
+	sig, err := r.signPayload([]byte(payload), keyID)
+	if err != nil {
+		return err
+	}
+	for i, kl := range tag.kvlm {
+		if kl.key == "" {
+			tag.kvlm[i].list = []string{kl.list[0] + sig}
+			return nil
+		}
+	}
+	tag.kvlm = append(tag.kvlm, KList{key: "", list: []string{sig}})
+	return nil
+}
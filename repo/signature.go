@@ -0,0 +1,545 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	pgpSignatureBegin = "-----BEGIN PGP SIGNATURE-----"
+	pgpMessageBegin   = "-----BEGIN PGP MESSAGE-----"
+	sshSignatureBegin = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureEnd   = "-----END SSH SIGNATURE-----"
+
+	sshSigMagic     = "SSHSIG"
+	sshSigNamespace = "git"
+)
+
+var errNoSignature = errors.New("no signature found")
+
+// signatureHeader returns the commit header carrying the signature for
+// the object format of the repository.
+func (r *Repository) signatureHeader() string {
+	if r.objectFormat() == FormatSHA256 {
+		return "gpgsig-sha256"
+	}
+	return "gpgsig"
+}
+
+// splitCommitSignature separates the signature headers of a raw commit
+// from the rest of it. The payload is the commit exactly as it was before
+// signing: every gpgsig header, including the continuation lines, is
+// removed and nothing else is touched.
+func splitCommitSignature(content string, header string) (string, string) {
+	var payload strings.Builder
+	sigs := map[string]*strings.Builder{}
+	current := ""
+	inHeader := true
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if !inHeader {
+			payload.WriteString(line)
+			continue
+		}
+		if line == "\n" {
+			inHeader = false
+			current = ""
+			payload.WriteString(line)
+			continue
+		}
+		if current != "" && strings.HasPrefix(line, " ") {
+			sigs[current].WriteString(line[1:])
+			continue
+		}
+		current = ""
+		for _, key := range []string{"gpgsig", "gpgsig-sha256"} {
+			if strings.HasPrefix(line, key+" ") {
+				current = key
+				sigs[key] = &strings.Builder{}
+				sigs[key].WriteString(strings.TrimPrefix(line, key+" "))
+			}
+		}
+		if current == "" {
+			payload.WriteString(line)
+		}
+	}
+
+	sig, ok := sigs[header]
+	if !ok {
+		for _, s := range sigs {
+			sig = s
+		}
+	}
+	if sig == nil {
+		return payload.String(), ""
+	}
+	return payload.String(), sig.String()
+}
+
+// splitTagSignature separates a signature appended to the message of a
+// raw tag. The payload is everything before the signature block.
+func splitTagSignature(content string) (string, string) {
+	idx := -1
+	for _, begin := range []string{pgpSignatureBegin, pgpMessageBegin, sshSignatureBegin} {
+		if strings.HasPrefix(content, begin) {
+			idx = 0
+		}
+		if i := strings.LastIndex(content, "\n"+begin); i+1 > idx && i >= 0 {
+			idx = i + 1
+		}
+	}
+	if idx < 0 {
+		return content, ""
+	}
+	return content[:idx], content[idx:]
+}
+
+// verifyObject checks the signature of a commit or tag and describes the
+// signer. It fails when the object isn't signed or the signature is bad.
+func (r *Repository) verifyObject(objSHA string, objType string) (string, error) {
+	format, content, err := r.parseObject(objSHA)
+	if err != nil {
+		return "", err
+	}
+	if objType != "" && format != objType {
+		return "", fmt.Errorf("%v: cannot verify a non-%v object of type %v.", objSHA, objType, format)
+	}
+
+	var payload, sig string
+	switch format {
+	case TypeCommit:
+		payload, sig = splitCommitSignature(content, r.signatureHeader())
+	case TypeTag:
+		payload, sig = splitTagSignature(content)
+	default:
+		return "", fmt.Errorf("%v: cannot verify an object of type %v.", objSHA, format)
+	}
+	if sig == "" {
+		return "", errNoSignature
+	}
+	return r.verifySignature([]byte(payload), []byte(sig))
+}
+
+func (r *Repository) verifySignature(payload []byte, sig []byte) (string, error) {
+	if bytes.HasPrefix(sig, []byte(sshSignatureBegin)) {
+		return r.verifySSHSignature(payload, sig)
+	}
+	return r.verifyPGPSignature(payload, sig)
+}
+
+// verifyPGPSignature checks an armored OpenPGP detached signature against
+// the public keys known to the user.
+func (r *Repository) verifyPGPSignature(payload []byte, sig []byte) (string, error) {
+	keyring, err := r.pgpKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	block, err := armor.Decode(bytes.NewReader(sig))
+	if err != nil {
+		return "", fmt.Errorf("Malformed signature: %v", err)
+	}
+	pkt, err := packet.Read(block.Body)
+	if err != nil {
+		return "", fmt.Errorf("Malformed signature: %v", err)
+	}
+	sigPkt, ok := pkt.(*packet.Signature)
+	if !ok {
+		return "", errors.New("Malformed signature: not a signature packet")
+	}
+	keyID := "unknown"
+	if sigPkt.IssuerKeyId != nil {
+		keyID = fmt.Sprintf("%016X", *sigPkt.IssuerKeyId)
+	}
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(payload), bytes.NewReader(sig), nil)
+	if err == pgperrors.ErrUnknownIssuer {
+		return "", fmt.Errorf("Can't check signature: no public key %v", keyID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("BAD signature from key %v: %v", keyID, err)
+	}
+
+	uid := ""
+	if identity := signer.PrimaryIdentity(); identity != nil {
+		uid = identity.Name
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Signature made %v using key %v\n", sigPkt.CreationTime.Format("Mon Jan 2 15:04:05 2006 -0700"), keyID))
+	sb.WriteString(fmt.Sprintf("Good signature from \"%v\"\n", uid))
+	sb.WriteString(fmt.Sprintf("Primary key fingerprint: %X\n", signer.PrimaryKey.Fingerprint))
+	return sb.String(), nil
+}
+
+// pgpKeyring collects public keys from gpg.keyring, the classic
+// pubring.gpg of GnuPG and finally whatever "gpg --export" returns. They
+// are only loaded once, as a log may verify many commits.
+func (r *Repository) pgpKeyring() (openpgp.EntityList, error) {
+	if r.keyringLoaded {
+		return r.keyring, nil
+	}
+	files := []string{}
+	if p, ok := r.configString("gpg.keyring"); ok {
+		files = append(files, expandConfigPath(p))
+	}
+	gnupgHome := os.Getenv("GNUPGHOME")
+	if gnupgHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			gnupgHome = path.Join(home, ".gnupg")
+		}
+	}
+	if gnupgHome != "" {
+		files = append(files, path.Join(gnupgHome, "pubring.gpg"))
+	}
+
+	keyring := openpgp.EntityList{}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		keys, err := readKeyRing(data)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read keyring %v: %v", f, err)
+		}
+		keyring = append(keyring, keys...)
+	}
+
+	program := "gpg"
+	if p, ok := r.configString("gpg.program"); ok && p != "" {
+		program = p
+	}
+	if exported, err := exec.Command(program, "--export").Output(); err == nil && len(exported) > 0 {
+		if keys, err := readKeyRing(exported); err == nil {
+			keyring = append(keyring, keys...)
+		}
+	}
+	r.keyring, r.keyringLoaded = keyring, true
+	return keyring, nil
+}
+
+func readKeyRing(data []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+// sshSignature is the body of an SSH signature after the "SSHSIG" magic,
+// as described in the PROTOCOL.sshsig file of OpenSSH.
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is what the SSH key actually signs.
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func parseSSHSignature(armored []byte) (*sshSignature, error) {
+	text := strings.TrimSpace(string(armored))
+	if !strings.HasPrefix(text, sshSignatureBegin) || !strings.HasSuffix(text, sshSignatureEnd) {
+		return nil, errors.New("Malformed SSH signature: missing armor")
+	}
+	text = strings.TrimSuffix(strings.TrimPrefix(text, sshSignatureBegin), sshSignatureEnd)
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, fmt.Errorf("Malformed SSH signature: %v", err)
+	}
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) {
+		return nil, errors.New("Malformed SSH signature: bad magic")
+	}
+
+	var sig sshSignature
+	if err := ssh.Unmarshal(blob[len(sshSigMagic):], &sig); err != nil {
+		return nil, fmt.Errorf("Malformed SSH signature: %v", err)
+	}
+	if sig.Version != 1 {
+		return nil, fmt.Errorf("Unsupported SSH signature version %v", sig.Version)
+	}
+	return &sig, nil
+}
+
+func sshSigHash(algorithm string) (crypto.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return crypto.SHA256, nil
+	case "sha512":
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("Unsupported SSH signature hash %v", algorithm)
+}
+
+// verifySSHSignature checks an SSH signature and looks its key up in
+// gpg.ssh.allowedSignersFile to find out who made it.
+func (r *Repository) verifySSHSignature(payload []byte, armored []byte) (string, error) {
+	sig, err := parseSSHSignature(armored)
+	if err != nil {
+		return "", err
+	}
+	if sig.Namespace != sshSigNamespace {
+		return "", fmt.Errorf("Unexpected SSH signature namespace %q", sig.Namespace)
+	}
+	pubKey, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("Malformed SSH signature: %v", err)
+	}
+	hashFunc, err := sshSigHash(sig.HashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	var digest []byte
+	if hashFunc == crypto.SHA256 {
+		sum := sha256.Sum256(payload)
+		digest = sum[:]
+	} else {
+		sum := sha512.Sum512(payload)
+		digest = sum[:]
+	}
+	signed := append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          digest,
+	})...)
+
+	var blob ssh.Signature
+	if err := ssh.Unmarshal(sig.Signature, &blob); err != nil {
+		return "", fmt.Errorf("Malformed SSH signature: %v", err)
+	}
+	keyDesc := fmt.Sprintf("%v key %v", strings.ToUpper(strings.TrimPrefix(pubKey.Type(), "ssh-")), ssh.FingerprintSHA256(pubKey))
+	if err := pubKey.Verify(signed, &blob); err != nil {
+		return "", fmt.Errorf("BAD signature with %v", keyDesc)
+	}
+
+	if err := r.checkSSHRevocation(pubKey); err != nil {
+		return "", fmt.Errorf("BAD signature with %v: %v", keyDesc, err)
+	}
+	principals, problems, err := r.sshPrincipals(pubKey, signedTime(payload))
+	if err != nil {
+		return "", err
+	}
+	if len(principals) == 0 {
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("Good \"git\" signature with %v\n", keyDesc))
+		for _, p := range problems {
+			sb.WriteString(p + "\n")
+		}
+		sb.WriteString("No principal matched.")
+		return "", errors.New(sb.String())
+	}
+	return fmt.Sprintf("Good \"git\" signature for %v with %v\n", strings.Join(principals, ","), keyDesc), nil
+}
+
+// signedTime returns the date of the committer or tagger of a payload,
+// which is when the signature is taken to have been made, or the zero
+// time when there is none.
+func signedTime(payload []byte) time.Time {
+	for _, line := range strings.Split(string(payload), "\n") {
+		if line == "" {
+			break
+		}
+		if !strings.HasPrefix(line, "committer ") && !strings.HasPrefix(line, "tagger ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			break
+		}
+		if sec, err := strconv.ParseInt(fields[len(fields)-2], 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+		break
+	}
+	return time.Time{}
+}
+
+// sshPrincipals returns the principals allowed to sign with key at the
+// time when, according to the allowed signers file. Like "ssh-keygen
+// -Overify-time", lines whose valid-after and valid-before options don't
+// cover that time are left out; the second result tells why.
+func (r *Repository) sshPrincipals(key ssh.PublicKey, when time.Time) ([]string, []string, error) {
+	file, ok := r.configString("gpg.ssh.allowedsignersfile")
+	if !ok || file == "" {
+		return nil, nil, errors.New("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+	file = expandConfigPath(file)
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	principals := []string{}
+	problems := []string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		signer, err := parseAllowedSigner(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		if signer.certAuthority || !signer.allowsNamespace(sshSigNamespace) {
+			continue
+		}
+		if !bytes.Equal(signer.key.Marshal(), key.Marshal()) {
+			continue
+		}
+		if problem := signer.checkTime(when); problem != "" {
+			problems = append(problems, fmt.Sprintf("%v:%v: %v", file, n, problem))
+			continue
+		}
+		principals = append(principals, signer.principals)
+	}
+	return principals, problems, scanner.Err()
+}
+
+// checkSSHRevocation fails when key is listed in gpg.ssh.revocationFile,
+// a file of public keys in the authorized_keys format.
+func (r *Repository) checkSSHRevocation(key ssh.PublicKey) error {
+	file, ok := r.configString("gpg.ssh.revocationfile")
+	if !ok || file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(expandConfigPath(file))
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(data, []byte("SSHKRL")) {
+		return fmt.Errorf("Unsupported key revocation list %v: only public keys can be listed", file)
+	}
+	for len(data) > 0 {
+		revoked, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+		if bytes.Equal(revoked.Marshal(), key.Marshal()) {
+			return errors.New("key is revoked")
+		}
+		data = rest
+	}
+	return nil
+}
+
+type allowedSigner struct {
+	principals    string
+	namespaces    []string
+	certAuthority bool
+	key           ssh.PublicKey
+	// validAfter and validBefore bound when the key may sign, when set.
+	validAfter  time.Time
+	validBefore time.Time
+}
+
+// checkTime tells why the key can't have signed at the time when, or ""
+// when it could. Nothing is checked without a time.
+func (s *allowedSigner) checkTime(when time.Time) string {
+	const layout = "2006-01-02T15:04:05"
+	switch {
+	case when.IsZero():
+		return ""
+	case !s.validBefore.IsZero() && when.After(s.validBefore):
+		return fmt.Sprintf("key has expired: verify time %v > valid-before %v",
+			when.Local().Format(layout), s.validBefore.Local().Format(layout))
+	case !s.validAfter.IsZero() && when.Before(s.validAfter):
+		return fmt.Sprintf("key is not yet valid: verify time %v < valid-after %v",
+			when.Local().Format(layout), s.validAfter.Local().Format(layout))
+	}
+	return ""
+}
+
+func (s *allowedSigner) allowsNamespace(ns string) bool {
+	if len(s.namespaces) == 0 {
+		return true
+	}
+	for _, n := range s.namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowedSigner parses one line of an allowed signers file:
+// "principals [options] keytype base64-key [comment]".
+func parseAllowedSigner(line string) (*allowedSigner, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("Invalid allowed signers line: %v", line)
+	}
+	signer := &allowedSigner{principals: fields[0]}
+	rest := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+
+	// The rest of the line follows the authorized_keys format, options
+	// included.
+	key, _, opts, _, err := ssh.ParseAuthorizedKey([]byte(rest))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid allowed signers line: %v", line)
+	}
+	for _, opt := range opts {
+		lower := strings.ToLower(opt)
+		switch {
+		case lower == "cert-authority":
+			signer.certAuthority = true
+		case strings.HasPrefix(lower, "namespaces="):
+			ns := strings.Trim(opt[len("namespaces="):], `"`)
+			signer.namespaces = strings.Split(ns, ",")
+		case strings.HasPrefix(lower, "valid-after="):
+			if signer.validAfter, err = parseSSHTime(opt[len("valid-after="):]); err != nil {
+				return nil, fmt.Errorf("Invalid allowed signers line: %v", line)
+			}
+		case strings.HasPrefix(lower, "valid-before="):
+			if signer.validBefore, err = parseSSHTime(opt[len("valid-before="):]); err != nil {
+				return nil, fmt.Errorf("Invalid allowed signers line: %v", line)
+			}
+		}
+	}
+	signer.key = key
+	return signer, nil
+}
+
+// parseSSHTime parses the time of a valid-after or valid-before option,
+// YYYYMMDD[HHMM[SS]] in local time, or in UTC with a trailing "Z".
+func parseSSHTime(value string) (time.Time, error) {
+	value = strings.Trim(value, `"`)
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") || strings.HasSuffix(value, "z") {
+		value, loc = value[:len(value)-1], time.UTC
+	}
+	layouts := map[int]string{8: "20060102", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(value)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("Invalid time %q", value)
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
@@ -0,0 +1,248 @@
+package repo
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const testPayload = `tree 4b825dc642cb6eb9a060e54bf8d69288fbe4904c
+author A <a@x> 1 +0000
+committer A <a@x> 1 +0000
+
+msg
+`
+
+const testSSHSignature = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgLznwJBuPujptIBFMJl96FHrJHh
+k+YfOGxwsZSlFgiVgAAAADZ2l0AAAAAAAAAAZzaGE1MTIAAABTAAAAC3NzaC1lZDI1NTE5
+AAAAQCGDQyDFjvDefOqy1xRXbONvBseM82A7SnZ3zcmc5deRu9RVwRRK7u/7HpHvWv7/pf
+mlcfmiPRFTyRAxJ2sbBgo=
+-----END SSH SIGNATURE-----
+`
+
+const testSSHKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIC858CQbj7o6bSARTCZfehR6yR4ZPmHzhscLGUpRYIlY"
+
+// testPGPKey signed testPGPCommitSignature over testPayload, and
+// testPGPTagSignature over testTagPayload.
+const testPGPKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEX+5mABYJKwYBBAHaRw8BAQdADItWPJ7/zFlPur0Q47NmjsfZoCf6xEJ2Vg2E
+2W1ysTC0DkEgVSBUaG9yIDxhQHg+iJAEExYIADgWIQTvVH3CWMxtZ5c70rjeL6bq
++SG27wUCX+5mAAIbAwULCQgHAgYVCgkICwIEFgIDAQIeAQIXgAAKCRDeL6bq+SG2
+7zMKAP45UMRXOTQSVbOp+or4LvjaKq2ANzJHvW9fe18MbycvrwEAoaLsNFSFvows
+Sdcn0qVzsKDAsmvJPMJj9wacLgMI7go=
+=d6tb
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+const testPGPCommitSignature = `-----BEGIN PGP SIGNATURE-----
+
+iHUEABYIAB0WIQTvVH3CWMxtZ5c70rjeL6bq+SG27wUCX+5mAAAKCRDeL6bq+SG2
+79X6AP0ZemZsThIb+EvUnVpZzuulnYBvxvi56epAd8nbu7A2WAD/apAr5sjKwRfl
+qPTBb2bMEvHa2oZtnm6X82GLMbGPuAM=
+=AO6N
+-----END PGP SIGNATURE-----
+`
+
+const testTagPayload = `object 4b825dc642cb6eb9a060e54bf8d69288fbe4904c
+type tree
+tag v1
+tagger A <a@x> 1 +0000
+
+msg
+`
+
+const testPGPTagSignature = `-----BEGIN PGP SIGNATURE-----
+
+iHUEABYIAB0WIQTvVH3CWMxtZ5c70rjeL6bq+SG27wUCX+5mAAAKCRDeL6bq+SG2
+73tCAP4zn5bIF9qWQlJtN/NQY3qI5s9Hz/WHpB3QAJx1BZ0ozgEAi7G/soo4CgOH
+KCrTgfjDoJSAHrtWQJlsAzhQuRD7OAg=
+=tb8Z
+-----END PGP SIGNATURE-----
+`
+
+func TestSplitCommitSignature(t *testing.T) {
+	signed := strings.Replace(testPayload, "committer A <a@x> 1 +0000\n",
+		"committer A <a@x> 1 +0000\ngpgsig "+strings.ReplaceAll(strings.TrimSuffix(testSSHSignature, "\n"), "\n", "\n ")+"\n", 1)
+	payload, sig := splitCommitSignature(signed, "gpgsig")
+	if payload != testPayload {
+		t.Errorf("payload mismatch:\n%v", payload)
+	}
+	if sig != testSSHSignature {
+		t.Errorf("signature mismatch:\n%v", sig)
+	}
+}
+
+func TestSplitTagSignature(t *testing.T) {
+	content := "object 4b825dc642cb6eb9a060e54bf8d69288fbe4904c\ntype tree\ntag v1\ntagger A <a@x> 1 +0000\n\nmsg\n"
+	payload, sig := splitTagSignature(content + testSSHSignature)
+	if payload != content || sig != testSSHSignature {
+		t.Errorf("unexpected split %q / %q", payload, sig)
+	}
+	if payload, sig := splitTagSignature(content); payload != content || sig != "" {
+		t.Errorf("unsigned tag: unexpected split %q / %q", payload, sig)
+	}
+}
+
+func TestVerifySSHSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signers := path.Join(dir, "allowed_signers")
+	ioutil.WriteFile(signers, []byte(`a@x namespaces="file,git" `+testSSHKey+"\n"), 0666)
+	cfgPath := path.Join(dir, "config")
+	ioutil.WriteFile(cfgPath, []byte("[gpg \"ssh\"]\n\tallowedSignersFile = "+signers+"\n"), 0666)
+	cfg, err := loadConfigFile(cfgPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &Repository{config: cfg}
+
+	result, err := repo.verifySignature([]byte(testPayload), []byte(testSSHSignature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(result, `Good "git" signature for a@x with ED25519 key`) {
+		t.Errorf("unexpected result %q", result)
+	}
+
+	if _, err := repo.verifySignature([]byte(testPayload+"tampered"), []byte(testSSHSignature)); err == nil {
+		t.Error("tampered payload must not verify")
+	}
+
+	ioutil.WriteFile(signers, []byte(`a@x namespaces="file" `+testSSHKey+"\n"), 0666)
+	if _, err := repo.verifySignature([]byte(testPayload), []byte(testSSHSignature)); err == nil {
+		t.Error("key not allowed for git must not match a principal")
+	}
+
+	// The payload was signed one second into 1970.
+	ioutil.WriteFile(signers, []byte(`a@x valid-before="19700101Z" `+testSSHKey+"\n"), 0666)
+	if _, err := repo.verifySignature([]byte(testPayload), []byte(testSSHSignature)); err == nil ||
+		!strings.Contains(err.Error(), "key has expired") {
+		t.Errorf("expired key: got %v", err)
+	}
+	ioutil.WriteFile(signers, []byte(`a@x valid-after="19700101Z",valid-before="19700102Z" `+testSSHKey+"\n"), 0666)
+	if _, err := repo.verifySignature([]byte(testPayload), []byte(testSSHSignature)); err != nil {
+		t.Errorf("key valid when signing: %v", err)
+	}
+
+	revoked := path.Join(dir, "revoked")
+	ioutil.WriteFile(revoked, []byte(testSSHKey+"\n"), 0666)
+	cfg.entries = append(cfg.entries, configEntry{section: "gpg", subsection: "ssh", name: "revocationfile", value: revoked, hasValue: true})
+	if _, err := repo.verifySignature([]byte(testPayload), []byte(testSSHSignature)); err == nil {
+		t.Error("revoked key must not verify")
+	}
+}
+
+func TestSignSSHRoundTrip(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signSSHPayload(signer, []byte(testPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	signers := path.Join(dir, "allowed_signers")
+	ioutil.WriteFile(signers, append([]byte("b@x "), ssh.MarshalAuthorizedKey(signer.PublicKey())...), 0666)
+	repo := &Repository{config: &Config{entries: []configEntry{
+		{section: "gpg", subsection: "ssh", name: "allowedsignersfile", value: signers, hasValue: true},
+		{section: "gpg", name: "format", value: "ssh", hasValue: true},
+	}}}
+
+	commit := createCommit(repo, []byte(testPayload))
+	if err := repo.signCommit(commit, ""); err == nil {
+		t.Fatal("signing without user.signingKey must fail")
+	}
+	result, err := repo.verifySignature([]byte(testPayload), []byte(sig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(result, `Good "git" signature for b@x`) {
+		t.Errorf("unexpected result %q", result)
+	}
+}
+
+func TestVerifyPGPSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyring := path.Join(dir, "pubring.asc")
+	ioutil.WriteFile(keyring, []byte(testPGPKey), 0666)
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+	// Only the test key is known: GnuPG has no keyring, and "gpg --export"
+	// can't run.
+	defer os.Setenv("GNUPGHOME", os.Getenv("GNUPGHOME"))
+	os.Setenv("GNUPGHOME", dir)
+	repo.config = &Config{entries: []configEntry{
+		{section: "gpg", name: "keyring", value: keyring, hasValue: true},
+		{section: "gpg", name: "program", value: path.Join(dir, "no-gpg"), hasValue: true},
+	}}
+	save := func(format, content string) string {
+		sha, err := repo.writeObjectStream(format, int64(len(content)), strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+	gpgsig := "gpgsig " + strings.ReplaceAll(strings.TrimSuffix(testPGPCommitSignature, "\n"), "\n", "\n ") + "\n"
+	signCommit := func(payload string) string {
+		return strings.Replace(payload, "\n\n", "\n"+gpgsig+"\n", 1)
+	}
+
+	cases := []struct {
+		name, format, content string
+		good                  bool
+	}{
+		{"commit", TypeCommit, signCommit(testPayload), true},
+		{"tag", TypeTag, testTagPayload + testPGPTagSignature, true},
+		{"tampered commit", TypeCommit, signCommit(strings.Replace(testPayload, "msg", "evil", 1)), false},
+		{"tampered tag", TypeTag, strings.Replace(testTagPayload, "v1", "v2", 1) + testPGPTagSignature, false},
+		{"tag signed for a commit", TypeTag, testTagPayload + testPGPCommitSignature, false},
+	}
+	for _, c := range cases {
+		result, err := repo.verifyObject(save(c.format, c.content), c.format)
+		if c.good && (err != nil || !strings.Contains(result, `Good signature from "A U Thor <a@x>"`)) {
+			t.Errorf("%v: got %q (%v)", c.name, result, err)
+		}
+		if c.good && !strings.Contains(result, "Primary key fingerprint: EF547DC258CC6D67973BD2B8DE2FA6EAF921B6EF") {
+			t.Errorf("%v: unexpected fingerprint in %q", c.name, result)
+		}
+		if !c.good && (err == nil || !strings.HasPrefix(err.Error(), "BAD signature from key DE2FA6EAF921B6EF")) {
+			t.Errorf("%v: expected a bad signature, got %q (%v)", c.name, result, err)
+		}
+	}
+
+	// The keyring is loaded once per repository.
+	os.Remove(keyring)
+	if _, err := repo.verifySignature([]byte(testPayload), []byte(testPGPCommitSignature)); err != nil {
+		t.Errorf("cached keyring: %v", err)
+	}
+	if _, err := (&Repository{config: repo.config}).verifySignature([]byte(testPayload), []byte(testPGPCommitSignature)); err == nil ||
+		!strings.Contains(err.Error(), "no public key DE2FA6EAF921B6EF") {
+		t.Errorf("unknown key: got %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package repo
+
+import (
+	"os"
+	"syscall"
+)
+
+// fillIndexStat records the stat data of an index entry that os.FileInfo
+// doesn't carry.
+func fillIndexStat(e *indexEntry, st os.FileInfo) {
+	sys, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	e.ctimeSec, e.ctimeNsec = uint32(sys.Ctim.Sec), uint32(sys.Ctim.Nsec)
+	e.dev, e.ino = uint32(sys.Dev), uint32(sys.Ino)
+	e.uid, e.gid = sys.Uid, sys.Gid
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package repo
+
+import "os"
+
+// fillIndexStat leaves the change time of an index entry to its
+// modification time, and the rest of its stat data empty, outside of
+// Linux.
+func fillIndexStat(e *indexEntry, st os.FileInfo) {
+}
@@ -0,0 +1,164 @@
+package repo
+
+import (
+	"bufio"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/pencil001/pit/util"
+)
+
+// objectReader streams the content of a loose object, after its header
+// has been consumed. It fails with io.ErrUnexpectedEOF when the object is
+// shorter than its header claims.
+type objectReader struct {
+	format    string
+	size      int64
+	remaining int64
+	zr        io.ReadCloser
+	br        *bufio.Reader
+	f         *os.File
+}
+
+func (or *objectReader) Read(p []byte) (int, error) {
+	if or.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > or.remaining {
+		p = p[:or.remaining]
+	}
+	n, err := or.br.Read(p)
+	or.remaining -= int64(n)
+	if err == io.EOF && or.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (or *objectReader) Close() error {
+	or.zr.Close()
+	return or.f.Close()
+}
+
+// openObject opens a loose object for reading and returns its format and
+// size along with a reader over the content. The caller must close it.
+func (r *Repository) openObject(objSHA string) (*objectReader, error) {
+	if len(objSHA) < 3 {
+		return nil, fmt.Errorf("Invalid object name %v", objSHA)
+	}
+	objFile := path.Join(r.commonDir, "objects", objSHA[:2], objSHA[2:])
+	fObj, err := os.Open(objFile)
+	if err != nil {
+		return nil, fmt.Errorf("Objects file %v missing", objFile)
+	}
+
+	zr, err := zlib.NewReader(fObj)
+	if err != nil {
+		fObj.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(zr)
+
+	format, err := br.ReadString(' ')
+	if err != nil {
+		zr.Close()
+		fObj.Close()
+		return nil, fmt.Errorf("Malformed object %v: bad header", objSHA)
+	}
+	strSize, err := br.ReadString('\x00')
+	if err != nil {
+		zr.Close()
+		fObj.Close()
+		return nil, fmt.Errorf("Malformed object %v: bad header", objSHA)
+	}
+	size, err := strconv.ParseInt(strSize[:len(strSize)-1], 10, 64)
+	if err != nil {
+		zr.Close()
+		fObj.Close()
+		return nil, err
+	}
+
+	return &objectReader{
+		format:    format[:len(format)-1],
+		size:      size,
+		remaining: size,
+		zr:        zr,
+		br:        br,
+		f:         fObj,
+	}, nil
+}
+
+// hashObjectStream computes the name of an object of the given format and
+// size whose content is read from src, copying the encoded object to w
+// on the way when w isn't nil.
+func hashObjectStream(objFormat *ObjectFormat, format string, size int64, src io.Reader, w io.Writer) (string, error) {
+	h := objFormat.New()
+	var dst io.Writer = h
+	if w != nil {
+		dst = io.MultiWriter(h, w)
+	}
+
+	if _, err := fmt.Fprintf(dst, "%v %v\x00", format, size); err != nil {
+		return "", err
+	}
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("Object size changed while reading: expected %v, got %v", size, n)
+	}
+	return util.BytesToHexStr(h.Sum(nil)), nil
+}
+
+// writeObjectStream stores an object whose content is read from src. The
+// object is compressed into a temporary file while it is being hashed and
+// moved into place once its name is known, so memory use doesn't depend on
+// the object size.
+func (r *Repository) writeObjectStream(format string, size int64, src io.Reader) (string, error) {
+	objRoot := path.Join(r.commonDir, "objects")
+	fTmp, err := ioutil.TempFile(objRoot, "tmp_obj_")
+	if err != nil {
+		return "", err
+	}
+	tmpName := fTmp.Name()
+	defer os.Remove(tmpName)
+
+	zw := zlib.NewWriter(fTmp)
+	sha, err := hashObjectStream(r.objectFormat(), format, size, src, zw)
+	if err != nil {
+		fTmp.Close()
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		fTmp.Close()
+		return "", err
+	}
+	if err := fTmp.Close(); err != nil {
+		return "", err
+	}
+
+	objDir := path.Join(objRoot, sha[:2])
+	if err := util.CreateDir(objDir); err != nil {
+		return "", err
+	}
+	objFile := path.Join(objDir, sha[2:])
+	if isExist, _ := util.IsExist(objFile); isExist {
+		return sha, nil
+	}
+	if err := os.Chmod(tmpName, 0444); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpName, objFile); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
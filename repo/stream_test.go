@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestObjectStreamRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 100000)
+	sha, err := repo.writeObjectStream(TypeBlob, int64(len(data)), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := createBlob(repo, data)
+	bs, err := blob.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := FormatSHA1.Sum(bs); sha != want {
+		t.Fatalf("got %v, want %v", sha, want)
+	}
+
+	or, err := repo.openObject(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer or.Close()
+	got, err := ioutil.ReadAll(or)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if or.format != TypeBlob || or.size != int64(len(data)) || !bytes.Equal(got, data) {
+		t.Fatalf("content mismatch: %v %v", or.format, or.size)
+	}
+
+	if _, err := repo.writeObjectStream(TypeBlob, int64(len(data))+1, bytes.NewReader(data)); err == nil {
+		t.Fatal("expected a size mismatch error")
+	}
+}
+
+func TestWriteBatchObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	repo := Init(path.Join(dir, "repo"), "", "", false)
+
+	sha, err := createBlob(repo, []byte("hello\n")).Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := strings.Repeat("0", 40)
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for _, name := range []string{sha, missing} {
+		if err := repo.writeBatchObject(w, name, name, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.writeBatchObject(w, sha, sha, false); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	want := sha + " blob 6\nhello\n\n" + missing + " missing\n" + sha + " blob 6\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	shas, err := repo.looseObjects()
+	if err != nil || len(shas) != 1 || shas[0] != sha {
+		t.Errorf("got objects %v (%v), want %v", shas, err, sha)
+	}
+}
@@ -1,5 +1,7 @@
 package repo
 
+import "strings"
+
 type Tag struct {
 	*Commit
 }
@@ -11,3 +13,41 @@ func createTag(repo *Repository, data []byte) *Tag {
 	tag.format = TypeTag
 	return tag
 }
+
+// Object returns the name of the tagged object.
+func (t *Tag) Object() string {
+	return t.value("object")
+}
+
+// ObjectType returns the type of the tagged object.
+func (t *Tag) ObjectType() string {
+	return t.value("type")
+}
+
+func (t *Tag) TagName() string {
+	return t.value("tag")
+}
+
+func (t *Tag) Tagger() (Signature, error) {
+	return parseSignature(t.value("tagger"))
+}
+
+// Message returns the tag message without its signature, if any.
+func (t *Tag) Message() string {
+	message, _ := splitTagSignature(t.value(""))
+	return message
+}
+
+// PGPSignature returns the signature appended to the tag message.
+func (t *Tag) PGPSignature() string {
+	_, sig := splitTagSignature(t.value(""))
+	return strings.TrimSpace(sig)
+}
+
+func (t *Tag) ExtraHeaders() []Header {
+	return t.extraHeaders("object", "type", "tag", "tagger")
+}
+
+func (t *Tag) Trailers() []Trailer {
+	return parseTrailers(t.Message())
+}
@@ -9,6 +9,14 @@ import (
 	"github.com/pencil001/pit/util"
 )
 
+const (
+	ModeTree    = "040000"
+	ModeBlob    = "100644"
+	ModeExec    = "100755"
+	ModeSymlink = "120000"
+	ModeGitlink = "160000"
+)
+
 type Leaf struct {
 	mode string
 	path string
@@ -38,18 +46,6 @@ func createTree(repo *Repository, data []byte) *Tree {
 	return tree
 }
 
-func (t *Tree) Display() (string, error) {
-	var sb strings.Builder
-	for _, leaf := range t.leaves {
-		obj, err := t.BaseObject.repo.readObject(leaf.sha)
-		if err != nil {
-			log.Panic(err)
-		}
-		sb.WriteString(fmt.Sprintf("%v %v %v\t%v\n", leaf.mode, obj.GetFormat(), leaf.sha, leaf.path))
-	}
-	return sb.String(), nil
-}
-
 func (t *Tree) Serialize() (string, error) {
 	var sb strings.Builder
 	for _, leaf := range t.leaves {
@@ -58,28 +54,134 @@ func (t *Tree) Serialize() (string, error) {
 	return sb.String(), nil
 }
 
+// Deserialize parses the entries of a tree. Two entries with the same
+// name are rejected whatever their modes, as checking out the second one
+// would write into the first, or through it when it is a symbolic link.
 func (t *Tree) Deserialize(data []byte) error {
 	pos := 0
 	max := len(data)
+	seen := map[string]bool{}
 	for pos < max {
 		var leaf Leaf
-		pos, leaf = t.parseOneLeaf(data, pos)
+		var err error
+		pos, leaf, err = t.parseOneLeaf(data, pos)
+		if err != nil {
+			return err
+		}
+		if seen[leaf.path] {
+			return fmt.Errorf("Malformed tree: duplicate entry %q", leaf.path)
+		}
+		seen[leaf.path] = true
 		t.leaves = append(t.leaves, leaf)
 	}
 	return nil
 }
 
-func (t *Tree) parseOneLeaf(rs []byte, start int) (int, Leaf) {
+func (t *Tree) parseOneLeaf(rs []byte, start int) (int, Leaf, error) {
 	idxSpace := util.FindInBytes(rs, ' ', start)
+	if idxSpace < 0 {
+		return 0, Leaf{}, fmt.Errorf("Malformed tree: missing mode at %v", start)
+	}
 	im, err := strconv.ParseUint(string(rs[start:idxSpace]), 10, 32)
 	if err != nil {
-		log.Panic(err)
+		return 0, Leaf{}, err
 	}
 	mode := fmt.Sprintf("%06d", im)
 
 	idxNULL := util.FindInBytes(rs, '\x00', idxSpace)
+	if idxNULL < 0 {
+		return 0, Leaf{}, fmt.Errorf("Malformed tree: missing path at %v", idxSpace)
+	}
 	path := string(rs[idxSpace+1 : idxNULL])
+	if err := verifyLeafPath(path); err != nil {
+		return 0, Leaf{}, err
+	}
 
-	sha := util.BytesToHexStr(rs[idxNULL+1 : idxNULL+21])
-	return idxNULL + 21, Leaf{mode, path, sha}
+	end := idxNULL + 1 + t.repo.objectFormat().Size
+	if end > len(rs) {
+		return 0, Leaf{}, fmt.Errorf("Malformed tree: truncated hash for %q", path)
+	}
+	sha := util.BytesToHexStr(rs[idxNULL+1 : end])
+	return end, Leaf{mode, path, sha}, nil
+}
+
+// lookupPath finds the entry at filePath, a slash separated path, under
+// the tree treeSHA. The second result is false when there is none.
+func (r *Repository) lookupPath(treeSHA string, filePath string) (Leaf, bool, error) {
+	leaf := Leaf{mode: ModeTree, sha: treeSHA}
+	for _, name := range strings.Split(strings.Trim(filePath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if leaf.mode != ModeTree {
+			return Leaf{}, false, nil
+		}
+		tree := createTree(r, nil)
+		if err := tree.Read(leaf.sha); err != nil {
+			return Leaf{}, false, err
+		}
+		found := false
+		for _, l := range tree.leaves {
+			if l.path == name {
+				leaf, found = l, true
+				break
+			}
+		}
+		if !found {
+			return Leaf{}, false, nil
+		}
+	}
+	leaf.path = filePath
+	return leaf, true, nil
+}
+
+// verifyLeafPath rejects tree entry names which are unsafe to create on
+// disk: empty names, "." and "..", anything containing a slash, backslash
+// or NUL, and every spelling of ".git" that Windows or macOS would treat
+// as the real thing.
+func verifyLeafPath(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("Invalid path %q in tree", name)
+	}
+	if strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("Invalid path %q in tree: contains a separator or NUL", name)
+	}
+	if isDotGit(name) {
+		return fmt.Errorf("Invalid path %q in tree: refusing to touch .git", name)
+	}
+	return nil
+}
+
+// isDotGit reports whether name would resolve to ".git" on some
+// filesystem. HFS+ ignores a set of zero-width code points when comparing
+// names, NTFS drops trailing dots and spaces, accepts the "git~1" short
+// name and allows an alternate data stream suffix after a colon.
+func isDotGit(name string) bool {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if isHFSIgnorable(r) {
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	folded := sb.String()
+	if idx := strings.Index(folded, ":"); idx >= 0 {
+		folded = folded[:idx]
+	}
+	folded = strings.TrimRight(folded, ". ")
+	return folded == ".git" || folded == "git~1"
+}
+
+func isHFSIgnorable(r rune) bool {
+	switch {
+	case r >= 0x200c && r <= 0x200f:
+		return true
+	case r >= 0x202a && r <= 0x202e:
+		return true
+	case r >= 0x206a && r <= 0x206f:
+		return true
+	case r == 0xfeff:
+		return true
+	}
+	return false
 }
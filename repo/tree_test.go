@@ -0,0 +1,173 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pencil001/pit/util"
+)
+
+var leafPathCorpus = []struct {
+	path string
+	ok   bool
+}{
+	{"README.md", true},
+	{".gitignore", true},
+	{".github", true},
+	{"git", true},
+	{"a..b", true},
+	{"...", true},
+	{"", false},
+	{".", false},
+	{"..", false},
+	{"../../etc/x", false},
+	{"a/b", false},
+	{"a\\b", false},
+	{"..\\..\\x", false},
+	{"a\x00b", false},
+	{".git", false},
+	{".GIT", false},
+	{".Git", false},
+	{".git.", false},
+	{".git ", false},
+	{".git . .", false},
+	{"git~1", false},
+	{"GIT~1", false},
+	{".git::$INDEX_ALLOCATION", false},
+	{".git:stream", false},
+	{".g\u200cit", false},
+	{"\u200d.git", false},
+	{".gi\ufefft", false},
+	{".G\u206aIT", false},
+}
+
+func TestVerifyLeafPath(t *testing.T) {
+	for _, c := range leafPathCorpus {
+		err := verifyLeafPath(c.path)
+		if c.ok && err != nil {
+			t.Errorf("%q: unexpected error %v", c.path, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%q: expected an error", c.path)
+		}
+	}
+}
+
+func encodeLeaf(mode, path string) []byte {
+	sha := util.HexStrToBytes(strings.Repeat("ab", 20))
+	return append([]byte(mode+" "+path+"\x00"), sha...)
+}
+
+func TestDeserializeTreeRejectsUnsafePath(t *testing.T) {
+	for _, c := range leafPathCorpus {
+		tree := createTree(nil, nil)
+		err := tree.Deserialize(encodeLeaf("100644", c.path))
+		if c.ok && err != nil {
+			t.Errorf("%q: unexpected error %v", c.path, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%q: expected an error", c.path)
+		}
+	}
+}
+
+func TestDeserializeTreeTruncated(t *testing.T) {
+	data := encodeLeaf("100644", "file")
+	for i := 1; i < len(data); i++ {
+		tree := createTree(nil, nil)
+		if err := tree.Deserialize(data[:i]); err == nil {
+			t.Errorf("truncated at %v: expected an error", i)
+		}
+	}
+}
+
+func FuzzDeserializeTree(f *testing.F) {
+	for _, c := range leafPathCorpus {
+		f.Add(encodeLeaf("100644", c.path))
+		f.Add(encodeLeaf("40000", c.path))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tree := createTree(nil, nil)
+		if err := tree.Deserialize(data); err != nil {
+			return
+		}
+		for _, leaf := range tree.leaves {
+			if err := verifyLeafPath(leaf.path); err != nil {
+				t.Fatalf("unsafe leaf accepted: %v", err)
+			}
+		}
+	})
+}
+
+func TestTreeSHA256RoundTrip(t *testing.T) {
+	repo := &Repository{objFormat: FormatSHA256}
+	sha := strings.Repeat("cd", FormatSHA256.Size)
+	data := append([]byte("100644 file\x00"), util.HexStrToBytes(sha)...)
+
+	tree := createTree(repo, data)
+	if len(tree.leaves) != 1 || tree.leaves[0].sha != sha {
+		t.Fatalf("unexpected leaves %#v", tree.leaves)
+	}
+	str, err := tree.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str != string(data) {
+		t.Fatalf("round trip mismatch: %q", str)
+	}
+}
+
+func TestLsTreeSpecs(t *testing.T) {
+	tests := []struct {
+		prefix string
+		args   []string
+		want   []lsTreeSpec
+	}{
+		{"", nil, []lsTreeSpec{""}},
+		{"a", nil, []lsTreeSpec{"a/"}},
+		{"a", []string{"b", "../c", "./d/"}, []lsTreeSpec{"a/b", "c", "a/d/"}},
+		{"a/b", []string{".."}, []lsTreeSpec{"a/"}},
+	}
+	for _, tt := range tests {
+		got, err := lsTreeSpecs(tt.prefix, tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%v %v: got %q, want %q", tt.prefix, tt.args, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v %v: got %q, want %q", tt.prefix, tt.args, got, tt.want)
+			}
+		}
+	}
+	if _, err := lsTreeSpecs("a", []string{"../.."}); err == nil {
+		t.Error("expected a path outside of the repository to be rejected")
+	}
+
+	spec := lsTreeSpec("a/b")
+	if !spec.matches("a/b") || !spec.matches("a/b/c") || spec.matches("a/bc") || spec.matches("a") {
+		t.Errorf("%q matches the wrong paths", spec)
+	}
+	if !spec.leadsTo("a") || spec.leadsTo("a/b") {
+		t.Errorf("%q leads to the wrong trees", spec)
+	}
+	if dir := lsTreeSpec("a/"); dir.matches("a") || !dir.matches("a/x") {
+		t.Errorf("%q matches the wrong paths", dir)
+	}
+}
+
+func TestQuotePath(t *testing.T) {
+	tests := map[string]string{
+		"plain":      "plain",
+		"with space": "with space",
+		"tab\t\"é":   `"tab\t\"\303\251"`,
+		`back\slash`: `"back\\slash"`,
+	}
+	for in, want := range tests {
+		if got := quotePath(in); got != want {
+			t.Errorf("quotePath(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
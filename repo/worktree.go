@@ -0,0 +1,259 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pencil001/pit/util"
+)
+
+// worktree is a checkout of the repository: the main one, around the
+// common directory, or a linked one whose git directory is
+// worktrees/<id> in the common directory.
+type worktree struct {
+	path       string
+	id         string
+	head       string
+	branch     string
+	bare       bool
+	locked     bool
+	lockReason string
+	prunable   string
+}
+
+func (w *worktree) isMain() bool {
+	return w.id == ""
+}
+
+// linkedWorktreeNames lists the ids of the linked worktrees.
+func (r *Repository) linkedWorktreeNames() ([]string, error) {
+	entries, err := ioutil.ReadDir(path.Join(r.commonDir, "worktrees"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, f := range entries {
+		if f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	return names, nil
+}
+
+// worktrees lists the main worktree, and then the linked ones. Linked
+// worktrees without a gitdir file are left out.
+func (r *Repository) worktrees() ([]*worktree, error) {
+	main := &worktree{path: r.commonDir, bare: r.configBool("core.bare", false)}
+	if !main.bare && filepath.Base(r.commonDir) == ".git" {
+		main.path = filepath.Dir(r.commonDir)
+	}
+	r.readWorktreeHead(main, "main-worktree/HEAD")
+	list := []*worktree{main}
+
+	names, err := r.linkedWorktreeNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range names {
+		adminDir := path.Join(r.commonDir, "worktrees", id)
+		gitFile, err := readWorktreeGitdir(adminDir)
+		if err != nil {
+			continue
+		}
+		wt := &worktree{path: filepath.Dir(gitFile), id: id}
+		if bs, err := ioutil.ReadFile(path.Join(adminDir, "locked")); err == nil {
+			wt.locked, wt.lockReason = true, strings.TrimSpace(string(bs))
+		}
+		wt.prunable = r.pruneReason(id)
+		r.readWorktreeHead(wt, "worktrees/"+id+"/HEAD")
+		list = append(list, wt)
+	}
+	return list, nil
+}
+
+// readWorktreeGitdir reads the gitdir file of a linked worktree, the path
+// of the .git file of its work tree.
+func readWorktreeGitdir(adminDir string) (string, error) {
+	bs, err := ioutil.ReadFile(path.Join(adminDir, "gitdir"))
+	if err != nil {
+		return "", err
+	}
+	gitFile := strings.TrimSpace(string(bs))
+	if gitFile == "" {
+		return "", fmt.Errorf("invalid gitdir file")
+	}
+	if !filepath.IsAbs(gitFile) {
+		gitFile = filepath.Join(adminDir, gitFile)
+	}
+	return filepath.Clean(gitFile), nil
+}
+
+// readWorktreeHead fills in the commit and branch of a worktree from its
+// HEAD. Both are empty when it can't be read.
+func (r *Repository) readWorktreeHead(wt *worktree, headRef string) {
+	if wt.bare {
+		return
+	}
+	wt.head, _ = r.readRef(headRef, nil)
+	wt.branch, _ = r.resolveSymbolicRef(headRef)
+}
+
+// checkedOutAt returns the worktree where branch, a full ref name, is
+// checked out, if any.
+func (r *Repository) checkedOutAt(branch string) (*worktree, error) {
+	list, err := r.worktrees()
+	if err != nil {
+		return nil, err
+	}
+	for _, wt := range list {
+		if !wt.bare && wt.branch == branch {
+			return wt, nil
+		}
+	}
+	return nil, nil
+}
+
+// findWorktree finds a worktree by its path, or by the last components
+// of its path when they are unique.
+func (r *Repository) findWorktree(arg string) (*worktree, error) {
+	list, err := r.worktrees()
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(arg)
+	if err != nil {
+		return nil, err
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = real
+	}
+	var found *worktree
+	for _, wt := range list {
+		if wt.path == abs {
+			return wt, nil
+		}
+		if strings.HasSuffix(wt.path, "/"+strings.Trim(arg, "/")) {
+			if found != nil {
+				return nil, fmt.Errorf("'%v' is not a working tree", arg)
+			}
+			found = wt
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("'%v' is not a working tree", arg)
+	}
+	return found, nil
+}
+
+// addWorktree checks out commit into a new linked worktree at wtPath,
+// with HEAD pointing to branch, or detached when branch is empty.
+func (r *Repository) addWorktree(wtPath string, branch string, commit string) (err error) {
+	abs, err := filepath.Abs(wtPath)
+	if err != nil {
+		return err
+	}
+	if err := ensureEmptyDir(abs); err != nil {
+		return fmt.Errorf("'%v' already exists", wtPath)
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = real
+	}
+
+	// The id of the worktree is the name of its directory, made unique.
+	id := filepath.Base(abs)
+	if strings.HasPrefix(id, ".") {
+		id = strings.TrimLeft(id, ".")
+	}
+	adminDir := path.Join(r.commonDir, "worktrees", id)
+	for n := 1; ; n++ {
+		if ok, _ := util.IsExist(adminDir); !ok {
+			break
+		}
+		adminDir = path.Join(r.commonDir, "worktrees", fmt.Sprintf("%v%v", id, n))
+	}
+	if err := os.MkdirAll(adminDir, 0777); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(adminDir)
+			os.RemoveAll(abs)
+		}
+	}()
+
+	files := map[string]string{
+		"gitdir":    path.Join(abs, ".git") + "\n",
+		"commondir": "../..\n",
+		"HEAD":      commit + "\n",
+	}
+	if branch != "" {
+		files["HEAD"] = fmt.Sprintf("ref: %v\n", branch)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(adminDir, name), []byte(content), 0666); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(abs, ".git"), []byte("gitdir: "+adminDir+"\n"), 0666); err != nil {
+		return err
+	}
+
+	wt, err := openRepository(abs, adminDir)
+	if err != nil {
+		return err
+	}
+	treeSHA, err := resolveRev(r, commit, TypeTree)
+	if err != nil {
+		return err
+	}
+	return wt.checkoutIndex(treeSHA)
+}
+
+// removeWorktree deletes a linked worktree, its files and its git
+// directory.
+func (r *Repository) removeWorktree(wt *worktree) error {
+	if err := os.RemoveAll(wt.path); err != nil {
+		return err
+	}
+	return r.deleteWorktreeDir(wt.id)
+}
+
+// deleteWorktreeDir deletes the git directory of a linked worktree, and
+// the worktrees directory once it is empty.
+func (r *Repository) deleteWorktreeDir(id string) error {
+	if err := os.RemoveAll(path.Join(r.commonDir, "worktrees", id)); err != nil {
+		return err
+	}
+	if names, err := r.linkedWorktreeNames(); err == nil && len(names) == 0 {
+		os.Remove(path.Join(r.commonDir, "worktrees"))
+	}
+	return nil
+}
+
+// pruneReason tells why the git directory of a linked worktree is stale,
+// or "" when it must be kept. Locked worktrees are kept even when their
+// files are gone.
+func (r *Repository) pruneReason(id string) string {
+	adminDir := path.Join(r.commonDir, "worktrees", id)
+	if ok, _ := util.IsExist(path.Join(adminDir, "locked")); ok {
+		return ""
+	}
+	if _, err := os.Stat(path.Join(adminDir, "gitdir")); os.IsNotExist(err) {
+		return "gitdir file does not exist"
+	}
+	gitFile, err := readWorktreeGitdir(adminDir)
+	if err != nil {
+		return "invalid gitdir file"
+	}
+	if _, err := os.Stat(gitFile); os.IsNotExist(err) {
+		return "gitdir file points to non-existent location"
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// saveCommit stores a commit of tree with the given parents, by a fixed
+// author at a fixed date.
+func saveCommit(t *testing.T, repo *Repository, tree string, message string, parents ...string) string {
+	c := createCommit(repo, nil)
+	ident := "A U Thor <author@example.com> 1000 +0000"
+	c.kvlm = []KList{{key: "tree", list: []string{tree}}}
+	if len(parents) > 0 {
+		c.kvlm = append(c.kvlm, KList{key: "parent", list: parents})
+	}
+	c.kvlm = append(c.kvlm,
+		KList{key: "author", list: []string{ident}},
+		KList{key: "committer", list: []string{ident}},
+		KList{key: "", list: []string{message}})
+	return saveObject(t, c)
+}
+
+func TestWorktree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	Init(filepath.Join(dir, "main"), "", "", false)
+	repo, err := discoverRepo(filepath.Join(dir, "main"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := saveObject(t, createBlob(repo, []byte("hello\n")))
+	sub := createTree(repo, nil)
+	sub.leaves = []Leaf{{ModeExec, "g", blob}}
+	tree := createTree(repo, nil)
+	tree.leaves = []Leaf{{ModeTree, "d", saveObject(t, sub)}, {ModeBlob, "f", blob}}
+	commit := saveCommit(t, repo, saveObject(t, tree), "first\n")
+	for _, ref := range []string{"refs/heads/master", "refs/heads/side"} {
+		if err := repo.writeRef(ref, commit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wtPath := filepath.Join(dir, "wt")
+	if err := repo.addWorktree(wtPath, "refs/heads/side", commit); err != nil {
+		t.Fatal(err)
+	}
+	linked, err := discoverRepo(filepath.Join(wtPath, "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.workTree != wtPath || linked.commonDir != repo.gitDir ||
+		linked.gitDir != filepath.Join(repo.gitDir, "worktrees", "wt") {
+		t.Fatalf("linked worktree: got %v, %v and %v", linked.workTree, linked.gitDir, linked.commonDir)
+	}
+	if wt, err := repo.checkedOutAt("refs/heads/side"); err != nil || wt == nil || wt.path != wtPath {
+		t.Errorf("side: expected to be checked out at %v, got %v (%v)", wtPath, wt, err)
+	}
+
+	idx, err := linked.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.entries) != 2 || idx.entries[0].path != "d/g" || idx.entries[0].leafMode() != ModeExec ||
+		idx.entries[1].path != "f" || idx.entries[1].sha != blob {
+		t.Errorf("unexpected index entries %v", idx.entries)
+	}
+	if clean, err := linked.isWorkTreeClean(); err != nil || !clean {
+		t.Errorf("expected a clean worktree (%v)", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(wtPath, "f"), []byte("changed\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if clean, err := linked.isWorkTreeClean(); err != nil || clean {
+		t.Errorf("expected a modified file to be seen (%v)", err)
+	}
+
+	// Refs under refs/worktree/ belong to each worktree.
+	if err := linked.writeRef("refs/worktree/mark", commit); err != nil {
+		t.Fatal(err)
+	}
+	mainRefs, err := repo.getRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkedRefs, err := linked.getRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mainRefs["refs/worktree/mark"]; ok {
+		t.Error("refs/worktree/mark leaked into the main worktree")
+	}
+	if linkedRefs["refs/worktree/mark"] != commit || linkedRefs["refs/heads/master"] != commit {
+		t.Errorf("linked worktree refs: %v", linkedRefs)
+	}
+	if sha, err := repo.readRef("worktrees/wt/HEAD", nil); err != nil || sha != commit {
+		t.Errorf("worktrees/wt/HEAD = %q (%v)", sha, err)
+	}
+}
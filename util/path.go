@@ -3,6 +3,8 @@ package util
 import (
 	"io/ioutil"
 	"os"
+	"regexp"
+	"strings"
 )
 
 func IsExist(path string) (bool, error) {
@@ -33,7 +35,7 @@ func IsEmptyDir(path string) (bool, error) {
 }
 
 func CreateDir(path string) error {
-	if err := os.MkdirAll(path, 0666); err != nil {
+	if err := os.MkdirAll(path, 0777); err != nil {
 		return err
 	}
 	return nil
@@ -42,3 +44,40 @@ func CreateDir(path string) error {
 func CreateFile(path string) (*os.File, error) {
 	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
 }
+
+func CreateFileWithMode(path string, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+// MatchGlob matches name against a wildmatch style pattern, where "*"
+// and "?" stay within a path component and "**" crosses them.
+func MatchGlob(pattern, name string, icase bool) bool {
+	var sb strings.Builder
+	if icase {
+		sb.WriteString("(?i)")
+	}
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			sb.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(name)
+}
@@ -2,20 +2,13 @@ package util
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"encoding/binary"
-	"encoding/hex"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
 )
 
-func CalcSHA(content []byte) string {
-	sha := sha1.Sum(content)
-	return hex.EncodeToString(sha[:])
-}
-
 func FindInRunes(rs []rune, c rune, start int) int {
 	for i, r := range rs {
 		if i < start {
@@ -61,3 +54,53 @@ func HexStrToBytes(hexStr string) []byte {
 	}
 	return buf.Bytes()
 }
+
+// CompareVersions compares two names the way "sort -V" does: runs of
+// digits are compared numerically, everything else byte by byte.
+func CompareVersions(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			na := strings.TrimLeft(a[si:i], "0")
+			nb := strings.TrimLeft(b[sj:j], "0")
+			if len(na) != len(nb) {
+				if len(na) < len(nb) {
+					return -1
+				}
+				return 1
+			}
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+		if a[i] != b[j] {
+			if a[i] < b[j] {
+				return -1
+			}
+			return 1
+		}
+		i, j = i+1, j+1
+	}
+	switch {
+	case len(a)-i < len(b)-j:
+		return -1
+	case len(a)-i > len(b)-j:
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}